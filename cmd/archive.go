@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/archive"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: archive [--format=<fmt>] [--prefix=<prefix>/] [-o <file>] [-<level>]
+//                <tree-ish> [<path>...]
+//        archive --list
+func cmdArchive(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	opts := archive.Options{Level: -1}
+	output := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func(name string) (string, bool) {
+			if v, ok := strings.CutPrefix(arg, name+"="); ok {
+				return v, true
+			}
+			if arg != name || i+1 >= len(args) {
+				return "", false
+			}
+			i++
+			return args[i], true
+		}
+		if v, ok := value("--format"); ok {
+			opts.Format = v
+			continue
+		}
+		if v, ok := value("--prefix"); ok {
+			opts.Prefix = v
+			continue
+		}
+		if v, ok := value("--output"); ok {
+			output = v
+			continue
+		}
+		switch {
+		case arg == "-o":
+			if i+1 >= len(args) {
+				fmt.Println("switch `o' requires a value")
+				return
+			}
+			i++
+			output = args[i]
+		case arg == "-l" || arg == "--list":
+			for _, f := range archive.Formats {
+				fmt.Println(f)
+			}
+			return
+		case len(arg) == 2 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			opts.Level = int(arg[1] - '0')
+		case arg == "--":
+		case arg == "--format" || arg == "--prefix" || arg == "--output":
+			fmt.Printf("option `%s' requires a value\n", arg[2:])
+			return
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 {
+		fmt.Println("Usage: archive [--format=<fmt>] [--prefix=<prefix>/] [-o <file>] <tree-ish> [<path>...]")
+		return
+	}
+	rev, paths := rest[0], rest[1:]
+	if opts.Format == "" {
+		opts.Format = archive.FormatFromName(output)
+	}
+
+	// like git, an archive made from a subdirectory holds only that directory
+	cwd := ""
+	if r.Worktree != "" {
+		rel, err := filepath.Rel(r.Worktree, dir)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			opts.Subdir = filepath.ToSlash(rel)
+		}
+		cwd = dir
+	}
+	if len(paths) > 0 {
+		tree, err := object.ObjectFind(r, rev, "tree")
+		if err != nil {
+			fmt.Println("fatal: not a valid object name:", rev)
+			return
+		}
+		files, err := object.TreeFiles(r, tree)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, p := range paths {
+			ps, err := pathspec.Parse(r.Worktree, cwd, []string{p})
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if !anyMatch(ps, files) {
+				fmt.Printf("fatal: pathspec '%s' did not match any files\n", p)
+				return
+			}
+		}
+		if opts.Pathspec, err = pathspec.Parse(r.Worktree, cwd, paths); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	if err := archive.Write(r, w, rev, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func anyMatch(ps *pathspec.Pathspec, files map[string]object.TreeFile) bool {
+	for name := range files {
+		if ps.Match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Blue-Onion/pygo/hanlder/bisect"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: bisect start [<bad> [<good>...]]
+//        bisect (good|bad|old|new) [<rev>...]
+//        bisect skip [<rev>...]
+//        bisect reset [<commit>]
+//        bisect (log|next)
+//        bisect replay <logfile>
+//        bisect run <cmd> [<arg>...]
+func cmdBisect(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(args) == 0 {
+		fmt.Println("Usage: bisect (start|good|bad|skip|reset|log|replay|run) [<args>]")
+		return
+	}
+	sub, rest := args[0], args[1:]
+	var status *bisect.Status
+	switch sub {
+	case "start":
+		var revs []string
+		for _, arg := range rest {
+			if arg == "--" {
+				break
+			}
+			revs = append(revs, arg)
+		}
+		bad, goods := "", []string(nil)
+		if len(revs) > 0 {
+			bad, goods = revs[0], revs[1:]
+		}
+		status, err = bisect.Start(r, bad, goods)
+	case "good", "bad", "old", "new", "skip":
+		status, err = bisect.Mark(r, bisect.Term(sub), rest)
+	case "next":
+		status, err = bisect.Next(r)
+	case "reset":
+		if len(rest) > 1 {
+			fmt.Println("'git bisect reset' requires either no argument or a commit")
+			return
+		}
+		target := ""
+		if len(rest) == 1 {
+			target = rest[0]
+		}
+		if target, err = bisect.Reset(r, target); err == nil {
+			if refs.Exists(r, "refs/heads/"+target) {
+				fmt.Printf("Switched to branch '%s'\n", target)
+			} else {
+				fmt.Printf("HEAD is now at %s\n", target)
+			}
+		}
+	case "log":
+		var log string
+		if log, err = bisect.Log(r); err == nil {
+			fmt.Print(log)
+		}
+	case "replay":
+		if len(rest) != 1 {
+			fmt.Println("no logfile given")
+			return
+		}
+		data, err := os.ReadFile(rest[0])
+		if err != nil {
+			fmt.Printf("cannot read file '%s' for replaying\n", rest[0])
+			return
+		}
+		status, err = bisect.Replay(r, string(data))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	case "run":
+		_, err = bisect.Run(r, rest, os.Stdout)
+	default:
+		fmt.Println("Unknown bisect subcommand:", sub)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if status != nil {
+		fmt.Print(status.String())
+	}
+}
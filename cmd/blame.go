@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Blue-Onion/pygo/hanlder/blame"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: blame [-L <range>]... [-l] [-s] [-e] [--porcelain|--line-porcelain]
+//              [--ignore-rev <rev>] [--ignore-revs-file <file>] [<rev>] [--] <file>
+func cmdBlame(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var opts blame.Options
+	var ignoreFiles, positional []string
+	if file, ok := repo.ConfigLookup(r, "blame", "ignorerevsfile"); ok && file != "" {
+		ignoreFiles = append(ignoreFiles, file)
+	}
+	porcelain, linePorcelain, long, noAuthor, email := false, false, false, false, false
+	value := func(i *int, arg, opt string) (string, bool) {
+		if v, ok := strings.CutPrefix(arg, opt+"="); ok {
+			return v, true
+		}
+		if strings.HasPrefix(opt, "-") && !strings.HasPrefix(opt, "--") && len(arg) > len(opt) {
+			return arg[len(opt):], true
+		}
+		if *i+1 >= len(args) {
+			fmt.Println("option", opt, "requires a value")
+			return "", false
+		}
+		*i++
+		return args[*i], true
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			positional = append(positional, args[i:]...)
+			i = len(args)
+		case arg == "-p" || arg == "--porcelain":
+			porcelain = true
+		case arg == "--line-porcelain":
+			porcelain, linePorcelain = true, true
+		case arg == "-l":
+			long = true
+		case arg == "-s":
+			noAuthor = true
+		case arg == "-e" || arg == "--show-email":
+			email = true
+		case strings.HasPrefix(arg, "-L"):
+			v, ok := value(&i, arg, "-L")
+			if !ok {
+				return
+			}
+			opts.Ranges = append(opts.Ranges, v)
+		case arg == "--ignore-rev" || strings.HasPrefix(arg, "--ignore-rev="):
+			v, ok := value(&i, arg, "--ignore-rev")
+			if !ok {
+				return
+			}
+			opts.IgnoreRevs = append(opts.IgnoreRevs, v)
+		case arg == "--ignore-revs-file" || strings.HasPrefix(arg, "--ignore-revs-file="):
+			v, ok := value(&i, arg, "--ignore-revs-file")
+			if !ok {
+				return
+			}
+			if v == "" {
+				// an empty name forgets the files given so far, including the configured one
+				ignoreFiles = nil
+				continue
+			}
+			ignoreFiles = append(ignoreFiles, v)
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	file := ""
+	for i, arg := range positional {
+		if arg == "--" {
+			if i == 1 {
+				opts.Rev = positional[0]
+			} else if i > 1 {
+				fmt.Println("Usage: blame [<options>] [<rev>] [--] <file>")
+				return
+			}
+			if i+1 != len(positional)-1 {
+				fmt.Println("Usage: blame [<options>] [<rev>] [--] <file>")
+				return
+			}
+			file = positional[i+1]
+			break
+		}
+	}
+	if file == "" {
+		switch len(positional) {
+		case 1:
+			file = positional[0]
+		case 2:
+			opts.Rev, file = positional[0], positional[1]
+		default:
+			fmt.Println("Usage: blame [<options>] [<rev>] [--] <file>")
+			return
+		}
+	}
+	for _, f := range ignoreFiles {
+		revs, err := blame.ReadIgnoreRevs(f)
+		if err != nil {
+			fmt.Println("fatal:", err)
+			return
+		}
+		opts.IgnoreRevs = append(opts.IgnoreRevs, revs...)
+	}
+
+	name := filepath.ToSlash(file)
+	if r.Worktree != "" {
+		abs := file
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(path, file)
+		}
+		rel, err := filepath.Rel(r.Worktree, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			fmt.Printf("fatal: %s: '%s' is outside repository at '%s'\n", file, file, r.Worktree)
+			return
+		}
+		name = filepath.ToSlash(rel)
+	}
+	lines, err := blame.File(r, name, opts)
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	if porcelain {
+		printBlamePorcelain(lines, linePorcelain)
+		return
+	}
+	printBlame(r, lines, name, long, noAuthor, email)
+}
+
+// printBlame prints lines the way git blame does by default
+func printBlame(r *repo.Gitrepo, lines []blame.Line, name string, long, noAuthor, email bool) {
+	markIgnored := repo.ConfigBool(r, "blame", "markignoredlines")
+	markUnblamable := repo.ConfigBool(r, "blame", "markunblamablelines")
+	showName, nameWidth, authorWidth, lineWidth := false, 0, 0, 0
+	for _, l := range lines {
+		if l.Path != name {
+			showName = true
+		}
+		nameWidth = max(nameWidth, utf8.RuneCountInString(l.Path))
+		author := l.Commit.Author
+		if email {
+			author = l.Commit.AuthorMail
+		}
+		authorWidth = max(authorWidth, utf8.RuneCountInString(author))
+		lineWidth = max(lineWidth, len(fmt.Sprint(l.FinalLine)))
+	}
+	for _, l := range lines {
+		c := l.Commit
+		length := 8
+		if long {
+			length = len(c.Sha)
+		}
+		var b strings.Builder
+		if c.Boundary {
+			length--
+			b.WriteByte('^')
+		}
+		if markUnblamable && l.Unblamable {
+			length--
+			b.WriteByte('*')
+		}
+		if markIgnored && l.Ignored {
+			length--
+			b.WriteByte('?')
+		}
+		b.WriteString(c.Sha[:length])
+		if showName {
+			fmt.Fprintf(&b, " %s%s", l.Path, strings.Repeat(" ", nameWidth-utf8.RuneCountInString(l.Path)))
+		}
+		if !noAuthor {
+			author := c.Author
+			if email {
+				author = c.AuthorMail
+			}
+			fmt.Fprintf(&b, " (%s%s %s", author, strings.Repeat(" ", authorWidth-utf8.RuneCountInString(author)),
+				c.AuthorTime.Format("2006-01-02 15:04:05 -0700"))
+		}
+		fmt.Fprintf(&b, " %*d) %s", lineWidth, l.FinalLine, l.Content)
+		if !strings.HasSuffix(l.Content, "\n") {
+			b.WriteByte('\n')
+		}
+		fmt.Print(b.String())
+	}
+}
+
+// printBlamePorcelain prints lines in git's machine readable format
+// A commit is described the first time it appears, or on every line with full
+func printBlamePorcelain(lines []blame.Line, full bool) {
+	shown := map[*blame.Commit]bool{}
+	for i, l := range lines {
+		c := l.Commit
+		first := i == 0 || lines[i-1].Commit != c || lines[i-1].Path != l.Path ||
+			lines[i-1].OrigLine+1 != l.OrigLine || lines[i-1].FinalLine+1 != l.FinalLine
+		if first {
+			n := 1
+			for j := i + 1; j < len(lines); j++ {
+				p, q := lines[j-1], lines[j]
+				if q.Commit != c || q.Path != l.Path || p.OrigLine+1 != q.OrigLine || p.FinalLine+1 != q.FinalLine {
+					break
+				}
+				n++
+			}
+			fmt.Printf("%s %d %d %d\n", c.Sha, l.OrigLine, l.FinalLine, n)
+		} else {
+			fmt.Printf("%s %d %d\n", c.Sha, l.OrigLine, l.FinalLine)
+		}
+		if full || (first && !shown[c]) {
+			shown[c] = true
+			fmt.Printf("author %s\n", c.Author)
+			fmt.Printf("author-mail %s\n", c.AuthorMail)
+			fmt.Printf("author-time %d\n", c.AuthorTime.Unix())
+			fmt.Printf("author-tz %s\n", c.AuthorTime.Format("-0700"))
+			fmt.Printf("committer %s\n", c.Committer)
+			fmt.Printf("committer-mail %s\n", c.CommitterMail)
+			fmt.Printf("committer-time %d\n", c.CommitterTime.Unix())
+			fmt.Printf("committer-tz %s\n", c.CommitterTime.Format("-0700"))
+			fmt.Printf("summary %s\n", c.Summary)
+			if c.Boundary {
+				fmt.Println("boundary")
+			}
+			if c.Previous != "" {
+				fmt.Printf("previous %s %s\n", c.Previous, c.PreviousPath)
+			}
+			fmt.Printf("filename %s\n", l.Path)
+		} else if first && len(c.Paths) > 1 {
+			fmt.Printf("filename %s\n", l.Path)
+		}
+		content := strings.TrimSuffix(l.Content, "\n")
+		fmt.Printf("\t%s\n", content)
+	}
+}
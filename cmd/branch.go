@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/branch"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// commitSubject returns the first line of a commit message
+func commitSubject(r *repo.Gitrepo, sha string) string {
+	c, err := object.ReadCommit(r, sha)
+	if err != nil {
+		return ""
+	}
+	return c.Subject()
+}
+
+// Usage: branch [-a|-r] [-v] [--list [pattern]]
+//        branch [-f] <name> [<start>]
+//        branch (-d|-D) <name>...
+//        branch (-m|-M) [<old>] <new>
+//        branch (-u <upstream>|--set-upstream-to=<upstream>) [<name>]
+//        branch --unset-upstream [<name>]
+func cmdBranch(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+
+	mode := "list"
+	local, remotes, verbose, force := true, false, false, false
+	upstream := ""
+	var names []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-a" || arg == "--all":
+			local, remotes = true, true
+		case arg == "-r" || arg == "--remotes":
+			local, remotes = false, true
+		case arg == "-v" || arg == "-vv" || arg == "--verbose":
+			verbose = true
+		case arg == "-l" || arg == "--list":
+			mode = "list"
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "-d" || arg == "--delete":
+			mode = "delete"
+		case arg == "-D":
+			mode, force = "delete", true
+		case arg == "-m" || arg == "--move":
+			mode = "rename"
+		case arg == "-M":
+			mode, force = "rename", true
+		case arg == "-u":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Missing upstream after -u")
+				os.Exit(129)
+			}
+			mode, upstream = "upstream", args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--set-upstream-to="):
+			mode, upstream = "upstream", strings.TrimPrefix(arg, "--set-upstream-to=")
+		case arg == "--unset-upstream":
+			mode = "unset-upstream"
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if mode == "list" && len(names) > 0 && !hasListFlag(args) {
+		mode = "create"
+	}
+
+	switch mode {
+	case "list":
+		listBranches(r, local, remotes, verbose, names)
+	case "create":
+		if len(names) > 2 {
+			fmt.Fprintln(os.Stderr, "Usage: branch [-f] <name> [<start>]")
+			os.Exit(129)
+		}
+		start := "HEAD"
+		if len(names) == 2 {
+			start = names[1]
+		}
+		if err := branch.Create(r, names[0], start, force); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	case "delete":
+		if len(names) == 0 {
+			fmt.Fprintln(os.Stderr, "fatal: branch name required")
+			os.Exit(128)
+		}
+		failed := false
+		for _, name := range names {
+			sha, err := branch.Delete(r, name, force)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Deleted branch %s (was %s).\n", name, object.Abbrev(sha))
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "rename":
+		var oldName, newName string
+		switch len(names) {
+		case 1:
+			current, _, err := refs.Head(r)
+			if err != nil || current == "" {
+				fmt.Fprintln(os.Stderr, "fatal: cannot rename the current branch while not on any")
+				os.Exit(128)
+			}
+			oldName, newName = refs.Shorten(current), names[0]
+		case 2:
+			oldName, newName = names[0], names[1]
+		default:
+			fmt.Fprintln(os.Stderr, "Usage: branch (-m|-M) [<old>] <new>")
+			os.Exit(129)
+		}
+		if err := branch.Rename(r, oldName, newName, force); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	case "upstream", "unset-upstream":
+		name, err := branchArg(r, names)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		if mode == "unset-upstream" {
+			if err := branch.UnsetUpstream(r, name); err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			return
+		}
+		short, err := branch.SetUpstream(r, name, upstream)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		fmt.Printf("branch '%s' set up to track '%s'.\n", name, short)
+	}
+}
+
+// hasListFlag reports whether names given to branch are patterns for --list
+func hasListFlag(args []string) bool {
+	for _, arg := range args {
+		if arg == "-l" || arg == "--list" || arg == "-a" || arg == "--all" || arg == "-r" || arg == "--remotes" {
+			return true
+		}
+	}
+	return false
+}
+
+// branchArg returns the branch named in names, or the current branch
+func branchArg(r *repo.Gitrepo, names []string) (string, error) {
+	if len(names) > 0 {
+		return names[0], nil
+	}
+	current, _, err := refs.Head(r)
+	if err != nil {
+		return "", err
+	}
+	if current == "" {
+		return "", errors.New("HEAD is detached; name a branch")
+	}
+	return refs.Shorten(current), nil
+}
+
+func listBranches(r *repo.Gitrepo, local, remotes, verbose bool, patterns []string) {
+	list, err := branch.List(r, local, remotes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	current, head, _ := refs.Head(r)
+	if current == "" && head != "" && local {
+		fmt.Printf("* (HEAD detached at %s)\n", object.Abbrev(head))
+	}
+	for _, b := range list {
+		if !matchesAny(b.Name, patterns) {
+			continue
+		}
+		marker := "  "
+		if b.Current {
+			marker = "* "
+		}
+		if !verbose {
+			fmt.Println(marker + b.Name)
+			continue
+		}
+		track := ""
+		if b.Upstream != "" {
+			track = "[" + refs.Shorten(b.Upstream) + "] "
+		}
+		fmt.Printf("%s%s %s %s%s\n", marker, b.Name, object.Abbrev(b.Sha), track, commitSubject(r, b.Sha))
+	}
+}
+
+// matchesAny reports whether name matches one of the shell patterns, or there are none
+func matchesAny(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage: switch [-c|-C <new>] [--detach] [-f|--discard-changes] <branch|start>
+func cmdSwitch(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	opts := branch.SwitchOptions{}
+	var newBranch string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-c", "--create", "-C", "--force-create":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Missing branch name after", arg)
+				os.Exit(129)
+			}
+			opts.Create = true
+			opts.Force = arg == "-C" || arg == "--force-create"
+			newBranch = args[i+1]
+			i++
+		case "-d", "--detach":
+			opts.Detach = true
+		case "-f", "--force", "--discard-changes":
+			opts.Discard = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	target := ""
+	if opts.Create {
+		target = newBranch
+		if len(rest) > 0 {
+			opts.Start = rest[0]
+		}
+	} else if len(rest) == 1 {
+		target = rest[0]
+	} else if opts.Detach && len(rest) == 0 {
+		target = "HEAD"
+	} else {
+		fmt.Fprintln(os.Stderr, "Usage: switch [-c|-C <new>] [--detach] [-f|--discard-changes] <branch|start>")
+		os.Exit(129)
+	}
+	res, err := branch.Switch(r, target, opts)
+	if errors.Is(err, branch.ErrNotBranch) {
+		fmt.Fprintf(os.Stderr, "fatal: %v\nhint: use --detach to check out a commit without a branch\n", err)
+		os.Exit(128)
+	}
+	reportSwitch(r, res, err)
+}
+
+// Usage: checkout [-b|-B <new>] [--detach] [-f] <branch|commit> [<start>]
+func cmdCheckout(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	opts := branch.SwitchOptions{}
+	var newBranch string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-b", "-B":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Missing branch name after", arg)
+				os.Exit(129)
+			}
+			opts.Create, opts.Force = true, arg == "-B"
+			newBranch = args[i+1]
+			i++
+		case "--detach":
+			opts.Detach = true
+		case "-f", "--force":
+			opts.Discard = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	target := "HEAD"
+	if opts.Create {
+		target = newBranch
+		if len(rest) > 0 {
+			opts.Start = rest[0]
+		}
+	} else if len(rest) == 1 {
+		target = rest[0]
+		// anything that is not a local branch is checked out detached
+		if !refs.Exists(r, "refs/heads/"+target) {
+			opts.Detach = true
+		}
+	} else if len(rest) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: checkout [-b|-B <new>] [--detach] [-f] <branch|commit> [<start>]")
+		os.Exit(129)
+	}
+	res, err := branch.Switch(r, target, opts)
+	reportSwitch(r, res, err)
+}
+
+// reportSwitch prints the outcome of a switch or checkout like git does, and exits with
+// status 1 when local changes refused it
+func reportSwitch(r *repo.Gitrepo, res *branch.Result, err error) {
+	var overwrite *worktree.OverwriteError
+	if errors.As(err, &overwrite) {
+		fmt.Fprintf(os.Stderr, "error: %v\nAborting\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	name := refs.Shorten(res.Branch)
+	switch {
+	case res.Created:
+		fmt.Printf("Switched to a new branch '%s'\n", name)
+	case res.Branch == "":
+		fmt.Printf("HEAD is now at %s %s\n", object.Abbrev(res.Sha), commitSubject(r, res.Sha))
+	case res.Same:
+		fmt.Printf("Already on '%s'\n", name)
+	default:
+		fmt.Printf("Switched to branch '%s'\n", name)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// defaultBatchFormat is the line batch modes print for each object
+const defaultBatchFormat = "%(objectname) %(objecttype) %(objectsize)"
+
+// batchOptions configure cat-file's batch modes
+type batchOptions struct {
+	mode   string // batch, batch-check or batch-command
+	format []formatPart
+	rest   bool
+	buffer bool
+	all    bool
+}
+
+// formatPart is a piece of a batch format: literal text or a %(atom)
+type formatPart struct {
+	text string
+	atom string
+}
+
+// Usage: cat-file <type> <object>
+//        cat-file (-e|-p|-t|-s) <object>
+//        cat-file (--batch|--batch-check|--batch-command)[=<format>] [--buffer] [--batch-all-objects]
+func cmdCatFile(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	var opt string
+	var batch batchOptions
+	var operands []string
+	format := defaultBatchFormat
+	for _, arg := range args {
+		switch {
+		case arg == "-e" || arg == "-p" || arg == "-t" || arg == "-s":
+			if opt != "" && opt != arg {
+				fmt.Fprintf(os.Stderr, "fatal: %s and %s cannot be used together\n", opt, arg)
+				os.Exit(128)
+			}
+			opt = arg
+		case arg == "--buffer":
+			batch.buffer = true
+		case arg == "--batch-all-objects":
+			batch.all = true
+		case strings.HasPrefix(arg, "--batch"):
+			mode, f, hasFormat := strings.Cut(arg[2:], "=")
+			if mode != "batch" && mode != "batch-check" && mode != "batch-command" {
+				fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+				os.Exit(129)
+			}
+			if batch.mode != "" {
+				fmt.Fprintln(os.Stderr, "fatal: only one batch option may be specified")
+				os.Exit(128)
+			}
+			batch.mode = mode
+			if hasFormat {
+				format = f
+			}
+		case arg == "--":
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			operands = append(operands, arg)
+		}
+	}
+
+	if batch.mode != "" {
+		switch {
+		case opt != "":
+			fmt.Fprintf(os.Stderr, "fatal: %s cannot be used with --%s\n", opt, batch.mode)
+		case len(operands) > 0:
+			fmt.Fprintln(os.Stderr, "fatal: batch modes take no arguments")
+		case batch.all && batch.mode == "batch-command":
+			fmt.Fprintln(os.Stderr, "fatal: --batch-all-objects cannot be used with --batch-command")
+		default:
+			batch.format, batch.rest, err = parseBatchFormat(format)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			catFileBatch(r, os.Stdin, os.Stdout, batch)
+			return
+		}
+		os.Exit(128)
+	}
+	switch {
+	case batch.buffer:
+		fmt.Fprintln(os.Stderr, "fatal: '--buffer' requires a batch mode")
+	case batch.all:
+		fmt.Fprintln(os.Stderr, "fatal: '--batch-all-objects' requires a batch mode")
+	case opt != "" && len(operands) == 0:
+		fmt.Fprintf(os.Stderr, "fatal: <object> required with '%s'\n", opt)
+	case opt != "" && len(operands) > 1, opt == "" && len(operands) > 2:
+		fmt.Fprintln(os.Stderr, "fatal: too many arguments")
+	case opt != "":
+		catFileObject(r, opt, operands[0])
+		return
+	case len(operands) == 2:
+		catFileTyped(r, operands[0], operands[1])
+		return
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: cat-file (<type>|-e|-p|-t|-s) <object>")
+		fmt.Fprintln(os.Stderr, "       cat-file (--batch|--batch-check|--batch-command)[=<format>] [--buffer] [--batch-all-objects]")
+		os.Exit(129)
+	}
+	os.Exit(128)
+}
+
+// catFileObject answers -e, -p, -t or -s for one object
+// -e prints nothing and exits with status 1 when the object does not exist.
+func catFileObject(r *repo.Gitrepo, opt, name string) {
+	sha, err := object.ObjectFind(r, name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: Not a valid object name", name)
+		os.Exit(128)
+	}
+	switch opt {
+	case "-e":
+		if _, _, err := object.ObjectHeader(r, sha); err != nil {
+			os.Exit(1)
+		}
+	case "-t", "-s":
+		typ, size, err := object.ObjectHeader(r, sha)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal: git cat-file: could not get object info")
+			os.Exit(128)
+		}
+		if opt == "-t" {
+			fmt.Println(typ)
+		} else {
+			fmt.Println(size)
+		}
+	case "-p":
+		typ, data, err := object.ObjectReadRaw(r, sha)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal: Not a valid object name", name)
+			os.Exit(128)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		if typ != "tree" {
+			w.Write(data)
+			return
+		}
+		if err := printTree(w, r, sha); err != nil {
+			w.Flush()
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+}
+
+// printTree writes one line per entry of a tree: its mode, type, id and name
+func printTree(w io.Writer, r *repo.Gitrepo, sha string) error {
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return err
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return fmt.Errorf("%s is not a tree", sha)
+	}
+	for _, e := range tree.Data {
+		mode, err := object.ParseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		typ := "blob"
+		switch {
+		case object.IsTreeMode(mode):
+			typ = "tree"
+		case mode == 0160000:
+			typ = "commit"
+		}
+		if _, err := fmt.Fprintf(w, "%06o %s %x\t%s\n", mode, typ, e.Sha, e.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catFileTyped prints the content of an object of the given type, peeling tags and
+// commits to reach it the way git cat-file <type> <object> does
+func catFileTyped(r *repo.Gitrepo, typ, name string) {
+	switch typ {
+	case "blob", "tree", "commit", "tag":
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: invalid object type \"%s\"\n", typ)
+		os.Exit(128)
+	}
+	sha, err := object.ObjectFind(r, name, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal: Not a valid object name", name)
+		os.Exit(128)
+	}
+	sha, err = object.Peel(r, sha, typ)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: git cat-file %s: bad file\n", name)
+		os.Exit(128)
+	}
+	_, data, err := object.ObjectReadRaw(r, sha)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: git cat-file %s: bad file\n", name)
+		os.Exit(128)
+	}
+	os.Stdout.Write(data)
+}
+
+// parseBatchFormat splits a batch format into text and %(atom) placeholders
+// It also reports whether the format uses %(rest), which makes input lines split at
+// their first whitespace.
+func parseBatchFormat(format string) ([]formatPart, bool, error) {
+	var parts []formatPart
+	var text strings.Builder
+	rest := false
+	for i := 0; i < len(format); i++ {
+		switch {
+		case strings.HasPrefix(format[i:], "%%"):
+			text.WriteByte('%')
+			i++
+		case strings.HasPrefix(format[i:], "%("):
+			end := strings.IndexByte(format[i:], ')')
+			if end == -1 {
+				return nil, false, fmt.Errorf("format element '%s' does not end in ')'", format[i+1:])
+			}
+			atom := format[i+2 : i+end]
+			switch atom {
+			case "objectname", "objecttype", "objectsize", "objectsize:disk", "deltabase":
+			case "rest":
+				rest = true
+			default:
+				return nil, false, fmt.Errorf("unknown format element: %s", atom)
+			}
+			parts = append(parts, formatPart{text: text.String()}, formatPart{atom: atom})
+			text.Reset()
+			i += end
+		default:
+			text.WriteByte(format[i])
+		}
+	}
+	parts = append(parts, formatPart{text: text.String()})
+	return parts, rest, nil
+}
+
+// expandBatchFormat fills in the placeholders of a batch format for one object
+func expandBatchFormat(r *repo.Gitrepo, parts []formatPart, sha, typ string, size int, rest string) string {
+	var b strings.Builder
+	for _, p := range parts {
+		switch p.atom {
+		case "":
+			b.WriteString(p.text)
+		case "objectname":
+			b.WriteString(sha)
+		case "objecttype":
+			b.WriteString(typ)
+		case "objectsize":
+			b.WriteString(strconv.Itoa(size))
+		case "objectsize:disk":
+			if info, err := os.Stat(repo.RepoPath(r, "objects", sha[:2], sha[2:])); err == nil {
+				b.WriteString(strconv.FormatInt(info.Size(), 10))
+			}
+		case "deltabase":
+			// loose objects are never deltas
+			b.WriteString(repo.Format(r).ZeroID())
+		case "rest":
+			b.WriteString(rest)
+		}
+	}
+	return b.String()
+}
+
+// catFileBatch answers the object names or commands read from in, one per line
+// Output is flushed after each answer, or with --buffer only at flush commands and the end.
+func catFileBatch(r *repo.Gitrepo, in io.Reader, out io.Writer, opts batchOptions) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	answer := func(line string, contents bool) {
+		name, rest := line, ""
+		if opts.rest {
+			if i := strings.IndexAny(line, " \t"); i != -1 {
+				name, rest = line[:i], strings.TrimLeft(line[i+1:], " \t")
+			}
+		}
+		// an empty name is not HEAD here
+		sha, err := "", fmt.Errorf("empty object name")
+		if name != "" {
+			sha, err = object.ObjectFind(r, name, "")
+		}
+		if err != nil {
+			if strings.HasSuffix(err.Error(), "is ambiguous") {
+				fmt.Fprintf(w, "%s ambiguous\n", name)
+			} else {
+				fmt.Fprintf(w, "%s missing\n", name)
+			}
+			return
+		}
+		if !contents {
+			typ, size, err := object.ObjectHeader(r, sha)
+			if err != nil {
+				fmt.Fprintf(w, "%s missing\n", name)
+				return
+			}
+			w.WriteString(expandBatchFormat(r, opts.format, sha, typ, size, rest) + "\n")
+			return
+		}
+		typ, data, err := object.ObjectReadRaw(r, sha)
+		if err != nil {
+			fmt.Fprintf(w, "%s missing\n", name)
+			return
+		}
+		w.WriteString(expandBatchFormat(r, opts.format, sha, typ, len(data), rest) + "\n")
+		w.Write(data)
+		w.WriteString("\n")
+	}
+	flush := func() {
+		if !opts.buffer {
+			w.Flush()
+		}
+	}
+
+	if opts.all {
+		ids, err := object.ListObjects(r)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		for _, sha := range ids {
+			answer(sha, opts.mode == "batch")
+		}
+		return
+	}
+	lines := bufio.NewReader(in)
+	for {
+		line, err := lines.ReadString('\n')
+		if line == "" && err != nil {
+			if err != io.EOF {
+				w.Flush()
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if opts.mode != "batch-command" {
+			answer(line, opts.mode == "batch")
+			flush()
+			continue
+		}
+		cmd, arg, hasArg := strings.Cut(line, " ")
+		switch {
+		case line == "":
+			w.Flush()
+			fmt.Fprintln(os.Stderr, "fatal: empty command in input")
+			os.Exit(128)
+		case strings.IndexAny(line[:1], " \t") == 0:
+			w.Flush()
+			fmt.Fprintf(os.Stderr, "fatal: whitespace before command: '%s'\n", line)
+			os.Exit(128)
+		case cmd == "contents" || cmd == "info":
+			if !hasArg || arg == "" {
+				w.Flush()
+				fmt.Fprintf(os.Stderr, "fatal: %s requires arguments\n", cmd)
+				os.Exit(128)
+			}
+			answer(arg, cmd == "contents")
+			flush()
+		case cmd == "flush":
+			if hasArg {
+				w.Flush()
+				fmt.Fprintln(os.Stderr, "fatal: flush takes no arguments")
+				os.Exit(128)
+			}
+			if !opts.buffer {
+				w.Flush()
+				fmt.Fprintln(os.Stderr, "fatal: flush is only for --buffer mode")
+				os.Exit(128)
+			}
+			w.Flush()
+		default:
+			w.Flush()
+			fmt.Fprintf(os.Stderr, "fatal: unknown command: '%s'\n", line)
+			os.Exit(128)
+		}
+	}
+}
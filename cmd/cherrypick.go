@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/sequencer"
+)
+
+// Usage: cherry-pick [-m <parent>] [-x] <commit>...
+//        cherry-pick (--continue|--skip|--abort)
+func cmdCherryPick(path string, args []string) {
+	runSequencer(path, sequencer.Pick, args)
+}
+
+// Usage: revert [-m <parent>] <commit>...
+//        revert (--continue|--skip|--abort)
+func cmdRevert(path string, args []string) {
+	runSequencer(path, sequencer.Revert, args)
+}
+
+// runSequencer parses the options shared by cherry-pick and revert and runs the action
+func runSequencer(path, action string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var opts sequencer.Options
+	var revs []string
+	control := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort":
+			control = arg
+		case arg == "-x" && action == sequencer.Pick:
+			opts.RecordOrigin = true
+		case arg == "-m" || arg == "--mainline" || strings.HasPrefix(arg, "--mainline="):
+			value, ok := strings.CutPrefix(arg, "--mainline=")
+			if !ok {
+				if i+1 >= len(args) {
+					fmt.Println("option", arg, "requires a value")
+					return
+				}
+				i++
+				value = args[i]
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				fmt.Println("error: option 'mainline' expects a number greater than zero")
+				return
+			}
+			opts.Mainline = n
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "^") && arg != "-":
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			revs = append(revs, arg)
+		}
+	}
+
+	var done []sequencer.Picked
+	switch control {
+	case "--continue":
+		done, err = sequencer.Continue(r)
+	case "--skip":
+		done, err = sequencer.Skip(r)
+	case "--abort":
+		err = sequencer.Abort(r)
+	default:
+		if len(revs) == 0 {
+			fmt.Println("fatal: no commits given")
+			return
+		}
+		done, err = sequencer.Start(r, action, revs, opts)
+	}
+
+	branch, _, _ := refs.Head(r)
+	where := "detached HEAD"
+	if branch != "" {
+		where = refs.Shorten(branch)
+	}
+	for _, p := range done {
+		for _, msg := range p.Messages {
+			fmt.Println(msg)
+		}
+		fmt.Printf("[%s %s] %s\n", where, object.Abbrev(p.Sha), p.Subject)
+	}
+	if err == nil {
+		return
+	}
+	var stop *sequencer.StopError
+	if errors.As(err, &stop) {
+		for _, msg := range stop.Messages {
+			fmt.Println(msg)
+		}
+		cmd := "cherry-pick"
+		if action == sequencer.Revert {
+			cmd = "revert"
+		}
+		fmt.Println("error:", err)
+		fmt.Println("hint: after resolving the conflicts, mark the corrected paths")
+		fmt.Println("hint: with 'git add <paths>' or 'git rm <paths>'")
+		fmt.Printf("hint: and run '%s --continue'\n", cmd)
+		os.Exit(1)
+	}
+	fmt.Println("error:", err)
+	os.Exit(1)
+}
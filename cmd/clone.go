@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/remote"
+)
+
+// Usage: clone [--bare] [-n|--no-checkout] [-q|--quiet] [--progress] [-o <name>] [-b <branch>]
+//        [-u <upload-pack>] <repository> [<directory>]
+// Copies a repository, by path or by file:// or ext:: URL, into a new directory and
+// checks out its HEAD.
+func cmdClone(path string, args []string) {
+	opts := remote.CloneOptions{Version: 2}
+	quiet, progress := false, false
+	var names []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--bare":
+			opts.Bare = true
+		case arg == "-n" || arg == "--no-checkout":
+			opts.NoCheckout = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "--progress":
+			progress = true
+		case arg == "-o" || arg == "--origin" || arg == "-b" || arg == "--branch" || arg == "-u" || arg == "--upload-pack":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Missing argument after", arg)
+				os.Exit(129)
+			}
+			switch arg {
+			case "-o", "--origin":
+				opts.Origin = args[i+1]
+			case "-b", "--branch":
+				opts.Branch = args[i+1]
+			default:
+				opts.UploadPack = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(arg, "--origin="):
+			opts.Origin = strings.TrimPrefix(arg, "--origin=")
+		case strings.HasPrefix(arg, "--branch="):
+			opts.Branch = strings.TrimPrefix(arg, "--branch=")
+		case strings.HasPrefix(arg, "--upload-pack="):
+			opts.UploadPack = strings.TrimPrefix(arg, "--upload-pack=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 || len(names) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: clone [<options>] [--] <repo> [<dir>]")
+		os.Exit(129)
+	}
+	dir := remote.DefaultDir(names[0], opts.Bare)
+	if len(names) == 2 {
+		dir = names[1]
+	}
+
+	opts.Progress = remoteProgress(quiet, progress)
+	switch {
+	case quiet:
+	case opts.Bare:
+		fmt.Printf("Cloning into bare repository '%s'...\n", dir)
+	default:
+		fmt.Printf("Cloning into '%s'...\n", dir)
+	}
+	r, err := remote.Clone(names[0], dir, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if !quiet && !strings.Contains(names[0], "://") && !strings.HasPrefix(names[0], "ext::") {
+		// like git, only a copy between paths reports being done
+		fmt.Println("done.")
+	}
+	if _, sha, err := refs.Head(r); err != nil || sha == "" {
+		fmt.Println("warning: You appear to have cloned an empty repository.")
+	}
+}
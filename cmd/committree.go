@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: commit-tree <tree> [-p <parent>]... [-m <message>]... [-F <file>]...
+// Writes a commit and prints its id without moving any ref. The message is read from stdin
+// when neither -m nor -F is given.
+func cmdCommitTree(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	var trees, parents []string
+	var message strings.Builder
+	haveMessage := false
+	// each -m or -F is a paragraph of the message; those from -m end in a newline
+	addParagraph := func(text string, completeLine bool) {
+		if message.Len() > 0 {
+			message.WriteString("\n")
+		}
+		message.WriteString(text)
+		if completeLine && message.Len() > 0 && !strings.HasSuffix(message.String(), "\n") {
+			message.WriteString("\n")
+		}
+		haveMessage = true
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-p", "-m", "-F":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: switch `%s' requires a value\n", arg[1:])
+				os.Exit(129)
+			}
+			i++
+			switch arg {
+			case "-p":
+				sha, err := commitTreeObject(r, args[i], "commit")
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "fatal:", err)
+					os.Exit(128)
+				}
+				if slices.Contains(parents, sha) {
+					fmt.Fprintf(os.Stderr, "error: duplicate parent %s ignored\n", sha)
+					continue
+				}
+				parents = append(parents, sha)
+			case "-m":
+				addParagraph(args[i], true)
+			case "-F":
+				var data []byte
+				if args[i] == "-" {
+					data, err = io.ReadAll(os.Stdin)
+				} else {
+					data, err = os.ReadFile(args[i])
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: could not read log file '%s'\n", args[i])
+					os.Exit(128)
+				}
+				addParagraph(string(data), false)
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+				os.Exit(129)
+			}
+			trees = append(trees, arg)
+		}
+	}
+	if len(trees) != 1 {
+		fmt.Fprintln(os.Stderr, "fatal: must give exactly one tree")
+		os.Exit(128)
+	}
+	tree, err := commitTreeObject(r, trees[0], "tree")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if !haveMessage {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		message.Write(data)
+	}
+
+	author, err := repo.Ident(r, "author")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	committer, err := repo.Ident(r, "committer")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	sha, err := object.ObjectWrite(r, object.NewCommit(tree, parents, author, committer, []byte(message.String())))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	fmt.Println(sha)
+}
+
+// commitTreeObject resolves name to an object that must already be of type typ
+func commitTreeObject(r *repo.Gitrepo, name, typ string) (string, error) {
+	sha, err := object.ObjectFind(r, name, "")
+	if err != nil {
+		return "", fmt.Errorf("not a valid object name %s", name)
+	}
+	if actual, _, err := object.ObjectHeader(r, sha); err != nil || actual != typ {
+		return "", fmt.Errorf("%s is not a valid '%s' object", sha, typ)
+	}
+	return sha, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/remote"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// remoteProgress says where the messages of a remote go; like git, progress is only
+// asked for when standard error is a terminal, or with --progress
+func remoteProgress(quiet, progress bool) remote.Progress {
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		progress = true
+	}
+	return remote.Progress{Out: os.Stderr, Quiet: quiet || !progress}
+}
+
+// Usage: fetch [-f|--force] [-t|--tags] [-q|--quiet] [--progress] [--upload-pack=<exec>] [<remote> [<refspec>...]]
+// Downloads the references the refspecs name, or those the remote is configured to fetch,
+// with the objects they need; FETCH_HEAD records what was fetched.
+func cmdFetch(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+
+	opts := remote.FetchOptions{Message: strings.TrimSpace("fetch " + strings.Join(args, " "))}
+	quiet, progress := false, false
+	uploadPack := ""
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f" || arg == "--force":
+			opts.Force = true
+		case arg == "-t" || arg == "--tags":
+			opts.Tags = true
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "--progress":
+			progress = true
+		case strings.HasPrefix(arg, "--upload-pack="):
+			uploadPack = strings.TrimPrefix(arg, "--upload-pack=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+	name := remote.DefaultName(r)
+	if len(names) > 0 {
+		name, names = names[0], names[1:]
+	}
+	rem, err := remote.Get(r, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if uploadPack != "" {
+		rem.UploadPack = uploadPack
+	}
+	opts.Progress = remoteProgress(quiet, progress)
+	var specs []remote.Refspec
+	for _, s := range names {
+		rs, err := remote.ParseRefspec(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		specs = append(specs, rs)
+	}
+
+	res, err := remote.Fetch(r, rem, specs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	for _, line := range res.Lines() {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/reffilter"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: for-each-ref [--count=<n>] [--shell|--perl|--python|--tcl] [--sort=<key>]... [--format=<format>]
+//        [--points-at=<object>] [--merged[=<commit>]] [--no-merged[=<commit>]]
+//        [--contains[=<commit>]] [--no-contains[=<commit>]] [<pattern>...]
+// Prints the references matching the patterns and filters, one formatted line each.
+func cmdForEachRef(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	format, quote, count := reffilter.DefaultFormat, reffilter.QuoteNone, 0
+	var sortKeys []string
+	var opts reffilter.Options
+	// commitArgs maps the history filters to where their commits go
+	commitArgs := map[string]*[]string{"--contains": &opts.Contains, "--no-contains": &opts.NoContains,
+		"--merged": &opts.Merged, "--no-merged": &opts.NoMerged, "--points-at": &opts.PointsAt}
+	var commitNames [][2]string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(arg, "=")
+		switch {
+		case name == "--format" || name == "--sort" || name == "--count" || name == "--points-at":
+			if !hasVal {
+				if i+1 >= len(args) {
+					fmt.Printf("error: option `%s' requires a value\n", strings.TrimPrefix(name, "--"))
+					return
+				}
+				i++
+				val = args[i]
+			}
+			switch name {
+			case "--format":
+				format = val
+			case "--sort":
+				sortKeys = append(sortKeys, val)
+			case "--count":
+				if count, err = strconv.Atoi(val); err != nil || count < 0 {
+					fmt.Printf("fatal: invalid --count argument: `%s'\n", val)
+					return
+				}
+			default:
+				commitNames = append(commitNames, [2]string{name, val})
+			}
+		case commitArgs[name] != nil:
+			// the commit is optional and defaults to HEAD only at the end of the line
+			if !hasVal {
+				val = "HEAD"
+				if i+1 < len(args) {
+					i++
+					val = args[i]
+				}
+			}
+			commitNames = append(commitNames, [2]string{name, val})
+		case arg == "-s" || arg == "--shell" || arg == "-p" || arg == "--perl" || arg == "--python" || arg == "--tcl":
+			style := map[string]string{"-s": reffilter.QuoteShell, "--shell": reffilter.QuoteShell, "-p": reffilter.QuotePerl,
+				"--perl": reffilter.QuotePerl, "--python": reffilter.QuotePython, "--tcl": reffilter.QuoteTcl}[arg]
+			if quote != reffilter.QuoteNone && quote != style {
+				fmt.Println("error: more than one quoting style?")
+				return
+			}
+			quote = style
+		case arg == "--":
+			opts.Patterns = append(opts.Patterns, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			opts.Patterns = append(opts.Patterns, arg)
+		}
+	}
+
+	for _, c := range commitNames {
+		typ := "commit"
+		if c[0] == "--points-at" {
+			typ = ""
+		}
+		sha, err := object.ObjectFind(r, c[1], typ)
+		if err != nil {
+			fmt.Println("error: malformed object name", c[1])
+			return
+		}
+		*commitArgs[c[0]] = append(*commitArgs[c[0]], sha)
+	}
+	if len(sortKeys) == 0 {
+		sortKeys = []string{"refname"}
+	}
+	keys, err := reffilter.ParseSort(sortKeys)
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	f, err := reffilter.ParseFormat(format, quote)
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+
+	list, err := reffilter.List(r, opts)
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	if err := reffilter.Sort(list, keys); err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	if count > 0 && count < len(list) {
+		list = list[:count]
+	}
+	for _, item := range list {
+		line, err := f.Expand(item)
+		if err != nil {
+			fmt.Println("fatal:", err)
+			return
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/grep"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: grep [-n] [-i] [-w] [-v] [-a] [-l|-c] [-E|-F|-G|-P] [--cached]
+//             [-e] <pattern> [<tree-ish>...] [[--] <pathspec>...]
+// -P takes Go's RE2 syntax rather than PCRE: lookaround, backreferences and the like are refused.
+func cmdGrep(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	opts := grep.Options{Syntax: grep.Basic}
+	lineNumbers, filesOnly, count := false, false, false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch arg {
+		case "-n", "--line-number":
+			lineNumbers = true
+		case "-i", "--ignore-case":
+			opts.IgnoreCase = true
+		case "-w", "--word-regexp":
+			opts.WordRegexp = true
+		case "-v", "--invert-match":
+			opts.Invert = true
+		case "-a", "--text":
+			opts.Text = true
+		case "-l", "--files-with-matches", "--name-only":
+			filesOnly = true
+		case "-c", "--count":
+			count = true
+		case "-E", "--extended-regexp":
+			opts.Syntax = grep.Extended
+		case "-F", "--fixed-strings":
+			opts.Syntax = grep.Fixed
+		case "-G", "--basic-regexp":
+			opts.Syntax = grep.Basic
+		case "-P", "--perl-regexp":
+			opts.Syntax = grep.Perl
+		case "--cached":
+			opts.Cached = true
+		case "-e":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "switch `e' requires a value")
+				os.Exit(129)
+			}
+			i++
+			opts.Patterns = append(opts.Patterns, args[i])
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+				os.Exit(129)
+			}
+			rest = append(rest, arg)
+		}
+	}
+	if len(opts.Patterns) == 0 {
+		if len(rest) == 0 || rest[0] == "--" {
+			fmt.Fprintln(os.Stderr, "fatal: no pattern given")
+			os.Exit(128)
+		}
+		opts.Patterns, rest = []string{rest[0]}, rest[1:]
+	}
+	// tree-ishes come first, until "--" or something that is not one
+	var paths []string
+	for i, arg := range rest {
+		if arg == "--" {
+			paths = rest[i+1:]
+			break
+		}
+		if _, err := object.ObjectFind(r, arg, "tree"); err != nil {
+			paths = rest[i:]
+			break
+		}
+		opts.Trees = append(opts.Trees, arg)
+	}
+	if opts.Cached && len(opts.Trees) > 0 {
+		fmt.Fprintln(os.Stderr, "fatal: --cached cannot be used with a tree")
+		os.Exit(128)
+	}
+
+	// like git, paths are relative to the current directory, which limits the search
+	prefix := ""
+	if r.Worktree != "" {
+		rel, err := filepath.Rel(r.Worktree, dir)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			prefix = filepath.ToSlash(rel)
+		}
+		if len(paths) == 0 && prefix != "" {
+			paths = []string{"."}
+		}
+		if opts.Pathspec, err = pathspec.Parse(r.Worktree, dir, paths); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	} else if len(paths) > 0 {
+		if opts.Pathspec, err = pathspec.Parse("", "", paths); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	}
+
+	files, err := grep.Search(r, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	for _, f := range files {
+		name := relativeTo(prefix, f.Path)
+		if f.Tree != "" {
+			name = f.Tree + ":" + name
+		}
+		switch {
+		case filesOnly:
+			fmt.Println(name)
+		case count:
+			fmt.Printf("%s:%d\n", name, len(f.Matches))
+		default:
+			for _, m := range f.Matches {
+				if lineNumbers {
+					fmt.Printf("%s:%d:%s\n", name, m.Line, m.Text)
+				} else {
+					fmt.Printf("%s:%s\n", name, m.Text)
+				}
+			}
+		}
+	}
+}
+
+// relativeTo returns the slash-separated path name as seen from the directory prefix
+func relativeTo(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	up := ""
+	for dir := prefix; dir != "."; dir = path.Dir(dir) {
+		if strings.HasPrefix(name, dir+"/") {
+			return up + strings.TrimPrefix(name, dir+"/")
+		}
+		up += "../"
+	}
+	return up + name
+}
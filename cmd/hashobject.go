@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// hashOptions say how hash-object turns content into an object
+type hashOptions struct {
+	typ       string
+	write     bool
+	literally bool
+	noFilters bool
+	path      string
+}
+
+// Usage: hash-object [-t <type>] [-w] [--path=<file>|--no-filters] [--literally] [--stdin] [--] <file>...
+//        hash-object [-t <type>] [-w] [--no-filters] --stdin-paths
+func cmdHashObject(dir string, args []string) {
+	opts := hashOptions{typ: "blob"}
+	stdin, stdinPaths := false, false
+	var files []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-t" || arg == "--path":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "fatal: %s requires a value\n", arg)
+				os.Exit(129)
+			}
+			i++
+			if arg == "-t" {
+				opts.typ = args[i]
+			} else {
+				opts.path = args[i]
+			}
+		case strings.HasPrefix(arg, "--type="):
+			opts.typ = strings.TrimPrefix(arg, "--type=")
+		case strings.HasPrefix(arg, "--path="):
+			opts.path = strings.TrimPrefix(arg, "--path=")
+		case arg == "-w":
+			opts.write = true
+		case arg == "--stdin":
+			stdin = true
+		case arg == "--stdin-paths":
+			stdinPaths = true
+		case arg == "--no-filters":
+			opts.noFilters = true
+		case arg == "--literally":
+			opts.literally = true
+		case arg == "--":
+			files = append(files, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			files = append(files, arg)
+		}
+	}
+	switch {
+	case stdinPaths && stdin:
+		fmt.Fprintln(os.Stderr, "error: Can't use --stdin-paths with --stdin")
+		os.Exit(129)
+	case stdinPaths && len(files) > 0:
+		fmt.Fprintln(os.Stderr, "error: Can't specify files with --stdin-paths")
+		os.Exit(129)
+	case stdinPaths && opts.path != "":
+		fmt.Fprintln(os.Stderr, "error: Can't use --stdin-paths with --path")
+		os.Exit(129)
+	case opts.path != "" && opts.noFilters:
+		fmt.Fprintln(os.Stderr, "error: Can't use --path with --no-filters")
+		os.Exit(129)
+	}
+
+	// hashing works outside a repository; writing does not
+	r, err := repo.RepoFind(dir, opts.write)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	var f *filter.Filter
+	if r != nil && r.Worktree != "" && !opts.noFilters {
+		f = filter.New(r)
+	}
+
+	if stdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		// content from stdin is only filtered when --path names it
+		name := ""
+		if opts.path != "" {
+			name = hashPath(r, dir, opts.path)
+		}
+		if !hashData(r, f, opts, name, data) {
+			os.Exit(128)
+		}
+	}
+	for _, file := range files {
+		if !hashFile(r, f, dir, opts, file) {
+			os.Exit(128)
+		}
+	}
+	if stdinPaths {
+		lines := bufio.NewScanner(os.Stdin)
+		for lines.Scan() {
+			if !hashFile(r, f, dir, opts, lines.Text()) {
+				os.Exit(128)
+			}
+		}
+	}
+}
+
+// hashFile prints the id of a file's content, filtered as the file it is or --path says it is
+func hashFile(r *repo.Gitrepo, f *filter.Filter, dir string, opts hashOptions, file string) bool {
+	abs := file
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(dir, file)
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		fmt.Fprintf(os.Stderr, "fatal: could not open '%s' for reading: %v\n", file, err)
+		return false
+	}
+	name := opts.path
+	if name == "" {
+		name = file
+	}
+	return hashData(r, f, opts, hashPath(r, dir, name), data)
+}
+
+// hashData prints the id of data as an object; blobs with a name go through the clean filters
+func hashData(r *repo.Gitrepo, f *filter.Filter, opts hashOptions, name string, data []byte) bool {
+	if opts.typ == "blob" && name != "" && f != nil {
+		var err error
+		data, err = f.ToGit(name, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			return false
+		}
+	}
+	sha, err := object.HashObject(r, opts.typ, data, opts.write, opts.literally)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		return false
+	}
+	fmt.Println(sha)
+	return true
+}
+
+// hashPath returns the path of a file relative to the worktree, which attributes are looked up by
+// Files outside the worktree keep the name they were given.
+func hashPath(r *repo.Gitrepo, dir, file string) string {
+	if r == nil || r.Worktree == "" {
+		return filepath.ToSlash(file)
+	}
+	abs := file
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(dir, file)
+	}
+	rel, err := filepath.Rel(r.Worktree, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -3,77 +3,58 @@ package main
 import (
 	"fmt"
 	"os"
-	"github.com/Blue-Onion/pygo/hanlder/object"
+	"strings"
+
 	"github.com/Blue-Onion/pygo/hanlder/repo"
 )
 
-func cmdInit(path string) (*repo.Gitrepo, error) {
+func cmdInit(path string, bare bool, objectFormat string) (*repo.Gitrepo, error) {
 
-	r, err := repo.RepoCreate(path)
+	format, err := repo.FormatByName(objectFormat)
 	if err != nil {
 		return nil, err
 	}
-	return r, nil
-}
-func cmdCatFile(path string, name string, typ string) {
-	repo, err := repo.RepoFind(path, true)
+	r, err := repo.RepoCreate(path, bare, format)
 	if err != nil {
-		fmt.Println(err.Error())
-		return
+		return nil, err
 	}
-
-	object.CatFile(repo, name, typ)
-	
-
-
+	return r, nil
 }
-func cmdHashObject(path string, args []string) {
-	repo, err := repo.RepoFind(path, true)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	if len(args) < 1 {
-		fmt.Println("Usage: hash-object [-t type] <file>")
-		return
-	}
-
-	typ := "blob" // default like real git
-	file := ""
-
-	for i := 0; i < len(args); i++ {
-
-		switch args[i] {
-		case "-t":
-			if i+1 >= len(args) {
-				fmt.Println("Missing type after -t")
-				return
+// parseGlobalOptions consumes the options given before the command name
+// --git-dir and --work-tree are passed on through the environment, like git does
+func parseGlobalOptions(args []string) ([]string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		opt, value, hasValue := strings.Cut(args[0], "=")
+		if opt != "--git-dir" && opt != "--work-tree" {
+			return nil, fmt.Errorf("unknown option: %s", args[0])
+		}
+		args = args[1:]
+		if !hasValue {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("no directory given for %s", opt)
 			}
-			typ = args[i+1]
-			i++
-		default:
-			file = args[i]
+			value = args[0]
+			args = args[1:]
+		}
+		env := "GIT_DIR"
+		if opt == "--work-tree" {
+			env = "GIT_WORK_TREE"
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return nil, err
 		}
 	}
+	return args, nil
+}
 
-	if file == "" {
-		fmt.Println("No file specified")
-		return
-	}
-
-	sha, err := object.ObjectHash(file, typ, repo)
+func main() {
+	var path string
+	args, err := parseGlobalOptions(os.Args[1:])
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Println(sha)
-}
-func main() {
-	var path string
-	args := os.Args[1:]
-
 	if len(args) < 1 {
 		fmt.Println("Invalid command.")
 		return
@@ -92,11 +73,29 @@ func main() {
 	switch cmd {
 
 	case "init":
-		if len(args) >= 2 {
-			path = args[1]
+		// Usage: init [--bare] [--object-format=<sha1|sha256>] [path]
+		bare := false
+		objectFormat := ""
+		for i := 1; i < len(args); i++ {
+			arg := args[i]
+			switch {
+			case arg == "--bare":
+				bare = true
+			case arg == "--object-format":
+				if i+1 >= len(args) {
+					fmt.Println("Missing format after --object-format")
+					return
+				}
+				objectFormat = args[i+1]
+				i++
+			case strings.HasPrefix(arg, "--object-format="):
+				objectFormat = strings.TrimPrefix(arg, "--object-format=")
+			default:
+				path = arg
+			}
 		}
 
-		_, err := cmdInit(path)
+		_, err := cmdInit(path, bare, objectFormat)
 		if err != nil {
 			fmt.Println("Error initializing repo:", err)
 			return
@@ -104,42 +103,65 @@ func main() {
 		fmt.Println("Repository initialized successfully!")
 
 	case "cat-file":
-
-		if len(args) < 3 {
-			fmt.Println("Usage: cat-file <type> <object> [path]")
-			return
-		}
-
-		tag := args[1]
-		name := args[2]
-
-		if len(args) >= 4 {
-			path = args[3]
-		}
-		cmdCatFile(path, name, tag)
+		cmdCatFile(path, args[1:])
 	case "hash-object":
-		// Usage: hash-object [-t type] [-w] <file> [path]
-		if len(args) < 2 {
-			fmt.Println("Usage: hash-object [-t type] [-w] <file> [path]")
-			return
-		}
-	
-		// Optional repo path as last argument
-		if len(args) >= 3 {
-			last := args[len(args)-1]
-			if info, err := os.Stat(last); err == nil && info.IsDir() {
-				path = last
-				args = args[1 : len(args)-1]
-			} else {
-				args = args[1:]
-			}
-		} else {
-			args = args[1:]
-		}
-	
-		cmdHashObject(path, args)
+		cmdHashObject(path, args[1:])
+	case "branch":
+		cmdBranch(path, args[1:])
+	case "switch":
+		cmdSwitch(path, args[1:])
+	case "checkout":
+		cmdCheckout(path, args[1:])
+	case "tag":
+		cmdTag(path, args[1:])
+	case "reflog":
+		cmdReflog(path, args[1:])
+	case "reset":
+		cmdReset(path, args[1:])
+	case "restore":
+		cmdRestore(path, args[1:])
+	case "cherry-pick":
+		cmdCherryPick(path, args[1:])
+	case "revert":
+		cmdRevert(path, args[1:])
+	case "stash":
+		cmdStash(path, args[1:])
+	case "rebase":
+		cmdRebase(path, args[1:])
+	case "blame":
+		cmdBlame(path, args[1:])
+	case "bisect":
+		cmdBisect(path, args[1:])
+	case "grep":
+		cmdGrep(path, args[1:])
+	case "archive":
+		cmdArchive(path, args[1:])
+	case "show":
+		cmdShow(path, args[1:])
+	case "mktree":
+		cmdMktree(path, args[1:])
+	case "commit-tree":
+		cmdCommitTree(path, args[1:])
+	case "read-tree":
+		cmdReadTree(path, args[1:])
+	case "write-tree":
+		cmdWriteTree(path, args[1:])
+	case "for-each-ref":
+		cmdForEachRef(path, args[1:])
+	case "rev-list":
+		cmdRevList(path, args[1:])
+	case "clone":
+		cmdClone(path, args[1:])
+	case "fetch":
+		cmdFetch(path, args[1:])
+	case "push":
+		cmdPush(path, args[1:])
+	case "upload-pack":
+		cmdUploadPack(args[1:])
+	case "receive-pack":
+		cmdReceivePack(args[1:])
 	default:
-		fmt.Println("Invalid command. Available commands: init, cat-file")
+		fmt.Println("Invalid command. Available commands: init, cat-file, hash-object, branch, switch, checkout, tag, reflog, reset, restore, cherry-pick, revert, rebase, stash, blame, bisect, grep, archive, show, mktree, commit-tree, read-tree, write-tree, for-each-ref, rev-list, clone, fetch, push, upload-pack, receive-pack")
 	}
 }
 
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: mktree [-z] [--missing] [--batch]
+// Reads ls-tree lines, "<mode> <type> <object>\t<name>", from stdin and writes them as a tree.
+// With --batch a blank line ends one tree and starts the next.
+func cmdMktree(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	nul, missing, batch := false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-z":
+			nul = true
+		case "--missing":
+			missing = true
+		case "--batch":
+			batch = true
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		}
+	}
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+	in := bufio.NewReader(os.Stdin)
+	for done := false; !done; {
+		t := &object.Tree{Fmt: []byte("tree"), HashSize: repo.Format(r).Size}
+		for {
+			line, err := in.ReadString(sep)
+			if err != nil && err != io.EOF {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			if line == "" && err == io.EOF {
+				done = true
+				break
+			}
+			line = strings.TrimSuffix(line, string(sep))
+			if line == "" {
+				if nul || batch {
+					break
+				}
+				fmt.Fprintln(os.Stderr, "fatal: input format error: (blank line only valid in batch mode)")
+				os.Exit(128)
+			}
+			e, err := mktreeEntry(r, line, nul, missing)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			t.Data = append(t.Data, e)
+		}
+		if batch && done && len(t.Data) == 0 {
+			break
+		}
+		sha, err := object.ObjectWrite(r, t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		fmt.Println(sha)
+	}
+}
+
+// mktreeEntry parses one ls-tree line and checks that its mode, type and object agree
+// The object may be missing with --missing; submodule commits are never looked up.
+func mktreeEntry(r *repo.Gitrepo, line string, nul, missing bool) (object.TreeData, error) {
+	formatErr := fmt.Errorf("input format error: %s", line)
+	info, name, ok := strings.Cut(line, "\t")
+	fields := strings.Split(info, " ")
+	if !ok || len(fields) != 3 {
+		return object.TreeData{}, formatErr
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil || !repo.Format(r).IsHexID(fields[2]) {
+		return object.TreeData{}, formatErr
+	}
+	typ, sha := fields[1], strings.ToLower(fields[2])
+	if !nul && strings.HasPrefix(name, "\"") {
+		if name, err = strconv.Unquote(name); err != nil {
+			return object.TreeData{}, fmt.Errorf("invalid quoting: %s", line)
+		}
+	}
+	if strings.Contains(name, "/") {
+		return object.TreeData{}, fmt.Errorf("path %s contains slash", name)
+	}
+
+	modeType := "blob"
+	switch {
+	case object.IsTreeMode(uint32(mode)):
+		modeType = "tree"
+	case mode&0170000 == 0160000:
+		modeType = "commit"
+	}
+	switch typ {
+	case "blob", "tree", "commit", "tag":
+	default:
+		return object.TreeData{}, fmt.Errorf("invalid object type \"%s\"", typ)
+	}
+	if typ != modeType {
+		return object.TreeData{}, fmt.Errorf("entry '%s' object type (%s) doesn't match mode type (%s)", name, typ, modeType)
+	}
+	if actual, _, err := object.ObjectHeader(r, sha); err != nil {
+		if !missing && modeType != "commit" {
+			return object.TreeData{}, fmt.Errorf("entry '%s' object %s is unavailable", name, sha)
+		}
+	} else if actual != typ {
+		return object.TreeData{}, fmt.Errorf("entry '%s' object %s is a %s but specified type was (%s)", name, sha, actual, typ)
+	}
+	raw, _ := hex.DecodeString(sha)
+	return object.TreeData{Mode: []byte(strconv.FormatUint(mode, 8)), Name: []byte(name), Sha: raw}, nil
+}
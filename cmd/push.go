@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/remote"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: push [-f|--force] [-u|--set-upstream] [--tags] [-q|--quiet] [--progress] [--receive-pack=<exec>] [<remote> [<refspec>...]]
+//        push (-d|--delete) <remote> <ref>...
+// Updates the references of the remote from local ones, sending the objects they need;
+// without refspecs the current branch goes to its upstream.
+func cmdPush(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+
+	var opts remote.PushOptions
+	tags, del, quiet, progress := false, false, false, false
+	receivePack := ""
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case arg == "--progress":
+			progress = true
+		case strings.HasPrefix(arg, "--receive-pack="):
+			receivePack = strings.TrimPrefix(arg, "--receive-pack=")
+		case arg == "-f" || arg == "--force":
+			opts.Force = true
+		case arg == "-u" || arg == "--set-upstream":
+			opts.SetUpstream = true
+		case arg == "--tags":
+			tags = true
+		case arg == "-d" || arg == "--delete":
+			del = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+	name := remote.DefaultName(r)
+	if len(names) > 0 {
+		name, names = names[0], names[1:]
+	}
+	rem, err := remote.Get(r, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if receivePack != "" {
+		rem.ReceivePack = receivePack
+	}
+	opts.Progress = remoteProgress(quiet, progress)
+	if del && len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "fatal: --delete doesn't make sense without any refs")
+		os.Exit(128)
+	}
+	var specs []remote.Refspec
+	for _, s := range names {
+		if del {
+			s = ":" + s
+		}
+		rs, err := remote.ParseRefspec(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		specs = append(specs, rs)
+	}
+	if tags {
+		specs = append(specs, remote.Refspec{Src: "refs/tags/*", Dst: "refs/tags/*"})
+	}
+	if len(specs) == 0 {
+		if specs, err = remote.DefaultPush(r, rem); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			if head, _, err := refs.Head(r); err == nil && strings.HasPrefix(head, "refs/heads/") {
+				fmt.Fprintln(os.Stderr, "To push the current branch and set the remote as upstream, use")
+				fmt.Fprintln(os.Stderr)
+				fmt.Fprintf(os.Stderr, "    push --set-upstream %s %s\n", name, strings.TrimPrefix(head, "refs/heads/"))
+			}
+			os.Exit(128)
+		}
+	}
+
+	res, err := remote.Push(r, rem, specs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintf(os.Stderr, "error: failed to push some refs to '%s'\n", rem.URL)
+		os.Exit(1)
+	}
+	lines := res.Lines()
+	if len(lines) == 0 {
+		fmt.Println("Everything up-to-date")
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if res.Failed() {
+		fmt.Fprintf(os.Stderr, "error: failed to push some refs to '%s'\n", rem.URL)
+		for _, u := range res.Updates {
+			switch u.Reason {
+			case "non-fast-forward":
+				fmt.Fprintln(os.Stderr, "hint: Updates were rejected because the tip of your current branch is behind")
+				fmt.Fprintln(os.Stderr, "hint: its remote counterpart. Integrate the remote changes before pushing again.")
+			case "fetch first":
+				fmt.Fprintln(os.Stderr, "hint: Updates were rejected because the remote contains work that you do not")
+				fmt.Fprintln(os.Stderr, "hint: have locally. Fetch the remote changes before pushing again.")
+			case "already exists":
+				fmt.Fprintln(os.Stderr, "hint: Updates were rejected because the tag already exists in the remote.")
+			default:
+				continue
+			}
+			break
+		}
+		os.Exit(1)
+	}
+	for _, line := range res.Upstream {
+		fmt.Println(line)
+	}
+}
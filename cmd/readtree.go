@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/readtree"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: read-tree [(-m [--aggressive] | --reset | --prefix=<prefix>) [-u | -i]] [-n] <tree-ish>...
+//        read-tree --empty
+// Reads trees into the index. With -m one tree is merged with the index, two move the index
+// from the first to the second and three merge base, ours and theirs.
+func cmdReadTree(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	var opts readtree.Options
+	empty := false
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-m":
+			opts.Merge = true
+		case arg == "--reset":
+			opts.Reset = true
+		case arg == "-u":
+			opts.Update = true
+		case arg == "-i":
+			opts.IndexOnly = true
+		case arg == "-n" || arg == "--dry-run":
+			opts.DryRun = true
+		case arg == "--aggressive":
+			opts.Aggressive = true
+		case arg == "--empty":
+			empty = true
+		case strings.HasPrefix(arg, "--prefix="):
+			opts.Prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	modes := 0
+	for _, set := range []bool{opts.Merge, opts.Reset, opts.Prefix != ""} {
+		if set {
+			modes++
+		}
+	}
+	switch {
+	case empty && len(names) > 0:
+		fmt.Fprintln(os.Stderr, "fatal: passing trees as arguments contradicts --empty")
+		os.Exit(128)
+	case modes > 1:
+		fmt.Fprintln(os.Stderr, "fatal: Which one? -m, --reset, or --prefix?")
+		os.Exit(128)
+	case opts.Update && opts.IndexOnly:
+		fmt.Fprintln(os.Stderr, "fatal: -u and -i at the same time makes no sense")
+		os.Exit(128)
+	case opts.Update && modes == 0:
+		fmt.Fprintln(os.Stderr, "fatal: -u is meaningless without -m, --reset, or --prefix")
+		os.Exit(128)
+	case opts.IndexOnly && modes == 0:
+		fmt.Fprintln(os.Stderr, "fatal: -i is meaningless without -m, --reset, or --prefix")
+		os.Exit(128)
+	case len(names) > readtree.MaxTrees:
+		fmt.Fprintf(os.Stderr, "fatal: I cannot read more than %d trees\n", readtree.MaxTrees)
+		os.Exit(128)
+	case modes > 0 && len(names) == 0:
+		fmt.Fprintln(os.Stderr, "fatal: you must specify at least one tree to merge")
+		os.Exit(128)
+	case opts.Prefix != "" && len(names) > 1:
+		fmt.Fprintln(os.Stderr, "fatal: --prefix reads exactly one tree")
+		os.Exit(128)
+	}
+
+	var trees []string
+	for _, name := range names {
+		sha, err := object.ObjectFind(r, name, "")
+		if err == nil {
+			sha, err = object.Peel(r, sha, "tree")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal: Not a valid object name", name)
+			os.Exit(128)
+		}
+		trees = append(trees, sha)
+	}
+	if len(trees) == 0 && !empty {
+		fmt.Fprintln(os.Stderr, "warning: read-tree: emptying the index with no arguments is deprecated; use --empty")
+	}
+	if err := readtree.Read(r, trees, opts); err != nil {
+		if err == readtree.ErrUnmerged {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(128)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/branch"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/rebase"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: rebase [-i] [--onto <newbase>] [-x <cmd>]... [--autosquash|--no-autosquash] [<upstream> [<branch>]]
+//        rebase (--continue|--skip|--abort|--quit)
+func cmdRebase(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	opts := rebase.Options{Autosquash: repo.ConfigBool(r, "rebase", "autosquash")}
+	var rest []string
+	control := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--continue" || arg == "--skip" || arg == "--abort" || arg == "--quit":
+			control = arg
+		case arg == "-i" || arg == "--interactive":
+			opts.Interactive = true
+		case arg == "--autosquash":
+			opts.Autosquash = true
+		case arg == "--no-autosquash":
+			opts.Autosquash = false
+		case arg == "--onto" || arg == "-x" || arg == "--exec":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "option", arg, "requires a value")
+				os.Exit(129)
+			}
+			i++
+			if arg == "--onto" {
+				opts.Onto = args[i]
+			} else {
+				opts.Exec = append(opts.Exec, args[i])
+			}
+		case strings.HasPrefix(arg, "--onto="):
+			opts.Onto = strings.TrimPrefix(arg, "--onto=")
+		case strings.HasPrefix(arg, "--exec="):
+			opts.Exec = append(opts.Exec, strings.TrimPrefix(arg, "--exec="))
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	var res *rebase.Result
+	switch control {
+	case "--continue":
+		res, err = rebase.Continue(r, os.Stdout)
+	case "--skip":
+		res, err = rebase.Skip(r, os.Stdout)
+	case "--abort":
+		err = rebase.Abort(r)
+	case "--quit":
+		err = rebase.Quit(r)
+	default:
+		if len(rest) > 2 {
+			fmt.Fprintln(os.Stderr, "Usage: rebase [-i] [--onto <newbase>] [-x <cmd>] [<upstream> [<branch>]]")
+			os.Exit(129)
+		}
+		if len(rest) == 2 {
+			opts.Branch = rest[1]
+		}
+		upstream := ""
+		if len(rest) > 0 {
+			upstream = rest[0]
+		} else {
+			current, _, err := refs.Head(r)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+			if current == "" {
+				fmt.Fprintln(os.Stderr, "fatal: no upstream given and HEAD is detached")
+				os.Exit(128)
+			}
+			if upstream, err = branch.Upstream(r, refs.Shorten(current)); err != nil {
+				fmt.Fprintln(os.Stderr, "fatal:", err)
+				os.Exit(128)
+			}
+		}
+		res, err = rebase.Start(r, upstream, opts, os.Stdout)
+	}
+
+	if res != nil {
+		for _, msg := range res.Messages {
+			fmt.Println(msg)
+		}
+	}
+	var stop *rebase.StopError
+	switch {
+	case errors.As(err, &stop):
+		printRebaseStop(stop)
+		// a stop to edit is what was asked for; conflicts and failed commands are not
+		if stop.Reason == rebase.StopConflict || stop.Reason == rebase.StopExec {
+			os.Exit(1)
+		}
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	case res == nil:
+	case res.UpToDate:
+		fmt.Printf("Current branch %s is up to date.\n", refs.Shorten(res.Branch))
+	case res.Branch != "":
+		fmt.Printf("Successfully rebased and updated %s.\n", res.Branch)
+	default:
+		fmt.Println("Successfully rebased and updated detached HEAD.")
+	}
+}
+
+// printRebaseStop explains why a rebase stopped and how to go on
+func printRebaseStop(stop *rebase.StopError) {
+	for _, msg := range stop.Messages {
+		fmt.Println(msg)
+	}
+	switch stop.Reason {
+	case rebase.StopConflict:
+		fmt.Println("error:", stop)
+		fmt.Println("hint: Resolve all conflicts manually, mark them as resolved with")
+		fmt.Println("hint: \"git add/rm <conflicted_files>\", then run \"rebase --continue\".")
+		fmt.Println("hint: You can instead skip this commit: run \"rebase --skip\".")
+		fmt.Println("hint: To abort and get back to the state before \"rebase\", run \"rebase --abort\".")
+	case rebase.StopEdit:
+		fmt.Printf("Stopped at %s...  %s\n", object.Abbrev(stop.Command.Sha), stop.Command.Subject)
+		fmt.Println("You can amend the commit now by staging your changes; then run")
+		fmt.Println()
+		fmt.Println("  rebase --continue")
+	case rebase.StopExec:
+		fmt.Println("warning:", stop)
+		fmt.Println("You can fix the problem, and then run")
+		fmt.Println()
+		fmt.Println("  rebase --continue")
+	default:
+		fmt.Println("Stopped at HEAD")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Blue-Onion/pygo/hanlder/remote"
+)
+
+// Usage: receive-pack <directory>
+// Serves a push to the repository over standard input and output, storing the pack it is
+// sent and updating the references whose objects are all there.
+func cmdReceivePack(args []string) {
+	r := servedRepo("receive-pack", args)
+	if err := remote.ServeReceivePack(r, os.Stdin, os.Stdout, os.Stderr, remote.ProtocolVersion(os.Getenv("GIT_PROTOCOL"))); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// defaultReflogExpire is how long entries are kept by reflog expire, like gc.reflogExpire
+const defaultReflogExpire = "90.days.ago"
+
+// Usage: reflog [show] [<ref>]
+//        reflog expire [--expire=<time>] [--dry-run] (--all | <ref>...)
+//        reflog delete [--rewrite] [--updateref] <ref>@{<n>}...
+func cmdReflog(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sub := "show"
+	if len(args) > 0 && (args[0] == "show" || args[0] == "expire" || args[0] == "delete") {
+		sub, args = args[0], args[1:]
+	}
+	switch sub {
+	case "show":
+		name := "HEAD"
+		if len(args) > 0 {
+			name = args[0]
+		}
+		showReflog(r, name)
+	case "expire":
+		expireReflogs(r, args)
+	case "delete":
+		deleteReflogEntries(r, args)
+	}
+}
+
+// reflogRef expands a short ref name for the reflog commands
+func reflogRef(r *repo.Gitrepo, name string) (string, error) {
+	if name == "HEAD" {
+		return name, nil
+	}
+	full, err := refs.Expand(r, name)
+	if err != nil {
+		return "", fmt.Errorf("unknown ref: %s", name)
+	}
+	return full, nil
+}
+
+func showReflog(r *repo.Gitrepo, name string) {
+	full, err := reflogRef(r, name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	entries, err := refs.ReadReflog(r, full)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	display := refs.Shorten(full)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Printf("%s %s@{%d}: %s\n", object.Abbrev(e.New), display, len(entries)-1-i, e.Message)
+	}
+}
+
+func expireReflogs(r *repo.Gitrepo, args []string) {
+	expire := defaultReflogExpire
+	if v, ok := repo.ConfigGet(r, "gc", "reflogexpire"); ok {
+		expire = v
+	}
+	all, dryRun := false, false
+	var names []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--expire="):
+			expire = strings.TrimPrefix(arg, "--expire=")
+		case arg == "--all":
+			all = true
+		case arg == "-n" || arg == "--dry-run":
+			dryRun = true
+		case arg == "--verbose":
+		default:
+			names = append(names, arg)
+		}
+	}
+	var before time.Time
+	switch expire {
+	case "never", "false":
+		return
+	case "all", "now":
+		before = time.Now().Add(time.Second)
+	default:
+		var err error
+		before, err = refs.ParseApproxDate(expire, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if all {
+		list, err := refs.ListReflogs(r)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		names = list
+	} else {
+		for i, name := range names {
+			full, err := reflogRef(r, name)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			names[i] = full
+		}
+	}
+	for _, name := range names {
+		n, err := refs.ExpireReflog(r, name, before, dryRun)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if n > 0 {
+			verb := "expired"
+			if dryRun {
+				verb = "would expire"
+			}
+			fmt.Printf("%s: %s %d entries\n", name, verb, n)
+		}
+	}
+}
+
+func deleteReflogEntries(r *repo.Gitrepo, args []string) {
+	rewrite, updateRef := false, false
+	type target struct {
+		ref string
+		n   int
+	}
+	var targets []target
+	for _, arg := range args {
+		switch arg {
+		case "--rewrite":
+			rewrite = true
+		case "--updateref":
+			updateRef = true
+		case "-n", "--dry-run", "--verbose":
+		default:
+			i := strings.Index(arg, "@{")
+			if i == -1 || !strings.HasSuffix(arg, "}") {
+				fmt.Printf("not a reflog: %s\n", arg)
+				return
+			}
+			n, err := strconv.Atoi(arg[i+2 : len(arg)-1])
+			if err != nil {
+				fmt.Printf("invalid reflog entry: %s\n", arg)
+				return
+			}
+			name := arg[:i]
+			if name == "" {
+				name = "HEAD"
+			}
+			full, err := reflogRef(r, name)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			targets = append(targets, target{full, n})
+		}
+	}
+	// remove the highest indexes first so the others keep their numbers
+	sort.Slice(targets, func(i, j int) bool { return targets[i].n > targets[j].n })
+	for _, t := range targets {
+		if err := refs.DeleteReflogEntry(r, t.ref, t.n, rewrite, updateRef); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
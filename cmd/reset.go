@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/reset"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// splitRevAndPaths separates an optional revision from the paths that follow it
+// Everything after "--" is a path; without "--" the first argument is a revision if it
+// resolves, and must otherwise name a file in the worktree, relative to cwd, or be a
+// pattern, as git refuses to guess.
+func splitRevAndPaths(r *repo.Gitrepo, cwd string, args []string) (string, []string, error) {
+	for i, arg := range args {
+		if arg == "--" {
+			rev := ""
+			if i > 0 {
+				rev = args[0]
+			}
+			return rev, args[i+1:], nil
+		}
+	}
+	if len(args) == 0 {
+		return "", nil, nil
+	}
+	_, err := os.Lstat(filepath.Join(cwd, args[0]))
+	isFile := err == nil
+	if _, err := object.ObjectFind(r, args[0], ""); err == nil {
+		if isFile {
+			return "", nil, fmt.Errorf("ambiguous argument '%s': both revision and filename", args[0])
+		}
+		return args[0], args[1:], nil
+	}
+	if !isFile && !strings.ContainsAny(args[0], "*?[") && !strings.HasPrefix(args[0], ":") {
+		return "", nil, fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree.", args[0])
+	}
+	return "", args, nil
+}
+
+// Usage: reset [--soft|--mixed|--hard|--keep] [-q] [<commit>]
+//        reset [-q] [<commit>] [--] <paths>...
+func cmdReset(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	mode, modeGiven, quiet := reset.Mixed, false, false
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if m, ok := reset.ParseMode(arg); ok {
+			mode, modeGiven = m, true
+			continue
+		}
+		if arg == "-q" || arg == "--quiet" {
+			quiet = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		}
+		rest = append(rest, arg)
+	}
+	rev, paths, err := splitRevAndPaths(r, path, rest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		fmt.Fprintln(os.Stderr, "Use '--' to separate paths from revisions, like this:")
+		fmt.Fprintln(os.Stderr, "'git <command> [<revision>...] -- [<file>...]'")
+		os.Exit(128)
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+
+	if len(paths) > 0 {
+		if modeGiven && mode != reset.Mixed {
+			fmt.Fprintln(os.Stderr, "fatal: Cannot do hard, soft or keep reset with paths.")
+			os.Exit(128)
+		}
+		ps, err := pathspec.Parse(r.Worktree, path, paths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		if _, err := reset.ResetPaths(r, rev, ps); err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		if !quiet {
+			printUnstaged(r)
+		}
+		return
+	}
+
+	sha, err := reset.Reset(r, rev, mode)
+	var overwrite *worktree.OverwriteError
+	if errors.As(err, &overwrite) {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		fmt.Fprintf(os.Stderr, "fatal: Could not reset index file to revision '%s'.\n", rev)
+		os.Exit(128)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	if quiet {
+		return
+	}
+	switch mode {
+	case reset.Hard, reset.Keep:
+		fmt.Printf("HEAD is now at %s %s\n", object.Abbrev(sha), commitSubject(r, sha))
+	case reset.Mixed:
+		printUnstaged(r)
+	}
+}
+
+// printUnstaged lists the worktree files that differ from the index
+func printUnstaged(r *repo.Gitrepo) {
+	idx, err := index.Read(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return
+	}
+	changes, err := worktree.Unstaged(r, filter.New(r), idx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Println("Unstaged changes after reset:")
+	for _, c := range changes {
+		fmt.Printf("%c\t%s\n", c.Status, c.Name)
+	}
+}
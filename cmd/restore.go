@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/restore"
+)
+
+// Usage: restore [-s <tree-ish>|--source=<tree-ish>] [-S|--staged] [-W|--worktree] [--ours|--theirs] [--] <pathspec>...
+func cmdRestore(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var opts restore.Options
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "-S" || arg == "--staged":
+			opts.Staged = true
+		case arg == "-W" || arg == "--worktree":
+			opts.Worktree = true
+		case arg == "-SW" || arg == "-WS":
+			opts.Staged, opts.Worktree = true, true
+		case arg == "--ours":
+			opts.Stage = 2
+		case arg == "--theirs":
+			opts.Stage = 3
+		case arg == "-s" || arg == "--source":
+			if i+1 >= len(args) {
+				fmt.Println("option", arg, "requires a value")
+				return
+			}
+			i++
+			opts.Source = args[i]
+		case strings.HasPrefix(arg, "--source="):
+			opts.Source = strings.TrimPrefix(arg, "--source=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Println("fatal: you must specify path(s) to restore")
+		return
+	}
+	if opts.Stage != 0 && (opts.Staged || opts.Source != "") {
+		fmt.Println("fatal: --ours and --theirs only restore the worktree from the index")
+		return
+	}
+	ps, err := pathspec.Parse(r.Worktree, path, paths)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if _, err := restore.Restore(r, ps, opts); err != nil {
+		if errors.Is(err, restore.ErrNoMatch) {
+			fmt.Printf("error: pathspec '%s' did not match any file(s) known to git\n", strings.Join(paths, " "))
+			return
+		}
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/revision"
+)
+
+// Usage: rev-list [--count] [--objects] [--topo-order|--date-order] [--first-parent] [--ancestry-path]
+//        [--since=<date>] [--until=<date>] [--author=<re>] [--committer=<re>] [--grep=<re>] [-i]
+//        [-n <n>] [--skip=<n>] [--reverse] [--all] [--branches[=<glob>]] [--tags[=<glob>]]
+//        [--remotes[=<glob>]] [--not] <commit>... [--] [<path>...]
+// Prints the commits selected from the history, newest first, and with --objects the
+// trees and blobs they hold; --count prints how many there are instead.
+func cmdRevList(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w, rest, err := revision.Parse(r, dir, args)
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	count, objects := false, false
+	for _, arg := range rest {
+		switch arg {
+		case "--count":
+			count = true
+		case "--objects":
+			objects = true
+		default:
+			fmt.Println("Unknown option:", arg)
+			return
+		}
+	}
+	if w.Empty() {
+		fmt.Println("usage: rev-list [<options>] <commit>... [--] [<path>...]")
+		return
+	}
+	commits, err := w.Commits()
+	if err != nil {
+		fmt.Println("fatal:", err)
+		return
+	}
+	var list []revision.Object
+	if objects {
+		if list, err = w.Objects(commits); err != nil {
+			fmt.Println("fatal:", err)
+			return
+		}
+	}
+	if count {
+		fmt.Println(len(commits) + len(list))
+		return
+	}
+	for _, sha := range commits {
+		fmt.Println(sha)
+	}
+	for _, o := range list {
+		fmt.Println(o.Sha, o.Name)
+	}
+}
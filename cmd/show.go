@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/diff"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pretty"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// showOptions select what show prints for a commit
+type showOptions struct {
+	format     string
+	abbrev     bool
+	patch      bool
+	stat       bool
+	nameStatus bool
+	nameOnly   bool
+	context    int
+	renames    bool
+}
+
+// Usage: show [--format=<format>|--pretty=<format>|--oneline] [--abbrev-commit]
+//             [-s|--no-patch] [-p] [--stat] [--name-status|--name-only]
+//             [-U<n>] [--no-renames] [<object>...]
+func cmdShow(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	opts := showOptions{format: "medium", context: diff.DefaultContext, renames: true}
+	patch, noPatch := false, false
+	var revs []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			opts.format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--pretty="):
+			opts.format = strings.TrimPrefix(arg, "--pretty=")
+		case arg == "--pretty":
+			opts.format = "medium"
+		case arg == "--oneline":
+			opts.format, opts.abbrev = "oneline", true
+		case arg == "--abbrev-commit":
+			opts.abbrev = true
+		case arg == "--no-abbrev-commit":
+			opts.abbrev = false
+		case arg == "-s" || arg == "--no-patch":
+			noPatch = true
+		case arg == "-p" || arg == "-u" || arg == "--patch":
+			patch = true
+		case arg == "--stat":
+			opts.stat = true
+		case arg == "--name-status":
+			opts.nameStatus = true
+		case arg == "--name-only":
+			opts.nameOnly = true
+		case arg == "--no-renames":
+			opts.renames = false
+		case arg == "-M" || arg == "--find-renames":
+			opts.renames = true
+		case strings.HasPrefix(arg, "-U") || strings.HasPrefix(arg, "--unified="):
+			n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(arg, "-U"), "--unified="))
+			if err != nil || n < 0 {
+				fmt.Println("invalid context length:", arg)
+				return
+			}
+			opts.context, patch = n, true
+		case arg == "--":
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	// the patch is shown unless another kind of diff output or -s was asked for
+	opts.patch = patch || !opts.stat && !opts.nameStatus && !opts.nameOnly
+	if noPatch {
+		opts.patch, opts.stat, opts.nameStatus, opts.nameOnly = false, false, false, false
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	shown := 0
+	for _, rev := range revs {
+		sha, err := object.ObjectFind(r, rev, "")
+		if err != nil {
+			w.Flush()
+			fmt.Printf("fatal: bad revision '%s'\n", rev)
+			return
+		}
+		if err := showObject(w, r, rev, sha, opts, &shown); err != nil {
+			w.Flush()
+			fmt.Println("fatal:", err)
+			return
+		}
+	}
+}
+
+// showObject prints one object: tags followed by what they point at, commits with their
+// changes, the entries of trees and the content of blobs
+func showObject(w *bufio.Writer, r *repo.Gitrepo, rev, sha string, opts showOptions, shown *int) error {
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return err
+	}
+	switch o := obj.(type) {
+	case *object.Tag:
+		fmt.Fprintf(w, "tag %s\n", o.Name())
+		if tagger := o.Tagger(); tagger != "" {
+			person, when, err := repo.ParseIdent(tagger)
+			fmt.Fprintf(w, "Tagger: %s\n", person)
+			if err == nil {
+				fmt.Fprintf(w, "Date:   %s\n", when.Format(pretty.DefaultDate))
+			}
+		}
+		w.WriteString("\n")
+		if msg := string(o.Data.Message); msg != "" {
+			w.WriteString(strings.TrimRight(msg, "\n") + "\n\n")
+		}
+		return showObject(w, r, rev, o.Object(), opts, shown)
+	case *object.Commit:
+		return showCommit(w, r, sha, o, opts, shown)
+	case *object.Tree:
+		fmt.Fprintf(w, "tree %s\n\n", rev)
+		for _, e := range o.Data {
+			name := string(e.Name)
+			if mode, err := object.ParseMode(e.Mode); err == nil && object.IsTreeMode(mode) {
+				name += "/"
+			}
+			w.WriteString(name + "\n")
+		}
+	case *object.Blob:
+		w.Write(o.Data)
+	}
+	return nil
+}
+
+// showCommit prints a commit and what it changed since its first parent
+// Merges only get the diffstat against their first parent.
+func showCommit(w *bufio.Writer, r *repo.Gitrepo, sha string, c *object.Commit, opts showOptions, shown *int) error {
+	header, err := pretty.Commit(r, sha, c, opts.format, opts.abbrev)
+	if err != nil {
+		return err
+	}
+	// built-in formats other than oneline, and format: strings, are separated by a blank line
+	separated := opts.format != "oneline" && !strings.HasPrefix(opts.format, "tformat:") &&
+		(strings.HasPrefix(opts.format, "format:") || !strings.Contains(opts.format, "%"))
+	if *shown > 0 && separated {
+		w.WriteString("\n")
+	}
+	*shown++
+	w.WriteString(header)
+
+	parents := c.Parents()
+	if !(opts.patch || opts.stat || opts.nameStatus || opts.nameOnly) {
+		return nil
+	}
+	if len(parents) > 1 {
+		// a merge's combined diff only lists paths that differ from every parent,
+		// which this does not compute; a clean merge has none
+		if !opts.stat || opts.nameStatus || opts.nameOnly {
+			if header != "" && !strings.HasSuffix(header, "\n") {
+				w.WriteString("\n")
+			}
+			w.WriteString("\n")
+			return nil
+		}
+		opts.patch = false
+	}
+	parentTree := ""
+	if len(parents) > 0 {
+		parent, err := object.ReadCommit(r, parents[0])
+		if err != nil {
+			return err
+		}
+		parentTree = parent.TreeSha()
+	}
+	changes, err := diff.TreeChanges(r, parentTree, c.TreeSha(), opts.renames)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	if header != "" && !strings.HasSuffix(header, "\n") {
+		w.WriteString("\n")
+	}
+	// a oneline is followed straight by the changes, except for merges
+	oneline := opts.format == "oneline" && len(parents) < 2
+	switch {
+	case opts.nameStatus:
+		w.WriteString(separator(oneline, ""))
+		return diff.WriteNameStatus(w, changes)
+	case opts.nameOnly:
+		w.WriteString(separator(oneline, ""))
+		return diff.WriteNameOnly(w, changes)
+	}
+	if opts.stat {
+		// a diffstat followed by a patch is set off from the message by a "---" line
+		sep := ""
+		if opts.patch {
+			sep = "---\n"
+		}
+		w.WriteString(separator(oneline, sep))
+		if err := diff.WriteStat(w, r, changes, diff.StatWidth); err != nil {
+			return err
+		}
+		if !opts.patch {
+			return nil
+		}
+		w.WriteString("\n")
+	} else {
+		w.WriteString(separator(oneline, ""))
+	}
+	return diff.WritePatch(w, r, changes, opts.context)
+}
+
+// separator returns what goes between a commit's message and its changes:
+// nothing after a oneline, else sep or a blank line
+func separator(oneline bool, sep string) string {
+	if oneline {
+		return ""
+	}
+	if sep != "" {
+		return sep
+	}
+	return "\n"
+}
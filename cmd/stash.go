@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/stash"
+)
+
+// Usage: stash [push] [-k|--keep-index] [-u|--include-untracked] [-q] [-m <message>]
+//        stash list
+//        stash (apply|pop) [--index] [-q] [<stash>]
+//        stash drop [-q] [<stash>]
+//        stash clear
+func cmdStash(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sub := "push"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	quiet, keepIndex, untracked, restoreIndex := false, false, false, false
+	message := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case (arg == "-k" || arg == "--keep-index") && sub == "push":
+			keepIndex = true
+		case arg == "--no-keep-index" && sub == "push":
+			keepIndex = false
+		case (arg == "-u" || arg == "--include-untracked") && sub == "push":
+			untracked = true
+		case arg == "--index" && (sub == "apply" || sub == "pop"):
+			restoreIndex = true
+		case (arg == "-m" || arg == "--message") && sub == "push":
+			if i+1 >= len(args) {
+				fmt.Println("option", arg, "requires a value")
+				return
+			}
+			i++
+			message = args[i]
+		case strings.HasPrefix(arg, "--message=") && sub == "push":
+			message = strings.TrimPrefix(arg, "--message=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown option:", arg)
+			return
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	name := ""
+	switch {
+	case sub == "push" || sub == "list" || sub == "clear":
+		if len(rest) > 0 {
+			fmt.Printf("Too many arguments for stash %s\n", sub)
+			return
+		}
+	case len(rest) > 1:
+		fmt.Println("Too many revisions specified:", strings.Join(rest, " "))
+		return
+	case len(rest) == 1:
+		name = rest[0]
+	}
+
+	switch sub {
+	case "push":
+		e, err := stash.Push(r, stash.PushOptions{Message: message, KeepIndex: keepIndex, IncludeUntracked: untracked})
+		if errors.Is(err, stash.ErrNoChanges) {
+			fmt.Println(err)
+			return
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		if !quiet {
+			fmt.Println("Saved working directory and index state", e.Message)
+		}
+	case "list":
+		list, err := stash.List(r)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, e := range list {
+			fmt.Printf("%s: %s\n", e.Name(), e.Message)
+		}
+	case "apply", "pop":
+		e, err := stash.Lookup(r, name)
+		if err != nil && sub == "apply" && name != "" {
+			// apply takes any stash-like commit
+			var sha string
+			if sha, _ = object.ObjectFind(r, name, "commit"); sha != "" {
+				e, err = stash.Entry{Index: -1, Sha: sha}, nil
+			}
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		res, err := stash.Apply(r, e.Sha, stash.ApplyOptions{Index: restoreIndex})
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		if !quiet {
+			for _, msg := range res.Messages {
+				fmt.Println(msg)
+			}
+		}
+		if !res.Clean() {
+			if sub == "pop" {
+				fmt.Println("The stash entry is kept in case you need it again.")
+			}
+			os.Exit(1)
+		}
+		if sub == "pop" {
+			dropStash(r, name, e, quiet)
+		}
+	case "drop":
+		e, err := stash.Lookup(r, name)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		dropStash(r, name, e, quiet)
+	case "clear":
+		if err := stash.Clear(r); err != nil {
+			fmt.Println(err)
+		}
+	default:
+		fmt.Println("Unknown stash subcommand:", sub)
+	}
+}
+
+// dropStash drops e, naming it as the user did, or refs/stash@{0} by default like git
+func dropStash(r *repo.Gitrepo, name string, e stash.Entry, quiet bool) {
+	if err := stash.Drop(r, e); err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+	if name == "" {
+		name = "refs/stash@{0}"
+	}
+	if !quiet {
+		fmt.Printf("Dropped %s (%s)\n", name, e.Sha)
+	}
+}
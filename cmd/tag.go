@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/tag"
+)
+
+// Usage: tag [-l] [-n] [--sort=[-]<key>] [<pattern>...]
+//        tag [-f] <name> [<object>]
+//        tag [-f] (-a|-m <msg>|-F <file>) <name> [<object>]
+//        tag -d <name>...
+//        tag -v <name>...
+func cmdTag(path string, args []string) {
+	r, err := repo.RepoFind(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+
+	mode := ""
+	annotate, force, showLines := false, false, false
+	message, sortKey := "", ""
+	hasMessage := false
+	var names []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-l" || arg == "--list":
+			mode = "list"
+		case arg == "-n":
+			mode, showLines = "list", true
+		case strings.HasPrefix(arg, "--sort="):
+			mode, sortKey = "list", strings.TrimPrefix(arg, "--sort=")
+		case arg == "-d" || arg == "--delete":
+			mode = "delete"
+		case arg == "-v" || arg == "--verify":
+			mode = "verify"
+		case arg == "-f" || arg == "--force":
+			force = true
+		case arg == "-a" || arg == "--annotate":
+			annotate = true
+		case arg == "-m" || arg == "-F":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Missing argument after", arg)
+				os.Exit(129)
+			}
+			value := args[i+1]
+			i++
+			if arg == "-F" {
+				data, err := os.ReadFile(value)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "fatal:", err)
+					os.Exit(128)
+				}
+				value = string(data)
+			}
+			if hasMessage {
+				message += "\n"
+			}
+			message += value
+			if arg == "-m" {
+				message += "\n"
+			}
+			annotate, hasMessage = true, true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if mode == "" {
+		mode = "list"
+		if len(names) > 0 {
+			mode = "create"
+		}
+	}
+
+	switch mode {
+	case "list":
+		list, err := tag.List(r, names, sortKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+		for _, t := range list {
+			if showLines {
+				fmt.Printf("%-15s %s\n", t.Name, t.Subject)
+			} else {
+				fmt.Println(t.Name)
+			}
+		}
+	case "create":
+		if len(names) > 2 {
+			fmt.Fprintln(os.Stderr, "Usage: tag [-a] [-m <msg>] <name> [<object>]")
+			os.Exit(129)
+		}
+		target := "HEAD"
+		if len(names) == 2 {
+			target = names[1]
+		}
+		if annotate && !hasMessage {
+			fmt.Fprintln(os.Stderr, "fatal: an annotated tag needs a message: use -m or -F")
+			os.Exit(128)
+		}
+		if annotate {
+			_, err = tag.CreateAnnotated(r, names[0], target, message, force)
+		} else {
+			_, err = tag.CreateLightweight(r, names[0], target, force)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fatal:", err)
+			os.Exit(128)
+		}
+	case "delete":
+		failed := false
+		for _, name := range names {
+			sha, err := tag.Delete(r, name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Deleted tag '%s' (was %s)\n", name, object.Abbrev(sha))
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "verify":
+		failed := false
+		for _, name := range names {
+			t, err := tag.Verify(r, name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("object %s\ntype %s\ntag %s\ntagger %s\n", t.Object(), t.ObjectType(), t.Name(), t.Tagger())
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/remote"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// servedRepo opens the repository a server command is given
+// Standard output carries the protocol, so failures go to standard error and end the
+// process with git's status.
+func servedRepo(service string, args []string) *repo.Gitrepo {
+	var dirs []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		}
+		dirs = append(dirs, arg)
+	}
+	if len(dirs) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s <directory>\n", service)
+		os.Exit(129)
+	}
+	r, err := repo.RepoOpen(dirs[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: '%s' does not appear to be a git repository\n", dirs[0])
+		os.Exit(128)
+	}
+	return r
+}
+
+// Usage: upload-pack <directory>
+// Serves a fetch or clone of the repository over standard input and output, in the
+// protocol version GIT_PROTOCOL asks for; git runs it with --upload-pack or ext:: URLs.
+func cmdUploadPack(args []string) {
+	r := servedRepo("upload-pack", args)
+	if err := remote.ServeUploadPack(r, os.Stdin, os.Stdout, remote.ProtocolVersion(os.Getenv("GIT_PROTOCOL"))); err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Usage: write-tree [--missing-ok] [--prefix=<prefix>/]
+// Writes the index as trees and prints the id of the top tree, or of the tree at the prefix.
+func cmdWriteTree(dir string, args []string) {
+	r, err := repo.RepoFind(dir, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(128)
+	}
+	prefix, missingOK := "", false
+	for _, arg := range args {
+		switch {
+		case arg == "--missing-ok":
+			missingOK = true
+		case strings.HasPrefix(arg, "--prefix="):
+			prefix = strings.TrimPrefix(arg, "--prefix=")
+		default:
+			fmt.Fprintln(os.Stderr, "Unknown option:", arg)
+			os.Exit(129)
+		}
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fatal:", err)
+		os.Exit(128)
+	}
+	sha, err := idx.WriteTree(r, prefix, missingOK)
+	if err != nil {
+		var treeErr *index.TreeError
+		if errors.As(err, &treeErr) {
+			for _, e := range treeErr.Unmerged {
+				fmt.Fprintf(os.Stderr, "%s: unmerged (%s)\n", e.Name, e.Sha)
+			}
+			for _, e := range treeErr.Missing {
+				fmt.Fprintf(os.Stderr, "error: invalid object %06o %s for '%s'\n", e.Mode, e.Sha, e.Name)
+			}
+		}
+		fmt.Fprintln(os.Stderr, "fatal: git-write-tree:", err)
+		os.Exit(128)
+	}
+	fmt.Println(sha)
+}
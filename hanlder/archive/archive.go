@@ -0,0 +1,270 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/pretty"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Archive formats
+const (
+	Tar   = "tar"
+	TarGz = "tar.gz"
+	Tgz   = "tgz"
+	Zip   = "zip"
+)
+
+// Formats lists the supported formats, as archive --list prints them
+var Formats = []string{Tar, Tgz, TarGz, Zip}
+
+// Options control an archive
+// Prefix is prepended to every path; when it ends in a slash it is a directory of its own.
+// Subdir archives only that directory of the tree, with paths relative to it, while
+// Pathspec still matches full paths. Level is the compression level, -1 for the default.
+type Options struct {
+	Format   string
+	Prefix   string
+	Subdir   string
+	Pathspec *pathspec.Pathspec
+	Level    int
+}
+
+// FormatFromName guesses the format from the extension of an output file name
+// It returns an empty string when the extension is not known
+func FormatFromName(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"):
+		return TarGz
+	case strings.HasSuffix(name, ".tgz"):
+		return Tgz
+	case strings.HasSuffix(name, ".tar"):
+		return Tar
+	case strings.HasSuffix(name, ".zip"):
+		return Zip
+	}
+	return ""
+}
+
+// entryWriter writes the entries of one archive format
+// Directory paths end in a slash; data is the content of files and the target of symlinks.
+type entryWriter interface {
+	entry(name string, mode uint32, sha string, data []byte) error
+	close() error
+}
+
+// archiver walks a tree and hands its entries to an entryWriter
+type archiver struct {
+	r       *repo.Gitrepo
+	opts    Options
+	out     entryWriter
+	checker *attr.Checker
+	filter  *filter.Filter
+	commit  *object.Commit
+	sha     string
+	// queued directories, written once a path below them is
+	queued []queuedDir
+}
+
+type queuedDir struct {
+	name string
+	sha  string
+}
+
+// Write streams an archive of the tree-ish rev to w
+// When rev names a commit its id is recorded in the archive and its committer time is
+// used as the time of every entry, so the same commit always gives the same bytes.
+// Files with the export-ignore attribute are left out, and $Format:...$ placeholders
+// are expanded in files with export-subst.
+func Write(r *repo.Gitrepo, w io.Writer, rev string, opts Options) error {
+	sha, err := object.ObjectFind(r, rev, "")
+	if err != nil {
+		return fmt.Errorf("not a valid object name: %s", rev)
+	}
+	tree, err := object.Peel(r, sha, "tree")
+	if err != nil {
+		return fmt.Errorf("not a tree object: %s", rev)
+	}
+	a := &archiver{r: r, opts: opts}
+	mtime := time.Now()
+	if commit, err := object.Peel(r, sha, "commit"); err == nil {
+		c, err := object.ReadCommit(r, commit)
+		if err != nil {
+			return err
+		}
+		a.commit, a.sha = c, commit
+		if v := c.Data.Header["committer"]; len(v) > 0 {
+			if _, when, err := repo.ParseIdent(v[0]); err == nil {
+				mtime = when
+			}
+		}
+	}
+
+	if opts.Subdir != "" {
+		if tree, err = object.ObjectFind(r, tree+":"+opts.Subdir, "tree"); err != nil {
+			return fmt.Errorf("current working directory is untracked")
+		}
+	}
+	// like git, attributes come from the archived tree, with paths relative to it
+	files, err := object.TreeFiles(r, tree)
+	if err != nil {
+		return err
+	}
+	a.checker = attr.NewTreeChecker(r, files)
+	a.filter = filter.NewWithChecker(r, a.checker)
+
+	switch opts.Format {
+	case Tar, "":
+		a.out = newTarWriter(w, mtime, a.sha)
+	case TarGz, Tgz:
+		a.out, err = newTarGzWriter(w, mtime, a.sha, opts.Level)
+		if err != nil {
+			return err
+		}
+	case Zip:
+		a.out = newZipWriter(w, mtime, a.sha, opts.Level)
+	default:
+		return fmt.Errorf("Unknown archive format '%s'", opts.Format)
+	}
+
+	if strings.HasSuffix(opts.Prefix, "/") {
+		if err := a.out.entry(opts.Prefix, 040777, tree, nil); err != nil {
+			return err
+		}
+	}
+	if err := a.walk(tree, ""); err != nil {
+		return err
+	}
+	return a.out.close()
+}
+
+// walk writes the entries below the tree sha, found at the relative directory dir
+func (a *archiver) walk(sha, dir string) error {
+	t, err := object.ReadTree(a.r, sha)
+	if err != nil {
+		return err
+	}
+	for _, e := range t.Data {
+		mode, err := object.ParseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		name := path.Join(dir, string(e.Name))
+		full := path.Join(a.opts.Subdir, name)
+		id := fmt.Sprintf("%x", e.Sha)
+		if a.checker.Lookup(name).IsSet("export-ignore") {
+			continue
+		}
+		if object.IsTreeMode(mode) {
+			if a.opts.Pathspec.Empty() || a.opts.Pathspec.Match(full) {
+				if err := a.flush(); err != nil {
+					return err
+				}
+				if err := a.out.entry(a.opts.Prefix+name+"/", mode, id, nil); err != nil {
+					return err
+				}
+			} else {
+				// the directory is only written if something below it is
+				a.queued = append(a.queued, queuedDir{name: name, sha: id})
+			}
+			if err := a.walk(id, name); err != nil {
+				return err
+			}
+			a.unqueue(name)
+			continue
+		}
+		if !a.opts.Pathspec.Match(full) {
+			continue
+		}
+		if err := a.flush(); err != nil {
+			return err
+		}
+		if mode == 0160000 {
+			// submodules are empty directories
+			if err := a.out.entry(a.opts.Prefix+name+"/", mode, id, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := a.content(name, id, mode)
+		if err != nil {
+			return err
+		}
+		if err := a.out.entry(a.opts.Prefix+name, mode, id, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the queued directories
+func (a *archiver) flush() error {
+	for _, d := range a.queued {
+		if err := a.out.entry(a.opts.Prefix+d.name+"/", 040000, d.sha, nil); err != nil {
+			return err
+		}
+	}
+	a.queued = a.queued[:0]
+	return nil
+}
+
+// unqueue drops the directory name if nothing below it was written
+func (a *archiver) unqueue(name string) {
+	if n := len(a.queued); n > 0 && a.queued[n-1].name == name {
+		a.queued = a.queued[:n-1]
+	}
+}
+
+// content returns the data of a file or symlink as it goes into the archive
+func (a *archiver) content(name, sha string, mode uint32) ([]byte, error) {
+	obj, err := object.ObjectRead(a.r, sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", sha)
+	}
+	data := blob.Data
+	if mode&0170000 != 0100000 {
+		return data, nil
+	}
+	if data, err = a.filter.ToWorktree(name, data); err != nil {
+		return nil, err
+	}
+	if a.commit != nil && a.checker.Lookup(name).IsSet("export-subst") {
+		data = a.substitute(data)
+	}
+	return data, nil
+}
+
+// substitute expands the $Format:...$ placeholders of data for the archived commit
+func (a *archiver) substitute(data []byte) []byte {
+	const marker = "$Format:"
+	text := string(data)
+	var b strings.Builder
+	for {
+		start := strings.Index(text, marker)
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(text[start+len(marker):], '$')
+		if end == -1 {
+			break
+		}
+		b.WriteString(text[:start])
+		format := text[start+len(marker) : start+len(marker)+end]
+		b.WriteString(pretty.Format(a.r, a.sha, a.commit, format))
+		text = text[start+len(marker)+end+1:]
+	}
+	b.WriteString(text)
+	return []byte(b.String())
+}
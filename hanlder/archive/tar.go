@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	blockSize = 512
+	// recordSize is the unit git pads tar archives to
+	recordSize = 20 * blockSize
+	// tarUmask is git's default tar.umask
+	tarUmask = 0002
+)
+
+// tarWriter writes ustar archives laid out like git archive's, with a pax global
+// header holding the commit id and pax extended headers for long names
+type tarWriter struct {
+	w      io.Writer
+	n      int64
+	mtime  int64
+	commit string
+	closer io.Closer
+}
+
+func newTarWriter(w io.Writer, mtime time.Time, commit string) *tarWriter {
+	return &tarWriter{w: w, mtime: mtime.Unix(), commit: commit}
+}
+
+// newTarGzWriter returns a tarWriter compressing its output
+// The gzip header has no name and no time, so the output only depends on the tree.
+func newTarGzWriter(w io.Writer, mtime time.Time, commit string, level int) (*tarWriter, error) {
+	if level < 0 {
+		level = gzip.DefaultCompression
+	}
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	zw.OS = 3 // unix, as git writes it
+	t := newTarWriter(zw, mtime, commit)
+	t.closer = zw
+	return t, nil
+}
+
+func (t *tarWriter) write(data []byte) error {
+	n, err := t.w.Write(data)
+	t.n += int64(n)
+	return err
+}
+
+// writeBlocked writes data padded with zeros to a whole number of blocks
+func (t *tarWriter) writeBlocked(data []byte) error {
+	if err := t.write(data); err != nil {
+		return err
+	}
+	if rest := len(data) % blockSize; rest != 0 {
+		return t.write(make([]byte, blockSize-rest))
+	}
+	return nil
+}
+
+// header returns a ustar header block; size is only recorded for regular files
+func (t *tarWriter) header(name string, typeflag byte, mode uint32, size int) []byte {
+	h := make([]byte, blockSize)
+	copy(h[0:100], name)
+	if mode&0170000 != 0100000 {
+		size = 0
+	}
+	copy(h[100:108], fmt.Sprintf("%07o", mode&07777))
+	copy(h[108:116], fmt.Sprintf("%07o", 0))
+	copy(h[116:124], fmt.Sprintf("%07o", 0))
+	copy(h[124:136], fmt.Sprintf("%011o", size))
+	copy(h[136:148], fmt.Sprintf("%011o", t.mtime))
+	h[156] = typeflag
+	copy(h[257:263], "ustar\x00")
+	copy(h[263:265], "00")
+	copy(h[265:297], "root")
+	copy(h[297:329], "root")
+	copy(h[329:337], fmt.Sprintf("%07o", 0))
+	copy(h[337:345], fmt.Sprintf("%07o", 0))
+	return h
+}
+
+// finish fills in the checksum of a header block
+func finish(h []byte) []byte {
+	copy(h[148:156], "        ")
+	sum := 0
+	for _, c := range h {
+		sum += int(c)
+	}
+	copy(h[148:156], fmt.Sprintf("%07o\x00", sum))
+	return h
+}
+
+// paxRecord formats one "<length> <key>=<value>\n" record, the length counting itself
+func paxRecord(key, value string) string {
+	body := " " + key + "=" + value + "\n"
+	n := len(body)
+	for digits := 1; ; digits++ {
+		total := len(body) + digits
+		if len(fmt.Sprint(total)) == digits {
+			n = total
+			break
+		}
+	}
+	return fmt.Sprintf("%d%s", n, body)
+}
+
+// pathPrefix returns where to split a long path between the ustar prefix and name fields
+func pathPrefix(name string, max int) int {
+	i := len(name)
+	if i > 1 && name[i-1] == '/' {
+		i--
+	}
+	if i > max {
+		i = max
+	}
+	for {
+		i--
+		if i <= 0 || name[i] == '/' {
+			break
+		}
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+func (t *tarWriter) entry(name string, mode uint32, sha string, data []byte) error {
+	if t.n == 0 && t.commit != "" {
+		if err := t.globalHeader(); err != nil {
+			return err
+		}
+	}
+	var typeflag byte
+	switch {
+	case mode&0170000 == 0040000 || mode == 0160000:
+		typeflag = '5'
+		mode = (040000 | mode&07777 | 0777) &^ tarUmask
+	case mode&0170000 == 0120000:
+		typeflag = '2'
+		mode |= 0777
+	case mode&0170000 == 0100000:
+		typeflag = '0'
+		if mode&0100 != 0 {
+			mode |= 0777
+		} else {
+			mode |= 0666
+		}
+		mode &^= tarUmask
+	default:
+		return fmt.Errorf("unsupported file mode: 0%o (SHA1: %s)", mode, sha)
+	}
+
+	var ext strings.Builder
+	var h []byte
+	if len(name) > 100 {
+		plen := pathPrefix(name, 155)
+		if rest := len(name) - plen - 1; plen > 0 && rest <= 100 {
+			h = t.header(name[plen+1:], typeflag, mode, len(data))
+			copy(h[345:500], name[:plen])
+		} else {
+			h = t.header(sha+".data", typeflag, mode, len(data))
+			ext.WriteString(paxRecord("path", name))
+		}
+	} else {
+		h = t.header(name, typeflag, mode, len(data))
+	}
+	if typeflag == '2' {
+		if len(data) > 100 {
+			copy(h[157:257], "see "+sha+".paxheader")
+			ext.WriteString(paxRecord("linkpath", string(data)))
+		} else {
+			copy(h[157:257], data)
+		}
+	}
+
+	if ext.Len() > 0 {
+		xh := t.header(sha+".paxheader", 'x', 0100666, ext.Len())
+		if err := t.writeBlocked(finish(xh)); err != nil {
+			return err
+		}
+		if err := t.writeBlocked([]byte(ext.String())); err != nil {
+			return err
+		}
+	}
+	if err := t.writeBlocked(finish(h)); err != nil {
+		return err
+	}
+	if typeflag == '0' && len(data) > 0 {
+		return t.writeBlocked(data)
+	}
+	return nil
+}
+
+// globalHeader records the commit id the archive was made from, like git's comment=<id>
+func (t *tarWriter) globalHeader() error {
+	record := paxRecord("comment", t.commit)
+	h := t.header("pax_global_header", 'g', 0100666, len(record))
+	if err := t.writeBlocked(finish(h)); err != nil {
+		return err
+	}
+	return t.writeBlocked([]byte(record))
+}
+
+// close writes the end of archive: zeros up to a whole record, with at least two blocks of them
+func (t *tarWriter) close() error {
+	if t.n == 0 && t.commit != "" {
+		if err := t.globalHeader(); err != nil {
+			return err
+		}
+	}
+	pad := recordSize - t.n%recordSize
+	if pad < 2*blockSize {
+		pad += recordSize
+	}
+	if err := t.write(make([]byte, pad)); err != nil {
+		return err
+	}
+	if t.closer != nil {
+		return t.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"os"
+	"time"
+)
+
+// zipWriter writes zip archives whose entries all carry the same time
+// The commit id is stored as the archive comment, as git does.
+type zipWriter struct {
+	zw    *zip.Writer
+	mtime time.Time
+	level int
+}
+
+func newZipWriter(w io.Writer, mtime time.Time, commit string, level int) *zipWriter {
+	zw := zip.NewWriter(w)
+	if level > 0 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	if commit != "" {
+		zw.SetComment(commit)
+	}
+	return &zipWriter{zw: zw, mtime: mtime, level: level}
+}
+
+func (z *zipWriter) entry(name string, mode uint32, sha string, data []byte) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Store, Modified: z.mtime}
+	switch {
+	case mode&0170000 == 0040000 || mode == 0160000:
+		h.SetMode(os.ModeDir | 0755)
+	case mode&0170000 == 0120000:
+		h.SetMode(os.ModeSymlink | 0777)
+	case mode&0100 != 0:
+		h.SetMode(0755)
+	default:
+		h.SetMode(0644)
+	}
+	if mode&0170000 == 0100000 && len(data) > 0 && z.level != 0 {
+		h.Method = zip.Deflate
+	}
+	w, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (z *zipWriter) close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,233 @@
+package attr
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Value is the state of one attribute for a path
+// Set is "true" for set, "false" for unset, or the assigned value
+type Value string
+
+const (
+	Set   Value = "true"
+	Unset Value = "false"
+)
+
+// Attributes maps attribute names to their value; missing names are unspecified
+type Attributes map[string]Value
+
+// IsSet reports whether the attribute is set or has a value
+func (a Attributes) IsSet(name string) bool {
+	v, ok := a[name]
+	return ok && v != Unset
+}
+
+// IsUnset reports whether the attribute is explicitly unset
+func (a Attributes) IsUnset(name string) bool {
+	return a[name] == Unset
+}
+
+// rule is one line of a .gitattributes file
+type rule struct {
+	dir     string
+	pattern string
+	attrs   []assignment
+}
+
+type assignment struct {
+	name  string
+	value Value
+	clear bool
+}
+
+// Checker answers attribute lookups from .gitattributes files
+// Files can come from the worktree or, via Blob lookups, from a tree
+type Checker struct {
+	rules []rule
+}
+
+// macros are the built-in attribute macros
+var macros = map[string][]assignment{
+	"binary": {{name: "diff", value: Unset}, {name: "merge", value: Unset}, {name: "text", value: Unset}},
+}
+
+// parse reads the rules of a .gitattributes file found in dir
+func parse(dir string, data []byte) []rule {
+	var rules []rule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		r := rule{dir: dir, pattern: fields[0]}
+		for _, f := range fields[1:] {
+			var a assignment
+			switch {
+			case strings.HasPrefix(f, "-"):
+				a = assignment{name: f[1:], value: Unset}
+			case strings.HasPrefix(f, "!"):
+				a = assignment{name: f[1:], clear: true}
+			case strings.Contains(f, "="):
+				name, value, _ := strings.Cut(f, "=")
+				a = assignment{name: name, value: Value(value)}
+			default:
+				a = assignment{name: f, value: Set}
+			}
+			if expansion, ok := macros[a.name]; ok && a.value == Set {
+				r.attrs = append(r.attrs, expansion...)
+			}
+			r.attrs = append(r.attrs, a)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// NewChecker loads the .gitattributes files of the worktree and info/attributes
+func NewChecker(r *repo.Gitrepo) *Checker {
+	c := &Checker{}
+	if r.Worktree != "" {
+		var dirs []string
+		filepath.WalkDir(r.Worktree, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" || d.Name() == repo.DefaultGitdirName {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Name() == ".gitattributes" {
+				rel, _ := filepath.Rel(r.Worktree, filepath.Dir(p))
+				dirs = append(dirs, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		// deeper files take precedence, so they are added last
+		sort.SliceStable(dirs, func(i, j int) bool {
+			return depth(dirs[i]) < depth(dirs[j])
+		})
+		for _, dir := range dirs {
+			data, err := os.ReadFile(filepath.Join(r.Worktree, filepath.FromSlash(dir), ".gitattributes"))
+			if err == nil {
+				c.Add(dir, data)
+			}
+		}
+	}
+	if data, err := os.ReadFile(repo.RepoPath(r, "info", "attributes")); err == nil {
+		// info/attributes has the highest precedence, so it goes last
+		c.rules = append(c.rules, parse("", data)...)
+	}
+	return c
+}
+
+// NewTreeChecker loads the .gitattributes files found in a tree, given by TreeFiles,
+// and info/attributes
+func NewTreeChecker(r *repo.Gitrepo, files map[string]object.TreeFile) *Checker {
+	c := &Checker{}
+	var dirs []string
+	for name, f := range files {
+		if path.Base(name) == ".gitattributes" && f.Mode&0170000 == 0100000 {
+			dirs = append(dirs, path.Dir(name))
+		}
+	}
+	sort.Strings(dirs)
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return depth(dirs[i]) < depth(dirs[j])
+	})
+	for _, dir := range dirs {
+		obj, err := object.ObjectRead(r, files[path.Join(dir, ".gitattributes")].Sha)
+		if err != nil {
+			continue
+		}
+		if blob, ok := obj.(*object.Blob); ok {
+			c.Add(dir, blob.Data)
+		}
+	}
+	if data, err := os.ReadFile(repo.RepoPath(r, "info", "attributes")); err == nil {
+		c.rules = append(c.rules, parse("", data)...)
+	}
+	return c
+}
+
+func depth(dir string) int {
+	if dir == "." || dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// Add adds the rules of a .gitattributes file found in the slash-separated directory dir
+// Deeper files must be added after the ones above them
+func (c *Checker) Add(dir string, data []byte) {
+	if dir == "." {
+		dir = ""
+	}
+	c.rules = append(c.rules, parse(dir, data)...)
+}
+
+// Lookup returns the attributes of the slash-separated path name
+func (c *Checker) Lookup(name string) Attributes {
+	attrs := Attributes{}
+	if c == nil {
+		return attrs
+	}
+	for _, r := range c.rules {
+		if !r.matches(name) {
+			continue
+		}
+		for _, a := range r.attrs {
+			if a.clear {
+				delete(attrs, a.name)
+			} else {
+				attrs[a.name] = a.value
+			}
+		}
+	}
+	return attrs
+}
+
+// matches reports whether the rule's pattern selects name
+// Patterns without a slash match the basename, others the path below the file's directory
+func (r rule) matches(name string) bool {
+	rel := name
+	if r.dir != "" {
+		if !strings.HasPrefix(name, r.dir+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(name, r.dir+"/")
+	}
+	pattern := r.pattern
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasPrefix(pattern, "**/") {
+		suffix := strings.TrimPrefix(pattern, "**/")
+		parts := strings.Split(rel, "/")
+		for i := range parts {
+			if ok, _ := path.Match(suffix, strings.Join(parts[i:], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(rel, strings.TrimSuffix(pattern, "**"))
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
@@ -0,0 +1,776 @@
+package bisect
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/branch"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Terms a commit can be marked with
+const (
+	Good = "good"
+	Bad  = "bad"
+	Skip = "skip"
+)
+
+// refs/bisect/bad is the bad commit; good and skipped commits get one ref each
+const refPrefix = "refs/bisect/"
+
+var (
+	ErrNotBisecting = errors.New("We are not bisecting.")
+	ErrNotStarted   = errors.New(`You need to start by "git bisect start"`)
+)
+
+// Status is where the search stands after a step
+// Waiting says what is missing before the search can start. Otherwise Next is the
+// commit checked out to be tested, FirstBad the answer, or Skipped lists the
+// commits one of which is the first bad one when only skipped commits are left.
+type Status struct {
+	Waiting   string
+	Next      string
+	Remaining int
+	Steps     int
+	MergeBase bool
+	FirstBad  string
+	Skipped   []string
+
+	r *repo.Gitrepo
+}
+
+// Done reports whether the search is over
+func (st *Status) Done() bool {
+	return st.FirstBad != "" || len(st.Skipped) > 0
+}
+
+// String describes the status the way git bisect prints it
+func (st *Status) String() string {
+	var b strings.Builder
+	switch {
+	case st.Waiting != "":
+		b.WriteString("status: " + st.Waiting + "\n")
+	case st.FirstBad != "":
+		fmt.Fprintf(&b, "%s is the first bad commit\n", st.FirstBad)
+		b.WriteString(describe(st.r, st.FirstBad))
+	case len(st.Skipped) > 0:
+		b.WriteString("There are only 'skip'ped commits left to test.\n")
+		b.WriteString("The first bad commit could be any of:\n")
+		for _, sha := range st.Skipped {
+			b.WriteString(sha + "\n")
+		}
+		b.WriteString("We cannot bisect more!\n")
+	case st.Next != "":
+		if st.MergeBase {
+			b.WriteString("Bisecting: a merge base must be tested\n")
+		} else {
+			fmt.Fprintf(&b, "Bisecting: %d revision%s left to test after this (roughly %d step%s)\n",
+				st.Remaining, plural(st.Remaining), st.Steps, plural(st.Steps))
+		}
+		fmt.Fprintf(&b, "[%s] %s\n", st.Next, subject(st.r, st.Next))
+	}
+	return b.String()
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// describe formats a commit like the header of git show
+func describe(r *repo.Gitrepo, sha string) string {
+	c, err := object.ReadCommit(r, sha)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "commit %s\n", sha)
+	if authors := c.Data.Header["author"]; len(authors) > 0 {
+		person, when, err := repo.ParseIdent(authors[0])
+		fmt.Fprintf(&b, "Author: %s\n", person)
+		if err == nil {
+			fmt.Fprintf(&b, "Date:   %s\n", when.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		}
+	}
+	b.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimRight(string(c.Data.Message), "\n"), "\n") {
+		if line == "" {
+			b.WriteString("\n")
+		} else {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+func subject(r *repo.Gitrepo, sha string) string {
+	c, err := object.ReadCommit(r, sha)
+	if err != nil {
+		return ""
+	}
+	return c.Subject()
+}
+
+func statePath(r *repo.Gitrepo, name string) string {
+	return repo.RepoPath(r, name)
+}
+
+// InProgress reports whether a bisection is going on
+func InProgress(r *repo.Gitrepo) bool {
+	exists, _ := repo.PathExist(statePath(r, "BISECT_START"))
+	return exists
+}
+
+func appendLog(r *repo.Gitrepo, text string) error {
+	f, err := os.OpenFile(statePath(r, "BISECT_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(text)
+	return err
+}
+
+// quote quotes an argument for the shell, as git does in its logs
+func quote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Start begins a bisection, with an optional bad commit and good commits
+// A bisection already in progress is started over, keeping the original HEAD.
+func Start(r *repo.Gitrepo, bad string, goods []string) (*Status, error) {
+	return start(r, bad, goods, true)
+}
+
+func start(r *repo.Gitrepo, bad string, goods []string, checkout bool) (*Status, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	// resolve everything before touching the state
+	var badSha string
+	var goodShas []string
+	var err error
+	if bad != "" {
+		if badSha, err = object.ObjectFind(r, bad, "commit"); err != nil {
+			return nil, fmt.Errorf("'%s' does not appear to be a valid revision", bad)
+		}
+	}
+	for _, good := range goods {
+		sha, err := object.ObjectFind(r, good, "commit")
+		if err != nil {
+			return nil, fmt.Errorf("'%s' does not appear to be a valid revision", good)
+		}
+		goodShas = append(goodShas, sha)
+	}
+
+	origin := ""
+	if InProgress(r) {
+		data, err := os.ReadFile(statePath(r, "BISECT_START"))
+		if err != nil {
+			return nil, err
+		}
+		origin = strings.TrimSpace(string(data))
+	} else {
+		branchRef, head, err := refs.Head(r)
+		if err != nil {
+			return nil, err
+		}
+		if head == "" {
+			return nil, errors.New("bad HEAD - I need a HEAD")
+		}
+		origin = head
+		if branchRef != "" {
+			origin = refs.Shorten(branchRef)
+		}
+	}
+	if err := clean(r); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(statePath(r, "BISECT_START"), []byte(origin+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(statePath(r, "BISECT_TERMS"), []byte(Bad+"\n"+Good+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(statePath(r, "BISECT_NAMES"), []byte("\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(statePath(r, "BISECT_LOG"), nil, 0644); err != nil {
+		return nil, err
+	}
+	if badSha != "" {
+		if err := mark(r, Bad, badSha, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, sha := range goodShas {
+		if err := mark(r, Good, sha, false); err != nil {
+			return nil, err
+		}
+	}
+	line := "git bisect start"
+	if bad != "" {
+		for _, arg := range append([]string{bad}, goods...) {
+			line += " " + quote(arg)
+		}
+	}
+	if err := appendLog(r, line+"\n"); err != nil {
+		return nil, err
+	}
+	return next(r, checkout)
+}
+
+// mark records sha as good, bad or skipped, logging the command when log is set
+func mark(r *repo.Gitrepo, term, sha string, log bool) error {
+	name := refPrefix + Bad
+	if term != Bad {
+		name = refPrefix + term + "-" + sha
+	}
+	if err := refs.UpdateRef(r, name, sha, ""); err != nil {
+		return err
+	}
+	text := fmt.Sprintf("# %s: [%s] %s\n", term, sha, subject(r, sha))
+	if log {
+		text += fmt.Sprintf("git bisect %s %s\n", term, sha)
+	}
+	return appendLog(r, text)
+}
+
+// Mark marks the commits revs, or HEAD when there are none, as good, bad or skipped,
+// then checks out the next commit to test
+func Mark(r *repo.Gitrepo, term string, revs []string) (*Status, error) {
+	return markAll(r, term, revs, true)
+}
+
+func markAll(r *repo.Gitrepo, term string, revs []string, checkout bool) (*Status, error) {
+	if !InProgress(r) {
+		return nil, ErrNotStarted
+	}
+	switch term {
+	case Good, Bad, Skip:
+	default:
+		return nil, fmt.Errorf("invalid term: %s", term)
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	if term == Bad && len(revs) > 1 {
+		return nil, errors.New("'git bisect bad' can take only one argument.")
+	}
+	var shas []string
+	for _, rev := range revs {
+		sha, err := object.ObjectFind(r, rev, "commit")
+		if err != nil {
+			return nil, fmt.Errorf("Bad rev input: %s", rev)
+		}
+		shas = append(shas, sha)
+	}
+	for _, sha := range shas {
+		if err := mark(r, term, sha, true); err != nil {
+			return nil, err
+		}
+	}
+	return next(r, checkout)
+}
+
+// state is what has been marked so far
+type state struct {
+	bad   string
+	goods []string
+	skips map[string]bool
+}
+
+func readState(r *repo.Gitrepo) (*state, error) {
+	list, err := refs.ListRefs(r, refPrefix)
+	if err != nil {
+		return nil, err
+	}
+	st := &state{skips: map[string]bool{}}
+	for _, ref := range list {
+		name := strings.TrimPrefix(ref.Name, refPrefix)
+		switch {
+		case name == Bad:
+			st.bad = ref.Sha
+		case strings.HasPrefix(name, Good+"-"):
+			st.goods = append(st.goods, ref.Sha)
+		case strings.HasPrefix(name, Skip+"-"):
+			st.skips[ref.Sha] = true
+		}
+	}
+	return st, nil
+}
+
+// Next works out the next commit to test from what has been marked and checks it out
+func Next(r *repo.Gitrepo) (*Status, error) {
+	if !InProgress(r) {
+		return nil, ErrNotStarted
+	}
+	return next(r, true)
+}
+
+func next(r *repo.Gitrepo, checkout bool) (*Status, error) {
+	st, err := readState(r)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{r: r}
+	switch {
+	case st.bad == "" && len(st.goods) == 0:
+		status.Waiting = "waiting for both good and bad commits"
+	case st.bad == "":
+		status.Waiting = fmt.Sprintf("waiting for bad commit, %d good commit%s known", len(st.goods), plural(len(st.goods)))
+	case len(st.goods) == 0:
+		status.Waiting = "waiting for good commit(s), bad commit known"
+	}
+	if status.Waiting != "" {
+		return status, appendLog(r, "# status: "+status.Waiting+"\n")
+	}
+
+	base, err := checkMergeBases(r, st)
+	if err != nil {
+		return nil, err
+	}
+	if base != "" {
+		status.Next, status.MergeBase = base, true
+		return status, checkoutNext(r, base, checkout)
+	}
+
+	list, err := candidates(r, st)
+	if err != nil {
+		return nil, err
+	}
+	best, reaches := bestCandidate(list, st.skips)
+	if best == "" || best == st.bad {
+		var skipped []string
+		for _, c := range list {
+			if st.skips[c.sha] {
+				skipped = append(skipped, c.sha)
+			}
+		}
+		if len(skipped) > 0 {
+			status.Skipped = append(skipped, st.bad)
+			text := "# only skipped commits left to test\n"
+			for _, sha := range status.Skipped {
+				text += fmt.Sprintf("# possible first %s commit: [%s] %s\n", Bad, sha, subject(r, sha))
+			}
+			return status, appendLog(r, text)
+		}
+		status.FirstBad = st.bad
+		return status, appendLog(r, fmt.Sprintf("# first %s commit: [%s] %s\n", Bad, st.bad, subject(r, st.bad)))
+	}
+	status.Next = best
+	status.Remaining = len(list) - reaches - 1
+	status.Steps = estimateSteps(len(list))
+	return status, checkoutNext(r, best, checkout)
+}
+
+func checkoutNext(r *repo.Gitrepo, sha string, checkout bool) error {
+	if err := os.WriteFile(statePath(r, "BISECT_EXPECTED_REV"), []byte(sha+"\n"), 0644); err != nil {
+		return err
+	}
+	if !checkout {
+		return nil
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return err
+	}
+	if head == sha {
+		return nil
+	}
+	_, err = branch.Switch(r, sha, branch.SwitchOptions{Detach: true})
+	return err
+}
+
+// estimateSteps guesses how many more tests a search among all commits takes
+func estimateSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := 0
+	for 1<<(n+1) <= all {
+		n++
+	}
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// candidate is a commit that may be the first bad one
+// weight is the number of candidates reachable from it, itself included
+type candidate struct {
+	sha    string
+	time   int64
+	weight int
+}
+
+// ancestors returns every commit reachable from the commits heads
+func ancestors(r *repo.Gitrepo, heads []string, stop map[string]bool) (map[string]*object.Commit, error) {
+	seen := map[string]*object.Commit{}
+	queue := append([]string(nil), heads...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[sha]; ok || stop[sha] {
+			continue
+		}
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		seen[sha] = c
+		queue = append(queue, c.Parents()...)
+	}
+	return seen, nil
+}
+
+// candidates lists the commits reachable from the bad commit but from no good one,
+// newest first, with their weights
+func candidates(r *repo.Gitrepo, st *state) ([]candidate, error) {
+	good, err := ancestors(r, st.goods, nil)
+	if err != nil {
+		return nil, err
+	}
+	hidden := map[string]bool{}
+	for sha := range good {
+		hidden[sha] = true
+	}
+	commits, err := ancestors(r, []string{st.bad}, hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	// parents come before children, so each reachable set can be built from the parents'
+	var order []string
+	visited := map[string]bool{}
+	var visit func(sha string)
+	visit = func(sha string) {
+		if visited[sha] {
+			return
+		}
+		visited[sha] = true
+		for _, p := range commits[sha].Parents() {
+			if _, ok := commits[p]; ok {
+				visit(p)
+			}
+		}
+		order = append(order, sha)
+	}
+	shas := make([]string, 0, len(commits))
+	for sha := range commits {
+		shas = append(shas, sha)
+	}
+	sort.Strings(shas)
+	for _, sha := range shas {
+		visit(sha)
+	}
+	pos := map[string]int{}
+	for i, sha := range order {
+		pos[sha] = i
+	}
+	words := (len(order) + 63) / 64
+	reach := make([][]uint64, len(order))
+	list := make([]candidate, len(order))
+	for i, sha := range order {
+		set := make([]uint64, words)
+		set[i/64] |= 1 << (i % 64)
+		for _, p := range commits[sha].Parents() {
+			if j, ok := pos[p]; ok {
+				for w := range set {
+					set[w] |= reach[j][w]
+				}
+			}
+		}
+		reach[i] = set
+		weight := 0
+		for _, w := range set {
+			weight += popcount(w)
+		}
+		list[i] = candidate{sha: sha, time: commitTime(commits[sha]), weight: weight}
+	}
+	// newest first, children before parents among equal times
+	sort.SliceStable(list, func(i, j int) bool { return list[i].time > list[j].time })
+	for i, j := 0, 0; i < len(list); i = j {
+		for j = i + 1; j < len(list) && list[j].time == list[i].time; j++ {
+		}
+		sort.SliceStable(list[i:j], func(a, b int) bool { return pos[list[i+a].sha] > pos[list[i+b].sha] })
+	}
+	return list, nil
+}
+
+func popcount(w uint64) int {
+	n := 0
+	for ; w != 0; w &= w - 1 {
+		n++
+	}
+	return n
+}
+
+func commitTime(c *object.Commit) int64 {
+	if v := c.Data.Header["committer"]; len(v) > 0 {
+		if _, when, err := repo.ParseIdent(v[0]); err == nil {
+			return when.Unix()
+		}
+	}
+	return 0
+}
+
+// bestCandidate picks the commit that splits the candidates most evenly: the one whose
+// reachable count is closest to half of them. Skipped commits are never picked.
+func bestCandidate(list []candidate, skips map[string]bool) (string, int) {
+	best, bestDistance, reaches := "", -1, 0
+	for _, c := range list {
+		if skips[c.sha] {
+			continue
+		}
+		distance := c.weight
+		if len(list)-distance < distance {
+			distance = len(list) - distance
+		}
+		if distance > bestDistance {
+			best, bestDistance, reaches = c.sha, distance, c.weight
+		}
+	}
+	return best, reaches
+}
+
+// checkMergeBases returns a merge base of the bad commit and a good commit that is not
+// its ancestor, when one still has to be tested
+func checkMergeBases(r *repo.Gitrepo, st *state) (string, error) {
+	if exists, _ := repo.PathExist(statePath(r, "BISECT_ANCESTORS_OK")); exists {
+		return "", nil
+	}
+	for _, good := range st.goods {
+		ok, err := object.IsAncestor(r, good, st.bad)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			continue
+		}
+		bases, err := mergeBases(r, st.bad, good)
+		if err != nil {
+			return "", err
+		}
+		for _, base := range bases {
+			if base == st.bad {
+				return "", fmt.Errorf("Some %s revs are not ancestors of the %s rev.\n"+
+					"git bisect cannot work properly in this case.\n"+
+					"Maybe you mistook %s and %s revs?", Good, Bad, Good, Bad)
+			}
+			known := st.skips[base]
+			for _, g := range st.goods {
+				known = known || g == base
+			}
+			if !known {
+				return base, nil
+			}
+		}
+	}
+	return "", os.WriteFile(statePath(r, "BISECT_ANCESTORS_OK"), nil, 0644)
+}
+
+// mergeBases returns the best common ancestors of a and b
+func mergeBases(r *repo.Gitrepo, a, b string) ([]string, error) {
+	fromA, err := ancestors(r, []string{a}, nil)
+	if err != nil {
+		return nil, err
+	}
+	fromB, err := ancestors(r, []string{b}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var common []string
+	for sha := range fromA {
+		if _, ok := fromB[sha]; ok {
+			common = append(common, sha)
+		}
+	}
+	// a common ancestor of another common ancestor is not a best one
+	var parents []string
+	for _, sha := range common {
+		parents = append(parents, fromA[sha].Parents()...)
+	}
+	below, err := ancestors(r, parents, nil)
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, sha := range common {
+		if _, ok := below[sha]; !ok {
+			bases = append(bases, sha)
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
+}
+
+// clean removes the bisection state
+func clean(r *repo.Gitrepo) error {
+	list, err := refs.ListRefs(r, refPrefix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range list {
+		if err := refs.DeleteRef(r, ref.Name); err != nil && !errors.Is(err, refs.ErrNotFound) {
+			return err
+		}
+	}
+	for _, name := range []string{"BISECT_START", "BISECT_TERMS", "BISECT_NAMES", "BISECT_LOG",
+		"BISECT_EXPECTED_REV", "BISECT_ANCESTORS_OK", "BISECT_RUN", "BISECT_HEAD"} {
+		if err := os.Remove(statePath(r, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset ends the bisection and checks out commit, or the branch it started from
+// It returns what was checked out
+func Reset(r *repo.Gitrepo, commit string) (string, error) {
+	if !InProgress(r) {
+		return "", ErrNotBisecting
+	}
+	target := commit
+	if target == "" {
+		data, err := os.ReadFile(statePath(r, "BISECT_START"))
+		if err != nil {
+			return "", err
+		}
+		target = strings.TrimSpace(string(data))
+	}
+	opts := branch.SwitchOptions{Detach: !refs.Exists(r, "refs/heads/"+target)}
+	if _, err := branch.Switch(r, target, opts); err != nil {
+		return "", fmt.Errorf("could not check out original HEAD '%s'. Try 'git bisect reset <commit>'.\n%w", target, err)
+	}
+	return target, clean(r)
+}
+
+// Log returns the log of the bisection, which Replay can play back
+func Log(r *repo.Gitrepo) (string, error) {
+	if !InProgress(r) {
+		return "", ErrNotBisecting
+	}
+	data, err := os.ReadFile(statePath(r, "BISECT_LOG"))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Replay starts over and plays back the bisect commands of a log, then checks out the next commit
+func Replay(r *repo.Gitrepo, data string) (*Status, error) {
+	if InProgress(r) {
+		if _, err := Reset(r, ""); err != nil {
+			return nil, err
+		}
+	}
+	var status *Status
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rest, ok := strings.CutPrefix(line, "git bisect ")
+		if !ok {
+			if rest, ok = strings.CutPrefix(line, "git-bisect "); !ok {
+				continue
+			}
+		}
+		words, err := splitWords(rest)
+		if err != nil || len(words) == 0 {
+			return nil, fmt.Errorf("?? what are you talking about? %s", line)
+		}
+		switch words[0] {
+		case "start":
+			bad, goods := "", []string(nil)
+			var args []string
+			for _, w := range words[1:] {
+				if w == "--" {
+					break
+				}
+				if !strings.HasPrefix(w, "--") {
+					args = append(args, w)
+				}
+			}
+			if len(args) > 0 {
+				bad, goods = args[0], args[1:]
+			}
+			status, err = start(r, bad, goods, false)
+		case Good, Bad, Skip, "old", "new":
+			status, err = markAll(r, Term(words[0]), words[1:], false)
+		default:
+			return nil, fmt.Errorf("?? what are you talking about? %s", line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if status == nil {
+		return nil, errors.New("no bisect commands in the log")
+	}
+	if status.Waiting != "" {
+		return status, nil
+	}
+	return next(r, true)
+}
+
+// Term maps the old/new aliases to good and bad
+func Term(word string) string {
+	switch word {
+	case "old":
+		return Good
+	case "new":
+		return Bad
+	}
+	return word
+}
+
+// splitWords splits a logged command line, undoing the shell quoting of its arguments
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted:
+			if c == '\'' {
+				quoted = false
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'':
+			quoted, inWord = true, true
+		case c == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
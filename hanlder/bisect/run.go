@@ -0,0 +1,75 @@
+package bisect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// skipCode is the exit code a run script uses to say the commit cannot be tested
+const skipCode = 125
+
+// Run tests commits with the command argv until the first bad commit is found
+// Exit code 0 marks the checked out commit good, 125 skips it, any other code below 128
+// marks it bad; anything else stops the run. Progress and the command's output go to out.
+func Run(r *repo.Gitrepo, argv []string, out io.Writer) (*Status, error) {
+	if !InProgress(r) {
+		return nil, ErrNotStarted
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("bisect run failed: no command provided.")
+	}
+	st, err := readState(r)
+	if err != nil {
+		return nil, err
+	}
+	if st.bad == "" || len(st.goods) == 0 {
+		return nil, errors.New("You need to give me at least one good and one bad revision.")
+	}
+	// each argument is quoted with a space in front, as git prints it
+	var b strings.Builder
+	for _, arg := range argv {
+		b.WriteString(" " + quote(arg))
+	}
+	command := b.String()
+	for {
+		fmt.Fprintf(out, "running %s\n", command)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = r.Worktree
+		cmd.Stdout, cmd.Stderr = out, out
+		code := 0
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return nil, err
+			}
+			code = exitErr.ExitCode()
+		}
+		term := Good
+		switch {
+		case code < 0 || code >= 128:
+			return nil, fmt.Errorf("bisect run failed: exit code %d from %s is < 0 or >= 128", code, command)
+		case code == skipCode:
+			term = Skip
+		case code != 0:
+			term = Bad
+		}
+		status, err := Mark(r, term, nil)
+		if err != nil {
+			return nil, fmt.Errorf("bisect run failed: %w", err)
+		}
+		fmt.Fprint(out, status.String())
+		if status.FirstBad != "" {
+			fmt.Fprintln(out, "bisect found first bad commit")
+			return status, nil
+		}
+		if len(status.Skipped) > 0 {
+			fmt.Fprintln(out, "bisect run cannot continue any more")
+			return status, nil
+		}
+	}
+}
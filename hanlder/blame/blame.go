@@ -0,0 +1,568 @@
+package blame
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/diff"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Commit is a commit some lines are blamed on
+// Previous and PreviousPath name the version the commit changed, if any;
+// Boundary is set for root commits. Paths lists the names the file had in the
+// commit's lines, more than one when a rename was followed through it.
+type Commit struct {
+	Sha                      string
+	Author, AuthorMail       string
+	AuthorTime               time.Time
+	Committer, CommitterMail string
+	CommitterTime            time.Time
+	Summary                  string
+	Boundary                 bool
+	Previous, PreviousPath   string
+	Paths                    []string
+}
+
+// Line is one line of the blamed file
+// OrigLine is its number in the version of Commit, where the file was named Path;
+// FinalLine its number in the blamed version. Ignored is set when the line was passed
+// through an ignored commit, Unblamable when an ignored commit added it and it could
+// not be passed on.
+type Line struct {
+	Commit     *Commit
+	Path       string
+	OrigLine   int
+	FinalLine  int
+	Content    string
+	Ignored    bool
+	Unblamable bool
+}
+
+// Options control a blame
+// Rev is the commit to start from; when empty the worktree version is blamed, with
+// uncommitted lines on a "Not Committed Yet" commit on top of HEAD. Ranges are -L
+// specifications limiting the lines returned, and IgnoreRevs commits whose changes
+// are seen through.
+type Options struct {
+	Rev        string
+	Ranges     []string
+	IgnoreRevs []string
+}
+
+// origin is a version of the file: a commit and the name the file has there
+type origin struct {
+	commit  *Commit
+	parents []string
+	tree    string
+	path    string
+	blob    string
+	lines   []string
+}
+
+type blamer struct {
+	r       *repo.Gitrepo
+	commits map[string]*Commit
+	origins map[string]*origin
+	trees   map[string]map[string]object.TreeFile
+	ignored map[string]bool
+}
+
+// File attributes each line of the file path, slash-separated from the top of the worktree,
+// to the commit that introduced it, following the file across renames
+func File(r *repo.Gitrepo, path string, opts Options) ([]Line, error) {
+	b := &blamer{
+		r:       r,
+		commits: map[string]*Commit{},
+		origins: map[string]*origin{},
+		trees:   map[string]map[string]object.TreeFile{},
+		ignored: map[string]bool{},
+	}
+	for _, rev := range opts.IgnoreRevs {
+		sha, err := object.ObjectFind(r, rev, "commit")
+		if err != nil {
+			return nil, fmt.Errorf("cannot find revision %s to ignore", rev)
+		}
+		b.ignored[sha] = true
+	}
+	final, err := b.start(path, opts.Rev)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := ParseRanges(opts.Ranges, final.lines, path)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(final.lines)
+	suspect := make([]*origin, n)
+	at := make([]int, n)
+	lines := make([]Line, n)
+	for i := range suspect {
+		suspect[i], at[i] = final, i
+		lines[i] = Line{FinalLine: i + 1, Content: final.lines[i]}
+	}
+	// lines outside the ranges need no blame
+	if len(ranges) > 0 {
+		for i := range suspect {
+			suspect[i] = nil
+		}
+		for _, rg := range ranges {
+			for i := rg.Start - 1; i < rg.End; i++ {
+				suspect[i] = final
+			}
+		}
+	}
+
+	queue := []*origin{final}
+	queued := map[*origin]bool{final: true}
+	for len(queue) > 0 {
+		// newest first, so a commit is handled after the commits built on it
+		best := 0
+		for i, o := range queue {
+			if o.commit.CommitterTime.After(queue[best].commit.CommitterTime) {
+				best = i
+			}
+		}
+		o := queue[best]
+		queue = append(queue[:best], queue[best+1:]...)
+		delete(queued, o)
+
+		var mine []int
+		for i := range suspect {
+			if suspect[i] == o {
+				mine = append(mine, i)
+			}
+		}
+		if len(mine) == 0 {
+			continue
+		}
+		pass := func(p *origin, i, line int) {
+			suspect[i], at[i] = p, line
+			if !queued[p] {
+				queued[p] = true
+				queue = append(queue, p)
+			}
+		}
+
+		parents := make([]*origin, len(o.parents))
+		for i, sha := range o.parents {
+			if parents[i], err = b.parentOrigin(o, sha); err != nil {
+				return nil, err
+			}
+		}
+		same := false
+		for _, p := range parents {
+			if p != nil && p.blob == o.blob {
+				for _, i := range mine {
+					pass(p, i, at[i])
+				}
+				same = true
+				break
+			}
+		}
+		if same {
+			continue
+		}
+		for _, p := range parents {
+			if p == nil {
+				continue
+			}
+			if o.commit.Previous == "" {
+				o.commit.Previous, o.commit.PreviousPath = p.commit.Sha, p.path
+			}
+			unchanged := unchangedLines(p.lines, o.lines)
+			for _, i := range mine {
+				if suspect[i] != o {
+					continue
+				}
+				if line, ok := unchanged[at[i]]; ok {
+					pass(p, i, line)
+				}
+			}
+		}
+		if b.ignored[o.commit.Sha] && len(parents) > 0 && parents[0] != nil {
+			p := parents[0]
+			hunks := diff.Diff(p.lines, o.lines)
+			matches := make([][]int, len(hunks))
+			for _, i := range mine {
+				if suspect[i] != o {
+					continue
+				}
+				for n, h := range hunks {
+					if at[i] < h.NewStart || at[i] >= h.NewEnd() {
+						continue
+					}
+					if matches[n] == nil {
+						matches[n] = matchLines(p.lines[h.OldStart:h.OldEnd()], o.lines[h.NewStart:h.NewEnd()])
+					}
+					if k := matches[n][at[i]-h.NewStart]; k >= 0 {
+						lines[i].Ignored = true
+						pass(p, i, h.OldStart+k)
+					} else {
+						lines[i].Unblamable = true
+					}
+					break
+				}
+			}
+		}
+		for _, i := range mine {
+			if suspect[i] == o {
+				lines[i].Commit, lines[i].Path, lines[i].OrigLine = o.commit, o.path, at[i]+1
+				suspect[i] = nil
+				addPath(o.commit, o.path)
+			}
+		}
+	}
+
+	if len(ranges) == 0 {
+		return lines, nil
+	}
+	var out []Line
+	for _, rg := range ranges {
+		out = append(out, lines[rg.Start-1:rg.End]...)
+	}
+	return out, nil
+}
+
+func addPath(c *Commit, path string) {
+	for _, p := range c.Paths {
+		if p == path {
+			return
+		}
+	}
+	c.Paths = append(c.Paths, path)
+}
+
+// start returns the version of path the blame starts from
+func (b *blamer) start(path, rev string) (*origin, error) {
+	if rev != "" {
+		sha, err := object.ObjectFind(b.r, rev, "commit")
+		if err != nil {
+			return nil, err
+		}
+		o, err := b.origin(sha, path)
+		if err != nil {
+			return nil, err
+		}
+		if o == nil {
+			return nil, fmt.Errorf("no such path '%s' in %s", path, rev)
+		}
+		return o, nil
+	}
+
+	_, head, err := refs.Head(b.r)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	inWorktree := false
+	if b.r.Worktree != "" {
+		if content, info, err := worktree.ReadFile(b.r, path); err == nil {
+			data, inWorktree = content, true
+			if info.Mode().IsRegular() {
+				if data, err = filter.New(b.r).ToGit(path, data); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if !inWorktree {
+		if head == "" {
+			return nil, fmt.Errorf("no such path '%s' in HEAD", path)
+		}
+		return b.start(path, "HEAD")
+	}
+	// an untracked file has no history to blame
+	idx, err := index.Read(b.r)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Find(path, 0) == nil && idx.Find(path, 2) == nil {
+		if head == "" {
+			return nil, fmt.Errorf("no such path '%s' in HEAD", path)
+		}
+		if o, err := b.origin(head, path); err != nil || o == nil {
+			if err == nil {
+				err = fmt.Errorf("no such path '%s' in HEAD", path)
+			}
+			return nil, err
+		}
+	}
+
+	// uncommitted lines are blamed on a commit that does not exist yet
+	now := time.Now()
+	c := &Commit{
+		Sha:    repo.Format(b.r).ZeroID(),
+		Author: "Not Committed Yet", AuthorMail: "<not.committed.yet>", AuthorTime: now,
+		Committer: "Not Committed Yet", CommitterMail: "<not.committed.yet>", CommitterTime: now,
+		Summary: fmt.Sprintf("Version of %s from %s", path, path),
+	}
+	o := &origin{commit: c, path: path, blob: object.HashString(b.r, "blob", data), lines: diff.Lines(data)}
+	if head != "" {
+		o.parents = []string{head}
+		headCommit, err := object.ReadCommit(b.r, head)
+		if err != nil {
+			return nil, err
+		}
+		o.tree = headCommit.TreeSha()
+	}
+	return o, nil
+}
+
+// commit returns the blame information of the commit sha
+func (b *blamer) commit(sha string) (*Commit, *object.Commit, error) {
+	obj, err := object.ReadCommit(b.r, sha)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c, ok := b.commits[sha]; ok {
+		return c, obj, nil
+	}
+	c := &Commit{Sha: sha, Boundary: len(obj.Parents()) == 0}
+	if v := obj.Data.Header["author"]; len(v) > 0 {
+		person, when, _ := repo.ParseIdent(v[0])
+		c.Author, c.AuthorMail = splitPerson(person)
+		c.AuthorTime = when
+	}
+	if v := obj.Data.Header["committer"]; len(v) > 0 {
+		person, when, _ := repo.ParseIdent(v[0])
+		c.Committer, c.CommitterMail = splitPerson(person)
+		c.CommitterTime = when
+	}
+	c.Summary = obj.Subject()
+	b.commits[sha] = c
+	return c, obj, nil
+}
+
+// splitPerson splits "Name <mail>" into the name and "<mail>"
+func splitPerson(person string) (string, string) {
+	i := strings.LastIndex(person, "<")
+	if i == -1 {
+		return person, ""
+	}
+	return strings.TrimSpace(person[:i]), person[i:]
+}
+
+// files returns the files of the tree sha
+func (b *blamer) files(tree string) (map[string]object.TreeFile, error) {
+	if files, ok := b.trees[tree]; ok {
+		return files, nil
+	}
+	files, err := object.TreeFiles(b.r, tree)
+	if err != nil {
+		return nil, err
+	}
+	b.trees[tree] = files
+	return files, nil
+}
+
+// origin returns the version of path in the commit sha, nil when it has no such file
+func (b *blamer) origin(sha, path string) (*origin, error) {
+	key := sha + ":" + path
+	if o, ok := b.origins[key]; ok {
+		return o, nil
+	}
+	c, obj, err := b.commit(sha)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.files(obj.TreeSha())
+	if err != nil {
+		return nil, err
+	}
+	f, ok := files[path]
+	if !ok || !isFile(f.Mode) {
+		return nil, nil
+	}
+	data, err := blobData(b.r, f.Sha)
+	if err != nil {
+		return nil, err
+	}
+	o := &origin{commit: c, parents: obj.Parents(), tree: obj.TreeSha(), path: path, blob: f.Sha, lines: diff.Lines(data)}
+	b.origins[key] = o
+	return o, nil
+}
+
+func isFile(mode uint32) bool {
+	return mode&0170000 == 0100000 || mode == 0120000
+}
+
+func blobData(r *repo.Gitrepo, sha string) ([]byte, error) {
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", sha)
+	}
+	return blob.Data, nil
+}
+
+// parentOrigin returns the version of o's file in the parent commit sha
+// When the parent has no file by that name, a file the commit deleted with the same
+// or similar enough content is taken as its old name.
+func (b *blamer) parentOrigin(o *origin, sha string) (*origin, error) {
+	p, err := b.origin(sha, o.path)
+	if p != nil || err != nil {
+		return p, err
+	}
+	_, obj, err := b.commit(sha)
+	if err != nil {
+		return nil, err
+	}
+	parentFiles, err := b.files(obj.TreeSha())
+	if err != nil {
+		return nil, err
+	}
+	ourFiles := map[string]object.TreeFile{}
+	if o.tree != "" {
+		if ourFiles, err = b.files(o.tree); err != nil {
+			return nil, err
+		}
+	}
+	best, bestScore := "", 0.0
+	for name, f := range parentFiles {
+		if _, kept := ourFiles[name]; kept || !isFile(f.Mode) {
+			continue
+		}
+		if f.Sha == o.blob {
+			return b.origin(sha, name)
+		}
+		data, err := blobData(b.r, f.Sha)
+		if err != nil || filter.IsBinary(data) {
+			continue
+		}
+		if score := similarity(diff.Lines(data), o.lines); score > bestScore || (score == bestScore && name < best) {
+			best, bestScore = name, score
+		}
+	}
+	// the same threshold as git's default rename detection
+	if bestScore < 0.5 {
+		return nil, nil
+	}
+	return b.origin(sha, best)
+}
+
+// similarity returns the share of lines a and b have in common
+func similarity(a, b []string) float64 {
+	if len(a)+len(b) == 0 {
+		return 1
+	}
+	common := len(a)
+	for _, h := range diff.Diff(a, b) {
+		common -= h.OldLines
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// matchSpan is how far from its proportional place in a changed hunk matchLines looks
+// for the line an ignored commit changed
+const matchSpan = 10
+
+// matchLines pairs each line b changed in a hunk of an ignored commit with the most
+// similar line of a, or -1 when none shares any pair of characters, as git guesses
+// The surest pair is made first and splits the rest in two, so the lines keep their order.
+func matchLines(a, b []string) []int {
+	aPrints, bPrints := make([]map[string]int, len(a)), make([]map[string]int, len(b))
+	for j, line := range a {
+		aPrints[j] = fingerprint(line)
+	}
+	match := make([]int, len(b))
+	for k, line := range b {
+		bPrints[k], match[k] = fingerprint(line), -1
+	}
+	var split func(alo, ahi, blo, bhi int)
+	split = func(alo, ahi, blo, bhi int) {
+		if alo >= ahi || blo >= bhi {
+			return
+		}
+		bestA, bestB, best := -1, -1, 0
+		for k := blo; k < bhi; k++ {
+			// look near the same relative place in a
+			center := alo + (k-blo)*(ahi-alo)/(bhi-blo)
+			for j := max(alo, center-matchSpan); j < min(ahi, center+matchSpan+1); j++ {
+				if common := commonPairs(bPrints[k], aPrints[j]); common > best {
+					bestA, bestB, best = j, k, common
+				}
+			}
+		}
+		if best == 0 {
+			return
+		}
+		match[bestB] = bestA
+		split(alo, bestA, blo, bestB)
+		split(bestA+1, ahi, bestB+1, bhi)
+	}
+	split(0, len(a), 0, len(b))
+	return match
+}
+
+// fingerprint counts the pairs of adjacent characters of a line, ignoring case
+func fingerprint(line string) map[string]int {
+	line = strings.ToLower(strings.TrimSuffix(line, "\n")) + "\n"
+	fp := map[string]int{}
+	for i := 0; i+1 < len(line); i++ {
+		fp[line[i:i+2]]++
+	}
+	return fp
+}
+
+// commonPairs counts the character pairs two fingerprints share
+func commonPairs(a, b map[string]int) int {
+	n := 0
+	for pair, count := range a {
+		n += min(count, b[pair])
+	}
+	return n
+}
+
+// unchangedLines maps the lines of b that are the same lines in a to their index there
+func unchangedLines(a, b []string) map[int]int {
+	m := map[int]int{}
+	ai, bi := 0, 0
+	for _, h := range diff.Diff(a, b) {
+		for bi < h.NewStart {
+			m[bi] = ai
+			ai, bi = ai+1, bi+1
+		}
+		ai, bi = h.OldEnd(), h.NewEnd()
+	}
+	for bi < len(b) {
+		m[bi] = ai
+		ai, bi = ai+1, bi+1
+	}
+	return m
+}
+
+// ReadIgnoreRevs reads a file of commits to ignore, one per line
+// Blank lines and comments starting with # are skipped
+func ReadIgnoreRevs(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not open object name list: %s", file)
+		}
+		return nil, err
+	}
+	var revs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			revs = append(revs, line)
+		}
+	}
+	return revs, scanner.Err()
+}
@@ -0,0 +1,151 @@
+package blame
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of line numbers, counting from 1
+type Range struct {
+	Start, End int
+}
+
+// ParseRanges resolves -L specifications against the lines of the file path
+// Each is <start>,<end> where start is a number or /regex/ and end is a number,
+// /regex/, +count or -count; either side may be left out. Overlapping ranges are merged.
+func ParseRanges(specs []string, lines []string, path string) ([]Range, error) {
+	var ranges []Range
+	for _, spec := range specs {
+		rg, err := parseRange(spec, lines)
+		if err != nil {
+			return nil, err
+		}
+		if rg.Start > len(lines) {
+			return nil, fmt.Errorf("file %s has only %d line%s", path, len(lines), plural(len(lines)))
+		}
+		if rg.End > len(lines) {
+			rg.End = len(lines)
+		}
+		ranges = append(ranges, rg)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	var merged []Range
+	for _, rg := range ranges {
+		if n := len(merged); n > 0 && rg.Start <= merged[n-1].End+1 {
+			if rg.End > merged[n-1].End {
+				merged[n-1].End = rg.End
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	return merged, nil
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func parseRange(spec string, lines []string) (Range, error) {
+	bad := fmt.Errorf("invalid -L range: %s", spec)
+	startSpec, endSpec, hasEnd := splitRange(spec)
+	start := 1
+	if startSpec != "" {
+		n, err := lineSpec(startSpec, lines, 0)
+		if err != nil {
+			return Range{}, err
+		}
+		if n < 1 {
+			return Range{}, bad
+		}
+		start = n
+	}
+	if !hasEnd || endSpec == "" {
+		// a lone start runs to the end of the file
+		return Range{Start: start, End: len(lines)}, nil
+	}
+	switch endSpec[0] {
+	case '+':
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return Range{}, bad
+		}
+		if n == 0 {
+			n = 1
+		}
+		return Range{Start: start, End: start + n - 1}, nil
+	case '-':
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return Range{}, bad
+		}
+		if n == 0 {
+			n = 1
+		}
+		first := start - n + 1
+		if first < 1 {
+			first = 1
+		}
+		return Range{Start: first, End: start}, nil
+	}
+	end, err := lineSpec(endSpec, lines, start)
+	if err != nil {
+		return Range{}, err
+	}
+	if end < 1 {
+		return Range{}, bad
+	}
+	if end < start {
+		start, end = end, start
+	}
+	return Range{Start: start, End: end}, nil
+}
+
+// splitRange splits a range at the comma that is not inside a /regex/
+func splitRange(spec string) (string, string, bool) {
+	i := 0
+	if strings.HasPrefix(spec, "/") {
+		for i = 1; i < len(spec) && spec[i] != '/'; i++ {
+			if spec[i] == '\\' {
+				i++
+			}
+		}
+		i++
+	}
+	if i > len(spec) {
+		return spec, "", false
+	}
+	j := strings.IndexByte(spec[i:], ',')
+	if j == -1 {
+		return spec, "", false
+	}
+	return spec[:i+j], spec[i+j+1:], true
+}
+
+// lineSpec resolves a line number or a /regex/ searched for from the line after after
+func lineSpec(spec string, lines []string, after int) (int, error) {
+	if !strings.HasPrefix(spec, "/") {
+		n, err := strconv.Atoi(spec)
+		if err != nil {
+			return 0, fmt.Errorf("invalid -L range: %s", spec)
+		}
+		return n, nil
+	}
+	pattern := strings.TrimSuffix(spec[1:], "/")
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, fmt.Errorf("-L parameter '%s': %v", pattern, err)
+	}
+	for i := after; i < len(lines); i++ {
+		if re.MatchString(strings.TrimSuffix(lines[i], "\n")) {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("-L parameter '%s': no match", pattern)
+}
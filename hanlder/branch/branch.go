@@ -0,0 +1,341 @@
+package branch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Info describes a branch for listing
+// Name is the short name, Ref the full reference name
+type Info struct {
+	Name     string
+	Ref      string
+	Sha      string
+	Current  bool
+	Upstream string
+}
+
+const (
+	headsPrefix   = "refs/heads/"
+	remotesPrefix = "refs/remotes/"
+)
+
+// section returns the config section holding the settings of a branch
+func section(name string) string {
+	return fmt.Sprintf("branch \"%s\"", name)
+}
+
+// List returns the local branches, and the remote-tracking ones when remotes is set
+func List(r *repo.Gitrepo, local, remotes bool) ([]Info, error) {
+	current, _, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	if local {
+		prefixes = append(prefixes, headsPrefix)
+	}
+	if remotes {
+		prefixes = append(prefixes, remotesPrefix)
+	}
+	var list []Info
+	for _, prefix := range prefixes {
+		all, err := refs.ListRefs(r, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range all {
+			info := Info{
+				Name:    strings.TrimPrefix(ref.Name, prefix),
+				Ref:     ref.Name,
+				Sha:     ref.Sha,
+				Current: ref.Name == current,
+			}
+			if prefix == remotesPrefix {
+				info.Name = "remotes/" + info.Name
+			} else {
+				info.Upstream, _ = Upstream(r, info.Name)
+			}
+			list = append(list, info)
+		}
+	}
+	return list, nil
+}
+
+// Create makes a new branch at the commit start
+// An existing branch is only moved with force, and never while checked out
+func Create(r *repo.Gitrepo, name, start string, force bool) error {
+	if err := refs.CheckRefName(headsPrefix + name); err != nil {
+		return err
+	}
+	sha, err := object.ObjectFind(r, start, "commit")
+	if err != nil {
+		return err
+	}
+	ref := headsPrefix + name
+	if refs.Exists(r, ref) {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", name)
+		}
+		if current, _, _ := refs.Head(r); current == ref {
+			return fmt.Errorf("cannot force update the current branch '%s'", name)
+		}
+	}
+	return refs.UpdateRef(r, ref, sha, "branch: Created from "+start)
+}
+
+// Delete removes a branch; without force it must be merged into its upstream, or HEAD
+func Delete(r *repo.Gitrepo, name string, force bool) (string, error) {
+	ref := headsPrefix + name
+	sha, err := refs.ResolveRef(r, ref)
+	if err != nil {
+		return "", fmt.Errorf("branch '%s' not found", name)
+	}
+	current, head, err := refs.Head(r)
+	if err != nil {
+		return "", err
+	}
+	if current == ref {
+		return "", fmt.Errorf("cannot delete branch '%s' checked out at '%s'", name, r.Worktree)
+	}
+	if !force {
+		into := head
+		if upstream, err := Upstream(r, name); err == nil {
+			if up, err := refs.ResolveRef(r, upstream); err == nil {
+				into = up
+			}
+		}
+		merged := false
+		if into != "" {
+			merged, err = object.IsAncestor(r, sha, into)
+			if err != nil {
+				return "", err
+			}
+		}
+		if !merged {
+			return "", fmt.Errorf("the branch '%s' is not fully merged.\nIf you are sure you want to delete it, run 'branch -D %s'", name, name)
+		}
+	}
+	if err := refs.DeleteRef(r, ref); err != nil {
+		return "", err
+	}
+	if repo.ConfigHasSection(r, section(name)) {
+		repo.ConfigRemoveSection(r, section(name))
+		if err := repo.ConfigWrite(r); err != nil {
+			return "", err
+		}
+	}
+	return sha, nil
+}
+
+// Rename moves a branch, its config and HEAD if it is checked out
+func Rename(r *repo.Gitrepo, oldName, newName string, force bool) error {
+	oldRef, newRef := headsPrefix+oldName, headsPrefix+newName
+	if err := refs.CheckRefName(newRef); err != nil {
+		return err
+	}
+	current, _, err := refs.Head(r)
+	if err != nil {
+		return err
+	}
+	if !refs.Exists(r, oldRef) {
+		if current != oldRef {
+			return fmt.Errorf("branch '%s' not found", oldName)
+		}
+		// renaming an unborn branch only moves HEAD
+		return refs.WriteSymbolicRef(r, "HEAD", newRef, "")
+	}
+	if oldRef == newRef {
+		return nil
+	}
+	if refs.Exists(r, newRef) {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", newName)
+		}
+		if err := refs.DeleteRef(r, newRef); err != nil {
+			return err
+		}
+	}
+	if err := refs.RenameRef(r, oldRef, newRef, fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef)); err != nil {
+		return err
+	}
+	if current == oldRef {
+		if err := refs.WriteSymbolicRef(r, "HEAD", newRef, ""); err != nil {
+			return err
+		}
+	}
+	if repo.ConfigHasSection(r, section(oldName)) {
+		repo.ConfigRemoveSection(r, section(newName))
+		repo.ConfigRenameSection(r, section(oldName), section(newName))
+		return repo.ConfigWrite(r)
+	}
+	return nil
+}
+
+// Upstream returns the full reference name of the branch's upstream
+func Upstream(r *repo.Gitrepo, name string) (string, error) {
+	remote, hasRemote := repo.ConfigGet(r, section(name), "remote")
+	merge, hasMerge := repo.ConfigGet(r, section(name), "merge")
+	if !hasRemote || !hasMerge {
+		return "", fmt.Errorf("branch '%s' has no upstream", name)
+	}
+	if remote == "." {
+		return merge, nil
+	}
+	return remotesPrefix + remote + "/" + strings.TrimPrefix(merge, headsPrefix), nil
+}
+
+// SetUpstream makes upstream, a local or remote-tracking branch, the upstream of name
+func SetUpstream(r *repo.Gitrepo, name, upstream string) (string, error) {
+	if !refs.Exists(r, headsPrefix+name) {
+		return "", fmt.Errorf("branch '%s' does not exist", name)
+	}
+	full, err := refs.Expand(r, upstream)
+	if err != nil {
+		return "", fmt.Errorf("the requested upstream branch '%s' does not exist", upstream)
+	}
+	var remote, merge string
+	switch {
+	case strings.HasPrefix(full, headsPrefix):
+		remote, merge = ".", full
+	case strings.HasPrefix(full, remotesPrefix):
+		rest := strings.TrimPrefix(full, remotesPrefix)
+		remoteName, branchName, ok := strings.Cut(rest, "/")
+		if !ok {
+			return "", fmt.Errorf("cannot set up tracking information; '%s' is not a branch", upstream)
+		}
+		remote, merge = remoteName, headsPrefix+branchName
+	default:
+		return "", fmt.Errorf("cannot set up tracking information; '%s' is not a branch", upstream)
+	}
+	repo.ConfigSet(r, section(name), "remote", remote)
+	repo.ConfigSet(r, section(name), "merge", merge)
+	return refs.Shorten(full), repo.ConfigWrite(r)
+}
+
+// UnsetUpstream removes the upstream configuration of a branch
+func UnsetUpstream(r *repo.Gitrepo, name string) error {
+	if _, err := Upstream(r, name); err != nil {
+		return err
+	}
+	repo.ConfigUnset(r, section(name), "remote")
+	repo.ConfigUnset(r, section(name), "merge")
+	return repo.ConfigWrite(r)
+}
+
+// SwitchOptions control Switch
+// Create makes a new branch at Start, Force also resets an existing one
+// Detach checks out the commit without a branch, Discard throws local changes away
+type SwitchOptions struct {
+	Create  bool
+	Force   bool
+	Detach  bool
+	Discard bool
+	Start   string
+}
+
+// Result describes what Switch did
+type Result struct {
+	Branch  string
+	Sha     string
+	Created bool
+	Same    bool
+}
+
+// ErrNotBranch is returned when switching to something that is not a branch without Detach
+var ErrNotBranch = errors.New("a branch is expected")
+
+// Switch checks out the branch target, or the commit target when detaching
+func Switch(r *repo.Gitrepo, target string, opts SwitchOptions) (*Result, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	current, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{}
+	var sha string
+	switch {
+	case opts.Create:
+		start := opts.Start
+		if start == "" {
+			start = "HEAD"
+		}
+		if refs.Exists(r, headsPrefix+target) && !opts.Force {
+			return nil, fmt.Errorf("a branch named '%s' already exists", target)
+		}
+		if err := refs.CheckRefName(headsPrefix + target); err != nil {
+			return nil, err
+		}
+		sha, err = object.ObjectFind(r, start, "commit")
+		if err != nil {
+			return nil, err
+		}
+		res.Branch, res.Created = headsPrefix+target, true
+	case !opts.Detach && refs.Exists(r, headsPrefix+target):
+		res.Branch = headsPrefix + target
+		sha, err = refs.ResolveRef(r, res.Branch)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		sha, err = object.ObjectFind(r, target, "commit")
+		if err != nil {
+			return nil, err
+		}
+		if !opts.Detach {
+			return nil, fmt.Errorf("%w, got commit '%s'", ErrNotBranch, target)
+		}
+	}
+	res.Sha = sha
+	res.Same = res.Branch != "" && res.Branch == current
+
+	oldTree := ""
+	if head != "" {
+		oldTree, err = object.ObjectFind(r, head, "tree")
+		if err != nil {
+			return nil, err
+		}
+	}
+	newTree, err := object.ObjectFind(r, sha, "tree")
+	if err != nil {
+		return nil, err
+	}
+	if err := worktree.Checkout(r, oldTree, newTree, opts.Discard); err != nil {
+		return nil, err
+	}
+	if res.Created {
+		start := opts.Start
+		if start == "" {
+			start = "HEAD"
+		}
+		if err := refs.UpdateRef(r, res.Branch, sha, "branch: Created from "+start); err != nil {
+			return nil, err
+		}
+	}
+	from := refs.Shorten(current)
+	if current == "" {
+		from = head
+	}
+	to := refs.Shorten(res.Branch)
+	if res.Branch == "" {
+		to = target
+	}
+	msg := fmt.Sprintf("checkout: moving from %s to %s", from, to)
+	if res.Branch == "" {
+		err = refs.DetachHead(r, sha, msg)
+	} else {
+		err = refs.WriteSymbolicRef(r, "HEAD", res.Branch, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
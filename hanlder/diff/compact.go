@@ -0,0 +1,304 @@
+package diff
+
+// The tuning of git's indent heuristic, which picks where an ambiguous change goes
+const (
+	maxIndent                       = 200
+	maxBlanks                       = 20
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+	indentMaxSliding                = 100
+)
+
+// changed marks the changed lines of one side of a diff
+// It has a false sentinel before the first line and after the last, so index i+1 is line i.
+type changed struct {
+	lines []string
+	flags []bool
+}
+
+func (c *changed) at(i int) bool { return c.flags[i+1] }
+
+func (c *changed) set(i int, v bool) { c.flags[i+1] = v }
+
+// group is a run of changed lines [start, end), possibly empty
+type group struct{ start, end int }
+
+func (c *changed) first() group {
+	g := group{}
+	for c.at(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (c *changed) next(g *group) bool {
+	if g.end == len(c.lines) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; c.at(g.end); g.end++ {
+	}
+	return true
+}
+
+func (c *changed) previous(g *group) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; c.at(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (c *changed) slideDown(g *group) bool {
+	if g.end < len(c.lines) && c.lines[g.start] == c.lines[g.end] {
+		c.set(g.start, false)
+		g.start++
+		c.set(g.end, true)
+		g.end++
+		for c.at(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (c *changed) slideUp(g *group) bool {
+	if g.start > 0 && c.lines[g.start-1] == c.lines[g.end-1] {
+		g.start--
+		c.set(g.start, true)
+		g.end--
+		c.set(g.end, false)
+		for c.at(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// compact moves the changes of the hunks where git would put them: ambiguous groups of
+// changed lines are slid to line up with changes on the other side, or else to where the
+// indentation suggests a block starts and ends. It is git's xdl_change_compact.
+func compact(a, b []string, hunks []Hunk) []Hunk {
+	ca := &changed{lines: a, flags: make([]bool, len(a)+2)}
+	cb := &changed{lines: b, flags: make([]bool, len(b)+2)}
+	for _, h := range hunks {
+		for i := h.OldStart; i < h.OldEnd(); i++ {
+			ca.set(i, true)
+		}
+		for i := h.NewStart; i < h.NewEnd(); i++ {
+			cb.set(i, true)
+		}
+	}
+	compactSide(ca, cb)
+	compactSide(cb, ca)
+
+	var out []Hunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if !ca.at(i) && !cb.at(j) {
+			i++
+			j++
+			continue
+		}
+		h := Hunk{OldStart: i, NewStart: j}
+		for i < len(a) && ca.at(i) {
+			i++
+		}
+		for j < len(b) && cb.at(j) {
+			j++
+		}
+		h.OldLines, h.NewLines = i-h.OldStart, j-h.NewStart
+		out = append(out, h)
+	}
+	return out
+}
+
+func compactSide(c, other *changed) {
+	g, og := c.first(), other.first()
+	for {
+		if g.end != g.start {
+			var earliestEnd, endMatchingOther int
+			for {
+				size := g.end - g.start
+				endMatchingOther = -1
+				for c.slideUp(&g) {
+					other.previous(&og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for c.slideDown(&g) {
+					other.next(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					c.slideUp(&g)
+					other.previous(&og)
+				}
+			default:
+				size := g.end - g.start
+				shift := earliestEnd
+				if g.end-size-1 > shift {
+					shift = g.end - size - 1
+				}
+				if g.end-indentMaxSliding > shift {
+					shift = g.end - indentMaxSliding
+				}
+				bestShift := -1
+				var best splitScore
+				for ; shift <= g.end; shift++ {
+					var score splitScore
+					score.add(measureSplit(c.lines, shift))
+					score.add(measureSplit(c.lines, shift-size))
+					if bestShift == -1 || score.cmp(best) <= 0 {
+						best, bestShift = score, shift
+					}
+				}
+				for g.end > bestShift {
+					c.slideUp(&g)
+					other.previous(&og)
+				}
+			}
+		}
+		if !c.next(&g) {
+			break
+		}
+		other.next(&og)
+	}
+}
+
+// indent returns the width of a line's leading whitespace, -1 for a blank line
+func indent(line string) int {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			n++
+		case '\t':
+			n += 8 - n%8
+		case '\n', '\r', '\f', '\v':
+		default:
+			return n
+		}
+		if n >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+// splitMeasure describes the lines around a place a change could start or end
+type splitMeasure struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+func measureSplit(lines []string, split int) splitMeasure {
+	m := splitMeasure{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(lines) {
+		m.endOfFile = true
+	} else {
+		m.indent = indent(lines[split])
+	}
+	for i := split - 1; i >= 0; i-- {
+		if m.preIndent = indent(lines[i]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	for i := split + 1; i < len(lines); i++ {
+		if m.postIndent = indent(lines[i]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) add(m splitMeasure) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*postBlank
+
+	ind := m.indent
+	if ind == -1 {
+		ind = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += ind
+
+	pick := func(withBlank, without int) int {
+		if anyBlanks {
+			return withBlank
+		}
+		return without
+	}
+	switch {
+	case ind == -1, m.preIndent == -1, ind == m.preIndent:
+	case ind > m.preIndent:
+		s.penalty += pick(relativeIndentWithBlankPenalty, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > ind:
+		s.penalty += pick(relativeOutdentWithBlankPenalty, relativeOutdentPenalty)
+	default:
+		s.penalty += pick(relativeDedentWithBlankPenalty, relativeDedentPenalty)
+	}
+}
+
+// cmp is negative when s is the better split
+func (s splitScore) cmp(o splitScore) int {
+	c := 0
+	if s.effectiveIndent > o.effectiveIndent {
+		c = 1
+	} else if s.effectiveIndent < o.effectiveIndent {
+		c = -1
+	}
+	return indentWeight*c + s.penalty - o.penalty
+}
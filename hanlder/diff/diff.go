@@ -0,0 +1,127 @@
+package diff
+
+import "strings"
+
+// Hunk is a region where two line sequences differ:
+// the lines a[OldStart:OldStart+OldLines] were replaced by b[NewStart:NewStart+NewLines]
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+}
+
+// OldEnd returns the index just past the hunk's lines in the old sequence
+func (h Hunk) OldEnd() int {
+	return h.OldStart + h.OldLines
+}
+
+// NewEnd returns the index just past the hunk's lines in the new sequence
+func (h Hunk) NewEnd() int {
+	return h.NewStart + h.NewLines
+}
+
+// Lines splits data into lines, each keeping its newline
+// A last line without a newline is kept as it is
+func Lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Diff returns the regions where a and b differ, in order
+func Diff(a, b []string) []Hunk {
+	// the common prefix and suffix never take part in an edit
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	pairs := matches(a[pre:len(a)-suf], b[pre:len(b)-suf])
+
+	var hunks []Hunk
+	x, y := 0, 0
+	add := func(nx, ny int) {
+		if nx > x || ny > y {
+			hunks = append(hunks, Hunk{OldStart: pre + x, OldLines: nx - x, NewStart: pre + y, NewLines: ny - y})
+		}
+	}
+	for _, p := range pairs {
+		add(p[0], p[1])
+		x, y = p[0]+1, p[1]+1
+	}
+	add(len(a)-pre-suf, len(b)-pre-suf)
+	return compact(a, b, hunks)
+}
+
+// matches returns the pairs of equal lines of a shortest edit script from a to b,
+// found with Myers' algorithm
+func matches(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds the furthest x of each diagonal -d..d before step d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace from the end back to the start, collecting the diagonal moves
+func backtrack(trace [][]int, x, y int) [][2]int {
+	var pairs [][2]int
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			pairs = append(pairs, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs
+}
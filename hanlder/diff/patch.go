@@ -0,0 +1,211 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change
+const DefaultContext = 3
+
+// funcLineMax is the longest function name git puts in a hunk header
+const funcLineMax = 80
+
+// side is one version of a changed file
+type side struct {
+	data   []byte
+	binary bool
+}
+
+// load reads both sides of a change; a side that does not exist is empty
+// A side is binary if the diff attribute is unset or it has a NUL in its first 8000 bytes.
+func load(r *repo.Gitrepo, checker *attr.Checker, c Change) (side, side, error) {
+	read := func(name, sha string, mode uint32) (side, error) {
+		if sha == "" {
+			return side{}, nil
+		}
+		if mode == 0160000 {
+			return side{data: []byte("Subproject commit " + sha + "\n")}, nil
+		}
+		data, err := blobData(r, sha)
+		if err != nil {
+			return side{}, err
+		}
+		a := checker.Lookup(name)
+		binary := a.IsUnset("diff") || !a.IsSet("diff") && filter.IsBinary(data)
+		return side{data: data, binary: binary}, nil
+	}
+	o, err := read(c.OldPath, c.OldSha, c.OldMode)
+	if err != nil {
+		return side{}, side{}, err
+	}
+	n, err := read(c.NewPath, c.NewSha, c.NewMode)
+	if err != nil {
+		return side{}, side{}, err
+	}
+	return o, n, nil
+}
+
+// WritePatch writes the changes as a git-style patch with context lines around each hunk
+// A type change is shown as the deletion of the old file and the creation of the new one.
+func WritePatch(w io.Writer, r *repo.Gitrepo, changes []Change, context int) error {
+	checker := attr.NewChecker(r)
+	for _, c := range changes {
+		if c.Status == 'T' {
+			del := Change{Status: 'D', OldPath: c.OldPath, OldMode: c.OldMode, OldSha: c.OldSha}
+			add := Change{Status: 'A', NewPath: c.NewPath, NewMode: c.NewMode, NewSha: c.NewSha}
+			if err := writeFilePatch(w, r, checker, del, context); err != nil {
+				return err
+			}
+			if err := writeFilePatch(w, r, checker, add, context); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFilePatch(w, r, checker, c, context); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFilePatch(w io.Writer, r *repo.Gitrepo, checker *attr.Checker, c Change, context int) error {
+	oldPath, newPath := c.OldPath, c.NewPath
+	if c.Status == 'A' {
+		oldPath = newPath
+	}
+	if c.Status == 'D' {
+		newPath = oldPath
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", oldPath, newPath)
+	switch {
+	case c.Status == 'A':
+		fmt.Fprintf(&b, "new file mode %06o\n", c.NewMode)
+	case c.Status == 'D':
+		fmt.Fprintf(&b, "deleted file mode %06o\n", c.OldMode)
+	case c.OldMode != c.NewMode:
+		fmt.Fprintf(&b, "old mode %06o\nnew mode %06o\n", c.OldMode, c.NewMode)
+	}
+	if c.Status == 'R' {
+		fmt.Fprintf(&b, "similarity index %d%%\nrename from %s\nrename to %s\n", c.Score, c.OldPath, c.NewPath)
+	}
+	if c.OldSha != c.NewSha {
+		fmt.Fprintf(&b, "index %s..%s", abbrev(r, c.OldSha), abbrev(r, c.NewSha))
+		if c.Status != 'A' && c.Status != 'D' && c.OldMode == c.NewMode {
+			fmt.Fprintf(&b, " %06o", c.NewMode)
+		}
+		b.WriteString("\n")
+	}
+
+	if c.OldSha != c.NewSha {
+		o, n, err := load(r, checker, c)
+		if err != nil {
+			return err
+		}
+		from, to := "a/"+oldPath, "b/"+newPath
+		if c.Status == 'A' {
+			from = "/dev/null"
+		}
+		if c.Status == 'D' {
+			to = "/dev/null"
+		}
+		if o.binary || n.binary {
+			fmt.Fprintf(&b, "Binary files %s and %s differ\n", from, to)
+		} else if hunks := Unified(Lines(o.data), Lines(n.data), context); hunks != "" {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+			b.WriteString(hunks)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// abbrev shortens an object id for an index line; a missing side is all zeros
+func abbrev(r *repo.Gitrepo, sha string) string {
+	if sha == "" {
+		sha = repo.Format(r).ZeroID()
+	}
+	return object.Abbrev(sha)
+}
+
+// Unified returns the hunks turning a into b in unified diff format, each with up to
+// context unchanged lines around its changes; changes closer than twice that share a hunk
+func Unified(a, b []string, context int) string {
+	hunks := Diff(a, b)
+	var out strings.Builder
+	for i := 0; i < len(hunks); {
+		j := i
+		for j+1 < len(hunks) && hunks[j+1].OldStart-hunks[j].OldEnd() <= 2*context {
+			j++
+		}
+		first, last := hunks[i], hunks[j]
+		oldStart := max(first.OldStart-context, 0)
+		oldEnd := min(last.OldEnd()+context, len(a))
+		newStart := first.NewStart - (first.OldStart - oldStart)
+		newEnd := last.NewEnd() + (oldEnd - last.OldEnd())
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@", hunkRange(oldStart, oldEnd-oldStart), hunkRange(newStart, newEnd-newStart))
+		if fn := funcLine(a, oldStart); fn != "" {
+			out.WriteString(" " + fn)
+		}
+		out.WriteString("\n")
+		x := oldStart
+		for _, h := range hunks[i : j+1] {
+			writeLines(&out, ' ', a[x:h.OldStart])
+			writeLines(&out, '-', a[h.OldStart:h.OldEnd()])
+			writeLines(&out, '+', b[h.NewStart:h.NewEnd()])
+			x = h.OldEnd()
+		}
+		writeLines(&out, ' ', a[x:oldEnd])
+		i = j + 1
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk side, git style:
+// the length is left out when it is 1, and an empty side names the line before it
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+func writeLines(b *strings.Builder, prefix byte, lines []string) {
+	for _, l := range lines {
+		b.WriteByte(prefix)
+		b.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// funcLine returns the last line before index start that begins with a letter, '_' or '$',
+// git's default for the text after a hunk header
+func funcLine(a []string, start int) string {
+	for i := start - 1; i >= 0; i-- {
+		l := a[i]
+		if l == "" {
+			continue
+		}
+		c := l[0]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			if len(l) > funcLineMax {
+				l = l[:funcLineMax]
+			}
+			return strings.TrimRight(l, " \t\r\n\f\v")
+		}
+	}
+	return ""
+}
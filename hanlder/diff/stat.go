@@ -0,0 +1,212 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// StatWidth is the width git gives a --stat when it is not writing to a terminal
+const StatWidth = 80
+
+// fileStat is the size of the change to one file
+type fileStat struct {
+	name             string
+	added, deleted   int
+	binary           bool
+	oldSize, newSize int
+}
+
+// WriteStat writes a diffstat of the changes, fitting the lines in width columns
+// like git: a name column, the number of changed lines and a graph of + and -, then a summary
+func WriteStat(w io.Writer, r *repo.Gitrepo, changes []Change, width int) error {
+	checker := attr.NewChecker(r)
+	var stats []fileStat
+	maxLen, maxChange := 0, 0
+	binWidth, numberWidth := 0, 0
+	insertions, deletions := 0, 0
+	for _, c := range changes {
+		o, n, err := load(r, checker, c)
+		if err != nil {
+			return err
+		}
+		s := fileStat{name: statName(c)}
+		if o.binary || n.binary {
+			s.binary, s.oldSize, s.newSize = true, len(o.data), len(n.data)
+			if w := 14 + len(fmt.Sprint(s.oldSize)) + len(fmt.Sprint(s.newSize)); w > binWidth {
+				binWidth = w
+			}
+			// the counts are aligned with "Bin"
+			numberWidth = 3
+		} else {
+			a := Lines(o.data)
+			for _, h := range Diff(a, Lines(n.data)) {
+				s.added += h.NewLines
+				s.deleted += h.OldLines
+			}
+			insertions += s.added
+			deletions += s.deleted
+			if s.added+s.deleted > maxChange {
+				maxChange = s.added + s.deleted
+			}
+		}
+		if len(s.name) > maxLen {
+			maxLen = len(s.name)
+		}
+		stats = append(stats, s)
+	}
+
+	if nw := len(fmt.Sprint(maxChange)); nw > numberWidth {
+		numberWidth = nw
+	}
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var b strings.Builder
+	for _, s := range stats {
+		name := s.name
+		if len(name) > nameWidth {
+			// keep the end of the name, from a slash if there is one
+			name = name[len(name)-nameWidth+3:]
+			if i := strings.IndexByte(name, '/'); i != -1 {
+				name = name[i:]
+			}
+			name = "..." + name
+		}
+		fmt.Fprintf(&b, " %-*s |", nameWidth, name)
+		if s.binary {
+			fmt.Fprintf(&b, " %*s", numberWidth, "Bin")
+			if s.oldSize != 0 || s.newSize != 0 {
+				fmt.Fprintf(&b, " %d -> %d bytes", s.oldSize, s.newSize)
+			}
+			b.WriteString("\n")
+			continue
+		}
+		total := s.added + s.deleted
+		fmt.Fprintf(&b, " %*d", numberWidth, total)
+		add, del := s.added, s.deleted
+		if graphWidth <= maxChange {
+			scaled := scaleLinear(total, graphWidth, maxChange)
+			if scaled < 2 && add > 0 && del > 0 {
+				scaled = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = scaled - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = scaled - del
+			}
+		}
+		if total > 0 {
+			b.WriteString(" " + strings.Repeat("+", add) + strings.Repeat("-", del))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(StatSummary(len(changes), insertions, deletions))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// scaleLinear scales n out of max to the width of a graph, keeping non-zero counts visible
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+// StatSummary returns the last line of a diffstat, such as
+// " 2 files changed, 3 insertions(+), 1 deletion(-)"
+func StatSummary(files, insertions, deletions int) string {
+	if files == 0 {
+		return " 0 files changed\n"
+	}
+	plural := func(n int, one, many string) string {
+		if n == 1 {
+			return fmt.Sprintf(one, n)
+		}
+		return fmt.Sprintf(many, n)
+	}
+	s := plural(files, " %d file changed", " %d files changed")
+	if insertions > 0 || deletions == 0 {
+		s += plural(insertions, ", %d insertion(+)", ", %d insertions(+)")
+	}
+	if deletions > 0 || insertions == 0 {
+		s += plural(deletions, ", %d deletion(-)", ", %d deletions(-)")
+	}
+	return s + "\n"
+}
+
+// statName is the name of a change in a diffstat; renames show both names around " => ",
+// sharing the leading and trailing directories as in "dir/{old => new}/file"
+func statName(c Change) string {
+	if c.Status != 'R' {
+		return c.Path()
+	}
+	a, b := c.OldPath, c.NewPath
+	// the common prefix ends at a slash
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+	// the common suffix starts at a slash, and does not overlap the prefix
+	sfx := 0
+	for i := 1; i <= len(a)-pfx && i <= len(b)-pfx && a[len(a)-i] == b[len(b)-i]; i++ {
+		if a[len(a)-i] == '/' {
+			sfx = i
+		}
+	}
+	if pfx == 0 && sfx == 0 {
+		return a + " => " + b
+	}
+	return a[:pfx] + "{" + a[pfx:len(a)-sfx] + " => " + b[pfx:len(b)-sfx] + "}" + a[len(a)-sfx:]
+}
+
+// WriteNameStatus writes one line per change: its status, with the score of renames, and its paths
+func WriteNameStatus(w io.Writer, changes []Change) error {
+	var b strings.Builder
+	for _, c := range changes {
+		if c.Status == 'R' {
+			fmt.Fprintf(&b, "R%03d\t%s\t%s\n", c.Score, c.OldPath, c.NewPath)
+			continue
+		}
+		fmt.Fprintf(&b, "%c\t%s\n", c.Status, c.Path())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteNameOnly writes the path of each change
+func WriteNameOnly(w io.Writer, changes []Change) error {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(c.Path() + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,235 @@
+package diff
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// renameScore is the similarity, in percent, a deleted and an added file need to be paired
+// as a rename, the same as git's default
+const renameScore = 50
+
+// Change is the difference between two trees at one path
+// Status is 'A' (added), 'D' (deleted), 'M' (modified), 'T' (type changed) or 'R' (renamed,
+// with Score the similarity of the two files in percent). Added files have no old side and
+// deleted files no new side.
+type Change struct {
+	Status  byte
+	OldPath string
+	NewPath string
+	OldMode uint32
+	NewMode uint32
+	OldSha  string
+	NewSha  string
+	Score   int
+}
+
+// Path returns the path the change is shown under: the new path, or the old one for deletions
+func (c Change) Path() string {
+	if c.Status == 'D' {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+// TreeChanges compares the trees oldTree and newTree, either of which can be empty
+// The changes come in path order. With renames set, deleted and added files with the same
+// or similar enough content are paired as renames.
+func TreeChanges(r *repo.Gitrepo, oldTree, newTree string, renames bool) ([]Change, error) {
+	oldFiles, err := object.TreeFiles(r, oldTree)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := object.TreeFiles(r, newTree)
+	if err != nil {
+		return nil, err
+	}
+	return FileChanges(r, oldFiles, newFiles, renames)
+}
+
+// FileChanges is TreeChanges for two sets of files keyed by path, as TreeFiles returns them
+func FileChanges(r *repo.Gitrepo, oldFiles, newFiles map[string]object.TreeFile, renames bool) ([]Change, error) {
+	var changes []Change
+	for name, o := range oldFiles {
+		n, ok := newFiles[name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Status: 'D', OldPath: name, OldMode: o.Mode, OldSha: o.Sha})
+		case o.Sha != n.Sha || o.Mode != n.Mode:
+			status := byte('M')
+			if o.Mode&0170000 != n.Mode&0170000 {
+				status = 'T'
+			}
+			changes = append(changes, Change{Status: status, OldPath: name, NewPath: name, OldMode: o.Mode, NewMode: n.Mode, OldSha: o.Sha, NewSha: n.Sha})
+		}
+	}
+	for name, n := range newFiles {
+		if _, ok := oldFiles[name]; !ok {
+			changes = append(changes, Change{Status: 'A', NewPath: name, NewMode: n.Mode, NewSha: n.Sha})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path() < changes[j].Path() })
+	if renames {
+		return detectRenames(r, changes)
+	}
+	return changes, nil
+}
+
+// detectRenames pairs deleted files with added ones, first those with the same content,
+// then the most similar pairs above renameScore. A rename takes the place of the addition.
+func detectRenames(r *repo.Gitrepo, changes []Change) ([]Change, error) {
+	var srcs, dsts []int
+	for i, c := range changes {
+		switch {
+		case c.Status == 'D' && renamable(c.OldMode):
+			srcs = append(srcs, i)
+		case c.Status == 'A' && renamable(c.NewMode):
+			dsts = append(dsts, i)
+		}
+	}
+	if len(srcs) == 0 || len(dsts) == 0 {
+		return changes, nil
+	}
+	used := map[int]bool{}
+	paired := map[int]int{}
+	score := map[int]int{}
+
+	// exact renames, preferring a source with the same base name
+	for _, d := range dsts {
+		best := -1
+		for _, s := range srcs {
+			if used[s] || changes[s].OldSha != changes[d].NewSha {
+				continue
+			}
+			if best == -1 || path.Base(changes[s].OldPath) == path.Base(changes[d].NewPath) && path.Base(changes[best].OldPath) != path.Base(changes[d].NewPath) {
+				best = s
+			}
+		}
+		if best != -1 {
+			used[best] = true
+			paired[d], score[d] = best, 100
+		}
+	}
+
+	// inexact renames, the most similar pairs first
+	type candidate struct{ src, dst, score int }
+	var candidates []candidate
+	contents := map[string][]string{}
+	lines := func(sha string) ([]string, bool, error) {
+		if l, ok := contents[sha]; ok {
+			return l, l != nil, nil
+		}
+		data, err := blobData(r, sha)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(data) == 0 || filter.IsBinary(data) {
+			contents[sha] = nil
+			return nil, false, nil
+		}
+		contents[sha] = Lines(data)
+		return contents[sha], true, nil
+	}
+	for _, d := range dsts {
+		if _, ok := paired[d]; ok {
+			continue
+		}
+		b, ok, err := lines(changes[d].NewSha)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, s := range srcs {
+			if used[s] {
+				continue
+			}
+			a, ok, err := lines(changes[s].OldSha)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if sc := Similarity(a, b); sc >= renameScore {
+				candidates = append(candidates, candidate{src: s, dst: d, score: sc})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	for _, c := range candidates {
+		if used[c.src] {
+			continue
+		}
+		if _, ok := paired[c.dst]; ok {
+			continue
+		}
+		used[c.src] = true
+		paired[c.dst], score[c.dst] = c.src, c.score
+	}
+
+	var out []Change
+	for i, c := range changes {
+		if used[i] {
+			continue
+		}
+		if s, ok := paired[i]; ok {
+			src := changes[s]
+			c = Change{Status: 'R', OldPath: src.OldPath, NewPath: c.NewPath, OldMode: src.OldMode, NewMode: c.NewMode,
+				OldSha: src.OldSha, NewSha: c.NewSha, Score: score[i]}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// renamable reports whether files of mode can take part in a rename: regular files and symlinks
+func renamable(mode uint32) bool {
+	return mode&0170000 == 0100000 || mode&0170000 == 0120000
+}
+
+// Similarity returns how much of a is kept in b, in percent of the larger of the two,
+// counting the bytes of unchanged lines as git does its rename scores
+func Similarity(a, b []string) int {
+	size := func(lines []string) int {
+		n := 0
+		for _, l := range lines {
+			n += len(l)
+		}
+		return n
+	}
+	max := size(a)
+	if s := size(b); s > max {
+		max = s
+	}
+	if max == 0 {
+		return 100
+	}
+	common := 0
+	x := 0
+	for _, h := range Diff(a, b) {
+		common += size(a[x:h.OldStart])
+		x = h.OldEnd()
+	}
+	common += size(a[x:])
+	return common * 100 / max
+}
+
+// blobData returns the content of a blob
+func blobData(r *repo.Gitrepo, sha string) ([]byte, error) {
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", sha)
+	}
+	return blob.Data, nil
+}
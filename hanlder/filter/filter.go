@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Filter converts file content between its form in the repository and in the worktree,
+// applying filter drivers and end-of-line conversion like git
+type Filter struct {
+	r     *repo.Gitrepo
+	attrs *attr.Checker
+}
+
+// New returns a filter using the repository's attributes and config
+func New(r *repo.Gitrepo) *Filter {
+	return &Filter{r: r, attrs: attr.NewChecker(r)}
+}
+
+// NewWithChecker returns a filter that looks attributes up in c
+func NewWithChecker(r *repo.Gitrepo, c *attr.Checker) *Filter {
+	return &Filter{r: r, attrs: c}
+}
+
+// IsBinary reports whether data looks binary: it has a NUL in the first 8000 bytes
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// config returns core.key, or "" when it is not set
+func (f *Filter) config(key string) string {
+	v, _ := repo.ConfigGet(f.r, "core", key)
+	return v
+}
+
+// textMode decides whether name is text, and whether that depends on its content
+func (f *Filter) textMode(a attr.Attributes) (text bool, auto bool) {
+	autocrlf := strings.ToLower(f.config("autocrlf"))
+	switch v := a["text"]; {
+	case v == attr.Set:
+		return true, false
+	case v == attr.Unset:
+		return false, false
+	case v == "auto":
+		return true, true
+	}
+	if _, ok := a["eol"]; ok {
+		return true, false
+	}
+	if autocrlf == "true" || autocrlf == "input" {
+		return true, true
+	}
+	return false, false
+}
+
+// wantsCRLF reports whether text files are written with CRLF line endings
+func (f *Filter) wantsCRLF(a attr.Attributes) bool {
+	switch a["eol"] {
+	case "crlf":
+		return true
+	case "lf":
+		return false
+	}
+	switch strings.ToLower(f.config("autocrlf")) {
+	case "true":
+		return true
+	case "input":
+		return false
+	}
+	return strings.ToLower(f.config("eol")) == "crlf"
+}
+
+// ToWorktree converts a blob of the file name to what is written in the worktree
+// A nil filter leaves the content as it is
+func (f *Filter) ToWorktree(name string, data []byte) ([]byte, error) {
+	if f == nil {
+		return data, nil
+	}
+	a := f.attrs.Lookup(name)
+	data, err := f.runDriver(a, "smudge", name, data)
+	if err != nil {
+		return nil, err
+	}
+	text, auto := f.textMode(a)
+	if !text || !f.wantsCRLF(a) {
+		return data, nil
+	}
+	if auto && (IsBinary(data) || bytes.Contains(data, []byte("\r\n"))) {
+		return data, nil
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")), nil
+}
+
+// ToGit converts the worktree content of the file name to what is stored as a blob
+// A nil filter leaves the content as it is
+func (f *Filter) ToGit(name string, data []byte) ([]byte, error) {
+	if f == nil {
+		return data, nil
+	}
+	a := f.attrs.Lookup(name)
+	data, err := f.runDriver(a, "clean", name, data)
+	if err != nil {
+		return nil, err
+	}
+	text, auto := f.textMode(a)
+	if !text || (auto && IsBinary(data)) {
+		return data, nil
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
+
+// runDriver pipes data through the clean or smudge command of the file's filter driver
+// A failing driver leaves the content unchanged unless it is marked required
+func (f *Filter) runDriver(a attr.Attributes, kind, name string, data []byte) ([]byte, error) {
+	driver, ok := a["filter"]
+	if !ok || driver == attr.Set || driver == attr.Unset {
+		return data, nil
+	}
+	section := fmt.Sprintf("filter \"%s\"", driver)
+	command, ok := repo.ConfigLookup(f.r, section, kind)
+	required := repo.ConfigBool(f.r, section, "required")
+	if !ok || command == "" {
+		if required {
+			return nil, fmt.Errorf("%s: %s filter '%s' is required but not configured", name, kind, driver)
+		}
+		return data, nil
+	}
+	command = strings.ReplaceAll(command, "%f", "'"+strings.ReplaceAll(name, "'", `'\''`)+"'")
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = f.r.Worktree
+	cmd.Stdin = bytes.NewReader(data)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		if required {
+			return nil, fmt.Errorf("%s: %s filter '%s' failed: %w", name, kind, driver, err)
+		}
+		return data, nil
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,373 @@
+package grep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Pattern syntaxes
+const (
+	Basic    = "basic"
+	Extended = "extended"
+	Fixed    = "fixed"
+	// Perl is Go's RE2 syntax: Perl's classes and flags, but no lookaround or backreferences
+	Perl = "perl"
+)
+
+// Options control a search
+// Patterns match when any of them does; Syntax is one of Basic (the default), Extended,
+// Fixed or Perl. Files are read from the worktree unless Cached asks for the index or
+// Trees names tree-ishes to search. Binary files are skipped unless Text is set.
+type Options struct {
+	Patterns   []string
+	Syntax     string
+	IgnoreCase bool
+	WordRegexp bool
+	Invert     bool
+	Text       bool
+	Cached     bool
+	Trees      []string
+	Pathspec   *pathspec.Pathspec
+	Workers    int
+}
+
+// Match is a selected line, numbered from 1, without its newline
+type Match struct {
+	Line int
+	Text string
+}
+
+// File is a file with selected lines
+// Tree is the tree-ish it was found in, empty for the worktree and the index
+type File struct {
+	Tree    string
+	Path    string
+	Matches []Match
+}
+
+// source is a file to search: a blob, or a worktree file when sha is empty
+type source struct {
+	tree string
+	path string
+	sha  string
+}
+
+// Compile builds one regular expression matching any of the patterns
+func Compile(patterns []string, syntax string, ignoreCase, word bool) (*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no pattern given")
+	}
+	parts := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		p := pattern
+		switch syntax {
+		case Fixed:
+			p = regexp.QuoteMeta(p)
+		case Basic, "":
+			p = basicToExtended(p)
+		case Perl:
+			if what := unsupportedPerl(p); what != "" {
+				return nil, fmt.Errorf("-P pattern '%s' uses %s, which is not supported", pattern, what)
+			}
+		case Extended:
+		default:
+			return nil, fmt.Errorf("unknown pattern syntax: %s", syntax)
+		}
+		// each pattern is checked alone so that errors quote what the user wrote
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, patternError(pattern, syntax == Perl, err)
+		}
+		if word {
+			p = `\b(?:` + p + `)\b`
+		}
+		parts[i] = "(?:" + p + ")"
+	}
+	expr := strings.Join(parts, "|")
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, patternError(strings.Join(patterns, "' or '"), syntax == Perl, err)
+	}
+	return re, nil
+}
+
+// patternError reports that pattern does not compile, without the rewriting Compile did
+func patternError(pattern string, perl bool, err error) error {
+	var syntaxErr *syntax.Error
+	if errors.As(err, &syntaxErr) {
+		err = errors.New(string(syntaxErr.Code))
+	}
+	if perl {
+		return fmt.Errorf("invalid pattern '%s': %v (-P takes RE2 syntax)", pattern, err)
+	}
+	return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+}
+
+// unsupportedPerl names the PCRE syntax in p that RE2 has no equivalent for, or returns ""
+func unsupportedPerl(p string) string {
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '\\' && i+1 < len(p):
+			i++
+			switch n := p[i]; {
+			case n >= '1' && n <= '9':
+				return "backreferences"
+			case n == 'K':
+				return `\K`
+			}
+		case c == '[':
+			// nothing is special in a class but escapes, and a ] right after the [ or ^
+			i++
+			if i < len(p) && p[i] == '^' {
+				i++
+			}
+			if i < len(p) && p[i] == ']' {
+				i++
+			}
+			for ; i < len(p) && p[i] != ']'; i++ {
+				if p[i] == '\\' {
+					i++
+				}
+			}
+		case strings.HasPrefix(p[i:], "(?=") || strings.HasPrefix(p[i:], "(?!") ||
+			strings.HasPrefix(p[i:], "(?<=") || strings.HasPrefix(p[i:], "(?<!"):
+			return "lookaround"
+		case strings.HasPrefix(p[i:], "(?>"):
+			return "atomic groups"
+		case strings.ContainsRune("*+?}", rune(c)) && i+1 < len(p) && p[i+1] == '+':
+			return "possessive quantifiers"
+		}
+	}
+	return ""
+}
+
+// basicToExtended rewrites a POSIX basic regular expression in extended syntax:
+// \( \) \{ \} \| \+ \? become operators and their bare forms literals
+func basicToExtended(p string) string {
+	var b strings.Builder
+	atStart := true
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '\\' && i+1 < len(p):
+			i++
+			switch n := p[i]; n {
+			case '(', ')', '{', '}', '|', '+', '?':
+				b.WriteByte(n)
+				atStart = n == '(' || n == '|'
+				continue
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(n)
+			}
+		case c == '[':
+			// bracket expressions are the same in both syntaxes
+			j := i + 1
+			if j < len(p) && p[j] == '^' {
+				j++
+			}
+			if j < len(p) && p[j] == ']' {
+				j++
+			}
+			for j < len(p) && p[j] != ']' {
+				if p[j] == '[' && j+1 < len(p) && (p[j+1] == ':' || p[j+1] == '=' || p[j+1] == '.') {
+					if end := strings.Index(p[j+2:], string(p[j+1])+"]"); end != -1 {
+						j += end + 4
+						continue
+					}
+				}
+				j++
+			}
+			if j >= len(p) {
+				b.WriteString(`\[`)
+			} else {
+				b.WriteString(p[i : j+1])
+				i = j
+			}
+		case c == '(' || c == ')' || c == '{' || c == '}' || c == '|' || c == '+' || c == '?':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '*' && atStart:
+			b.WriteString(`\*`)
+		case c == '^' && !atStart:
+			b.WriteString(`\^`)
+		case c == '$' && i+1 < len(p) && !strings.HasPrefix(p[i+1:], `\)`) && !strings.HasPrefix(p[i+1:], `\|`):
+			b.WriteString(`\$`)
+		default:
+			b.WriteByte(c)
+		}
+		atStart = c == '^' && atStart
+	}
+	return b.String()
+}
+
+// Search looks for the patterns in the selected files, reading them in parallel
+// The files come back in path order, tree by tree, with only those that have matches.
+func Search(r *repo.Gitrepo, opts Options) ([]File, error) {
+	re, err := Compile(opts.Patterns, opts.Syntax, opts.IgnoreCase, opts.WordRegexp)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := listSources(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	checker := attr.NewChecker(r)
+	results := make([]*File, len(sources))
+	errs := make([]error, len(sources))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = searchFile(r, checker, re, sources[i], opts)
+			}
+		}()
+	}
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var files []File
+	for i, f := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if f != nil {
+			files = append(files, *f)
+		}
+	}
+	return files, nil
+}
+
+// listSources returns the files to search, in order
+func listSources(r *repo.Gitrepo, opts Options) ([]source, error) {
+	var sources []source
+	if len(opts.Trees) > 0 {
+		for _, rev := range opts.Trees {
+			tree, err := object.ObjectFind(r, rev, "tree")
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve revision: %s", rev)
+			}
+			files, err := object.TreeFiles(r, tree)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(files))
+			for name, f := range files {
+				if isFile(f.Mode) && opts.Pathspec.Match(name) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				sources = append(sources, source{tree: rev, path: name, sha: files[name].Sha})
+			}
+		}
+		return sources, nil
+	}
+
+	if !opts.Cached {
+		if err := repo.RequireWorktree(r); err != nil {
+			return nil, err
+		}
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, e := range idx.Entries {
+		if seen[e.Name] || !isFile(e.Mode) || !opts.Pathspec.Match(e.Name) {
+			continue
+		}
+		seen[e.Name] = true
+		s := source{path: e.Name, sha: e.Sha}
+		if !opts.Cached {
+			// the worktree copy is searched, unmerged paths once
+			s.sha = ""
+		} else if e.Stage != 0 {
+			continue
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+func isFile(mode uint32) bool {
+	return mode&0170000 == 0100000
+}
+
+// searchFile returns the selected lines of one file, nil when there are none
+func searchFile(r *repo.Gitrepo, checker *attr.Checker, re *regexp.Regexp, s source, opts Options) (*File, error) {
+	var data []byte
+	if s.sha == "" {
+		content, info, err := worktree.ReadFile(r, s.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		data = content
+	} else {
+		obj, err := object.ObjectRead(r, s.sha)
+		if err != nil {
+			return nil, err
+		}
+		blob, ok := obj.(*object.Blob)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a blob", s.sha)
+		}
+		data = blob.Data
+	}
+	if !opts.Text {
+		a := checker.Lookup(s.path)
+		if a.IsUnset("diff") || (!a.IsSet("diff") && filter.IsBinary(data)) {
+			return nil, nil
+		}
+	}
+
+	f := &File{Tree: s.tree, Path: s.path}
+	text := string(data)
+	for n := 1; len(text) > 0; n++ {
+		line, rest, _ := strings.Cut(text, "\n")
+		text = rest
+		if re.MatchString(line) != opts.Invert {
+			f.Matches = append(f.Matches, Match{Line: n, Text: line})
+		}
+	}
+	if len(f.Matches) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
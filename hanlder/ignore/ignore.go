@@ -0,0 +1,198 @@
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// pattern is one line of an ignore file
+type pattern struct {
+	dir     string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher answers whether worktree paths are ignored, from the .gitignore files of the
+// worktree, info/exclude and core.excludesFile
+type Matcher struct {
+	patterns []pattern
+}
+
+// New loads the ignore rules of the repository
+func New(r *repo.Gitrepo) *Matcher {
+	m := &Matcher{}
+	// lowest precedence first: later patterns win
+	if file, ok := repo.ConfigLookup(r, "core", "excludesfile"); ok && file != "" {
+		if strings.HasPrefix(file, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				file = filepath.Join(home, file[2:])
+			}
+		}
+		if data, err := os.ReadFile(file); err == nil {
+			m.Add("", data)
+		}
+	}
+	if data, err := os.ReadFile(repo.RepoPath(r, "info", "exclude")); err == nil {
+		m.Add("", data)
+	}
+	if r.Worktree == "" {
+		return m
+	}
+	var dirs []string
+	filepath.WalkDir(r.Worktree, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == repo.DefaultGitdirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == ".gitignore" {
+			rel, _ := filepath.Rel(r.Worktree, filepath.Dir(p))
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") < strings.Count(dirs[j], "/") || dirs[i] == "."
+	})
+	for _, dir := range dirs {
+		if data, err := os.ReadFile(filepath.Join(r.Worktree, filepath.FromSlash(dir), ".gitignore")); err == nil {
+			m.Add(dir, data)
+		}
+	}
+	return m
+}
+
+// Add adds the patterns of an ignore file found in the slash-separated directory dir
+func (m *Matcher) Add(dir string, data []byte) {
+	if dir == "." {
+		dir = ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		p := pattern{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := wildmatch(line)
+		if !anchored {
+			// a pattern without a slash matches at any depth
+			expr = "(.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			continue
+		}
+		p.re = re
+		m.patterns = append(m.patterns, p)
+	}
+}
+
+// wildmatch translates a gitignore glob to a regular expression
+// * and ? do not match a slash, ** matches across directories
+func wildmatch(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// match returns whether the last pattern matching name ignores it, and whether any matched
+func (m *Matcher) match(name string, isDir bool) (ignored bool, matched bool) {
+	for i := len(m.patterns) - 1; i >= 0; i-- {
+		p := m.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		rel := name
+		if p.dir != "" {
+			if !strings.HasPrefix(name, p.dir+"/") {
+				continue
+			}
+			rel = name[len(p.dir)+1:]
+		}
+		if p.re.MatchString(rel) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
+
+// Ignored reports whether the slash-separated worktree path name is ignored
+// A path inside an ignored directory is ignored too
+func (m *Matcher) Ignored(name string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if ignored, _ := m.match(strings.Join(parts[:i], "/"), true); ignored {
+			return true
+		}
+	}
+	ignored, _ := m.match(name, isDir)
+	return ignored
+}
@@ -0,0 +1,363 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Index is the staging area stored in .git/index
+// Entries are kept sorted by name and stage
+type Index struct {
+	Version uint32
+	Entries []*Entry
+}
+
+// Entry is one file in the index
+// Sha is the hex id of the blob, Stage is 0 for merged entries and 1-3 during a conflict
+type Entry struct {
+	CtimeSec, CtimeNsec uint32
+	MtimeSec, MtimeNsec uint32
+	Dev, Ino            uint32
+	Mode                uint32
+	Uid, Gid            uint32
+	Size                uint32
+	Sha                 string
+	Stage               int
+	AssumeValid         bool
+	SkipWorktree        bool
+	IntentToAdd         bool
+	Name                string
+}
+
+const (
+	flagAssumeValid  = 0x8000
+	flagExtended     = 0x4000
+	flagStageMask    = 0x3000
+	flagStageShift   = 12
+	flagNameMask     = 0x0fff
+	flagSkipWorktree = 0x4000
+	flagIntentToAdd  = 0x2000
+)
+
+// New returns an empty index
+func New() *Index {
+	return &Index{Version: 2}
+}
+
+// Path returns the index file of the repository, or GIT_INDEX_FILE when it is set
+func Path(r *repo.Gitrepo) string {
+	if path := os.Getenv("GIT_INDEX_FILE"); path != "" {
+		return path
+	}
+	return repo.RepoPath(r, "index")
+}
+
+// Read loads the index of the repository; a missing index is empty
+func Read(r *repo.Gitrepo) (*Index, error) {
+	data, err := os.ReadFile(Path(r))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	return parse(data, repo.Format(r))
+}
+
+// parse decodes the index file format versions 2, 3 and 4
+func parse(data []byte, format *repo.ObjectFormat) (*Index, error) {
+	size := format.Size
+	if len(data) < 12+size {
+		return nil, errors.New("index file too short")
+	}
+	body := data[:len(data)-size]
+	h := format.New()
+	h.Write(body)
+	if !bytes.Equal(h.Sum(nil), data[len(data)-size:]) {
+		return nil, errors.New("index file checksum mismatch")
+	}
+	if string(body[:4]) != "DIRC" {
+		return nil, errors.New("index file has a bad signature")
+	}
+	idx := &Index{Version: binary.BigEndian.Uint32(body[4:8])}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+	count := binary.BigEndian.Uint32(body[8:12])
+	pos := 12
+	prevName := ""
+	for i := uint32(0); i < count; i++ {
+		start := pos
+		if pos+40+size+2 > len(body) {
+			return nil, errors.New("index entry overflows the file")
+		}
+		u32 := func() uint32 {
+			v := binary.BigEndian.Uint32(body[pos : pos+4])
+			pos += 4
+			return v
+		}
+		e := &Entry{}
+		e.CtimeSec, e.CtimeNsec = u32(), u32()
+		e.MtimeSec, e.MtimeNsec = u32(), u32()
+		e.Dev, e.Ino = u32(), u32()
+		e.Mode = u32()
+		e.Uid, e.Gid = u32(), u32()
+		e.Size = u32()
+		e.Sha = hex.EncodeToString(body[pos : pos+size])
+		pos += size
+		flags := binary.BigEndian.Uint16(body[pos : pos+2])
+		pos += 2
+		e.AssumeValid = flags&flagAssumeValid != 0
+		e.Stage = int(flags&flagStageMask) >> flagStageShift
+		if flags&flagExtended != 0 {
+			if idx.Version < 3 || pos+2 > len(body) {
+				return nil, errors.New("index entry has unexpected extended flags")
+			}
+			ext := binary.BigEndian.Uint16(body[pos : pos+2])
+			pos += 2
+			e.SkipWorktree = ext&flagSkipWorktree != 0
+			e.IntentToAdd = ext&flagIntentToAdd != 0
+		}
+		if idx.Version == 4 {
+			// the name is stored as a prefix length to drop from the previous name and a suffix
+			strip, n := binary.Uvarint(body[pos:])
+			if n <= 0 || int(strip) > len(prevName) {
+				return nil, errors.New("index entry has a bad path prefix")
+			}
+			pos += n
+			end := bytes.IndexByte(body[pos:], 0)
+			if end == -1 {
+				return nil, errors.New("index entry name is not terminated")
+			}
+			e.Name = prevName[:len(prevName)-int(strip)] + string(body[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(body[pos:], 0)
+			if end == -1 {
+				return nil, errors.New("index entry name is not terminated")
+			}
+			e.Name = string(body[pos : pos+end])
+			pos += end
+			// entries are padded with 1-8 NULs to a multiple of 8 bytes
+			pos = start + ((pos-start)/8+1)*8
+		}
+		prevName = e.Name
+		idx.Entries = append(idx.Entries, e)
+	}
+	// optional extensions (cached trees, resolve undo, ...), named in upper case, are
+	// dropped as git rebuilds them; the others, like a split index (link) or sparse
+	// directories (sdir), change what the entries mean
+	for pos+8 <= len(body) {
+		sig := body[pos : pos+4]
+		if sig[0] < 'A' || sig[0] > 'Z' {
+			return nil, fmt.Errorf("index uses %s extension, which we do not understand", sig)
+		}
+		pos += 8 + int(binary.BigEndian.Uint32(body[pos+4:pos+8]))
+	}
+	return idx, nil
+}
+
+// Write stores the index in the repository
+func Write(r *repo.Gitrepo, idx *Index) error {
+	idx.Sort()
+	format := repo.Format(r)
+	version := idx.Version
+	if version != 3 {
+		version = 2
+	}
+	for _, e := range idx.Entries {
+		if e.SkipWorktree || e.IntentToAdd {
+			version = 3
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.Entries)))
+	for _, e := range idx.Entries {
+		start := buf.Len()
+		for _, v := range []uint32{e.CtimeSec, e.CtimeNsec, e.MtimeSec, e.MtimeNsec, e.Dev, e.Ino, e.Mode, e.Uid, e.Gid, e.Size} {
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+		sha, err := hex.DecodeString(e.Sha)
+		if err != nil || len(sha) != format.Size {
+			return fmt.Errorf("index entry %s has an invalid id %q", e.Name, e.Sha)
+		}
+		buf.Write(sha)
+		flags := uint16(e.Stage<<flagStageShift) & flagStageMask
+		if len(e.Name) < flagNameMask {
+			flags |= uint16(len(e.Name))
+		} else {
+			flags |= flagNameMask
+		}
+		if e.AssumeValid {
+			flags |= flagAssumeValid
+		}
+		extended := e.SkipWorktree || e.IntentToAdd
+		if extended {
+			flags |= flagExtended
+		}
+		binary.Write(&buf, binary.BigEndian, flags)
+		if extended {
+			var ext uint16
+			if e.SkipWorktree {
+				ext |= flagSkipWorktree
+			}
+			if e.IntentToAdd {
+				ext |= flagIntentToAdd
+			}
+			binary.Write(&buf, binary.BigEndian, ext)
+		}
+		buf.WriteString(e.Name)
+		pad := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, pad))
+	}
+	h := format.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+
+	path := Path(r)
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("unable to lock %s: lock file exists", path)
+		}
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, path)
+}
+
+// Sort orders the entries by name, then stage, as git requires
+func (idx *Index) Sort() {
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Stage < b.Stage
+	})
+}
+
+// Find returns the entry for name at stage, or nil
+func (idx *Index) Find(name string, stage int) *Entry {
+	if i, ok := idx.pos(name, stage); ok {
+		return idx.Entries[i]
+	}
+	return nil
+}
+
+// pos returns where the entry for name at stage is or would go in the sorted entries,
+// and whether it is there
+func (idx *Index) pos(name string, stage int) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		e := idx.Entries[i]
+		return e.Name > name || e.Name == name && e.Stage >= stage
+	})
+	return i, i < len(idx.Entries) && idx.Entries[i].Name == name && idx.Entries[i].Stage == stage
+}
+
+// Add inserts e, replacing any entries for the same path
+func (idx *Index) Add(e *Entry) {
+	idx.Remove(e.Name)
+	idx.Insert(e)
+}
+
+// Insert puts e in its place among the entries, replacing only the entry for the same
+// path and stage, so that the stages of a conflict can be added one by one
+func (idx *Index) Insert(e *Entry) {
+	i, ok := idx.pos(e.Name, e.Stage)
+	if ok {
+		idx.Entries[i] = e
+		return
+	}
+	idx.Entries = slices.Insert(idx.Entries, i, e)
+}
+
+// Remove drops every stage of the entry for name
+func (idx *Index) Remove(name string) bool {
+	i, _ := idx.pos(name, 0)
+	j := i
+	for j < len(idx.Entries) && idx.Entries[j].Name == name {
+		j++
+	}
+	idx.Entries = slices.Delete(idx.Entries, i, j)
+	return j > i
+}
+
+// Conflicts returns the names of the paths with unmerged entries
+func (idx *Index) Conflicts() []string {
+	var names []string
+	for _, e := range idx.Entries {
+		if e.Stage != 0 && (len(names) == 0 || names[len(names)-1] != e.Name) {
+			names = append(names, e.Name)
+		}
+	}
+	return names
+}
+
+// Files returns the merged entries as tree files keyed by path
+func (idx *Index) Files() map[string]object.TreeFile {
+	files := map[string]object.TreeFile{}
+	for _, e := range idx.Entries {
+		if e.Stage == 0 {
+			files[e.Name] = object.TreeFile{Mode: e.Mode, Sha: e.Sha}
+		}
+	}
+	return files
+}
+
+// SetStat copies the stat information of a worktree file into the entry
+func (e *Entry) SetStat(info os.FileInfo) {
+	mtime := info.ModTime()
+	e.MtimeSec = uint32(mtime.Unix())
+	e.MtimeNsec = uint32(mtime.Nanosecond())
+	e.CtimeSec, e.CtimeNsec = e.MtimeSec, e.MtimeNsec
+	e.Size = uint32(info.Size())
+	setSysStat(e, info)
+}
+
+// StatMatches reports whether info still describes the file the entry was made from
+func (e *Entry) StatMatches(info os.FileInfo) bool {
+	mtime := info.ModTime()
+	if e.MtimeSec != uint32(mtime.Unix()) || e.MtimeNsec != uint32(mtime.Nanosecond()) {
+		return false
+	}
+	if e.Size != uint32(info.Size()) {
+		return false
+	}
+	// a file written in the same second as the index may have changed since
+	return mtime.Before(time.Now().Add(-time.Second))
+}
+
+// ModeFromFileInfo returns the git mode of a worktree file
+func ModeFromFileInfo(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return 0120000
+	case info.IsDir():
+		return 0160000
+	case info.Mode()&0111 != 0:
+		return 0100755
+	}
+	return 0100644
+}
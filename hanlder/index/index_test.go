@@ -0,0 +1,78 @@
+package index
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// git runs git on the repository and returns its output
+func git(t *testing.T, r *repo.Gitrepo, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Worktree
+	cmd.Env = append(os.Environ(), "GIT_DIR="+r.Gitdir, "GIT_WORK_TREE="+r.Worktree)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestVersion4RoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	r, err := repo.RepoCreate(t.TempDir(), false, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// names sharing long prefixes, and shorter names after longer ones, exercise the
+	// prefix compression of version 4
+	names := []string{"dir/sub/deep/file", "dir/sub/deep/file2", "dir/sub/other", "dir/x", "top"}
+	for _, name := range names {
+		path := filepath.Join(r.Worktree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, r, append([]string{"add", "--"}, names...)...)
+	git(t, r, "update-index", "--index-version", "4")
+	want := git(t, r, "ls-files", "--stage")
+
+	idx, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != 4 {
+		t.Errorf("index version is %d, want 4", idx.Version)
+	}
+	var got []string
+	for _, e := range idx.Entries {
+		got = append(got, strings.Join([]string{"100644", e.Sha, "0\t" + e.Name}, " "))
+	}
+	if strings.Join(got, "\n") != want {
+		t.Errorf("read entries\n%s\nwant\n%s", strings.Join(got, "\n"), want)
+	}
+
+	// writing keeps the entries, in a version git reads
+	if err := Write(r, idx); err != nil {
+		t.Fatal(err)
+	}
+	if after := git(t, r, "ls-files", "--stage"); after != want {
+		t.Errorf("git reads the written index as\n%s\nwant\n%s", after, want)
+	}
+	// and the stat data, so git sees no change in the worktree
+	if changed := git(t, r, "diff", "--name-only"); changed != "" {
+		t.Errorf("git sees changes after the index was written:\n%s", changed)
+	}
+}
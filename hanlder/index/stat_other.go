@@ -0,0 +1,8 @@
+//go:build !unix
+
+package index
+
+import "os"
+
+// setSysStat is a no-op where the platform has no inode information
+func setSysStat(e *Entry, info os.FileInfo) {}
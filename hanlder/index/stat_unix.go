@@ -0,0 +1,20 @@
+//go:build unix
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// setSysStat copies the device, inode and owner of a file into the entry
+func setSysStat(e *Entry, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.Dev = uint32(st.Dev)
+	e.Ino = uint32(st.Ino)
+	e.Uid = st.Uid
+	e.Gid = st.Gid
+}
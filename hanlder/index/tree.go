@@ -0,0 +1,60 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// TreeError holds the entries that kept the index from being written as a tree:
+// unmerged entries, and entries naming objects the repository does not have
+type TreeError struct {
+	Unmerged []*Entry
+	Missing  []*Entry
+}
+
+func (e *TreeError) Error() string {
+	return "error building trees"
+}
+
+// WriteTree writes the merged entries of the index as trees and returns the id of the tree
+// at prefix, or of the top tree when prefix is empty
+// Intent-to-add entries are left out. Unless missingOK is set every entry must name an
+// object in the repository; submodule commits are not looked up.
+func (idx *Index) WriteTree(r *repo.Gitrepo, prefix string, missingOK bool) (string, error) {
+	problems := &TreeError{}
+	files := map[string]object.TreeFile{}
+	for _, e := range idx.Entries {
+		switch {
+		case e.Stage != 0:
+			problems.Unmerged = append(problems.Unmerged, e)
+			continue
+		case e.IntentToAdd:
+			continue
+		case !missingOK && e.Mode != 0160000:
+			if _, _, err := object.ObjectHeader(r, e.Sha); err != nil {
+				problems.Missing = append(problems.Missing, e)
+				continue
+			}
+		}
+		files[e.Name] = object.TreeFile{Mode: e.Mode, Sha: e.Sha}
+	}
+	if len(problems.Unmerged) > 0 || len(problems.Missing) > 0 {
+		return "", problems
+	}
+	if dir := strings.Trim(prefix, "/"); dir != "" {
+		sub := map[string]object.TreeFile{}
+		for name, f := range files {
+			if rest, ok := strings.CutPrefix(name, dir+"/"); ok {
+				sub[rest] = f
+			}
+		}
+		if len(sub) == 0 {
+			return "", fmt.Errorf("prefix %s not found", prefix)
+		}
+		files = sub
+	}
+	return object.WriteTree(r, files)
+}
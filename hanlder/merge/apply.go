@@ -0,0 +1,153 @@
+package merge
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// ErrDirtyIndex is returned when the index has changes that are not committed
+var ErrDirtyIndex = errors.New("your local changes would be overwritten: commit your changes or stash them to proceed")
+
+// Apply writes the result of a merge into the index and worktree, which must be at the tree head
+// Paths the merge did not change keep their local modifications; if a path it changes
+// has some, nothing is touched and a *worktree.OverwriteError is returned.
+// Conflicts are recorded as index stages 1 to 3.
+func Apply(r *repo.Gitrepo, head string, res *Result) error {
+	if err := repo.RequireWorktree(r); err != nil {
+		return err
+	}
+	headFiles, err := object.TreeFiles(r, head)
+	if err != nil {
+		return err
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return err
+	}
+	if len(idx.Conflicts()) > 0 {
+		return errors.New("you need to resolve your current index first")
+	}
+	if len(idx.Entries) != len(headFiles) {
+		return ErrDirtyIndex
+	}
+	for _, e := range idx.Entries {
+		if f, ok := headFiles[e.Name]; !ok || f.Sha != e.Sha || f.Mode != e.Mode {
+			return ErrDirtyIndex
+		}
+	}
+
+	names := map[string]bool{}
+	for name := range headFiles {
+		names[name] = true
+	}
+	for name := range res.Files {
+		names[name] = true
+	}
+	for name := range res.Conflicts {
+		names[name] = true
+	}
+	var touched []string
+	for name := range names {
+		_, conflicted := res.Conflicts[name]
+		if !conflicted && same(lookup(headFiles, name), lookup(res.Files, name)) {
+			continue
+		}
+		touched = append(touched, name)
+	}
+	sort.Strings(touched)
+
+	// check everything first, so a refused merge leaves everything as it was
+	filters := filter.New(r)
+	refused := &worktree.OverwriteError{Op: "merge"}
+	for _, name := range touched {
+		if e := idx.Find(name, 0); e != nil {
+			modified, err := worktree.IsModified(r, filters, e)
+			if err != nil {
+				return err
+			}
+			if modified {
+				refused.Modified = append(refused.Modified, name)
+			}
+			continue
+		}
+		if info, err := os.Lstat(worktree.FullPath(r, name)); err == nil && !info.IsDir() {
+			f, ok := res.Files[name]
+			if sha, _, err := worktree.HashFile(r, filters, name); !ok || err != nil || sha != f.Sha {
+				refused.Untracked = append(refused.Untracked, name)
+			}
+		}
+	}
+	// untracked files may also be in the way of the directories of what is written, or
+	// sit in a directory where a file goes
+	removed := func(name string) bool {
+		_, tracked := headFiles[name]
+		_, written := res.Files[name]
+		_, conflicted := res.Conflicts[name]
+		return tracked && !written && !conflicted
+	}
+	reported := map[string]bool{}
+	for _, name := range touched {
+		var mode uint32
+		if c, ok := res.Conflicts[name]; ok {
+			mode = c.Mode
+		} else if f, ok := res.Files[name]; ok {
+			mode = f.Mode
+		} else {
+			continue
+		}
+		blockers, err := worktree.InTheWay(r, name, mode, removed)
+		if err != nil {
+			return err
+		}
+		for _, p := range blockers {
+			if !reported[p] {
+				reported[p] = true
+				refused.Untracked = append(refused.Untracked, p)
+			}
+		}
+	}
+	if len(refused.Modified) > 0 || len(refused.Untracked) > 0 {
+		return refused
+	}
+
+	// removals go first, so that files can take the place of directories they empty
+	for _, name := range touched {
+		if removed(name) {
+			if err := worktree.RemoveFile(r, name); err != nil {
+				return err
+			}
+			idx.Remove(name)
+		}
+	}
+	for _, name := range touched {
+		if c, ok := res.Conflicts[name]; ok {
+			if _, err := worktree.WriteContent(r, filters, name, c.Mode, c.Content); err != nil {
+				return err
+			}
+			idx.Remove(name)
+			for stage, f := range []*object.TreeFile{c.Base, c.Ours, c.Theirs} {
+				if f != nil {
+					idx.Insert(&index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name, Stage: stage + 1})
+				}
+			}
+			continue
+		}
+		f, ok := res.Files[name]
+		if !ok {
+			continue
+		}
+		e, err := worktree.WriteFile(r, filters, name, f.Mode, f.Sha)
+		if err != nil {
+			return err
+		}
+		idx.Add(e)
+	}
+	return index.Write(r, idx)
+}
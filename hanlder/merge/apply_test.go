@@ -0,0 +1,117 @@
+package merge
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// writeTree stores files, path to content, as blobs and trees and returns the top tree
+func writeTree(t *testing.T, r *repo.Gitrepo, files map[string]string) string {
+	t.Helper()
+	entries := map[string]object.TreeFile{}
+	for name, content := range files {
+		sha, err := object.HashObject(r, "blob", []byte(content), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[name] = object.TreeFile{Mode: 0100644, Sha: sha}
+	}
+	sha, err := object.WriteTree(r, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+// applyTrees checks out head, lets prepare touch the worktree, then merges theirs into it
+// with base as the merge base
+func applyTrees(t *testing.T, base, head, theirs map[string]string, prepare func(dir string)) (*repo.Gitrepo, error) {
+	t.Helper()
+	r, err := repo.RepoCreate(t.TempDir(), false, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseTree, headTree, theirTree := writeTree(t, r, base), writeTree(t, r, head), writeTree(t, r, theirs)
+	if err := worktree.Checkout(r, "", headTree, false); err != nil {
+		t.Fatal(err)
+	}
+	prepare(r.Worktree)
+	res, err := Trees(r, baseTree, headTree, theirTree, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, Apply(r, headTree, res)
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	base := map[string]string{"a": "a\n", "d/x": "x\n"}
+	theirs := map[string]string{"a": "a\nb\n", "d": "file\n"}
+	r, err := applyTrees(t, base, base, theirs, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(r.Worktree, "d"))
+	if err != nil || string(data) != "file\n" {
+		t.Errorf("d is %q, %v after the merge", data, err)
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range idx.Entries {
+		names = append(names, e.Name)
+	}
+	if !slices.Equal(names, []string{"a", "d"}) {
+		t.Errorf("index holds %q after the merge", names)
+	}
+}
+
+func TestApplyUntrackedInTheWay(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		base, theirs  map[string]string
+		untracked     string
+		wantUntracked []string
+	}{
+		{"file where a directory goes", map[string]string{"a": "a\n"}, map[string]string{"a": "a\n", "q/inner": "i\n"}, "q", []string{"q"}},
+		{"directory where a file goes", map[string]string{"a": "a\n", "d/x": "x\n"}, map[string]string{"a": "a\n", "d": "file\n"}, "d/u", []string{"d/u"}},
+		{"file where a file goes", map[string]string{"a": "a\n"}, map[string]string{"a": "a\n", "n": "new\n"}, "n", []string{"n"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+			_, err := applyTrees(t, tc.base, tc.base, tc.theirs, func(dir string) {
+				path = filepath.Join(dir, filepath.FromSlash(tc.untracked))
+				write(t, path, "precious\n")
+			})
+			var overwrite *worktree.OverwriteError
+			if !errors.As(err, &overwrite) {
+				t.Fatalf("Apply returned %v, want an OverwriteError", err)
+			}
+			if !slices.Equal(overwrite.Untracked, tc.wantUntracked) {
+				t.Errorf("untracked files in the way are %q, want %q", overwrite.Untracked, tc.wantUntracked)
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != "precious\n" {
+				t.Errorf("%s is %q, %v after a refused merge", tc.untracked, data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package merge
+
+import (
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/diff"
+)
+
+// markerSize is the length of the conflict markers
+const markerSize = 7
+
+// Options name the sides of a merge in conflict markers
+// With Diff3 conflicts also show the base version
+type Options struct {
+	Ours   string
+	Base   string
+	Theirs string
+	Diff3  bool
+}
+
+// change is a hunk from base to one side of the merge
+type change struct {
+	hunk diff.Hunk
+	ours bool
+}
+
+// File merges the changes from base to ours and from base to theirs line by line
+// It returns the merged content and whether it has conflicts; conflicting regions,
+// including changes to adjacent lines, are written between conflict markers
+func File(base, ours, theirs []byte, opts Options) ([]byte, bool) {
+	baseLines, ourLines, theirLines := diff.Lines(base), diff.Lines(ours), diff.Lines(theirs)
+	var changes []change
+	ourHunks, theirHunks := diff.Diff(baseLines, ourLines), diff.Diff(baseLines, theirLines)
+	for i, j := 0, 0; i < len(ourHunks) || j < len(theirHunks); {
+		if j == len(theirHunks) || (i < len(ourHunks) && ourHunks[i].OldStart <= theirHunks[j].OldStart) {
+			changes = append(changes, change{hunk: ourHunks[i], ours: true})
+			i++
+		} else {
+			changes = append(changes, change{hunk: theirHunks[j]})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	conflict := false
+	pos := 0
+	for i := 0; i < len(changes); {
+		// group the changes that overlap or touch in the base
+		start, end := changes[i].hunk.OldStart, changes[i].hunk.OldEnd()
+		var ourGroup, theirGroup []diff.Hunk
+		for ; i < len(changes) && changes[i].hunk.OldStart <= end; i++ {
+			c := changes[i]
+			if c.hunk.OldEnd() > end {
+				end = c.hunk.OldEnd()
+			}
+			if c.ours {
+				ourGroup = append(ourGroup, c.hunk)
+			} else {
+				theirGroup = append(theirGroup, c.hunk)
+			}
+		}
+		writeLines(&out, baseLines[pos:start])
+		pos = end
+
+		ourText := apply(baseLines, ourLines, ourGroup, start, end)
+		theirText := apply(baseLines, theirLines, theirGroup, start, end)
+		switch {
+		case len(theirGroup) == 0:
+			writeLines(&out, ourText)
+		case len(ourGroup) == 0:
+			writeLines(&out, theirText)
+		case equalLines(ourText, theirText):
+			writeLines(&out, ourText)
+		default:
+			conflict = true
+			writeConflict(&out, baseLines[start:end], ourText, theirText, opts)
+		}
+	}
+	writeLines(&out, baseLines[pos:])
+	return []byte(out.String()), conflict
+}
+
+// apply returns the lines base[start:end] with the side's hunks in that range applied
+func apply(base, side []string, hunks []diff.Hunk, start, end int) []string {
+	var out []string
+	pos := start
+	for _, h := range hunks {
+		out = append(out, base[pos:h.OldStart]...)
+		out = append(out, side[h.NewStart:h.NewEnd()]...)
+		pos = h.OldEnd()
+	}
+	return append(out, base[pos:end]...)
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+// writeConflict writes a conflicting region between markers
+// Lines both sides agree on at its edges are moved out of the conflict
+func writeConflict(out *strings.Builder, base, ours, theirs []string, opts Options) {
+	pre := 0
+	if !opts.Diff3 {
+		for pre < len(ours) && pre < len(theirs) && ours[pre] == theirs[pre] {
+			pre++
+		}
+	}
+	suf := 0
+	if !opts.Diff3 {
+		for suf < len(ours)-pre && suf < len(theirs)-pre && ours[len(ours)-1-suf] == theirs[len(theirs)-1-suf] {
+			suf++
+		}
+	}
+	writeLines(out, ours[:pre])
+	marker(out, "<", opts.Ours)
+	writeSide(out, ours[pre:len(ours)-suf])
+	if opts.Diff3 {
+		marker(out, "|", opts.Base)
+		writeSide(out, base)
+	}
+	marker(out, "=", "")
+	writeSide(out, theirs[pre:len(theirs)-suf])
+	marker(out, ">", opts.Theirs)
+	writeLines(out, ours[len(ours)-suf:])
+}
+
+// writeSide writes one side of a conflict, ending it with a newline so the marker starts a line
+func writeSide(out *strings.Builder, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+func marker(out *strings.Builder, c, label string) {
+	out.WriteString(strings.Repeat(c, markerSize))
+	if label != "" {
+		out.WriteString(" " + label)
+	}
+	out.WriteByte('\n')
+}
@@ -0,0 +1,234 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Blue-Onion/pygo/hanlder/attr"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Conflict is a path the merge could not resolve
+// Base, Ours and Theirs are its versions on each side, nil where it is missing;
+// Content and Mode are what is left in the worktree
+type Conflict struct {
+	Base, Ours, Theirs *object.TreeFile
+	Content            []byte
+	Mode               uint32
+}
+
+// Result is the outcome of merging three trees
+// Files holds the resolved paths; Messages reports what happened, like git's
+// "Auto-merging" and "CONFLICT" lines
+type Result struct {
+	Files     map[string]object.TreeFile
+	Conflicts map[string]*Conflict
+	Messages  []string
+}
+
+// Clean reports whether every path was resolved
+func (res *Result) Clean() bool {
+	return len(res.Conflicts) == 0
+}
+
+// ConflictNames returns the conflicted paths in order
+func (res *Result) ConflictNames() []string {
+	names := make([]string, 0, len(res.Conflicts))
+	for name := range res.Conflicts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Trees merges the changes from the tree base to ours and from base to theirs
+// Any tree may be empty; resolved content is written as new blobs
+func Trees(r *repo.Gitrepo, base, ours, theirs string, opts Options) (*Result, error) {
+	baseFiles, err := object.TreeFiles(r, base)
+	if err != nil {
+		return nil, err
+	}
+	ourFiles, err := object.TreeFiles(r, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirFiles, err := object.TreeFiles(r, theirs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Ours == "" {
+		opts.Ours = "HEAD"
+	}
+	if !opts.Diff3 {
+		style, _ := repo.ConfigLookup(r, "merge", "conflictstyle")
+		opts.Diff3 = style == "diff3" || style == "zdiff3"
+	}
+
+	names := map[string]bool{}
+	for _, files := range []map[string]object.TreeFile{baseFiles, ourFiles, theirFiles} {
+		for name := range files {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	m := &merger{r: r, opts: opts, attrs: attr.NewChecker(r)}
+	res := &Result{Files: map[string]object.TreeFile{}, Conflicts: map[string]*Conflict{}}
+	for _, name := range sorted {
+		b, o, t := lookup(baseFiles, name), lookup(ourFiles, name), lookup(theirFiles, name)
+		if err := m.path(res, name, b, o, t); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func lookup(files map[string]object.TreeFile, name string) *object.TreeFile {
+	if f, ok := files[name]; ok {
+		return &f
+	}
+	return nil
+}
+
+func same(a, b *object.TreeFile) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+type merger struct {
+	r     *repo.Gitrepo
+	opts  Options
+	attrs *attr.Checker
+}
+
+// path merges one path given its version on each side
+func (m *merger) path(res *Result, name string, b, o, t *object.TreeFile) error {
+	resolve := func(f *object.TreeFile) {
+		if f != nil {
+			res.Files[name] = *f
+		}
+	}
+	switch {
+	case same(o, t):
+		resolve(o)
+		return nil
+	case same(b, o):
+		resolve(t)
+		return nil
+	case same(b, t):
+		resolve(o)
+		return nil
+	}
+
+	if o == nil || t == nil {
+		// one side deleted what the other changed
+		kept, deletedIn, modifiedIn := o, m.opts.Theirs, m.opts.Ours
+		if o == nil {
+			kept, deletedIn, modifiedIn = t, m.opts.Ours, m.opts.Theirs
+		}
+		data, err := m.content(kept)
+		if err != nil {
+			return err
+		}
+		res.Conflicts[name] = &Conflict{Base: b, Ours: o, Theirs: t, Content: data, Mode: kept.Mode}
+		res.Messages = append(res.Messages, fmt.Sprintf("CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.", name, deletedIn, modifiedIn, modifiedIn, name))
+		return nil
+	}
+
+	kind := "content"
+	if b == nil {
+		kind = "add/add"
+	}
+	mode, modeClean := o.Mode, true
+	switch {
+	case b != nil && o.Mode == b.Mode:
+		mode = t.Mode
+	case b != nil && t.Mode == b.Mode:
+	case o.Mode != t.Mode:
+		modeClean = false
+	}
+
+	if o.Sha == t.Sha {
+		if modeClean {
+			res.Files[name] = object.TreeFile{Mode: mode, Sha: o.Sha}
+			return nil
+		}
+		data, err := m.content(o)
+		if err != nil {
+			return err
+		}
+		res.Conflicts[name] = &Conflict{Base: b, Ours: o, Theirs: t, Content: data, Mode: o.Mode}
+		res.Messages = append(res.Messages, fmt.Sprintf("CONFLICT (mode): %s has mode %o in %s and %o in %s", name, o.Mode, m.opts.Ours, t.Mode, m.opts.Theirs))
+		return nil
+	}
+
+	var baseData []byte
+	if b != nil && b.Mode&0170000 == o.Mode&0170000 {
+		var err error
+		if baseData, err = m.content(b); err != nil {
+			return err
+		}
+	}
+	ourData, err := m.content(o)
+	if err != nil {
+		return err
+	}
+	if !isRegular(o.Mode) || !isRegular(t.Mode) {
+		// symlinks and submodules have no lines to merge
+		res.Conflicts[name] = &Conflict{Base: b, Ours: o, Theirs: t, Content: ourData, Mode: o.Mode}
+		res.Messages = append(res.Messages, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name))
+		return nil
+	}
+	theirData, err := m.content(t)
+	if err != nil {
+		return err
+	}
+	res.Messages = append(res.Messages, "Auto-merging "+name)
+	if filter.IsBinary(baseData) || filter.IsBinary(ourData) || filter.IsBinary(theirData) || m.attrs.Lookup(name).IsUnset("merge") {
+		res.Conflicts[name] = &Conflict{Base: b, Ours: o, Theirs: t, Content: ourData, Mode: o.Mode}
+		res.Messages = append(res.Messages,
+			fmt.Sprintf("warning: Cannot merge binary files: %s (%s vs. %s)", name, m.opts.Ours, m.opts.Theirs),
+			fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name))
+		return nil
+	}
+	merged, conflicted := File(baseData, ourData, theirData, m.opts)
+	if conflicted || !modeClean {
+		res.Conflicts[name] = &Conflict{Base: b, Ours: o, Theirs: t, Content: merged, Mode: mode}
+		res.Messages = append(res.Messages, fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", kind, name))
+		return nil
+	}
+	sha, err := object.ObjectWrite(m.r, &object.Blob{Data: merged})
+	if err != nil {
+		return err
+	}
+	res.Files[name] = object.TreeFile{Mode: mode, Sha: sha}
+	return nil
+}
+
+func isRegular(mode uint32) bool {
+	return mode&0170000 == 0100000
+}
+
+// content reads the blob of f
+func (m *merger) content(f *object.TreeFile) ([]byte, error) {
+	if f == nil || f.Mode == 0160000 {
+		return nil, nil
+	}
+	obj, err := object.ObjectRead(m.r, f.Sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, not a blob", f.Sha, obj.Type())
+	}
+	return blob.Data, nil
+}
@@ -0,0 +1,87 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// CheckObject reports whether data is well-formed content for an object of type typ,
+// with the same light checks git makes before hashing an object
+func CheckObject(Gitrepo *repo.Gitrepo, typ string, data []byte) error {
+	switch typ {
+	case "blob":
+		return nil
+	case "tree":
+		return checkTree(Gitrepo, data)
+	case "commit":
+		return checkCommit(Gitrepo, data)
+	case "tag":
+		return checkTag(Gitrepo, data)
+	}
+	return fmt.Errorf("invalid object type \"%s\"", typ)
+}
+
+// checkTree checks that every entry of a tree has a mode, a name and a full id
+func checkTree(Gitrepo *repo.Gitrepo, data []byte) error {
+	t := &Tree{HashSize: repo.Format(Gitrepo).Size}
+	if err := t.Deserialize(data); err != nil {
+		return fmt.Errorf("too-short tree object")
+	}
+	for _, e := range t.Data {
+		if _, err := ParseMode(e.Mode); err != nil || len(e.Mode) == 0 {
+			return fmt.Errorf("malformed mode in tree entry")
+		}
+		if len(e.Name) == 0 {
+			return fmt.Errorf("empty filename in tree entry")
+		}
+	}
+	return nil
+}
+
+// checkCommit checks that a commit starts with its tree and parent lines
+func checkCommit(Gitrepo *repo.Gitrepo, data []byte) error {
+	rest, ok := headerID(Gitrepo, data, "tree")
+	if !ok {
+		return fmt.Errorf("corrupt commit")
+	}
+	for bytes.HasPrefix(rest, []byte("parent ")) {
+		if rest, ok = headerID(Gitrepo, rest, "parent"); !ok {
+			return fmt.Errorf("corrupt commit")
+		}
+	}
+	return nil
+}
+
+// checkTag checks that a tag starts with the id and type of the object it tags
+func checkTag(Gitrepo *repo.Gitrepo, data []byte) error {
+	rest, ok := headerID(Gitrepo, data, "object")
+	if !ok || !bytes.HasPrefix(rest, []byte("type ")) {
+		return fmt.Errorf("corrupt tag")
+	}
+	line, _, ok := bytes.Cut(rest[len("type "):], []byte("\n"))
+	switch string(line) {
+	case "blob", "tree", "commit", "tag":
+	default:
+		return fmt.Errorf("corrupt tag")
+	}
+	if !ok {
+		return fmt.Errorf("corrupt tag")
+	}
+	return nil
+}
+
+// headerID checks that data starts with the line "<key> <object id>" and returns what follows it
+func headerID(Gitrepo *repo.Gitrepo, data []byte, key string) ([]byte, bool) {
+	line, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil, false
+	}
+	id, found := strings.CutPrefix(string(line), key+" ")
+	if !found || !repo.Format(Gitrepo).IsHexID(id) {
+		return nil, false
+	}
+	return rest, true
+}
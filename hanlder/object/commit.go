@@ -1,6 +1,10 @@
 package object
 
-import "bytes"
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
 
 
 
@@ -9,30 +13,69 @@ type Commit struct {
 	Fmt  []byte
 }
 
+// CommitData holds the headers and message of a commit or tag
+// Keys records the order headers appeared in, so serializing keeps the object id
 type CommitData struct{
 	Header map[string][]string
 	Message []byte
+	Keys []string
 }
 func (c *Commit) Type() string {
 	return "commit"
 }
 func (c *Commit) Deserialize(raw []byte)error{
 	kvlm:=map[string][]string{}
-	header,message,err:=kvlmParse(raw,0,kvlm)
+	var keys []string
+	header,message,err:=kvlmParse(raw,0,kvlm,&keys)
 	if err != nil {
 		return err
 	}
 	c.Data.Header=header
 	c.Data.Message=message
+	c.Data.Keys=keys
 	c.Fmt=[]byte("commit")
 	return nil
 }
 func (c *Commit) Serialize() ([]byte,error) {
+	return kvlmSerialize(c.Data), nil
+}
+
+// headerOrder is the order git writes the headers of commits and tags in
+var headerOrder = []string{"tree", "parent", "object", "type", "tag", "author", "committer", "tagger", "encoding", "mergetag", "gpgsig"}
+
+// orderedKeys returns the header keys of d, in the order they were parsed when known
+func orderedKeys(d CommitData) []string {
+	seen := map[string]bool{}
+	var keys []string
+	add := func(k string) {
+		if _, ok := d.Header[k]; ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range d.Keys {
+		add(k)
+	}
+	for _, k := range headerOrder {
+		add(k)
+	}
+	rest := []string{}
+	for k := range d.Header {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// kvlmSerialize writes the headers and message of a commit or tag
+func kvlmSerialize(d CommitData) []byte {
 	var buf bytes.Buffer
 
 	// Write headers
-	for key, values := range c.Data.Header {
-		for _, value := range values {
+	for _, key := range orderedKeys(d) {
+		for _, value := range d.Header[key] {
 
 			
 			lines := bytes.Split([]byte(value), []byte("\n"))
@@ -56,11 +99,14 @@ func (c *Commit) Serialize() ([]byte,error) {
 	buf.WriteByte('\n')
 
 
-	buf.Write(c.Data.Message)
+	buf.Write(d.Message)
 
-	return buf.Bytes(),nil
+	return buf.Bytes()
 }
-func kvlmParse(raw []byte, start int, kvlm map[string][]string) (map[string][]string, []byte, error) {
+func kvlmParse(raw []byte, start int, kvlm map[string][]string, keys *[]string) (map[string][]string, []byte, error) {
+	if start >= len(raw) {
+		return kvlm, nil, nil
+	}
 	spc := bytes.Index(raw[start:], []byte(" "))
 	nl := bytes.Index(raw[start:], []byte("\n"))
 	if spc == -1 || nl == -1 || spc > nl {
@@ -86,7 +132,49 @@ func kvlmParse(raw []byte, start int, kvlm map[string][]string) (map[string][]st
 		kvlm[string(key)] = append(v, string(value))
 	} else {
 		kvlm[string(key)] = []string{string(value)}
+		*keys = append(*keys, string(key))
+	}
+
+	return kvlmParse(raw, end+1, kvlm, keys)
+}
+
+// TreeSha returns the id of the commit's tree
+func (c *Commit) TreeSha() string {
+	if v := c.Data.Header["tree"]; len(v) > 0 {
+		return v[0]
 	}
+	return ""
+}
+
+// Subject returns the first line of the commit message
+func (c *Commit) Subject() string {
+	return Subject(c.Data.Message)
+}
+
+// Subject returns the first line of a commit or tag message
+func Subject(message []byte) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(string(message), "\n"), "\n")
+	return line
+}
 
-	return kvlmParse(raw, end+1, kvlm)
+// Abbrev shortens an object id to the seven characters git shows by default
+func Abbrev(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// Parents returns the ids of the commit's parents
+func (c *Commit) Parents() []string {
+	return c.Data.Header["parent"]
+}
+
+// NewCommit returns a commit of tree with the given parents, identity lines and message
+func NewCommit(tree string, parents []string, author, committer string, message []byte) *Commit {
+	header := map[string][]string{"tree": {tree}, "author": {author}, "committer": {committer}}
+	if len(parents) > 0 {
+		header["parent"] = parents
+	}
+	return &Commit{Data: CommitData{Header: header, Message: message}, Fmt: []byte("commit")}
 }
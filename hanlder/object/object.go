@@ -1,12 +1,14 @@
 package object
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"github.com/Blue-Onion/pygo/hanlder/repo"
 )
@@ -29,89 +31,171 @@ func lengthAndContent(raw []byte) (int, []byte, error) {
 	return length, parts[0], nil
 
 }
-func ObjectRead(repo *repo.Gitrepo, name string) (GitObject, error) {
+// newObject returns an empty object of the given type for the repository's object format
+func newObject(Gitrepo *repo.Gitrepo, typ string) (GitObject, error) {
+	switch typ {
+	case "blob":
+		return &Blob{}, nil
+	case "tree":
+		return &Tree{HashSize: repo.Format(Gitrepo).Size}, nil
+	case "commit":
+		return &Commit{}, nil
+	case "tag":
+		return &Tag{}, nil
+	}
+	return nil, fmt.Errorf("Type not found")
+}
+
+func ObjectRead(Gitrepo *repo.Gitrepo, name string) (GitObject, error) {
+	typ, content, err := ObjectReadRaw(Gitrepo, name)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := newObject(Gitrepo, typ)
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.Deserialize(content); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ObjectReadRaw returns the type and the undecoded content of the object with id name
+func ObjectReadRaw(Gitrepo *repo.Gitrepo, name string) (string, []byte, error) {
+	if !repo.Format(Gitrepo).IsHexID(name) {
+		return "", nil, fmt.Errorf("invalid object name: %s", name)
+	}
 	file := name[2:]
 	dir := name[:2]
-	path := repo.Gitdir + "/objects/" + dir + "/" + file
+	path := repo.RepoPath(Gitrepo, "objects", dir, file)
 	raw, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		if typ, data, ok, packErr := readPacked(Gitrepo, name); ok {
+			return typ, data, packErr
+		}
+		return "", nil, err
 	}
 	r, err := zlib.NewReader(bytes.NewReader(raw))
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	defer r.Close()
 	rawdata, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	i := bytes.IndexByte(rawdata, 0)
+	if i == -1 {
+		return "", nil, fmt.Errorf("Malformed object %s", name)
+	}
 	headers := rawdata[:i]
 	content := rawdata[i+1:]
-	_, typ, err := lengthAndContent(headers)
+	size, typ, err := lengthAndContent(headers)
+	if err != nil {
+		return "", nil, err
+	}
+	if size != len(content) {
+		return "", nil, fmt.Errorf("Malformed object %s", name)
+	}
+	return string(typ), content, nil
+}
+
+// ObjectHeader returns the type and size of the object with id name without reading its content
+func ObjectHeader(Gitrepo *repo.Gitrepo, name string) (string, int, error) {
+	if !repo.Format(Gitrepo).IsHexID(name) {
+		return "", 0, fmt.Errorf("invalid object name: %s", name)
+	}
+	f, err := os.Open(repo.RepoPath(Gitrepo, "objects", name[:2], name[2:]))
+	if err != nil {
+		if typ, data, ok, packErr := readPacked(Gitrepo, name); ok {
+			return typ, len(data), packErr
+		}
+		return "", 0, err
+	}
+	defer f.Close()
+	r, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close()
+	headers, err := bufio.NewReader(r).ReadBytes(0)
+	if err != nil {
+		return "", 0, fmt.Errorf("Malformed object %s", name)
+	}
+	size, typ, err := lengthAndContent(headers[:len(headers)-1])
+	if err != nil {
+		return "", 0, err
+	}
+	return string(typ), size, nil
+}
+
+// ListObjects returns the ids of all objects in the repository, in order
+func ListObjects(Gitrepo *repo.Gitrepo) ([]string, error) {
+	format := repo.Format(Gitrepo)
+	dirs, err := os.ReadDir(repo.RepoPath(Gitrepo, "objects"))
 	if err != nil {
 		return nil, err
 	}
-	var obj GitObject
-	switch string(typ) {
-	case "blob":
-		obj = &Blob{}
-		obj.Deserialize(content)
-	case "tree":
-		obj = &Tree{}
-		obj.Deserialize(content)
-	default:
-		return nil, fmt.Errorf("Type not found")
+	var ids []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(repo.RepoPath(Gitrepo, "objects", dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if id := dir.Name() + f.Name(); format.IsHexID(id) {
+				ids = append(ids, id)
+			}
+		}
 	}
-	return obj, nil
+	ids = append(ids, packedObjects(Gitrepo)...)
+	sort.Strings(ids)
+	// an object can be both loose and packed
+	return slices.Compact(ids), nil
 }
 
-func HashString(objType string, data []byte) string {
+// HashString returns the id of data stored as an object of objType
+func HashString(Gitrepo *repo.Gitrepo, objType string, data []byte) string {
 
 	header := objType + " " + strconv.Itoa(len(data)) + "\x00"
 
 	store := append([]byte(header), data...)
 
-	hash := sha1.Sum(store)
-
-	return fmt.Sprintf("%x", hash)
+	return repo.Format(Gitrepo).Sum(store)
 }
-func ObjectHash(path string, typ string, repo *repo.Gitrepo) (string, error) {
-	var obj GitObject
-	switch typ {
-	case "blob":
-		obj = &Blob{}
-	case "tree":
-		obj = &Tree{}
-	case "commit":
-		obj = &Commit{}
+// HashObject returns the id of data stored as an object of type typ, writing it when write is set
+// Unless literally is set, typ must be a known type and data must be well-formed for it.
+func HashObject(Gitrepo *repo.Gitrepo, typ string, data []byte, write, literally bool) (string, error) {
+	if !literally {
+		if err := CheckObject(Gitrepo, typ, data); err != nil {
+			return "", err
+		}
 	}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
+	if !write {
+		return HashString(Gitrepo, typ, data), nil
 	}
-
-	err = obj.Deserialize(data)
-	if err != nil {
-		return "", err
-	}
-
-	return ObjectWrite(repo, obj)
-
+	return writeRaw(Gitrepo, typ, data)
 }
+
 func ObjectWrite(Gitrepo *repo.Gitrepo, obj GitObject) (string, error) {
 
 	data, err := obj.Serialize()
 	if err != nil {
 		return "", err
 	}
+	return writeRaw(Gitrepo, obj.Type(), data)
+}
 
-	header := obj.Type() + " " + strconv.Itoa(len(data)) + "\x00"
+// writeRaw stores data as a loose object of type typ and returns its id
+func writeRaw(Gitrepo *repo.Gitrepo, typ string, data []byte) (string, error) {
+	header := typ + " " + strconv.Itoa(len(data)) + "\x00"
 	store := append([]byte(header), data...)
 
-	hash := sha1.Sum(store)
-	sha := fmt.Sprintf("%x", hash)
+	sha := repo.Format(Gitrepo).Sum(store)
 
 	path, err := repo.RepoFile(Gitrepo, true, "objects", sha[:2], sha[2:])
 	if err != nil {
@@ -119,7 +203,7 @@ func ObjectWrite(Gitrepo *repo.Gitrepo, obj GitObject) (string, error) {
 	}
 
 	exist, _ := repo.PathExist(path)
-	if exist {
+	if exist || findPacked(Gitrepo, sha) != nil {
 		return sha, nil
 	}
 
@@ -139,27 +223,3 @@ func ObjectWrite(Gitrepo *repo.Gitrepo, obj GitObject) (string, error) {
 
 	return sha, nil
 }
-func CatFile(repo *repo.Gitrepo, name string, tag string) {
-	obj, err := ObjectRead(repo, name)
-	if err != nil {
-		fmt.Println(err.Error())
-		return
-	}
-	switch tag {
-	case "-p":
-		switch o := obj.(type) {
-		case *Tree:
-			for _, v := range o.Data {
-				fmt.Printf("%s %s\t%s\n",
-					v.Mode,
-					fmt.Sprintf("%x", v.Sha), // <-- hex string
-					v.Name,
-				)
-			}
-		case *Blob:
-			fmt.Print(string(o.Data))
-		}
-	case "-t":
-		fmt.Println(obj.Type())
-	}
-}
@@ -0,0 +1,103 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Blue-Onion/pygo/hanlder/pack"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// openPacks keeps the packs of each object directory open between reads
+var openPacks = struct {
+	sync.Mutex
+	dirs map[string][]*pack.Pack
+}{dirs: map[string][]*pack.Pack{}}
+
+// packs returns the packs of the repository; rescan looks for packs added since the last call
+func packs(Gitrepo *repo.Gitrepo, rescan bool) []*pack.Pack {
+	dir := repo.RepoPath(Gitrepo, "objects", "pack")
+	openPacks.Lock()
+	defer openPacks.Unlock()
+	list, ok := openPacks.dirs[dir]
+	if ok && !rescan {
+		return list
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "pack-*.pack"))
+	known := map[string]bool{}
+	for _, p := range list {
+		known[p.Path] = true
+	}
+	for _, name := range names {
+		if known[name] {
+			continue
+		}
+		// a pack without its index is still being written
+		p, err := pack.Open(name, repo.Format(Gitrepo))
+		if err != nil {
+			continue
+		}
+		list = append(list, p)
+	}
+	openPacks.dirs[dir] = list
+	return list
+}
+
+// findPacked returns the pack holding sha, looking for new packs if none does
+func findPacked(Gitrepo *repo.Gitrepo, sha string) *pack.Pack {
+	for _, rescan := range []bool{false, true} {
+		for _, p := range packs(Gitrepo, rescan) {
+			if p.Has(sha) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// readPacked reads sha from the packs of the repository
+func readPacked(Gitrepo *repo.Gitrepo, sha string) (string, []byte, bool, error) {
+	p := findPacked(Gitrepo, sha)
+	if p == nil {
+		return "", nil, false, nil
+	}
+	typ, data, err := p.Read(sha)
+	return typ, data, true, err
+}
+
+// packedObjects lists the ids of the objects in packs
+func packedObjects(Gitrepo *repo.Gitrepo) []string {
+	var ids []string
+	for _, p := range packs(Gitrepo, true) {
+		for i := 0; i < p.Index.Len(); i++ {
+			ids = append(ids, p.Index.Sha(i))
+		}
+	}
+	return ids
+}
+
+// ObjectExists reports whether the object sha is in the repository, loose or packed
+func ObjectExists(Gitrepo *repo.Gitrepo, sha string) bool {
+	if !repo.Format(Gitrepo).IsHexID(sha) {
+		return false
+	}
+	if _, err := os.Stat(repo.RepoPath(Gitrepo, "objects", sha[:2], sha[2:])); err == nil {
+		return true
+	}
+	return findPacked(Gitrepo, sha) != nil
+}
+
+// packedWithPrefix lists the packed objects whose id starts with prefix
+func packedWithPrefix(Gitrepo *repo.Gitrepo, prefix string) []string {
+	var found []string
+	for _, id := range packedObjects(Gitrepo) {
+		if strings.HasPrefix(id, prefix) {
+			found = append(found, id)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
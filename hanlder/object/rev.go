@@ -0,0 +1,320 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// minShortSha is the shortest abbreviated id accepted
+const minShortSha = 4
+
+// ObjectFind resolves a revision such as HEAD~2, master^{tree} or v1.0:path to an object id
+// A non-empty typ peels the result to that type
+func ObjectFind(Gitrepo *repo.Gitrepo, name string, typ string) (string, error) {
+	rev, path, hasPath := strings.Cut(name, ":")
+	if hasPath && rev == "" {
+		return "", fmt.Errorf("index paths are not supported: %s", name)
+	}
+	sha, err := resolveRev(Gitrepo, rev)
+	if err != nil {
+		return "", err
+	}
+	if hasPath {
+		sha, err = resolvePath(Gitrepo, sha, path)
+		if err != nil {
+			return "", err
+		}
+	}
+	if typ == "" {
+		return sha, nil
+	}
+	return Peel(Gitrepo, sha, typ)
+}
+
+// resolveRev resolves a revision without a :path suffix
+func resolveRev(Gitrepo *repo.Gitrepo, rev string) (string, error) {
+	end := strings.IndexAny(rev, "^~")
+	if end == -1 {
+		end = len(rev)
+	}
+	sha, err := resolveBase(Gitrepo, rev[:end])
+	if err != nil {
+		return "", err
+	}
+	rest := rev[end:]
+	for rest != "" {
+		op := rest[0]
+		rest = rest[1:]
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			close := strings.Index(rest, "}")
+			if close == -1 {
+				return "", fmt.Errorf("bad revision: %s", rev)
+			}
+			typ := rest[1:close]
+			rest = rest[close+1:]
+			if typ == "" {
+				sha, err = Peel(Gitrepo, sha, "")
+			} else {
+				sha, err = Peel(Gitrepo, sha, typ)
+			}
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(rest[:digits])
+			rest = rest[digits:]
+		}
+		if op == '^' {
+			sha, err = nthParent(Gitrepo, sha, n)
+		} else {
+			for i := 0; i < n && err == nil; i++ {
+				sha, err = nthParent(Gitrepo, sha, 1)
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("bad revision %s: %w", rev, err)
+		}
+	}
+	return sha, nil
+}
+
+// resolveBase resolves a ref name or a full or abbreviated object id
+func resolveBase(Gitrepo *repo.Gitrepo, name string) (string, error) {
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+	if i := strings.Index(name, "@{"); i != -1 && strings.HasSuffix(name, "}") {
+		return resolveReflog(Gitrepo, name[:i], name[i+2:len(name)-1])
+	}
+	format := repo.Format(Gitrepo)
+	if format.IsHexID(strings.ToLower(name)) {
+		return strings.ToLower(name), nil
+	}
+	if full, err := refs.Expand(Gitrepo, name); err == nil {
+		return refs.ResolveRef(Gitrepo, full)
+	}
+	if len(name) >= minShortSha && len(name) < format.HexSize() && isHex(name) {
+		return expandShortSha(Gitrepo, strings.ToLower(name))
+	}
+	return "", fmt.Errorf("unknown revision: %s", name)
+}
+
+// resolveReflog resolves ref@{n} and ref@{date}; an empty ref is the current branch
+func resolveReflog(Gitrepo *repo.Gitrepo, ref, spec string) (string, error) {
+	var full string
+	if ref == "" {
+		branch, _, err := refs.Head(Gitrepo)
+		if err != nil {
+			return "", err
+		}
+		full = branch
+		if full == "" {
+			full = "HEAD"
+		}
+	} else {
+		var err error
+		full, err = refs.Expand(Gitrepo, ref)
+		if err != nil {
+			return "", fmt.Errorf("unknown revision: %s", ref)
+		}
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		return refs.ReflogAt(Gitrepo, full, n)
+	}
+	when, err := refs.ParseApproxDate(spec, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return refs.ReflogAtTime(Gitrepo, full, when)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// expandShortSha finds the single object whose id starts with prefix
+func expandShortSha(Gitrepo *repo.Gitrepo, prefix string) (string, error) {
+	entries, err := os.ReadDir(repo.RepoPath(Gitrepo, "objects", prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var found []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix[2:]) {
+			found = append(found, prefix[:2]+e.Name())
+		}
+	}
+	for _, id := range packedWithPrefix(Gitrepo, prefix) {
+		if !slices.Contains(found, id) {
+			found = append(found, id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("unknown revision: %s", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("short object id %s is ambiguous", prefix)
+}
+
+// nthParent returns the n-th parent of a commit, or the commit itself for n == 0
+func nthParent(Gitrepo *repo.Gitrepo, sha string, n int) (string, error) {
+	sha, err := Peel(Gitrepo, sha, "commit")
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return sha, nil
+	}
+	c, err := ReadCommit(Gitrepo, sha)
+	if err != nil {
+		return "", err
+	}
+	parents := c.Parents()
+	if n > len(parents) {
+		return "", fmt.Errorf("commit %s has no parent %d", sha, n)
+	}
+	return parents[n-1], nil
+}
+
+// resolvePath returns the id of the entry at path in the tree of a tree-ish
+func resolvePath(Gitrepo *repo.Gitrepo, sha, path string) (string, error) {
+	sha, err := Peel(Gitrepo, sha, "tree")
+	if err != nil {
+		return "", err
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return sha, nil
+	}
+	for _, part := range strings.Split(path, "/") {
+		t, err := ReadTree(Gitrepo, sha)
+		if err != nil {
+			return "", fmt.Errorf("path '%s' does not exist", path)
+		}
+		found := false
+		for _, e := range t.Data {
+			if string(e.Name) == part {
+				sha = fmt.Sprintf("%x", e.Sha)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("path '%s' does not exist", path)
+		}
+	}
+	return sha, nil
+}
+
+// Peel follows tags to their target and commits to their tree until an object of type typ is reached
+// An empty typ peels to the first object that is not a tag
+func Peel(Gitrepo *repo.Gitrepo, sha, typ string) (string, error) {
+	for {
+		obj, err := ObjectRead(Gitrepo, sha)
+		if err != nil {
+			return "", err
+		}
+		if obj.Type() == typ {
+			return sha, nil
+		}
+		switch o := obj.(type) {
+		case *Tag:
+			sha = o.Object()
+		case *Commit:
+			if typ == "" {
+				return sha, nil
+			}
+			if typ != "tree" {
+				return "", fmt.Errorf("%s is a commit, not a %s", sha, typ)
+			}
+			sha = o.TreeSha()
+		default:
+			if typ == "" {
+				return sha, nil
+			}
+			return "", fmt.Errorf("%s is a %s, not a %s", sha, obj.Type(), typ)
+		}
+	}
+}
+
+// ReadCommitTree returns the tree of the commit sha, or "" for an unborn HEAD, sha ""
+func ReadCommitTree(r *repo.Gitrepo, sha string) (string, error) {
+	if sha == "" {
+		return "", nil
+	}
+	c, err := ReadCommit(r, sha)
+	if err != nil {
+		return "", err
+	}
+	return c.TreeSha(), nil
+}
+
+// ReadCommit reads the commit with id sha
+func ReadCommit(Gitrepo *repo.Gitrepo, sha string) (*Commit, error) {
+	obj, err := ObjectRead(Gitrepo, sha)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := obj.(*Commit)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, not a commit", sha, obj.Type())
+	}
+	return c, nil
+}
+
+// ReadTree reads the tree with id sha
+func ReadTree(Gitrepo *repo.Gitrepo, sha string) (*Tree, error) {
+	obj, err := ObjectRead(Gitrepo, sha)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := obj.(*Tree)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, not a tree", sha, obj.Type())
+	}
+	return t, nil
+}
+
+// IsAncestor reports whether the commit ancestor is reachable from the commit sha
+func IsAncestor(Gitrepo *repo.Gitrepo, ancestor, sha string) (bool, error) {
+	seen := map[string]bool{}
+	queue := []string{sha}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == ancestor {
+			return true, nil
+		}
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		c, err := ReadCommit(Gitrepo, cur)
+		if err != nil {
+			return false, err
+		}
+		queue = append(queue, c.Parents()...)
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package object
+
+// Tag is an annotated tag; it shares the header and message layout of a commit
+type Tag struct {
+	Data CommitData
+	Fmt  []byte
+}
+
+func (t *Tag) Type() string {
+	return "tag"
+}
+
+func (t *Tag) Deserialize(raw []byte) error {
+	kvlm := map[string][]string{}
+	var keys []string
+	header, message, err := kvlmParse(raw, 0, kvlm, &keys)
+	if err != nil {
+		return err
+	}
+	t.Data.Header = header
+	t.Data.Message = message
+	t.Data.Keys = keys
+	t.Fmt = []byte("tag")
+	return nil
+}
+
+func (t *Tag) Serialize() ([]byte, error) {
+	return kvlmSerialize(t.Data), nil
+}
+
+// header returns the first value of a header, or ""
+func (t *Tag) header(key string) string {
+	if v := t.Data.Header[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Subject returns the first line of the tag message
+func (t *Tag) Subject() string {
+	return Subject(t.Data.Message)
+}
+
+// Object returns the id of the tagged object
+func (t *Tag) Object() string {
+	return t.header("object")
+}
+
+// ObjectType returns the type of the tagged object
+func (t *Tag) ObjectType() string {
+	return t.header("type")
+}
+
+// Name returns the tag name recorded in the object
+func (t *Tag) Name() string {
+	return t.header("tag")
+}
+
+// Tagger returns the identity line of the tagger
+func (t *Tag) Tagger() string {
+	return t.header("tagger")
+}
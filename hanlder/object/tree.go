@@ -2,11 +2,21 @@ package object
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
 )
+// Tree is a directory listing; HashSize is the raw id length of its entries,
+// 20 for sha1 when unset
 type Tree struct {
-	Data []TreeData
-	Fmt  []byte
+	Data     []TreeData
+	Fmt      []byte
+	HashSize int
 }
 type TreeData struct {
 	Mode []byte
@@ -14,13 +24,32 @@ type TreeData struct {
 	Sha  []byte
 }
 
+// hashSize returns the raw id length of the tree entries
+func (t *Tree) hashSize() int {
+	if t.HashSize == 0 {
+		return 20
+	}
+	return t.HashSize
+}
+
+// Serialize writes the entries in git's canonical order, sorting Data in place
+// Entries with an invalid mode, an empty name, a name holding a slash or NUL, or a name
+// that appears twice are refused.
 func (t *Tree) Serialize() ([]byte, error) {
 	var out bytes.Buffer
 
+	t.Sort()
+	seen := map[string]bool{}
 	for _, entry := range t.Data {
-
-		if len(entry.Sha) != 20 {
-			return nil, fmt.Errorf("invalid sha length: expected 20 bytes")
+		if err := checkEntry(entry); err != nil {
+			return nil, err
+		}
+		if seen[string(entry.Name)] {
+			return nil, fmt.Errorf("duplicate tree entry %q", entry.Name)
+		}
+		seen[string(entry.Name)] = true
+		if len(entry.Sha) != t.hashSize() {
+			return nil, fmt.Errorf("invalid sha length: expected %d bytes", t.hashSize())
 		}
 		out.Write(entry.Mode)
 		out.WriteByte(' ')
@@ -32,10 +61,29 @@ func (t *Tree) Serialize() ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// validModes are the modes git writes in trees
+var validModes = map[uint32]bool{0100644: true, 0100755: true, 0120000: true, 0040000: true, 0160000: true}
+
+// checkEntry checks that a tree entry has a canonical mode and a name git can store
+func checkEntry(e TreeData) error {
+	mode, err := ParseMode(e.Mode)
+	if err != nil || !validModes[mode] || string(e.Mode) != strconv.FormatUint(uint64(mode), 8) {
+		return fmt.Errorf("invalid mode %q for tree entry %q", e.Mode, e.Name)
+	}
+	switch {
+	case len(e.Name) == 0:
+		return fmt.Errorf("empty name in tree entry")
+	case bytes.IndexByte(e.Name, '/') != -1:
+		return fmt.Errorf("tree entry name %q contains a slash", e.Name)
+	case bytes.IndexByte(e.Name, 0) != -1:
+		return fmt.Errorf("tree entry name %q contains NUL", e.Name)
+	}
+	return nil
+}
+
 func (t *Tree) Deserialize(raw []byte) error {
 	t.Data = nil
 	n := 0
-	fmt.Println(string(raw))
 	for n < len(raw) {
 		spaceI := bytes.IndexByte(raw[n:], ' ')
 		if spaceI == -1 {
@@ -50,7 +98,7 @@ func (t *Tree) Deserialize(raw []byte) error {
 		nullI += spaceI + 1
 		name := raw[spaceI+1 : nullI]
 		shaStart := nullI + 1
-		shaEnd := shaStart + 20
+		shaEnd := shaStart + t.hashSize()
 		if shaEnd > len(raw) {
 			return fmt.Errorf("invalid tree: sha overflow")
 		}
@@ -69,4 +117,129 @@ func (t *Tree) Deserialize(raw []byte) error {
 
 func (t *Tree) Type() string {
 	return "tree"
-}
\ No newline at end of file
+}
+// TreeFile is a non-tree entry found while walking a tree
+type TreeFile struct {
+	Mode uint32
+	Sha  string
+}
+
+// ParseMode parses an octal tree entry mode
+func ParseMode(mode []byte) (uint32, error) {
+	m, err := strconv.ParseUint(string(mode), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return uint32(m), nil
+}
+
+// IsTreeMode reports whether mode is the mode of a subtree
+func IsTreeMode(mode uint32) bool {
+	return mode&0170000 == 0040000
+}
+
+// TreeFiles returns every file below the tree sha keyed by its slash-separated path
+// Submodules are included as entries with mode 160000
+func TreeFiles(Gitrepo *repo.Gitrepo, sha string) (map[string]TreeFile, error) {
+	files := map[string]TreeFile{}
+	if sha == "" {
+		return files, nil
+	}
+	err := walkTree(Gitrepo, sha, "", files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func walkTree(Gitrepo *repo.Gitrepo, sha, prefix string, files map[string]TreeFile) error {
+	t, err := ReadTree(Gitrepo, sha)
+	if err != nil {
+		return err
+	}
+	for _, e := range t.Data {
+		mode, err := ParseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, string(e.Name))
+		id := fmt.Sprintf("%x", e.Sha)
+		if IsTreeMode(mode) {
+			if err := walkTree(Gitrepo, id, name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = TreeFile{Mode: mode, Sha: id}
+	}
+	return nil
+}
+
+// treeEntryKey is the name git sorts a tree entry by: subtrees sort as if they ended in a slash
+func treeEntryKey(name string, mode uint32) string {
+	if IsTreeMode(mode) {
+		return name + "/"
+	}
+	return name
+}
+
+// Sort puts the entries in the order git requires: by name, with subtrees sorting as if
+// their names ended in a slash
+func (t *Tree) Sort() {
+	key := func(e TreeData) string {
+		mode, _ := ParseMode(e.Mode)
+		return treeEntryKey(string(e.Name), mode)
+	}
+	sort.SliceStable(t.Data, func(i, j int) bool { return key(t.Data[i]) < key(t.Data[j]) })
+}
+
+// WriteTree writes the trees holding files, keyed by slash-separated path, and returns the top tree's id
+func WriteTree(Gitrepo *repo.Gitrepo, files map[string]TreeFile) (string, error) {
+	type entry struct {
+		name string
+		mode uint32
+		sha  string
+	}
+	dirs := map[string][]entry{"": nil}
+	var addDir func(dir string)
+	addDir = func(dir string) {
+		if _, ok := dirs[dir]; ok {
+			return
+		}
+		dirs[dir] = nil
+		parent, name := path.Split(dir)
+		parent = strings.TrimSuffix(parent, "/")
+		addDir(parent)
+		dirs[parent] = append(dirs[parent], entry{name: name, mode: 040000})
+	}
+	for name, f := range files {
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		addDir(dir)
+		dirs[dir] = append(dirs[dir], entry{name: base, mode: f.Mode, sha: f.Sha})
+	}
+
+	format := repo.Format(Gitrepo)
+	var write func(dir string) (string, error)
+	write = func(dir string) (string, error) {
+		entries := dirs[dir]
+		t := &Tree{Fmt: []byte("tree"), HashSize: format.Size}
+		for _, e := range entries {
+			sha := e.sha
+			if IsTreeMode(e.mode) {
+				var err error
+				sha, err = write(path.Join(dir, e.name))
+				if err != nil {
+					return "", err
+				}
+			}
+			raw, err := hex.DecodeString(sha)
+			if err != nil {
+				return "", fmt.Errorf("invalid object id %s for %s", sha, path.Join(dir, e.name))
+			}
+			t.Data = append(t.Data, TreeData{Mode: []byte(strconv.FormatUint(uint64(e.mode), 8)), Name: []byte(e.name), Sha: raw})
+		}
+		return ObjectWrite(Gitrepo, t)
+	}
+	return write("")
+}
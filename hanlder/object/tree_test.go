@@ -0,0 +1,64 @@
+package object
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func treeEntry(mode, name string) TreeData {
+	return TreeData{Mode: []byte(mode), Name: []byte(name), Sha: bytes.Repeat([]byte{1}, 20)}
+}
+
+func TestTreeSort(t *testing.T) {
+	tree := &Tree{Data: []TreeData{
+		treeEntry("100644", "a0"),
+		treeEntry("40000", "a"),
+		treeEntry("100644", "a.b"),
+		treeEntry("100644", "B"),
+		treeEntry("160000", "a-"),
+	}}
+	tree.Sort()
+	var names []string
+	for _, e := range tree.Data {
+		names = append(names, string(e.Name))
+	}
+	// a directory sorts as if its name ended in a slash, between '.' and '0';
+	// a submodule is not a directory
+	if want := []string{"B", "a-", "a.b", "a", "a0"}; !slices.Equal(names, want) {
+		t.Errorf("sorted entries are %q, want %q", names, want)
+	}
+}
+
+func TestTreeSerialize(t *testing.T) {
+	tree := &Tree{Data: []TreeData{treeEntry("40000", "a"), treeEntry("100644", "a.b")}}
+	raw, err := tree.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, j := bytes.Index(raw, []byte("100644 a.b\x00")), bytes.Index(raw, []byte("40000 a\x00")); i == -1 || j == -1 || i > j {
+		t.Errorf("serialized tree is out of order: %q", raw)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		entries []TreeData
+		want    string
+	}{
+		{"duplicate name", []TreeData{treeEntry("100644", "x"), treeEntry("100755", "x")}, "duplicate"},
+		{"duplicate file and directory", []TreeData{treeEntry("100644", "x"), treeEntry("40000", "x")}, "duplicate"},
+		{"unknown mode", []TreeData{treeEntry("100664", "x")}, "invalid mode"},
+		{"zero-padded mode", []TreeData{treeEntry("040000", "x")}, "invalid mode"},
+		{"mode that is not octal", []TreeData{treeEntry("10064x", "x")}, "invalid mode"},
+		{"empty name", []TreeData{treeEntry("100644", "")}, "empty name"},
+		{"name with a slash", []TreeData{treeEntry("100644", "x/y")}, "slash"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := (&Tree{Data: tc.entries}).Serialize()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Serialize returned %v, want an error about %s", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// indexSignature starts a version 2 pack index
+var indexSignature = []byte{0xff, 't', 'O', 'c'}
+
+// Index maps the objects of a pack to where they start in it
+type Index struct {
+	Checksum string
+	size     int
+	ids      []byte
+	offsets  []int64
+}
+
+// WriteIndex writes a version 2 index of the pack entries to w
+func WriteIndex(w io.Writer, format *repo.ObjectFormat, entries []Entry, checksum string) error {
+	sorted := append([]Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Sha < sorted[j].Sha })
+	var b bytes.Buffer
+	b.Write(indexSignature)
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, e := range sorted {
+		id, err := hex.DecodeString(e.Sha)
+		if err != nil || len(id) != format.Size {
+			return fmt.Errorf("invalid object id: %s", e.Sha)
+		}
+		fanout[id[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(&b, binary.BigEndian, fanout)
+	for _, e := range sorted {
+		id, _ := hex.DecodeString(e.Sha)
+		b.Write(id)
+	}
+	for _, e := range sorted {
+		binary.Write(&b, binary.BigEndian, e.CRC)
+	}
+	// offsets past 2GB go to a second table, pointed at with the high bit
+	var large []int64
+	for _, e := range sorted {
+		if e.Offset < 0x80000000 {
+			binary.Write(&b, binary.BigEndian, uint32(e.Offset))
+			continue
+		}
+		binary.Write(&b, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, e.Offset)
+	}
+	for _, off := range large {
+		binary.Write(&b, binary.BigEndian, uint64(off))
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return err
+	}
+	b.Write(sum)
+	h := format.New()
+	h.Write(b.Bytes())
+	b.Write(h.Sum(nil))
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// ErrIndex is returned for an index file that cannot be read
+var ErrIndex = errors.New("corrupt pack index")
+
+// ReadIndex reads the version 2 pack index at path
+func ReadIndex(path string, format *repo.ObjectFormat) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	size := format.Size
+	header := 8 + 256*4
+	if len(data) < header+2*size || !bytes.Equal(data[:4], indexSignature) || binary.BigEndian.Uint32(data[4:]) != 2 {
+		return nil, fmt.Errorf("%s: %w", path, ErrIndex)
+	}
+	n := int(binary.BigEndian.Uint32(data[header-4:]))
+	idsEnd := header + n*size
+	offsetsStart := idsEnd + n*4
+	offsetsEnd := offsetsStart + n*4
+	if len(data) < offsetsEnd+2*size {
+		return nil, fmt.Errorf("%s: %w", path, ErrIndex)
+	}
+	idx := &Index{size: size, ids: data[header:idsEnd], offsets: make([]int64, n)}
+	large := data[offsetsEnd : len(data)-2*size]
+	for i := range idx.offsets {
+		off := binary.BigEndian.Uint32(data[offsetsStart+4*i:])
+		if off&0x80000000 == 0 {
+			idx.offsets[i] = int64(off)
+			continue
+		}
+		j := int(off&0x7fffffff) * 8
+		if j+8 > len(large) {
+			return nil, fmt.Errorf("%s: %w", path, ErrIndex)
+		}
+		idx.offsets[i] = int64(binary.BigEndian.Uint64(large[j:]))
+	}
+	idx.Checksum = hex.EncodeToString(data[len(data)-2*size : len(data)-size])
+	return idx, nil
+}
+
+// Len returns how many objects the pack holds
+func (idx *Index) Len() int {
+	return len(idx.offsets)
+}
+
+// Sha returns the id of the i-th object, in id order
+func (idx *Index) Sha(i int) string {
+	return hex.EncodeToString(idx.ids[i*idx.size : (i+1)*idx.size])
+}
+
+// Offset returns where the object sha starts in the pack
+func (idx *Index) Offset(sha string) (int64, bool) {
+	id, err := hex.DecodeString(sha)
+	if err != nil || len(id) != idx.size {
+		return 0, false
+	}
+	i := sort.Search(idx.Len(), func(i int) bool {
+		return bytes.Compare(idx.ids[i*idx.size:(i+1)*idx.size], id) >= 0
+	})
+	if i < idx.Len() && bytes.Equal(idx.ids[i*idx.size:(i+1)*idx.size], id) {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
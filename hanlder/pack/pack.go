@@ -0,0 +1,233 @@
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Object types as numbered in pack entries
+const (
+	typeCommit   = 1
+	typeTree     = 2
+	typeBlob     = 3
+	typeTag      = 4
+	typeOfsDelta = 6
+	typeRefDelta = 7
+)
+
+var typeNames = map[int]string{typeCommit: "commit", typeTree: "tree", typeBlob: "blob", typeTag: "tag"}
+
+var typeNumbers = map[string]int{"commit": typeCommit, "tree": typeTree, "blob": typeBlob, "tag": typeTag}
+
+// signature starts every pack
+var signature = []byte("PACK")
+
+// Entry is an object of a pack: its id, where it starts and the checksum of its bytes
+type Entry struct {
+	Sha    string
+	Offset int64
+	CRC    uint32
+}
+
+// ReadFunc returns the type and content of the object sha
+type ReadFunc func(sha string) (string, []byte, error)
+
+// counter counts and checksums what goes through it
+type counter struct {
+	w   io.Writer
+	n   int64
+	crc uint32
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// Write writes the objects shas, read with read, as a version 2 pack to w
+// Objects are stored whole, without deltas. It returns the entries for the index,
+// in pack order, and the pack checksum.
+func Write(w io.Writer, format *repo.ObjectFormat, shas []string, read ReadFunc) ([]Entry, string, error) {
+	h := format.New()
+	c := &counter{w: io.MultiWriter(w, h)}
+	header := make([]byte, 12)
+	copy(header, signature)
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(shas)))
+	if _, err := c.Write(header); err != nil {
+		return nil, "", err
+	}
+	entries := make([]Entry, 0, len(shas))
+	for _, sha := range shas {
+		typ, data, err := read(sha)
+		if err != nil {
+			return nil, "", err
+		}
+		num, ok := typeNumbers[typ]
+		if !ok {
+			return nil, "", fmt.Errorf("cannot pack object %s of type %s", sha, typ)
+		}
+		e := Entry{Sha: sha, Offset: c.n}
+		if e.CRC, err = writeEntry(c, num, data); err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, e)
+	}
+	sum := h.Sum(nil)
+	if _, err := w.Write(sum); err != nil {
+		return nil, "", err
+	}
+	return entries, hex.EncodeToString(sum), nil
+}
+
+// writeEntry writes a whole object as a pack entry and returns its checksum
+func writeEntry(c *counter, typ int, data []byte) (uint32, error) {
+	c.crc = 0
+	if _, err := c.Write(entryHeader(typ, len(data))); err != nil {
+		return 0, err
+	}
+	z := zlib.NewWriter(c)
+	if _, err := z.Write(data); err != nil {
+		return 0, err
+	}
+	if err := z.Close(); err != nil {
+		return 0, err
+	}
+	return c.crc, nil
+}
+
+// entryHeader encodes the type and size that start a pack entry
+func entryHeader(typ, size int) []byte {
+	b := []byte{byte(typ<<4) | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		b[len(b)-1] |= 0x80
+		b = append(b, byte(size&0x7f))
+		size >>= 7
+	}
+	return b
+}
+
+// readEntryHeader decodes the type and size that start a pack entry
+func readEntryHeader(r io.ByteReader) (int, int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+	return typ, size, nil
+}
+
+// readOfsDelta decodes the distance back to the base of an offset delta
+func readOfsDelta(r io.ByteReader) (int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		offset = (offset+1)<<7 | int64(c&0x7f)
+	}
+	return offset, nil
+}
+
+// inflate reads a zlib stream holding size bytes
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	z, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(z, data); err != nil {
+		return nil, err
+	}
+	// reading to the end checks the stream's checksum
+	if n, err := io.Copy(io.Discard, z); err != nil || n != 0 {
+		return nil, errors.New("pack entry is longer than its header says")
+	}
+	return data, nil
+}
+
+// ErrDelta is returned for a delta that does not fit its base
+var ErrDelta = errors.New("corrupt delta")
+
+// applyDelta rebuilds an object from its base and a delta
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	srcSize, err := binary.ReadUvarint(r)
+	if err != nil || srcSize != uint64(len(base)) {
+		return nil, ErrDelta
+	}
+	dstSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrDelta
+	}
+	out := make([]byte, 0, dstSize)
+	for r.Len() > 0 {
+		cmd, _ := r.ReadByte()
+		switch {
+		case cmd&0x80 != 0:
+			// copy from the base: the low bits say which offset and size bytes follow
+			var offset, size uint64
+			for i := 0; i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, ErrDelta
+					}
+					offset |= uint64(b) << (8 * i)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					b, err := r.ReadByte()
+					if err != nil {
+						return nil, ErrDelta
+					}
+					size |= uint64(b) << (8 * i)
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, ErrDelta
+			}
+			out = append(out, base[offset:offset+size]...)
+		case cmd != 0:
+			// insert the next cmd bytes
+			start := len(out)
+			out = append(out, make([]byte, cmd)...)
+			if _, err := io.ReadFull(r, out[start:]); err != nil {
+				return nil, ErrDelta
+			}
+		default:
+			return nil, ErrDelta
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, ErrDelta
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package pack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// maxDeltaDepth bounds the delta chains followed while reading
+const maxDeltaDepth = 10000
+
+// Pack is a pack file opened with its index
+// It is safe for concurrent reads.
+type Pack struct {
+	Path  string
+	Index *Index
+	f     *os.File
+}
+
+// Open opens the pack at path, a .pack file with its .idx beside it
+func Open(path string, format *repo.ObjectFormat) (*Pack, error) {
+	idx, err := ReadIndex(strings.TrimSuffix(path, ".pack")+".idx", format)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Pack{Path: path, Index: idx, f: f}, nil
+}
+
+// Close closes the pack file
+func (p *Pack) Close() error {
+	return p.f.Close()
+}
+
+// Has reports whether the pack holds the object sha
+func (p *Pack) Has(sha string) bool {
+	_, ok := p.Index.Offset(sha)
+	return ok
+}
+
+// Read returns the type and content of the object sha, rebuilding it from its deltas
+func (p *Pack) Read(sha string) (string, []byte, error) {
+	offset, ok := p.Index.Offset(sha)
+	if !ok {
+		return "", nil, fmt.Errorf("object %s is not in %s", sha, p.Path)
+	}
+	return p.readAt(offset, 0)
+}
+
+// readAt reads the object starting at offset, depth deltas down
+func (p *Pack) readAt(offset int64, depth int) (string, []byte, error) {
+	if depth > maxDeltaDepth {
+		return "", nil, fmt.Errorf("%s: delta chain too long", p.Path)
+	}
+	r := bufio.NewReader(io.NewSectionReader(p.f, offset, 1<<62))
+	typ, size, err := readEntryHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	var baseType string
+	var base []byte
+	switch typ {
+	case typeOfsDelta:
+		back, err := readOfsDelta(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if back <= 0 || back > offset {
+			return "", nil, fmt.Errorf("%s: bad delta base offset at %d", p.Path, offset)
+		}
+		if baseType, base, err = p.readAt(offset-back, depth+1); err != nil {
+			return "", nil, err
+		}
+	case typeRefDelta:
+		id := make([]byte, p.Index.size)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return "", nil, err
+		}
+		baseOffset, ok := p.Index.Offset(fmt.Sprintf("%x", id))
+		if !ok {
+			return "", nil, fmt.Errorf("%s: delta base %x is not in the pack", p.Path, id)
+		}
+		if baseType, base, err = p.readAt(baseOffset, depth+1); err != nil {
+			return "", nil, err
+		}
+	default:
+		name, ok := typeNames[typ]
+		if !ok {
+			return "", nil, fmt.Errorf("%s: unknown object type %d at %d", p.Path, typ, offset)
+		}
+		data, err := inflate(r, size)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v at %d", p.Path, err, offset)
+		}
+		return name, data, nil
+	}
+	delta, err := inflate(r, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v at %d", p.Path, err, offset)
+	}
+	data, err := applyDelta(base, delta)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v at %d", p.Path, err, offset)
+	}
+	return baseType, data, nil
+}
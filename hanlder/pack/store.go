@@ -0,0 +1,284 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// scanner reads a pack stream one byte at a time where it matters, keeping a copy of
+// what it read, its checksum, and the checksum of the current entry
+type scanner struct {
+	r   *bufio.Reader
+	h   hash.Hash
+	out bytes.Buffer
+	n   int64
+	crc uint32
+}
+
+func (s *scanner) took(p []byte) {
+	s.n += int64(len(p))
+	s.h.Write(p)
+	s.out.Write(p)
+	s.crc = crc32.Update(s.crc, crc32.IEEETable, p)
+}
+
+func (s *scanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.took(p[:n])
+	return n, err
+}
+
+// ReadByte keeps zlib from reading past the end of an entry
+func (s *scanner) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.took([]byte{b})
+	}
+	return b, err
+}
+
+// received is an entry of a pack being stored
+type received struct {
+	Entry
+	typ     string
+	data    []byte
+	base    int64
+	baseSha string
+}
+
+// Store reads a pack from rd and keeps it, with an index, among the packs of r
+// The bases of its deltas must be in the pack. It returns the ids of the objects it holds;
+// an empty pack is not kept.
+func Store(r *repo.Gitrepo, rd io.Reader) ([]string, error) {
+	return StoreThin(r, rd, nil)
+}
+
+// StoreThin is Store for a thin pack, whose deltas may have bases only r holds
+// Those bases are read with bases and added to the kept pack, like git's
+// index-pack --fix-thin does, so that it stands on its own.
+func StoreThin(r *repo.Gitrepo, rd io.Reader, bases ReadFunc) ([]string, error) {
+	format := repo.Format(r)
+	s := &scanner{r: bufio.NewReader(rd), h: format.New()}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(s, header); err != nil {
+		return nil, fmt.Errorf("unable to read pack header: %w", err)
+	}
+	if !bytes.Equal(header[:4], signature) {
+		return nil, errors.New("protocol error (pack signature mismatch detected)")
+	}
+	if v := binary.BigEndian.Uint32(header[4:]); v != 2 && v != 3 {
+		return nil, fmt.Errorf("protocol error (pack version %d unsupported)", v)
+	}
+	n := int(binary.BigEndian.Uint32(header[8:]))
+	entries := make([]*received, 0, n)
+	byOffset := map[int64]*received{}
+	for i := 0; i < n; i++ {
+		e := &received{Entry: Entry{Offset: s.n}}
+		s.crc = 0
+		typ, size, err := readEntryHeader(s)
+		if err != nil {
+			return nil, fmt.Errorf("pack is truncated: %w", err)
+		}
+		switch typ {
+		case typeOfsDelta:
+			back, err := readOfsDelta(s)
+			if err != nil {
+				return nil, err
+			}
+			if back <= 0 || back > e.Offset {
+				return nil, fmt.Errorf("delta base offset is out of bound at %d", e.Offset)
+			}
+			e.base = e.Offset - back
+		case typeRefDelta:
+			id := make([]byte, format.Size)
+			if _, err := io.ReadFull(s, id); err != nil {
+				return nil, err
+			}
+			e.baseSha = hex.EncodeToString(id)
+		default:
+			if e.typ = typeNames[typ]; e.typ == "" {
+				return nil, fmt.Errorf("unknown object type %d at offset %d", typ, e.Offset)
+			}
+		}
+		if e.data, err = inflate(s, size); err != nil {
+			return nil, fmt.Errorf("inflate returned %v at offset %d", err, e.Offset)
+		}
+		e.CRC = s.crc
+		if e.typ != "" {
+			e.Sha = objectID(format, e.typ, e.data)
+		}
+		entries = append(entries, e)
+		byOffset[e.Offset] = e
+	}
+	sum := s.h.Sum(nil)
+	trailer := make([]byte, format.Size)
+	if _, err := io.ReadFull(s.r, trailer); err != nil {
+		return nil, fmt.Errorf("pack is truncated: %w", err)
+	}
+	if !bytes.Equal(sum, trailer) {
+		return nil, errors.New("pack is corrupted (SHA1 mismatch)")
+	}
+	s.out.Write(trailer)
+	extra, err := resolveDeltas(format, entries, byOffset, bases)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra) > 0 {
+		if sum, err = fixThin(format, s, n, extra); err != nil {
+			return nil, err
+		}
+		entries = append(entries, extra...)
+		n += len(extra)
+	}
+
+	shas := make([]string, len(entries))
+	list := make([]Entry, len(entries))
+	for i, e := range entries {
+		shas[i], list[i] = e.Sha, e.Entry
+	}
+	if n == 0 {
+		return shas, nil
+	}
+	checksum := hex.EncodeToString(sum)
+	dir, err := repo.RepoDir(r, true, "objects", "pack")
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, "pack-"+checksum)
+	if exists, _ := repo.PathExist(base + ".idx"); exists {
+		return shas, nil
+	}
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, format, list, checksum); err != nil {
+		return nil, err
+	}
+	// the index goes last: a pack is only used once its index is there
+	if err := writeFile(base+".pack", s.out.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writeFile(base+".idx", idx.Bytes()); err != nil {
+		return nil, err
+	}
+	return shas, nil
+}
+
+// resolveDeltas rebuilds the objects stored as deltas, whose bases may be deltas too
+// Bases the pack does not hold are read with bases, if given, and returned as entries
+// to add to it.
+func resolveDeltas(format *repo.ObjectFormat, entries []*received, byOffset map[int64]*received, bases ReadFunc) ([]*received, error) {
+	bySha := map[string]*received{}
+	for _, e := range entries {
+		if e.typ != "" {
+			bySha[e.Sha] = e
+		}
+	}
+	var extra []*received
+	for progress := true; progress; {
+		progress = false
+		for _, e := range entries {
+			if e.typ != "" {
+				continue
+			}
+			base := bySha[e.baseSha]
+			if e.baseSha == "" {
+				base = byOffset[e.base]
+				if base == nil {
+					return nil, fmt.Errorf("delta base offset %d is not an object", e.base)
+				}
+			}
+			if base == nil || base.typ == "" {
+				continue
+			}
+			data, err := applyDelta(base.data, e.data)
+			if err != nil {
+				return nil, fmt.Errorf("%v at offset %d", err, e.Offset)
+			}
+			e.typ, e.data = base.typ, data
+			e.Sha = objectID(format, e.typ, e.data)
+			bySha[e.Sha] = e
+			progress = true
+		}
+		if progress || bases == nil {
+			continue
+		}
+		// what is left waits on bases from outside the pack
+		for _, e := range entries {
+			if e.typ != "" || e.baseSha == "" || bySha[e.baseSha] != nil {
+				continue
+			}
+			typ, data, err := bases(e.baseSha)
+			if err != nil {
+				continue
+			}
+			base := &received{Entry: Entry{Sha: e.baseSha}, typ: typ, data: data}
+			bySha[base.Sha] = base
+			extra = append(extra, base)
+			progress = true
+		}
+	}
+	unresolved := 0
+	for _, e := range entries {
+		if e.typ == "" {
+			unresolved++
+		}
+	}
+	if unresolved > 0 {
+		return nil, fmt.Errorf("pack has %d unresolved deltas", unresolved)
+	}
+	return extra, nil
+}
+
+// fixThin appends the bases a thin pack lacks to the n entries scanned, counting them in
+// the header, and returns the new pack checksum
+func fixThin(format *repo.ObjectFormat, s *scanner, n int, extra []*received) ([]byte, error) {
+	data := s.out.Bytes()
+	var out bytes.Buffer
+	h := format.New()
+	c := &counter{w: io.MultiWriter(&out, h)}
+	header := bytes.Clone(data[:12])
+	binary.BigEndian.PutUint32(header[8:], uint32(n+len(extra)))
+	c.Write(header)
+	c.Write(data[12 : len(data)-format.Size])
+	for _, e := range extra {
+		e.Offset = c.n
+		crc, err := writeEntry(c, typeNumbers[e.typ], e.data)
+		if err != nil {
+			return nil, err
+		}
+		e.CRC = crc
+	}
+	sum := h.Sum(nil)
+	out.Write(sum)
+	s.out.Reset()
+	s.out.Write(out.Bytes())
+	return sum, nil
+}
+
+// objectID returns the id of data stored as an object of type typ
+func objectID(format *repo.ObjectFormat, typ string, data []byte) string {
+	h := format.New()
+	h.Write([]byte(typ + " " + strconv.Itoa(len(data)) + "\x00"))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeFile writes data to path through a temporary file, read-only like git's packs
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0444); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
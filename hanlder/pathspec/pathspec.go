@@ -0,0 +1,176 @@
+package pathspec
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pathspec is a parsed list of patterns limiting a command to some paths
+// An empty Pathspec matches everything
+type Pathspec struct {
+	include []pattern
+	exclude []pattern
+}
+
+type pattern struct {
+	prefix string
+	glob   *regexp.Regexp
+}
+
+// Parse builds a pathspec from command line arguments given relative to cwd
+// worktree is the root the resulting patterns are relative to; patterns
+// starting with :! , :^ or :(exclude) exclude what they match
+func Parse(worktree, cwd string, args []string) (*Pathspec, error) {
+	prefix := ""
+	if worktree != "" && cwd != "" {
+		rel, err := filepath.Rel(worktree, cwd)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("%s is outside repository at %s", cwd, worktree)
+		}
+		if rel != "." {
+			prefix = rel
+		}
+	}
+	ps := &Pathspec{}
+	for _, arg := range args {
+		exclude := false
+		switch {
+		case strings.HasPrefix(arg, ":(exclude)"):
+			arg, exclude = strings.TrimPrefix(arg, ":(exclude)"), true
+		case strings.HasPrefix(arg, ":!"), strings.HasPrefix(arg, ":^"):
+			arg, exclude = arg[2:], true
+		case strings.HasPrefix(arg, ":/"):
+			// relative to the top of the worktree
+			arg = "/" + arg[2:]
+		}
+		p, err := newPattern(prefix, arg)
+		if err != nil {
+			return nil, err
+		}
+		if exclude {
+			ps.exclude = append(ps.exclude, p)
+		} else {
+			ps.include = append(ps.include, p)
+		}
+	}
+	return ps, nil
+}
+
+// newPattern makes a pattern for arg, resolved against the directory prefix
+func newPattern(prefix, arg string) (pattern, error) {
+	arg = filepath.ToSlash(arg)
+	var full string
+	if strings.HasPrefix(arg, "/") {
+		full = path.Clean(strings.TrimLeft(arg, "/"))
+	} else {
+		full = path.Clean(path.Join(prefix, arg))
+	}
+	if full == ".." || strings.HasPrefix(full, "../") {
+		return pattern{}, fmt.Errorf("%s: '%s' is outside repository", arg, arg)
+	}
+	if full == "." {
+		full = ""
+	}
+	if !strings.ContainsAny(full, "*?[") {
+		return pattern{prefix: full}, nil
+	}
+	re, err := globRegexp(full)
+	if err != nil {
+		return pattern{}, err
+	}
+	return pattern{glob: re}, nil
+}
+
+// globRegexp translates a git wildmatch pattern where * also matches /
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// a pattern naming a directory matches everything below it
+	b.WriteString("(/.*)?$")
+	return regexp.Compile(b.String())
+}
+
+func (p pattern) match(name string) bool {
+	if p.glob != nil {
+		return p.glob.MatchString(name)
+	}
+	return p.prefix == "" || name == p.prefix || strings.HasPrefix(name, p.prefix+"/")
+}
+
+// Match reports whether the slash-separated path name is selected
+func (ps *Pathspec) Match(name string) bool {
+	if ps == nil {
+		return true
+	}
+	for _, p := range ps.exclude {
+		if p.match(name) {
+			return false
+		}
+	}
+	if len(ps.include) == 0 {
+		return true
+	}
+	for _, p := range ps.include {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the pathspec selects everything
+func (ps *Pathspec) Empty() bool {
+	return ps == nil || len(ps.include) == 0 && len(ps.exclude) == 0
+}
+
+// MatchDir reports whether paths below the slash-separated directory dir can be selected
+// Globs are assumed to reach into any directory.
+func (ps *Pathspec) MatchDir(dir string) bool {
+	if ps == nil {
+		return true
+	}
+	for _, p := range ps.exclude {
+		if p.glob == nil && p.match(dir) {
+			return false
+		}
+	}
+	if len(ps.include) == 0 {
+		return true
+	}
+	for _, p := range ps.include {
+		if p.glob != nil || p.match(dir) || strings.HasPrefix(p.prefix, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
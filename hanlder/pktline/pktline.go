@@ -0,0 +1,123 @@
+package pktline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MaxData is the most data a packet can carry
+const MaxData = 65516
+
+// Kind tells data packets from the special packets that carry none
+type Kind int
+
+const (
+	Data Kind = iota
+	Flush
+	Delim
+	ResponseEnd
+)
+
+// ErrFormat is returned for a packet whose length is not valid
+var ErrFormat = errors.New("protocol error: bad line length character")
+
+// Reader reads packets from a stream
+type Reader struct {
+	r   io.Reader
+	buf [MaxData + 4]byte
+}
+
+// NewReader returns a Reader reading packets from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read returns the next packet; the data is only valid until the next call
+func (pr *Reader) Read() (Kind, []byte, error) {
+	head := pr.buf[:4]
+	if _, err := io.ReadFull(pr.r, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("the remote end hung up unexpectedly")
+		}
+		return 0, nil, err
+	}
+	n, err := strconv.ParseUint(string(head), 16, 16)
+	if err != nil {
+		return 0, nil, ErrFormat
+	}
+	switch n {
+	case 0:
+		return Flush, nil, nil
+	case 1:
+		return Delim, nil, nil
+	case 2:
+		return ResponseEnd, nil, nil
+	case 3:
+		return 0, nil, ErrFormat
+	}
+	data := pr.buf[4:n]
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return 0, nil, errors.New("the remote end hung up unexpectedly")
+	}
+	return Data, data, nil
+}
+
+// ReadLine returns the text of the next data packet without its newline
+// It returns io.EOF at a flush packet; other special packets are errors.
+func (pr *Reader) ReadLine() (string, error) {
+	kind, data, err := pr.Read()
+	if err != nil {
+		return "", err
+	}
+	switch kind {
+	case Flush:
+		return "", io.EOF
+	case Data:
+		if len(data) > 0 && data[len(data)-1] == '\n' {
+			data = data[:len(data)-1]
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("protocol error: unexpected special packet %d", kind)
+}
+
+// Writer writes packets to a stream
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer writing packets to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes p as one data packet
+func (pw *Writer) Write(p []byte) error {
+	if len(p) > MaxData {
+		return fmt.Errorf("packet of %d bytes is too long", len(p))
+	}
+	if _, err := fmt.Fprintf(pw.w, "%04x", len(p)+4); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(p)
+	return err
+}
+
+// Printf writes a formatted line as one data packet
+func (pw *Writer) Printf(format string, args ...any) error {
+	return pw.Write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// Flush writes a flush packet, which ends a message
+func (pw *Writer) Flush() error {
+	_, err := io.WriteString(pw.w, "0000")
+	return err
+}
+
+// Delim writes a delimiter packet, which separates the sections of a message
+func (pw *Writer) Delim() error {
+	_, err := io.WriteString(pw.w, "0001")
+	return err
+}
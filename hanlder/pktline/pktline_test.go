@@ -0,0 +1,75 @@
+package pktline
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Printf("hello\n")
+	w.Delim()
+	w.Write([]byte("raw"))
+	w.Flush()
+	if got, want := buf.String(), "000ahello\n00010007raw0000"; got != want {
+		t.Fatalf("wrote %q, want %q", got, want)
+	}
+
+	r := NewReader(&buf)
+	if line, err := r.ReadLine(); err != nil || line != "hello" {
+		t.Fatalf("ReadLine = %q, %v", line, err)
+	}
+	if kind, _, err := r.Read(); err != nil || kind != Delim {
+		t.Fatalf("Read = %v, %v, want a delimiter", kind, err)
+	}
+	if kind, data, err := r.Read(); err != nil || kind != Data || string(data) != "raw" {
+		t.Fatalf("Read = %v, %q, %v", kind, data, err)
+	}
+	if _, err := r.ReadLine(); err != io.EOF {
+		t.Fatalf("ReadLine at a flush = %v, want io.EOF", err)
+	}
+}
+
+func TestBadLength(t *testing.T) {
+	for _, in := range []string{"zzzz", "0003", "0010abc"} {
+		if _, _, err := NewReader(strings.NewReader(in)).Read(); err == nil {
+			t.Errorf("Read(%q) succeeded", in)
+		}
+	}
+}
+
+func TestSideBand(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	m := NewMux(w, SideBandMax)
+	data := bytes.Repeat([]byte("0123456789"), 500)
+	m.Send(BandProgress, []byte("Counting: 1\rCounting: 2, done.\n"))
+	m.Write(data)
+	m.Send(BandProgress, []byte("Total 1\n"))
+	w.Flush()
+
+	var progress bytes.Buffer
+	got, err := io.ReadAll(NewDemux(NewReader(&buf), &progress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes of data, want %d", len(got), len(data))
+	}
+	if want := "remote: Counting: 1\rremote: Counting: 2, done.\nremote: Total 1\n"; progress.String() != want {
+		t.Errorf("progress = %q, want %q", progress.String(), want)
+	}
+}
+
+func TestSideBandError(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	NewMux(w, SideBand64kMax).Send(BandError, []byte("no such object\n"))
+	_, err := io.ReadAll(NewDemux(NewReader(&buf), nil))
+	if err == nil || err.Error() != "remote error: no such object" {
+		t.Fatalf("err = %v", err)
+	}
+}
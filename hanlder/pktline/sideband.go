@@ -0,0 +1,131 @@
+package pktline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Side-band channels: pack data, progress messages and a fatal error
+const (
+	BandData     = 1
+	BandProgress = 2
+	BandError    = 3
+)
+
+// Demux reads the data channel of a side-band stream up to its flush packet
+// Progress messages go to Progress, prefixed with "remote: " like git shows them.
+type Demux struct {
+	r        *Reader
+	progress io.Writer
+	pending  []byte
+	done     bool
+	midLine  bool
+}
+
+// NewDemux returns a Demux reading from r; a nil progress drops the messages
+func NewDemux(r *Reader, progress io.Writer) *Demux {
+	return &Demux{r: r, progress: progress}
+}
+
+func (d *Demux) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		kind, data, err := d.r.Read()
+		if err != nil {
+			return 0, err
+		}
+		if kind == Flush {
+			d.done = true
+			continue
+		}
+		if kind != Data || len(data) == 0 {
+			return 0, fmt.Errorf("protocol error: bad band packet")
+		}
+		switch data[0] {
+		case BandData:
+			d.pending = append(d.pending[:0], data[1:]...)
+		case BandProgress:
+			d.show(data[1:])
+		case BandError:
+			return 0, fmt.Errorf("remote error: %s", strings.TrimRight(string(data[1:]), "\n"))
+		default:
+			return 0, fmt.Errorf("protocol error: bad band #%d", data[0])
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// Drain reads what is left of the stream, so that the next message can be read
+func (d *Demux) Drain() error {
+	_, err := io.Copy(io.Discard, d)
+	return err
+}
+
+// show writes a progress message, starting each of its lines with "remote: "
+func (d *Demux) show(msg []byte) {
+	if d.progress == nil {
+		return
+	}
+	var b bytes.Buffer
+	for len(msg) > 0 {
+		if !d.midLine {
+			b.WriteString("remote: ")
+		}
+		i := bytes.IndexAny(msg, "\r\n")
+		if i < 0 {
+			b.Write(msg)
+			d.midLine = true
+			break
+		}
+		b.Write(msg[:i+1])
+		msg = msg[i+1:]
+		d.midLine = false
+	}
+	d.progress.Write(b.Bytes())
+}
+
+// Packet sizes of the two side-band flavours, counting the band byte
+const (
+	SideBandMax    = 1000 - 4
+	SideBand64kMax = MaxData
+)
+
+// Mux writes a side-band stream, splitting what it is given into packets of at most
+// size bytes
+type Mux struct {
+	w    *Writer
+	size int
+}
+
+// NewMux returns a Mux writing packets of at most size bytes to w
+func NewMux(w *Writer, size int) *Mux {
+	return &Mux{w: w, size: size}
+}
+
+// Write sends p on the data channel
+func (m *Mux) Write(p []byte) (int, error) {
+	if err := m.Send(BandData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Send sends p on a channel
+func (m *Mux) Send(band byte, p []byte) error {
+	buf := make([]byte, 0, m.size)
+	for len(p) > 0 {
+		n := min(len(p), m.size-1)
+		buf = append(append(buf[:0], band), p[:n]...)
+		if err := m.w.Write(buf); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package pretty
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Date layouts of git's date formats
+const (
+	DefaultDate = "Mon Jan 2 15:04:05 2006 -0700"
+	RFC2822Date = "Mon, 2 Jan 2006 15:04:05 -0700"
+	ISODate     = "2006-01-02 15:04:05 -0700"
+	StrictISO   = "2006-01-02T15:04:05-07:00"
+	ShortDate   = "2006-01-02"
+)
+
+// Format expands the placeholders of a --format string for the commit sha
+// Placeholders git knows but this does not are copied as they are.
+func Format(r *repo.Gitrepo, sha string, c *object.Commit, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		n, ok := expand(r, sha, c, format[i+1:], &b)
+		if !ok {
+			b.WriteByte('%')
+			continue
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// expand writes the value of the placeholder at the start of spec
+// It returns the length of the placeholder, and false when it is not one
+func expand(r *repo.Gitrepo, sha string, c *object.Commit, spec string, b *strings.Builder) (int, bool) {
+	switch spec[0] {
+	case '%':
+		b.WriteByte('%')
+		return 1, true
+	case 'n':
+		b.WriteByte('\n')
+		return 1, true
+	case 'x':
+		if len(spec) >= 3 {
+			if v, err := strconv.ParseUint(spec[1:3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				return 3, true
+			}
+		}
+		return 0, false
+	case 'H':
+		b.WriteString(sha)
+		return 1, true
+	case 'h':
+		b.WriteString(object.Abbrev(sha))
+		return 1, true
+	case 'T':
+		b.WriteString(c.TreeSha())
+		return 1, true
+	case 't':
+		b.WriteString(object.Abbrev(c.TreeSha()))
+		return 1, true
+	case 'P':
+		b.WriteString(strings.Join(c.Parents(), " "))
+		return 1, true
+	case 'p':
+		parents := c.Parents()
+		short := make([]string, len(parents))
+		for i, p := range parents {
+			short[i] = object.Abbrev(p)
+		}
+		b.WriteString(strings.Join(short, " "))
+		return 1, true
+	case 's':
+		b.WriteString(Subject(c))
+		return 1, true
+	case 'b':
+		b.WriteString(Body(c))
+		return 1, true
+	case 'B':
+		b.WriteString(strings.TrimLeft(string(c.Data.Message), "\n"))
+		return 1, true
+	case 'd', 'D':
+		decorations := Decorations(r, sha)
+		if len(decorations) == 0 {
+			return 1, true
+		}
+		if spec[0] == 'd' {
+			b.WriteString(" (" + strings.Join(decorations, ", ") + ")")
+		} else {
+			b.WriteString(strings.Join(decorations, ", "))
+		}
+		return 1, true
+	case 'a', 'c':
+		if len(spec) < 2 {
+			return 0, false
+		}
+		role := "author"
+		if spec[0] == 'c' {
+			role = "committer"
+		}
+		var line string
+		if v := c.Data.Header[role]; len(v) > 0 {
+			line = v[0]
+		}
+		value, ok := identPart(line, spec[1])
+		if !ok {
+			return 0, false
+		}
+		b.WriteString(value)
+		return 2, true
+	}
+	return 0, false
+}
+
+// identPart returns one part of an identity line: its name, email or date in some format
+func identPart(line string, part byte) (string, bool) {
+	person, when, err := repo.ParseIdent(line)
+	name, mail := person, ""
+	if start := strings.LastIndex(person, "<"); start != -1 {
+		name = strings.TrimSpace(person[:start])
+		mail = strings.TrimSuffix(person[start+1:], ">")
+	}
+	switch part {
+	case 'n':
+		return name, true
+	case 'e':
+		return mail, true
+	case 'l':
+		local, _, _ := strings.Cut(mail, "@")
+		return local, true
+	}
+	if err != nil {
+		switch part {
+		case 'd', 'D', 'i', 'I', 't', 's', 'r':
+			return "", true
+		}
+		return "", false
+	}
+	switch part {
+	case 'd':
+		return when.Format(DefaultDate), true
+	case 'D':
+		return when.Format(RFC2822Date), true
+	case 'i':
+		return when.Format(ISODate), true
+	case 'I':
+		return when.Format(StrictISO), true
+	case 's':
+		return when.Format(ShortDate), true
+	case 't':
+		return strconv.FormatInt(when.Unix(), 10), true
+	case 'r':
+		return Relative(when, time.Now()), true
+	}
+	return "", false
+}
+
+// Subject returns the first paragraph of the commit message joined into one line
+func Subject(c *object.Commit) string {
+	msg := strings.TrimLeft(string(c.Data.Message), "\n")
+	para, _, _ := strings.Cut(msg, "\n\n")
+	lines := strings.Split(strings.TrimRight(para, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// Body returns the commit message after its subject paragraph
+func Body(c *object.Commit) string {
+	msg := strings.TrimLeft(string(c.Data.Message), "\n")
+	_, body, ok := strings.Cut(msg, "\n\n")
+	if !ok {
+		return ""
+	}
+	return strings.TrimLeft(body, "\n")
+}
+
+// Decorations returns the names of the refs pointing at sha, as git log --decorate shows them:
+// HEAD first, then branches, remote-tracking branches and tags
+func Decorations(r *repo.Gitrepo, sha string) []string {
+	var head, branches, remotes, tags, others []string
+	headRef, headSha, _ := refs.Head(r)
+	list, err := refs.ListRefs(r, "refs/")
+	if err != nil {
+		return nil
+	}
+	for _, ref := range list {
+		target := ref.Sha
+		if strings.HasPrefix(ref.Name, "refs/tags/") {
+			if peeled, err := object.Peel(r, ref.Sha, "commit"); err == nil {
+				target = peeled
+			}
+		}
+		if target != sha {
+			continue
+		}
+		name := refs.Shorten(ref.Name)
+		switch {
+		case ref.Name == headRef:
+			head = append(head, "HEAD -> "+name)
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			branches = append(branches, name)
+		case strings.HasPrefix(ref.Name, "refs/remotes/"):
+			remotes = append(remotes, name)
+		case strings.HasPrefix(ref.Name, "refs/tags/"):
+			tags = append(tags, "tag: "+name)
+		case strings.HasPrefix(ref.Name, "refs/stash"), strings.HasPrefix(ref.Name, "refs/bisect/"):
+		default:
+			others = append(others, name)
+		}
+	}
+	if head == nil && headSha == sha && headRef == "" {
+		head = []string{"HEAD"}
+	}
+	var out []string
+	for _, group := range [][]string{head, branches, remotes, tags, others} {
+		out = append(out, group...)
+	}
+	return out
+}
+
+// Relative describes how long before now the time when is, like "3 days ago"
+func Relative(when, now time.Time) string {
+	secs := int64(now.Sub(when).Seconds())
+	if secs < 0 {
+		return "in the future"
+	}
+	unit := func(n int64, name string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s ago", n, name)
+		}
+		return fmt.Sprintf("%d %ss ago", n, name)
+	}
+	switch {
+	case secs < 90:
+		return unit(secs, "second")
+	case secs < 90*60:
+		return unit((secs+30)/60, "minute")
+	case secs < 36*3600:
+		return unit((secs+1800)/3600, "hour")
+	case secs < 14*86400:
+		return unit((secs+43200)/86400, "day")
+	case secs < 70*86400:
+		return unit((secs+3*86400)/(7*86400), "week")
+	case secs < 365*86400:
+		return unit((secs+15*86400)/(30*86400), "month")
+	}
+	years := (secs + 183*86400) / (365 * 86400)
+	return unit(years, "year")
+}
+
+// Commit formats a commit the way show and log do for --pretty=<format>
+// format is oneline, short, medium, full, fuller or raw, or a format string: "format:"
+// strings have no line end, "tformat:" strings and bare ones containing a % end in a newline.
+// abbrev shortens the commit ids of the headers.
+func Commit(r *repo.Gitrepo, sha string, c *object.Commit, format string, abbrev bool) (string, error) {
+	if f, ok := strings.CutPrefix(format, "format:"); ok {
+		return Format(r, sha, c, f), nil
+	}
+	if f, ok := strings.CutPrefix(format, "tformat:"); ok {
+		return Format(r, sha, c, f) + "\n", nil
+	}
+	if strings.Contains(format, "%") {
+		return Format(r, sha, c, format) + "\n", nil
+	}
+	id := sha
+	if abbrev {
+		id = object.Abbrev(sha)
+	}
+	if format == "oneline" {
+		return id + " " + Subject(c) + "\n", nil
+	}
+	header := func(role string) (string, string) {
+		line := ""
+		if v := c.Data.Header[role]; len(v) > 0 {
+			line = v[0]
+		}
+		person, when, err := repo.ParseIdent(line)
+		if err != nil {
+			return person, ""
+		}
+		return person, when.Format(DefaultDate)
+	}
+	author, authorDate := header("author")
+	committer, commitDate := header("committer")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "commit %s\n", id)
+	if format == "raw" {
+		for _, key := range []string{"tree", "parent", "author", "committer"} {
+			for _, v := range c.Data.Header[key] {
+				fmt.Fprintf(&b, "%s %s\n", key, v)
+			}
+		}
+	} else if parents := c.Parents(); len(parents) > 1 {
+		short := make([]string, len(parents))
+		for i, p := range parents {
+			short[i] = object.Abbrev(p)
+		}
+		fmt.Fprintf(&b, "Merge: %s\n", strings.Join(short, " "))
+	}
+	switch format {
+	case "short":
+		fmt.Fprintf(&b, "Author: %s\n", author)
+	case "medium", "":
+		fmt.Fprintf(&b, "Author: %s\nDate:   %s\n", author, authorDate)
+	case "full":
+		fmt.Fprintf(&b, "Author: %s\nCommit: %s\n", author, committer)
+	case "fuller":
+		fmt.Fprintf(&b, "Author:     %s\nAuthorDate: %s\nCommit:     %s\nCommitDate: %s\n", author, authorDate, committer, commitDate)
+	case "raw":
+	default:
+		return "", fmt.Errorf("invalid --pretty format: %s", format)
+	}
+	b.WriteString("\n")
+	message := strings.TrimRight(strings.TrimLeft(string(c.Data.Message), "\n"), "\n")
+	if format == "short" {
+		message = Subject(c)
+	}
+	for _, line := range strings.Split(message, "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,535 @@
+package readtree
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// MaxTrees is the largest number of trees read at once
+const MaxTrees = 8
+
+// Options select how trees are read into the index
+// Merge and Reset merge one, two or more trees with the index the way git read-tree -m
+// does, the trees before the last two being merge bases. Reset also drops unmerged entries
+// and ignores local changes. Without either, the trees replace the index, later trees
+// winning over earlier ones. Prefix reads one tree below a directory and keeps the rest
+// of the index.
+// Update writes the result to the worktree. IndexOnly leaves the worktree out of the checks,
+// as does a repository without a worktree. Aggressive also resolves paths deleted on one
+// side and unchanged on the other, and paths added identically on both sides, in a
+// three-way merge. DryRun checks everything but writes nothing.
+type Options struct {
+	Merge      bool
+	Reset      bool
+	Update     bool
+	IndexOnly  bool
+	Aggressive bool
+	DryRun     bool
+	Prefix     string
+}
+
+// ErrUnmerged is returned when merging into an index that has unmerged entries
+var ErrUnmerged = errors.New("You need to resolve your current index first")
+
+// reader holds the state of one read-tree
+type reader struct {
+	r       *repo.Gitrepo
+	opts    Options
+	filters *filter.Filter
+	// old holds the merged entries of the index; unmerged paths get an entry without an id
+	old      map[string]*index.Entry
+	unmerged map[string]bool
+	// tracked holds every path of the index, for telling untracked files apart
+	tracked map[string]bool
+	// initial is set when there was no index file yet
+	initial bool
+	out     []*index.Entry
+	update  map[*index.Entry]bool
+	remove  []string
+}
+
+// Read reads the trees into the index following opts
+// Nothing is written when a path cannot be merged; the error names the first such path.
+func Read(r *repo.Gitrepo, trees []string, opts Options) error {
+	if opts.Update && !opts.DryRun {
+		if err := repo.RequireWorktree(r); err != nil {
+			return err
+		}
+	}
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	old, err := index.Read(r)
+	if err != nil {
+		return err
+	}
+	rd := &reader{r: r, opts: opts, old: map[string]*index.Entry{}, unmerged: map[string]bool{},
+		tracked: map[string]bool{}, update: map[*index.Entry]bool{}}
+	if r.Worktree == "" {
+		rd.opts.IndexOnly = true
+	} else {
+		rd.filters = filter.New(r)
+	}
+	if _, err := os.Stat(index.Path(r)); os.IsNotExist(err) {
+		rd.initial = true
+	}
+	for _, e := range old.Entries {
+		rd.tracked[e.Name] = true
+		if e.Stage == 0 {
+			rd.old[e.Name] = e
+		} else if !rd.unmerged[e.Name] {
+			rd.unmerged[e.Name] = true
+			rd.old[e.Name] = &index.Entry{Name: e.Name}
+		}
+	}
+
+	merging := opts.Merge || opts.Reset || opts.Prefix != ""
+	if !merging {
+		if err := rd.readOnly(trees); err != nil {
+			return err
+		}
+	} else {
+		if len(rd.unmerged) > 0 && !opts.Reset {
+			return ErrUnmerged
+		}
+		sides := make([]map[string]object.TreeFile, len(trees))
+		for i, t := range trees {
+			if sides[i], err = object.TreeFiles(r, t); err != nil {
+				return err
+			}
+		}
+		switch {
+		case opts.Prefix != "":
+			err = rd.bind(sides[0])
+		case len(trees) == 1:
+			err = rd.each(sides, rd.oneway)
+		case len(trees) == 2:
+			err = rd.each(sides, rd.twoway)
+		default:
+			err = rd.each(sides, rd.threeway)
+		}
+		if err != nil {
+			return err
+		}
+		rd.replaceConflicts()
+	}
+	if opts.DryRun {
+		return nil
+	}
+	if opts.Update {
+		if err := rd.checkout(); err != nil {
+			return err
+		}
+	}
+	idx := &index.Index{Version: old.Version, Entries: rd.out}
+	idx.Sort()
+	return index.Write(r, idx)
+}
+
+// readOnly replaces the index with the files of the trees
+func (rd *reader) readOnly(trees []string) error {
+	files := map[string]object.TreeFile{}
+	for _, t := range trees {
+		tf, err := object.TreeFiles(rd.r, t)
+		if err != nil {
+			return err
+		}
+		for name, f := range tf {
+			files[name] = f
+		}
+	}
+	for name, f := range files {
+		rd.out = append(rd.out, newEntry(name, &f, 0))
+	}
+	return nil
+}
+
+// each calls merge for every path of the index and the trees, in path order, with the
+// path's index entry and its file in each tree
+func (rd *reader) each(sides []map[string]object.TreeFile, merge func(name string, current *index.Entry, files []*object.TreeFile) error) error {
+	names := map[string]bool{}
+	for name := range rd.old {
+		names[name] = true
+	}
+	for _, side := range sides {
+		for name := range side {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		files := make([]*object.TreeFile, len(sides))
+		for i, side := range sides {
+			if f, ok := side[name]; ok {
+				files[i] = &f
+			}
+		}
+		if err := merge(name, rd.old[name], files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oneway makes the index match a single tree, keeping the entries it already has
+// With Reset and Update, files with local changes are written again.
+func (rd *reader) oneway(name string, current *index.Entry, files []*object.TreeFile) error {
+	a := files[0]
+	if a == nil {
+		return rd.deleted(name, current)
+	}
+	if current != nil && same(current, a) {
+		rd.out = append(rd.out, current)
+		if rd.opts.Reset && rd.opts.Update && rd.modified(current) {
+			rd.update[current] = true
+		}
+		return nil
+	}
+	return rd.merged(name, a, current, 0)
+}
+
+// twoway moves the index from the tree head to the tree merge, following the table
+// of git read-tree's two tree merge
+func (rd *reader) twoway(name string, current *index.Entry, files []*object.TreeFile) error {
+	head, merge := files[0], files[1]
+	if current != nil {
+		switch {
+		case rd.unmerged[name]:
+			if sameFiles(head, merge) || rd.opts.Reset {
+				if merge == nil {
+					return rd.deleted(name, current)
+				}
+				return rd.merged(name, merge, current, 0)
+			}
+			return rejected(name)
+		case head == nil && merge == nil, // 4 and 5
+			head == nil && same(current, merge),                   // 6 and 7
+			head != nil && merge != nil && sameFiles(head, merge), // 14 and 15
+			head != nil && merge != nil && same(current, merge):   // 18 and 19
+			rd.out = append(rd.out, current)
+			return nil
+		case head != nil && merge == nil && same(current, head): // 10 and 11
+			return rd.deleted(name, current)
+		case head != nil && merge != nil && same(current, head): // 20 and 21
+			return rd.merged(name, merge, current, 0)
+		}
+		return rejected(name)
+	}
+	if merge != nil {
+		if head != nil && !rd.initial {
+			// the removal of the path is staged
+			if sameFiles(head, merge) {
+				return nil
+			}
+			return rejected(name)
+		}
+		return rd.merged(name, merge, nil, 0)
+	}
+	return rd.deleted(name, nil)
+}
+
+// threeway merges the trees bases..., head and remote into the index, leaving the stages
+// of paths that need a file-level merge
+// The index must match head for every path the merge changes.
+func (rd *reader) threeway(name string, current *index.Entry, files []*object.TreeFile) error {
+	bases := files[:len(files)-2]
+	head, remote := files[len(files)-2], files[len(files)-1]
+	anyBaseMissing, noBaseExists := false, true
+	for _, b := range bases {
+		if b == nil {
+			anyBaseMissing = true
+		} else {
+			noBaseExists = false
+		}
+	}
+	// a side matches when some base has the same file, or lacks the path like it does
+	headMatch, remoteMatch := false, false
+	if !sameFiles(head, remote) {
+		for _, b := range bases {
+			headMatch = headMatch || sameFiles(b, head)
+			remoteMatch = remoteMatch || sameFiles(b, remote)
+		}
+	}
+	unchangedIndex := current == nil && head == nil || current != nil && same(current, head)
+
+	// changed only on their side
+	if remote != nil && headMatch && !remoteMatch {
+		if current != nil && !same(current, remote) && !same(current, head) {
+			return rejected(name)
+		}
+		return rd.merged(name, remote, current, 0)
+	}
+	if current != nil && !unchangedIndex {
+		return rejected(name)
+	}
+	if head != nil {
+		// the same on both sides, or changed only on our side
+		if sameFiles(head, remote) || remoteMatch && !headMatch {
+			return rd.merged(name, head, current, 0)
+		}
+	}
+	if head == nil && remote == nil && anyBaseMissing {
+		return nil
+	}
+	if rd.opts.Aggressive {
+		headDeleted, remoteDeleted := head == nil, remote == nil
+		if headDeleted && remoteDeleted || headDeleted && remote != nil && remoteMatch || remoteDeleted && head != nil && headMatch {
+			if current != nil {
+				return rd.deleted(name, current)
+			}
+			if !headDeleted {
+				return rd.absent(name, "removed")
+			}
+			return nil
+		}
+		if noBaseExists && head != nil && remote != nil && sameFiles(head, remote) {
+			return rd.merged(name, head, current, 0)
+		}
+	}
+
+	// left for a file-level merge: the worktree file must not have local changes
+	if current != nil {
+		if err := rd.uptodate(current); err != nil {
+			return err
+		}
+	}
+	if !headMatch || !remoteMatch {
+		for _, b := range bases {
+			if b != nil {
+				rd.out = append(rd.out, newEntry(name, b, 1))
+				break
+			}
+		}
+	}
+	if head != nil {
+		rd.out = append(rd.out, newEntry(name, head, 2))
+	}
+	if remote != nil {
+		rd.out = append(rd.out, newEntry(name, remote, 3))
+	}
+	return nil
+}
+
+// bind reads the files of a tree below the prefix, next to the entries already in the index
+func (rd *reader) bind(files map[string]object.TreeFile) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		full := rd.opts.Prefix + name
+		if rd.old[full] != nil {
+			return fmt.Errorf("Entry '%s' overlaps with '%s'.  Cannot bind.", full, full)
+		}
+		f := files[name]
+		if err := rd.merged(full, &f, nil, 0); err != nil {
+			return err
+		}
+	}
+	for _, e := range rd.old {
+		rd.out = append(rd.out, e)
+	}
+	return nil
+}
+
+// merged puts the tree file f in the index in place of current
+// A new path must not clobber an untracked file, and a changed one must not lose local changes.
+func (rd *reader) merged(name string, f *object.TreeFile, current *index.Entry, stage int) error {
+	e := newEntry(name, f, stage)
+	switch {
+	case current == nil:
+		if err := rd.absent(name, "overwritten"); err != nil {
+			return err
+		}
+	case rd.unmerged[name]:
+	case same(current, f):
+		rd.out = append(rd.out, current)
+		return nil
+	default:
+		if err := rd.uptodate(current); err != nil {
+			return err
+		}
+	}
+	rd.out = append(rd.out, e)
+	rd.update[e] = true
+	return nil
+}
+
+// deleted drops current from the index, and its file from the worktree with Update
+func (rd *reader) deleted(name string, current *index.Entry) error {
+	if current == nil {
+		return rd.absent(name, "removed")
+	}
+	if !rd.unmerged[name] {
+		if err := rd.uptodate(current); err != nil {
+			return err
+		}
+	}
+	rd.remove = append(rd.remove, name)
+	return nil
+}
+
+// uptodate fails when the worktree file of e has changes that are not in the index
+// A missing file has none.
+func (rd *reader) uptodate(e *index.Entry) error {
+	if rd.opts.IndexOnly || rd.opts.Reset || !rd.modified(e) {
+		return nil
+	}
+	return fmt.Errorf("Entry '%s' not uptodate. Cannot merge.", e.Name)
+}
+
+// modified reports whether the worktree file of e exists and differs from it
+func (rd *reader) modified(e *index.Entry) bool {
+	if rd.r.Worktree == "" {
+		return false
+	}
+	if _, err := os.Lstat(worktree.FullPath(rd.r, e.Name)); err != nil {
+		return false
+	}
+	modified, err := worktree.IsModified(rd.r, rd.filters, e)
+	return err != nil || modified
+}
+
+// absent fails when updating the worktree would overwrite or remove an untracked file at name
+func (rd *reader) absent(name, action string) error {
+	if rd.opts.IndexOnly || !rd.opts.Update || rd.opts.Reset {
+		return nil
+	}
+	// an untracked file where the path needs a directory is in the way as well
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		info, err := os.Lstat(worktree.FullPath(rd.r, dir))
+		if err != nil {
+			break
+		}
+		if !info.IsDir() {
+			if !rd.tracked[dir] {
+				return fmt.Errorf("Untracked working tree file '%s' would be %s by merge.", dir, action)
+			}
+			break
+		}
+	}
+	full := worktree.FullPath(rd.r, name)
+	info, err := os.Lstat(full)
+	if err != nil {
+		return nil
+	}
+	untracked := !rd.tracked[name]
+	if info.IsDir() {
+		// a directory is in the way only if it holds untracked files
+		untracked = false
+		filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(rd.r.Worktree, p)
+			if !rd.tracked[filepath.ToSlash(rel)] {
+				untracked = true
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+	switch {
+	case untracked && info.IsDir():
+		return fmt.Errorf("Updating '%s' would lose untracked files in it", name)
+	case untracked:
+		return fmt.Errorf("Untracked working tree file '%s' would be %s by merge.", name, action)
+	}
+	return nil
+}
+
+// replaceConflicts drops the entries that a new or changed entry leaves no room for: files
+// where it needs a directory and files below it
+func (rd *reader) replaceConflicts() {
+	files, dirs := map[string]bool{}, map[string]bool{}
+	for e := range rd.update {
+		files[e.Name] = true
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	kept := rd.out[:0]
+	for _, e := range rd.out {
+		replaced := !rd.update[e] && dirs[e.Name]
+		for dir := path.Dir(e.Name); dir != "." && !replaced; dir = path.Dir(dir) {
+			replaced = !rd.update[e] && files[dir]
+		}
+		if replaced {
+			rd.remove = append(rd.remove, e.Name)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	rd.out = kept
+}
+
+// checkout removes the files dropped from the index and writes the new and changed ones
+func (rd *reader) checkout() error {
+	kept := map[string]bool{}
+	for _, e := range rd.out {
+		kept[e.Name] = true
+	}
+	for _, name := range rd.remove {
+		if !kept[name] {
+			if err := worktree.RemoveFile(rd.r, name); err != nil {
+				return err
+			}
+		}
+	}
+	for i, e := range rd.out {
+		if !rd.update[e] || e.Stage != 0 {
+			continue
+		}
+		written, err := worktree.WriteFile(rd.r, rd.filters, e.Name, e.Mode, e.Sha)
+		if err != nil {
+			return err
+		}
+		rd.out[i] = written
+	}
+	return nil
+}
+
+// rejected is the error for a path whose index entry a merge would overwrite
+func rejected(name string) error {
+	return fmt.Errorf("Entry '%s' would be overwritten by merge. Cannot merge.", name)
+}
+
+// newEntry returns an index entry, without stat information, for a tree file
+func newEntry(name string, f *object.TreeFile, stage int) *index.Entry {
+	return &index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name, Stage: stage}
+}
+
+// same reports whether the index entry e holds the tree file f; both may be nil
+func same(e *index.Entry, f *object.TreeFile) bool {
+	if e == nil || f == nil {
+		return e == nil && f == nil
+	}
+	return e.Sha == f.Sha && e.Mode == f.Mode
+}
+
+// sameFiles reports whether two tree files are the same; both may be nil
+func sameFiles(a, b *object.TreeFile) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,705 @@
+package rebase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/branch"
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/sequencer"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// stateDir is the directory below the git directory holding a rebase in progress
+const stateDir = "rebase-merge"
+
+// detached is the head-name of a rebase started on a detached HEAD
+const detached = "detached HEAD"
+
+// ErrNoRebase is returned by Continue, Skip, Abort and Quit when no rebase is in progress
+var ErrNoRebase = errors.New("no rebase in progress")
+
+// ErrInProgress is returned by Start when an earlier rebase has not finished
+var ErrInProgress = errors.New("a rebase is already in progress: try --continue, --skip, --abort or --quit")
+
+// Options control how a rebase builds and runs its todo list
+// Onto is where the commits are replayed, the upstream when empty. With Interactive
+// the todo list is handed to GIT_SEQUENCE_EDITOR or sequence.editor, which may rewrite it.
+// Exec adds an exec line after each commit. Branch is the branch to rebase instead of the
+// current one; it is only checked out once the rebase is known to start.
+type Options struct {
+	Onto        string
+	Interactive bool
+	Autosquash  bool
+	Exec        []string
+	Branch      string
+}
+
+// Result describes a finished rebase
+// Branch is the rebased branch, "" for a detached HEAD
+type Result struct {
+	Branch   string
+	UpToDate bool
+	Messages []string
+}
+
+// Stop reasons
+const (
+	StopConflict = "conflict"
+	StopEdit     = "edit"
+	StopExec     = "exec"
+	StopBreak    = "break"
+)
+
+// StopError is returned when the rebase stops for the user
+// Reason is one of the Stop constants; the rebase goes on with Continue
+type StopError struct {
+	Reason    string
+	Command   Command
+	Messages  []string
+	Conflicts []string
+}
+
+func (e *StopError) Error() string {
+	switch e.Reason {
+	case StopConflict:
+		return fmt.Sprintf("could not apply %s... %s", object.Abbrev(e.Command.Sha), e.Command.Subject)
+	case StopEdit:
+		return fmt.Sprintf("stopped at %s... %s", object.Abbrev(e.Command.Sha), e.Command.Subject)
+	case StopExec:
+		return "execution failed: " + e.Command.Exec
+	}
+	return "stopped at HEAD"
+}
+
+// state is what the rebase-merge directory records between commands
+type state struct {
+	headName string
+	onto     string
+	origHead string
+	todo     []Command
+	done     []Command
+	messages []string
+}
+
+func statePath(r *repo.Gitrepo, name string) string {
+	return repo.RepoPath(r, stateDir, name)
+}
+
+// InProgress reports whether a rebase has stopped and waits to be continued
+func InProgress(r *repo.Gitrepo) bool {
+	exists, _ := repo.PathExist(repo.RepoPath(r, stateDir))
+	return exists
+}
+
+// TodoPath returns the path of the todo file of the rebase in progress
+// It may be edited while the rebase is stopped
+func TodoPath(r *repo.Gitrepo) string {
+	return statePath(r, "git-rebase-todo")
+}
+
+func readStateFile(r *repo.Gitrepo, name string) string {
+	data, err := os.ReadFile(statePath(r, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// load reads the rebase-merge directory
+func load(r *repo.Gitrepo) (*state, error) {
+	if !InProgress(r) {
+		return nil, ErrNoRebase
+	}
+	s := &state{
+		headName: readStateFile(r, "head-name"),
+		onto:     readStateFile(r, "onto"),
+		origHead: readStateFile(r, "orig-head"),
+	}
+	todo, err := os.ReadFile(TodoPath(r))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.todo, err = ParseTodo(r, string(todo)); err != nil {
+		return nil, err
+	}
+	done, _ := os.ReadFile(statePath(r, "done"))
+	if s.done, err = ParseTodo(r, string(done)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save writes the rebase-merge directory
+func (s *state) save(r *repo.Gitrepo) error {
+	if _, err := repo.RepoDir(r, true, stateDir); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"head-name":       s.headName + "\n",
+		"onto":            s.onto + "\n",
+		"orig-head":       s.origHead + "\n",
+		"git-rebase-todo": FormatTodo(s.todo),
+		"done":            FormatTodo(s.done),
+		"msgnum":          fmt.Sprintf("%d\n", len(s.done)),
+		"end":             fmt.Sprintf("%d\n", len(s.done)+len(s.todo)),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(statePath(r, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearStop removes what a stopped command leaves behind
+func clearStop(r *repo.Gitrepo) {
+	os.Remove(statePath(r, "stopped-sha"))
+	os.Remove(statePath(r, "message"))
+	os.Remove(statePath(r, "amend"))
+	os.Remove(repo.RepoPath(r, "REBASE_HEAD"))
+}
+
+// headCommit returns HEAD and its commit
+func headCommit(r *repo.Gitrepo) (string, *object.Commit, error) {
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if head == "" {
+		return "", nil, errors.New("HEAD does not point to a commit")
+	}
+	c, err := object.ReadCommit(r, head)
+	if err != nil {
+		return "", nil, err
+	}
+	return head, c, nil
+}
+
+// checkClean refuses to start on a worktree or index with uncommitted changes
+func checkClean(r *repo.Gitrepo, headTree string) error {
+	idx, err := index.Read(r)
+	if err != nil {
+		return err
+	}
+	if len(idx.Conflicts()) > 0 {
+		return errors.New("cannot rebase: you need to resolve your current index first")
+	}
+	files, err := object.TreeFiles(r, headTree)
+	if err != nil {
+		return err
+	}
+	staged := idx.Files()
+	if len(staged) != len(files) {
+		return errors.New("cannot rebase: your index contains uncommitted changes")
+	}
+	for name, f := range files {
+		if staged[name] != f {
+			return errors.New("cannot rebase: your index contains uncommitted changes")
+		}
+	}
+	changes, err := worktree.Unstaged(r, filter.New(r), idx)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		return errors.New("cannot rebase: you have unstaged changes")
+	}
+	return nil
+}
+
+// Start replays the commits of the current branch that are not in upstream on top of
+// Options.Onto, or upstream itself; merge commits are left out. exec commands are
+// announced on out, where their output goes too.
+func Start(r *repo.Gitrepo, upstream string, opts Options, out io.Writer) (*Result, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	if InProgress(r) {
+		return nil, ErrInProgress
+	}
+	current, _, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	head, headC, err := headCommit(r)
+	if err != nil {
+		return nil, err
+	}
+	// the worktree is checked against what is checked out, the commits come from the branch
+	worktreeTree := headC.TreeSha()
+	if opts.Branch != "" {
+		current = "refs/heads/" + opts.Branch
+		if head, err = refs.ResolveRef(r, current); err != nil || head == "" {
+			return nil, fmt.Errorf("no such branch: '%s'", opts.Branch)
+		}
+		if headC, err = object.ReadCommit(r, head); err != nil {
+			return nil, err
+		}
+	}
+	upstreamSha, err := object.ObjectFind(r, upstream, "commit")
+	if err != nil {
+		return nil, err
+	}
+	ontoName, onto := upstream, upstreamSha
+	if opts.Onto != "" {
+		ontoName = opts.Onto
+		if onto, err = object.ObjectFind(r, opts.Onto, "commit"); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkClean(r, worktreeTree); err != nil {
+		return nil, err
+	}
+
+	shas, err := sequencer.ListCommits(r, []string{"^" + upstreamSha, head}, true)
+	if err != nil {
+		return nil, err
+	}
+	var commands []Command
+	linear, prev := true, onto
+	for _, sha := range shas {
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents()) > 1 {
+			linear = false
+			continue
+		}
+		if len(c.Parents()) != 1 || c.Parents()[0] != prev {
+			linear = false
+		}
+		prev = sha
+		commands = append(commands, Command{Action: Pick, Sha: sha, Subject: c.Subject()})
+	}
+	if opts.Autosquash {
+		squashed := Autosquash(commands)
+		for i := range squashed {
+			if squashed[i] != commands[i] {
+				linear = false
+			}
+		}
+		commands = squashed
+	}
+	commands = AddExec(commands, opts.Exec)
+
+	res := &Result{}
+	if current != "" {
+		res.Branch = current
+	}
+	if linear && prev == head && !opts.Interactive && len(opts.Exec) == 0 {
+		if opts.Branch != "" {
+			if _, err := branch.Switch(r, opts.Branch, branch.SwitchOptions{}); err != nil {
+				return nil, err
+			}
+		}
+		res.UpToDate = true
+		return res, nil
+	}
+
+	s := &state{headName: detached, onto: onto, origHead: head, todo: commands}
+	if current != "" {
+		s.headName = current
+	}
+	if err := s.save(r); err != nil {
+		return nil, err
+	}
+	if opts.Interactive {
+		if editor := sequenceEditor(r); editor != "" {
+			if err := runEditor(r, editor, TodoPath(r)); err != nil {
+				os.RemoveAll(repo.RepoPath(r, stateDir))
+				return nil, fmt.Errorf("could not execute editor: %w", err)
+			}
+			data, err := os.ReadFile(TodoPath(r))
+			if err != nil {
+				return nil, err
+			}
+			if s.todo, err = ParseTodo(r, string(data)); err != nil {
+				os.RemoveAll(repo.RepoPath(r, stateDir))
+				return nil, err
+			}
+			if len(s.todo) == 0 {
+				os.RemoveAll(repo.RepoPath(r, stateDir))
+				return nil, errors.New("nothing to do")
+			}
+		}
+	}
+
+	ontoC, err := object.ReadCommit(r, onto)
+	if err != nil {
+		return nil, err
+	}
+	if err := worktree.Checkout(r, worktreeTree, ontoC.TreeSha(), false); err != nil {
+		os.RemoveAll(repo.RepoPath(r, stateDir))
+		return nil, err
+	}
+	if err := refs.UpdateRef(r, "ORIG_HEAD", head, ""); err != nil {
+		return nil, err
+	}
+	if err := refs.DetachHead(r, onto, "rebase (start): checkout "+ontoName); err != nil {
+		return nil, err
+	}
+	return s.run(r, out)
+}
+
+// Continue finishes the command the rebase stopped at, committing the resolved
+// conflicts, or amending the commit of an edit with the staged changes, and goes on
+func Continue(r *repo.Gitrepo, out io.Writer) (*Result, error) {
+	s, err := load(r)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.Conflicts()) > 0 {
+		return nil, errors.New("you must edit all merge conflicts and then mark them as resolved")
+	}
+	head, headC, err := headCommit(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := object.WriteTree(r, idx.Files())
+	if err != nil {
+		return nil, err
+	}
+
+	stopped := readStateFile(r, "stopped-sha")
+	amend := readStateFile(r, "amend")
+	switch {
+	case stopped != "" && len(s.done) > 0:
+		c := s.done[len(s.done)-1]
+		message := readStateFile(r, "message") + "\n"
+		orig, err := object.ReadCommit(r, stopped)
+		if err != nil {
+			return nil, err
+		}
+		switch c.Action {
+		case Squash, Fixup:
+			if _, err := sequencer.Commit(r, tree, headC.Parents(), headC.Data.Header["author"][0], message, "rebase (continue): "+object.Subject([]byte(message))); err != nil {
+				return nil, err
+			}
+		default:
+			// a resolution that leaves nothing to commit drops the commit
+			if tree == headC.TreeSha() {
+				break
+			}
+			if _, err := sequencer.Commit(r, tree, []string{head}, orig.Data.Header["author"][0], message, "rebase (continue): "+object.Subject([]byte(message))); err != nil {
+				return nil, err
+			}
+			if c.Action == Reword {
+				if err := reword(r); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case amend != "" && amend == head && tree != headC.TreeSha():
+		if _, err := sequencer.Commit(r, tree, headC.Parents(), headC.Data.Header["author"][0], string(headC.Data.Message), "rebase (continue): "+headC.Subject()); err != nil {
+			return nil, err
+		}
+	}
+	clearStop(r)
+	return s.run(r, out)
+}
+
+// Skip drops the command the rebase stopped at, resetting the index and worktree to HEAD, and goes on
+func Skip(r *repo.Gitrepo, out io.Writer) (*Result, error) {
+	s, err := load(r)
+	if err != nil {
+		return nil, err
+	}
+	_, headC, err := headCommit(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := worktree.Checkout(r, headC.TreeSha(), headC.TreeSha(), true); err != nil {
+		return nil, err
+	}
+	clearStop(r)
+	return s.run(r, out)
+}
+
+// Abort gives up the rebase and goes back to the branch, index and worktree it started from
+func Abort(r *repo.Gitrepo) error {
+	s, err := load(r)
+	if err != nil {
+		return err
+	}
+	_, headC, err := headCommit(r)
+	if err != nil {
+		return err
+	}
+	orig, err := object.ReadCommit(r, s.origHead)
+	if err != nil {
+		return err
+	}
+	if err := worktree.Checkout(r, headC.TreeSha(), orig.TreeSha(), true); err != nil {
+		return err
+	}
+	if s.headName != detached {
+		err = refs.WriteSymbolicRef(r, "HEAD", s.headName, "rebase (abort): returning to "+s.headName)
+	} else {
+		err = refs.DetachHead(r, s.origHead, "rebase (abort): returning to "+s.origHead)
+	}
+	if err != nil {
+		return err
+	}
+	clearStop(r)
+	return os.RemoveAll(repo.RepoPath(r, stateDir))
+}
+
+// Quit forgets the rebase, leaving HEAD, the index and the worktree as they are
+func Quit(r *repo.Gitrepo) error {
+	if !InProgress(r) {
+		return ErrNoRebase
+	}
+	clearStop(r)
+	return os.RemoveAll(repo.RepoPath(r, stateDir))
+}
+
+// run carries out the commands left in the todo list, then finishes the rebase
+func (s *state) run(r *repo.Gitrepo, out io.Writer) (*Result, error) {
+	for len(s.todo) > 0 {
+		c := s.todo[0]
+		s.todo = s.todo[1:]
+		s.done = append(s.done, c)
+		if err := s.save(r); err != nil {
+			return nil, err
+		}
+		if err := s.step(r, c, out); err != nil {
+			return nil, err
+		}
+		// the todo file may have been edited by an exec command
+		if c.Action == Exec {
+			data, err := os.ReadFile(TodoPath(r))
+			if err != nil {
+				return nil, err
+			}
+			if s.todo, err = ParseTodo(r, string(data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.finish(r)
+}
+
+// finish moves the branch to the rebased commits and checks it out again
+func (s *state) finish(r *repo.Gitrepo) (*Result, error) {
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Messages: s.messages}
+	if s.headName != detached {
+		res.Branch = s.headName
+		if err := refs.UpdateRef(r, s.headName, head, "rebase (finish): "+s.headName+" onto "+s.onto); err != nil {
+			return nil, err
+		}
+		if err := refs.WriteSymbolicRef(r, "HEAD", s.headName, "rebase (finish): returning to "+s.headName); err != nil {
+			return nil, err
+		}
+	}
+	clearStop(r)
+	return res, os.RemoveAll(repo.RepoPath(r, stateDir))
+}
+
+// stop records the commit being applied and the message it will get for Continue
+func stop(r *repo.Gitrepo, sha, message string) error {
+	if err := os.WriteFile(statePath(r, "stopped-sha"), []byte(sha+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(statePath(r, "message"), []byte(message), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(repo.RepoPath(r, "REBASE_HEAD"), []byte(sha+"\n"), 0644)
+}
+
+// step carries out one command, writing what an exec command prints to out
+func (s *state) step(r *repo.Gitrepo, c Command, out io.Writer) error {
+	switch c.Action {
+	case Drop:
+		return nil
+	case Break:
+		return &StopError{Reason: StopBreak, Command: c}
+	case Exec:
+		fmt.Fprintln(out, "Executing:", c.Exec)
+		cmd := exec.Command("sh", "-c", c.Exec)
+		cmd.Dir = r.Worktree
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, out, out
+		if err := cmd.Run(); err != nil {
+			return &StopError{Reason: StopExec, Command: c}
+		}
+		return nil
+	}
+
+	orig, err := object.ReadCommit(r, c.Sha)
+	if err != nil {
+		return err
+	}
+	head, headC, err := headCommit(r)
+	if err != nil {
+		return err
+	}
+	squashing := c.Action == Squash || c.Action == Fixup
+	message := string(orig.Data.Message)
+	if squashing {
+		message = squashMessage(c, headC, orig)
+	}
+
+	parents := orig.Parents()
+	if !squashing && len(parents) == 1 && parents[0] == head {
+		// the commit already sits on HEAD: move forward without rewriting it
+		if err := worktree.Checkout(r, headC.TreeSha(), orig.TreeSha(), false); err != nil {
+			return err
+		}
+		if err := refs.UpdateRef(r, "HEAD", c.Sha, "rebase (pick): "+c.Subject); err != nil {
+			return err
+		}
+	} else {
+		res, headTree, err := sequencer.Replay(r, sequencer.Pick, c.Sha, 0)
+		if err != nil {
+			return err
+		}
+		s.messages = append(s.messages, res.Messages...)
+		if !res.Clean() {
+			if err := stop(r, c.Sha, message); err != nil {
+				return err
+			}
+			return &StopError{Reason: StopConflict, Command: c, Messages: res.Messages, Conflicts: res.ConflictNames()}
+		}
+		tree, err := object.WriteTree(r, res.Files)
+		if err != nil {
+			return err
+		}
+		switch {
+		case squashing:
+			if c.Action == Squash {
+				message = editMessage(r, message)
+			}
+			if _, err := sequencer.Commit(r, tree, headC.Parents(), headC.Data.Header["author"][0], message, "rebase ("+c.Action+"): "+object.Subject([]byte(message))); err != nil {
+				return err
+			}
+			return nil
+		case tree == headTree:
+			// the change is already upstream; the exec lines that follow it go with it
+			s.messages = append(s.messages, fmt.Sprintf("dropping %s %s -- patch contents already upstream", c.Sha, c.Subject))
+			for len(s.todo) > 0 && s.todo[0].Action == Exec {
+				s.todo = s.todo[1:]
+			}
+			return nil
+		}
+		if _, err := sequencer.Commit(r, tree, []string{head}, orig.Data.Header["author"][0], message, "rebase (pick): "+c.Subject); err != nil {
+			return err
+		}
+	}
+
+	switch c.Action {
+	case Reword:
+		return reword(r)
+	case Edit:
+		_, head, err := refs.Head(r)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(statePath(r, "amend"), []byte(head+"\n"), 0644); err != nil {
+			return err
+		}
+		return &StopError{Reason: StopEdit, Command: c}
+	}
+	return nil
+}
+
+// squashMessage returns the message of HEAD once the commit orig is folded into it
+func squashMessage(c Command, head, orig *object.Commit) string {
+	if c.Action == Fixup {
+		if c.KeepMessage {
+			return stripAutosquashSubject(string(orig.Data.Message))
+		}
+		return string(head.Data.Message)
+	}
+	body := stripAutosquashSubject(string(orig.Data.Message))
+	if strings.TrimSpace(body) == "" {
+		return string(head.Data.Message)
+	}
+	return strings.TrimRight(string(head.Data.Message), "\n") + "\n\n" + body
+}
+
+// stripAutosquashSubject drops a "squash! ..." or "amend! ..." subject line, keeping the rest of the message
+func stripAutosquashSubject(message string) string {
+	subject, rest, _ := strings.Cut(message, "\n")
+	for prefix := range autosquashPrefixes {
+		if strings.HasPrefix(subject, prefix) {
+			return strings.TrimLeft(rest, "\n")
+		}
+	}
+	return message
+}
+
+// reword amends the message of HEAD with the editor, when one is configured
+func reword(r *repo.Gitrepo) error {
+	_, headC, err := headCommit(r)
+	if err != nil {
+		return err
+	}
+	message := editMessage(r, string(headC.Data.Message))
+	if message == string(headC.Data.Message) {
+		return nil
+	}
+	_, err = sequencer.Commit(r, headC.TreeSha(), headC.Parents(), headC.Data.Header["author"][0], message, "rebase (reword): "+object.Subject([]byte(message)))
+	return err
+}
+
+// editMessage lets the user edit message with GIT_EDITOR or core.editor
+// Without a configured editor, or when the result is empty, message is kept
+func editMessage(r *repo.Gitrepo, message string) string {
+	editor := os.Getenv("GIT_EDITOR")
+	if editor == "" {
+		editor, _ = repo.ConfigLookup(r, "core", "editor")
+	}
+	if editor == "" {
+		return message
+	}
+	path := repo.RepoPath(r, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(message), 0644); err != nil {
+		return message
+	}
+	if err := runEditor(r, editor, path); err != nil {
+		return message
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return message
+	}
+	edited := sequencer.CleanupMessage(string(data))
+	if strings.TrimSpace(edited) == "" {
+		return message
+	}
+	return edited
+}
+
+// sequenceEditor returns the command that edits the todo list, GIT_SEQUENCE_EDITOR or sequence.editor
+func sequenceEditor(r *repo.Gitrepo) string {
+	if editor := os.Getenv("GIT_SEQUENCE_EDITOR"); editor != "" {
+		return editor
+	}
+	editor, _ := repo.ConfigLookup(r, "sequence", "editor")
+	return editor
+}
+
+// runEditor runs the shell command editor on the file at path
+func runEditor(r *repo.Gitrepo, editor, path string) error {
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Dir = r.Worktree
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
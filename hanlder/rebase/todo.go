@@ -0,0 +1,199 @@
+package rebase
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Todo actions
+const (
+	Pick   = "pick"
+	Reword = "reword"
+	Edit   = "edit"
+	Squash = "squash"
+	Fixup  = "fixup"
+	Drop   = "drop"
+	Exec   = "exec"
+	Break  = "break"
+)
+
+var abbreviations = map[string]string{
+	"p": Pick, "r": Reword, "e": Edit, "s": Squash, "f": Fixup, "d": Drop, "x": Exec, "b": Break,
+}
+
+// Command is one line of the todo list
+// Exec holds the shell command of exec lines; KeepMessage is set by "fixup -C",
+// which uses the fixup's message instead of the one it amends
+type Command struct {
+	Action      string
+	Sha         string
+	Subject     string
+	Exec        string
+	KeepMessage bool
+}
+
+// String formats the command as a todo line
+func (c Command) String() string {
+	switch c.Action {
+	case Exec:
+		return Exec + " " + c.Exec
+	case Break:
+		return Break
+	}
+	action := c.Action
+	if c.KeepMessage {
+		action += " -C"
+	}
+	return fmt.Sprintf("%s %s %s", action, object.Abbrev(c.Sha), c.Subject)
+}
+
+// FormatTodo writes commands as the lines of a todo file
+func FormatTodo(commands []Command) string {
+	var b strings.Builder
+	for _, c := range commands {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// ParseTodo reads a todo file, resolving the commits it names
+// Blank lines and lines starting with # are ignored
+func ParseTodo(r *repo.Gitrepo, data string) ([]Command, error) {
+	var commands []Command
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		word, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		action := word
+		if full, ok := abbreviations[word]; ok {
+			action = full
+		}
+		c := Command{Action: action}
+		switch action {
+		case "noop":
+			continue
+		case Break:
+		case Exec:
+			if rest == "" {
+				return nil, fmt.Errorf("missing command after exec: %s", line)
+			}
+			c.Exec = rest
+		case Pick, Reword, Edit, Squash, Fixup, Drop:
+			if action == Fixup && (strings.HasPrefix(rest, "-C ") || strings.HasPrefix(rest, "-c ")) {
+				c.KeepMessage = true
+				rest = strings.TrimSpace(rest[3:])
+			}
+			name, subject, _ := strings.Cut(rest, " ")
+			if name == "" {
+				return nil, fmt.Errorf("missing commit in todo line: %s", line)
+			}
+			sha, err := object.ObjectFind(r, name, "commit")
+			if err != nil {
+				return nil, fmt.Errorf("invalid line in todo list: %s: %w", line, err)
+			}
+			c.Sha, c.Subject = sha, subject
+		default:
+			return nil, fmt.Errorf("invalid command '%s' in todo list", word)
+		}
+		commands = append(commands, c)
+	}
+	return commands, nil
+}
+
+// autosquashPrefixes are the subject prefixes that move a commit next to the one it fixes
+var autosquashPrefixes = map[string]string{"fixup! ": Fixup, "squash! ": Squash, "amend! ": Fixup}
+
+// Autosquash moves the commits whose subject starts with fixup!, squash! or amend!
+// after the commit they name, by subject or by id, turning them into fixups and squashes
+func Autosquash(commands []Command) []Command {
+	targetOf := map[int]int{}
+	for i, c := range commands {
+		if c.Action != Pick {
+			continue
+		}
+		subject, action, keep := c.Subject, "", false
+		for {
+			matched := false
+			for prefix, a := range autosquashPrefixes {
+				if strings.HasPrefix(subject, prefix) {
+					subject = strings.TrimPrefix(subject, prefix)
+					if action == "" {
+						action, keep = a, prefix == "amend! "
+					}
+					matched = true
+				}
+			}
+			if !matched {
+				break
+			}
+		}
+		if action == "" {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			t := commands[j]
+			if _, fixes := targetOf[j]; fixes {
+				continue
+			}
+			if t.Subject == subject || (len(subject) >= 4 && strings.HasPrefix(t.Sha, subject)) {
+				targetOf[i] = j
+				commands[i].Action, commands[i].KeepMessage = action, keep
+				break
+			}
+		}
+		if _, ok := targetOf[i]; !ok {
+			// fall back to a prefix of the subject
+			for j := 0; j < i; j++ {
+				if _, fixes := targetOf[j]; !fixes && strings.HasPrefix(commands[j].Subject, subject) {
+					targetOf[i] = j
+					commands[i].Action, commands[i].KeepMessage = action, keep
+					break
+				}
+			}
+		}
+	}
+	if len(targetOf) == 0 {
+		return commands
+	}
+	var out []Command
+	for i, c := range commands {
+		if _, moved := targetOf[i]; moved {
+			continue
+		}
+		out = append(out, c)
+		for j := i + 1; j < len(commands); j++ {
+			if t, ok := targetOf[j]; ok && t == i {
+				out = append(out, commands[j])
+			}
+		}
+	}
+	return out
+}
+
+// AddExec inserts an exec line for each command after every commit, or after the last fixup or squash folded into it
+func AddExec(commands []Command, execs []string) []Command {
+	if len(execs) == 0 {
+		return commands
+	}
+	var out []Command
+	for i, c := range commands {
+		out = append(out, c)
+		if c.Sha == "" || c.Action == Drop {
+			continue
+		}
+		if i+1 < len(commands) && (commands[i+1].Action == Fixup || commands[i+1].Action == Squash) {
+			continue
+		}
+		for _, e := range execs {
+			out = append(out, Command{Action: Exec, Exec: e})
+		}
+	}
+	return out
+}
@@ -0,0 +1,1008 @@
+package reffilter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Blue-Onion/pygo/hanlder/branch"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pretty"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// DefaultFormat is the format used when none is given
+const DefaultFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+// Quote styles for the values of a format, for output read by scripts
+const (
+	QuoteNone   = ""
+	QuoteShell  = "shell"
+	QuotePerl   = "perl"
+	QuotePython = "python"
+	QuoteTcl    = "tcl"
+)
+
+// atom is a %(name:arg) placeholder; deref atoms, written %(*name), describe the object
+// a tag points at
+type atom struct {
+	deref bool
+	name  string
+	arg   string
+}
+
+// value is what an atom expands to; dates, sizes and counts also sort as numbers
+type value struct {
+	s       string
+	n       int64
+	numeric bool
+}
+
+// block reports whether the atom opens or closes a part of the format rather than
+// expanding to a value
+func (a atom) block() bool {
+	switch a.name {
+	case "if", "then", "else", "end", "align":
+		return true
+	}
+	return false
+}
+
+// node is a piece of a parsed format: literal text, an atom, or an %(if) or %(align) block
+type node struct {
+	text string
+	atom *atom
+	// if blocks
+	isIf              bool
+	cond, then, els   []node
+	equals, notEquals *string
+	// align blocks
+	isAlign  bool
+	width    int
+	position string
+	body     []node
+}
+
+// Format is a parsed --format string
+type Format struct {
+	nodes []node
+	quote string
+}
+
+func unknownField(name string) error {
+	return fmt.Errorf("unknown field name: %s", name)
+}
+
+// ParseFormat parses a format of literal text, %(atom) placeholders, %% and %xx hex escapes
+// Values are quoted in the given style.
+func ParseFormat(format, quote string) (*Format, error) {
+	tokens, err := tokenize(format)
+	if err != nil {
+		return nil, err
+	}
+	nodes, _, stop, err := parseNodes(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if stop != nil {
+		return nil, strayAtom(stop.name)
+	}
+	return &Format{nodes: nodes, quote: quote}, nil
+}
+
+// tokenize splits a format into literal text and atoms
+func tokenize(format string) ([]node, error) {
+	var tokens []node
+	var lit strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			lit.WriteByte(c)
+			continue
+		}
+		switch next := format[i+1]; {
+		case next == '%':
+			lit.WriteByte('%')
+			i++
+		case next == '(':
+			end := strings.IndexByte(format[i:], ')')
+			if end == -1 {
+				return nil, fmt.Errorf("malformed format string %s", format[i:])
+			}
+			a, err := parseAtom(format[i+2 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			if lit.Len() > 0 {
+				tokens = append(tokens, node{text: lit.String()})
+				lit.Reset()
+			}
+			tokens = append(tokens, node{atom: &a})
+			i += end
+		default:
+			if i+2 < len(format) {
+				if v, err := strconv.ParseUint(format[i+1:i+3], 16, 8); err == nil {
+					lit.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			lit.WriteByte('%')
+		}
+	}
+	if lit.Len() > 0 {
+		tokens = append(tokens, node{text: lit.String()})
+	}
+	return tokens, nil
+}
+
+// parseNodes builds the nodes of tokens up to a %(then), %(else) or %(end), which is returned
+// along with the tokens after it
+func parseNodes(tokens []node) ([]node, []node, *atom, error) {
+	var nodes []node
+	for len(tokens) > 0 {
+		t := tokens[0]
+		tokens = tokens[1:]
+		if t.atom == nil || !t.atom.block() {
+			nodes = append(nodes, t)
+			continue
+		}
+		var err error
+		switch t.atom.name {
+		case "then", "else", "end":
+			return nodes, tokens, t.atom, nil
+		case "align":
+			n := node{isAlign: true}
+			n.width, n.position, _ = parseAlign(t.atom.arg)
+			var stop *atom
+			n.body, tokens, stop, err = parseNodes(tokens)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if stop == nil {
+				return nil, nil, nil, errors.New("format: %(end) atom missing")
+			}
+			if stop.name != "end" {
+				return nil, nil, nil, strayAtom(stop.name)
+			}
+			nodes = append(nodes, n)
+		case "if":
+			n := node{isIf: true}
+			if v, ok := strings.CutPrefix(t.atom.arg, "equals="); ok {
+				n.equals = &v
+			} else if v, ok := strings.CutPrefix(t.atom.arg, "notequals="); ok {
+				n.notEquals = &v
+			}
+			if tokens, err = parseIf(&n, tokens); err != nil {
+				return nil, nil, nil, err
+			}
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil, nil, nil
+}
+
+// parseIf fills in the condition, then and else parts of an %(if) block
+func parseIf(n *node, tokens []node) ([]node, error) {
+	var stop *atom
+	var err error
+	n.cond, tokens, stop, err = parseNodes(tokens)
+	switch {
+	case err != nil:
+		return nil, err
+	case stop == nil:
+		return nil, errors.New("format: %(end) atom missing")
+	case stop.name == "else":
+		return nil, errors.New("format: %(else) atom used without a %(then) atom")
+	case stop.name != "then":
+		return nil, errors.New("format: %(if) atom used without a %(then) atom")
+	}
+	n.then, tokens, stop, err = parseNodes(tokens)
+	if err == nil && stop != nil && stop.name == "else" {
+		n.els, tokens, stop, err = parseNodes(tokens)
+		if err == nil && stop != nil && stop.name == "else" {
+			return nil, errors.New("format: %(else) atom used more than once")
+		}
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case stop == nil:
+		return nil, errors.New("format: %(end) atom missing")
+	case stop.name == "then":
+		return nil, errors.New("format: %(then) atom used more than once")
+	}
+	return tokens, nil
+}
+
+// strayAtom is the error for a %(then), %(else) or %(end) outside the block it belongs to
+func strayAtom(name string) error {
+	if name == "end" {
+		return errors.New("format: %(end) atom used without corresponding atom")
+	}
+	return fmt.Errorf("format: %%(%s) atom used without a %%(if) atom", name)
+}
+
+// parseAlign parses the "<width>[,<position>]" argument of %(align)
+func parseAlign(arg string) (int, string, error) {
+	if arg == "" {
+		return 0, "", errors.New("expected format: %(align:<width>,<position>)")
+	}
+	width, position := -1, "left"
+	for _, part := range strings.Split(arg, ",") {
+		part = strings.TrimSpace(part)
+		if v, ok := strings.CutPrefix(part, "width="); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return 0, "", fmt.Errorf("unrecognized width:%s", v)
+			}
+			width = n
+			continue
+		}
+		if v, ok := strings.CutPrefix(part, "position="); ok {
+			part = v
+		} else if n, err := strconv.Atoi(part); err == nil && n >= 0 {
+			width = n
+			continue
+		}
+		switch part {
+		case "left", "middle", "right":
+			position = part
+		default:
+			return 0, "", fmt.Errorf("unrecognized %%(align) argument: %s", part)
+		}
+	}
+	if width < 0 {
+		return 0, "", errors.New("positive width expected with the %(align) atom")
+	}
+	return width, position, nil
+}
+
+// dateFormats are the formats a date atom takes after its colon
+var dateFormats = map[string]bool{"": true, "default": true, "relative": true, "short": true, "local": true,
+	"iso": true, "iso8601": true, "iso-strict": true, "iso8601-strict": true, "rfc": true, "rfc2822": true,
+	"unix": true, "raw": true}
+
+// parseAtom parses the text between %( and ), checking the name and its argument
+func parseAtom(raw string) (atom, error) {
+	a := atom{}
+	spec := raw
+	if rest, ok := strings.CutPrefix(spec, "*"); ok {
+		a.deref, spec = true, rest
+	}
+	a.name, a.arg, _ = strings.Cut(spec, ":")
+	badArg := fmt.Errorf("unrecognized %%(%s) argument: %s", spec, a.arg)
+	role, part := personAtom(a.name)
+	switch {
+	case a.name == "refname" || a.name == "symref":
+		if _, err := stripName("", a.arg); err != nil {
+			return a, badArg
+		}
+	case a.name == "upstream":
+		for _, opt := range strings.Split(a.arg, ",") {
+			switch opt {
+			case "", "short", "track", "trackshort", "nobracket", "remotename", "remoteref":
+			default:
+				if _, err := stripName("", opt); err != nil {
+					return a, badArg
+				}
+			}
+		}
+	case a.name == "objectname" || a.name == "tree" || a.name == "parent":
+		if _, ok := abbrevLength(a.arg); !ok {
+			return a, badArg
+		}
+	case a.name == "objecttype" || a.name == "deltabase":
+		if a.arg != "" {
+			return a, fmt.Errorf("%%(%s) does not take arguments", a.name)
+		}
+	case a.name == "objectsize":
+		if a.arg != "" && a.arg != "disk" {
+			return a, badArg
+		}
+	case a.name == "raw":
+		if a.arg != "" && a.arg != "size" {
+			return a, badArg
+		}
+	case a.name == "subject":
+		if a.arg != "" && a.arg != "sanitize" {
+			return a, badArg
+		}
+	case a.name == "contents":
+		switch a.arg {
+		case "", "subject", "body", "signature":
+		default:
+			lines, ok := strings.CutPrefix(a.arg, "lines=")
+			if n, err := strconv.Atoi(lines); !ok || err != nil || n < 0 {
+				return a, badArg
+			}
+		}
+	case a.name == "align":
+		if _, _, err := parseAlign(a.arg); err != nil {
+			return a, err
+		}
+	case a.name == "if":
+		if a.arg != "" && !strings.HasPrefix(a.arg, "equals=") && !strings.HasPrefix(a.arg, "notequals=") {
+			return a, badArg
+		}
+	case role != "" && part == "email":
+		switch a.arg {
+		case "", "trim", "localpart":
+		default:
+			return a, badArg
+		}
+	case role != "" && part == "date":
+		if !dateFormats[a.arg] && !strings.HasPrefix(a.arg, "format:") {
+			return a, fmt.Errorf("unknown date format %s", a.arg)
+		}
+	case role != "",
+		a.name == "numparent", a.name == "object", a.name == "type", a.name == "tag", a.name == "HEAD",
+		a.name == "body", a.name == "color", a.name == "then", a.name == "else", a.name == "end":
+	default:
+		return a, unknownField(spec)
+	}
+	return a, nil
+}
+
+// personAtom splits atoms like authorname or taggerdate into the role and the part of the
+// identity they show; the part is empty for the whole identity line
+func personAtom(name string) (string, string) {
+	for _, role := range []string{"author", "committer", "tagger", "creator"} {
+		if part, ok := strings.CutPrefix(name, role); ok {
+			switch part {
+			case "", "name", "email", "date":
+				if role == "creator" && (part == "name" || part == "email") {
+					return "", ""
+				}
+				return role, part
+			}
+		}
+	}
+	return "", ""
+}
+
+// Expand formats a reference, quoting the values of atoms outside blocks, and the output
+// of each block, in the style of the format
+func (f *Format) Expand(item *Ref) (string, error) {
+	var b strings.Builder
+	if err := f.expand(item, f.nodes, &b, true); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (f *Format) expand(item *Ref, nodes []node, b *strings.Builder, top bool) error {
+	for _, n := range nodes {
+		var out string
+		switch {
+		case n.atom != nil:
+			v, err := item.value(*n.atom)
+			if err != nil {
+				return err
+			}
+			out = v.s
+		case n.isIf:
+			var cond strings.Builder
+			if err := f.expand(item, n.cond, &cond, false); err != nil {
+				return err
+			}
+			satisfied := strings.TrimSpace(cond.String()) != ""
+			if n.equals != nil {
+				satisfied = cond.String() == *n.equals
+			} else if n.notEquals != nil {
+				satisfied = cond.String() != *n.notEquals
+			}
+			branch := n.els
+			if satisfied {
+				branch = n.then
+			}
+			var body strings.Builder
+			if err := f.expand(item, branch, &body, false); err != nil {
+				return err
+			}
+			out = body.String()
+		case n.isAlign:
+			var body strings.Builder
+			if err := f.expand(item, n.body, &body, false); err != nil {
+				return err
+			}
+			out = align(body.String(), n.width, n.position)
+		default:
+			b.WriteString(n.text)
+			continue
+		}
+		if top {
+			out = quote(out, f.quote)
+		}
+		b.WriteString(out)
+	}
+	return nil
+}
+
+// align pads s to width columns; longer strings are left as they are
+func align(s string, width int, position string) string {
+	pad := width - utf8.RuneCountInString(s)
+	if pad <= 0 {
+		return s
+	}
+	switch position {
+	case "right":
+		return strings.Repeat(" ", pad) + s
+	case "middle":
+		return strings.Repeat(" ", pad/2) + s + strings.Repeat(" ", pad-pad/2)
+	}
+	return s + strings.Repeat(" ", pad)
+}
+
+// quote quotes s as a string literal of the shell, Perl, Python or Tcl
+func quote(s, style string) string {
+	var b strings.Builder
+	switch style {
+	case QuoteShell:
+		b.WriteByte('\'')
+		for _, c := range []byte(s) {
+			if c == '\'' || c == '!' {
+				b.WriteString("'\\" + string(c) + "'")
+				continue
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('\'')
+	case QuotePerl, QuotePython:
+		b.WriteByte('\'')
+		for _, c := range []byte(s) {
+			switch {
+			case c == '\n' && style == QuotePython:
+				b.WriteString("\\n")
+				continue
+			case c == '\'' || c == '\\':
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('\'')
+	case QuoteTcl:
+		b.WriteByte('"')
+		for _, c := range []byte(s) {
+			switch c {
+			case '[', ']', '{', '}', '$', '\\', '"':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case '\f':
+				b.WriteString("\\f")
+			case '\r':
+				b.WriteString("\\r")
+			case '\n':
+				b.WriteString("\\n")
+			case '\t':
+				b.WriteString("\\t")
+			case '\v':
+				b.WriteString("\\v")
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+	default:
+		return s
+	}
+	return b.String()
+}
+
+// objectInfo is an object read for a format, with its commit or tag parsed
+type objectInfo struct {
+	typ    string
+	data   []byte
+	commit *object.Commit
+	tag    *object.Tag
+}
+
+// object reads the object sha once for all the atoms that need it
+func (item *Ref) object(sha string) (*objectInfo, error) {
+	if info, ok := item.objects[sha]; ok {
+		return info, nil
+	}
+	typ, data, err := object.ObjectReadRaw(item.r, sha)
+	if err != nil {
+		return nil, fmt.Errorf("missing object %s for %s", sha, item.Name)
+	}
+	info := &objectInfo{typ: typ, data: data}
+	switch typ {
+	case "commit":
+		info.commit = &object.Commit{}
+		err = info.commit.Deserialize(data)
+	case "tag":
+		info.tag = &object.Tag{}
+		err = info.tag.Deserialize(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse_object_buffer failed on %s for %s", sha, item.Name)
+	}
+	item.objects[sha] = info
+	return info, nil
+}
+
+// value returns what the atom a expands to for the reference
+func (item *Ref) value(a atom) (value, error) {
+	if v, ok := item.values[a]; ok {
+		return v, nil
+	}
+	v, err := item.compute(a)
+	if err != nil {
+		return value{}, err
+	}
+	item.values[a] = v
+	return v, nil
+}
+
+func text(s string) value {
+	return value{s: s}
+}
+
+func number(n int64) value {
+	return value{s: strconv.FormatInt(n, 10), n: n, numeric: true}
+}
+
+func (item *Ref) compute(a atom) (value, error) {
+	switch a.name {
+	case "refname":
+		if a.deref {
+			return value{}, nil
+		}
+		s, _ := stripName(item.Name, a.arg)
+		return text(s), nil
+	case "symref":
+		target, symbolic, err := refs.ReadRef(item.r, item.Name)
+		if err != nil || !symbolic || a.deref {
+			return value{}, nil
+		}
+		s, _ := stripName(target, a.arg)
+		return text(s), nil
+	case "HEAD":
+		if item.Name == item.head {
+			return text("*"), nil
+		}
+		return text(" "), nil
+	case "upstream":
+		return item.upstream(a.arg)
+	case "color", "if", "then", "else", "end", "align":
+		return value{}, nil
+	}
+
+	sha := item.Sha
+	info, err := item.object(sha)
+	if err != nil {
+		return value{}, err
+	}
+	if a.deref {
+		if info.tag == nil {
+			return value{}, nil
+		}
+		sha = info.tag.Object()
+		if info, err = item.object(sha); err != nil {
+			return value{}, err
+		}
+	}
+	role, part := personAtom(a.name)
+	switch {
+	case a.name == "objectname":
+		return text(abbrev(sha, a.arg)), nil
+	case a.name == "objecttype":
+		return text(info.typ), nil
+	case a.name == "objectsize" && a.arg == "disk":
+		fi, err := os.Stat(repo.RepoPath(item.r, "objects", sha[:2], sha[2:]))
+		if err != nil {
+			return number(0), nil
+		}
+		return number(fi.Size()), nil
+	case a.name == "objectsize":
+		return number(int64(len(info.data))), nil
+	case a.name == "deltabase":
+		// loose objects are never deltas
+		return text(repo.Format(item.r).ZeroID()), nil
+	case a.name == "raw" && a.arg == "size":
+		return number(int64(len(info.data))), nil
+	case a.name == "raw":
+		return text(string(info.data)), nil
+	case a.name == "tree":
+		if info.commit == nil {
+			return value{}, nil
+		}
+		return text(abbrev(info.commit.TreeSha(), a.arg)), nil
+	case a.name == "parent":
+		if info.commit == nil {
+			return value{}, nil
+		}
+		var parents []string
+		for _, p := range info.commit.Parents() {
+			parents = append(parents, abbrev(p, a.arg))
+		}
+		return text(strings.Join(parents, " ")), nil
+	case a.name == "numparent":
+		if info.commit == nil {
+			return value{numeric: true}, nil
+		}
+		return number(int64(len(info.commit.Parents()))), nil
+	case a.name == "object" || a.name == "type" || a.name == "tag":
+		if info.tag == nil {
+			return value{}, nil
+		}
+		return text(header(info.tag.Data, a.name)), nil
+	case role != "":
+		return person(info, role, part, a.arg)
+	case a.name == "subject" || a.name == "body" || a.name == "contents":
+		return contents(info, a), nil
+	}
+	return value{}, unknownField(a.name)
+}
+
+// header returns the first value of a commit or tag header, or ""
+func header(d object.CommitData, key string) string {
+	if v := d.Header[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// person expands the identity atoms; creator is the committer of a commit or the tagger of a tag
+func person(info *objectInfo, role, part, arg string) (value, error) {
+	line := ""
+	switch {
+	case info.commit != nil && (role == "author" || role == "committer"):
+		line = header(info.commit.Data, role)
+	case info.commit != nil && role == "creator":
+		line = header(info.commit.Data, "committer")
+	case info.tag != nil && (role == "tagger" || role == "creator"):
+		line = header(info.tag.Data, "tagger")
+	}
+	if part == "date" {
+		_, when, err := repo.ParseIdent(line)
+		if line == "" || err != nil {
+			return value{numeric: true}, nil
+		}
+		s, err := formatDate(when, arg)
+		if err != nil {
+			return value{}, err
+		}
+		return value{s: s, n: when.Unix(), numeric: true}, nil
+	}
+	if line == "" {
+		return value{}, nil
+	}
+	name, mail, _ := strings.Cut(line, "<")
+	mail, _, _ = strings.Cut(mail, ">")
+	switch part {
+	case "name":
+		return text(strings.TrimSpace(name)), nil
+	case "email":
+		switch arg {
+		case "trim":
+			return text(mail), nil
+		case "localpart":
+			local, _, _ := strings.Cut(mail, "@")
+			return text(local), nil
+		}
+		return text("<" + mail + ">"), nil
+	}
+	return text(line), nil
+}
+
+// formatDate formats a date the way --date does
+func formatDate(when time.Time, format string) (string, error) {
+	switch format {
+	case "", "default":
+		return when.Format(pretty.DefaultDate), nil
+	case "relative":
+		return pretty.Relative(when, time.Now()), nil
+	case "short":
+		return when.Format(pretty.ShortDate), nil
+	case "local":
+		return when.Local().Format("Mon Jan 2 15:04:05 2006"), nil
+	case "iso", "iso8601":
+		return when.Format(pretty.ISODate), nil
+	case "iso-strict", "iso8601-strict":
+		return when.Format(pretty.StrictISO), nil
+	case "rfc", "rfc2822":
+		return when.Format(pretty.RFC2822Date), nil
+	case "unix":
+		return strconv.FormatInt(when.Unix(), 10), nil
+	case "raw":
+		return strconv.FormatInt(when.Unix(), 10) + " " + when.Format("-0700"), nil
+	}
+	if layout, ok := strings.CutPrefix(format, "format:"); ok {
+		return strftime(when, layout), nil
+	}
+	return "", fmt.Errorf("unknown date format %s", format)
+}
+
+// strftime expands the common strftime conversions of a date format
+// Conversions it does not know are copied as they are.
+func strftime(when time.Time, layout string) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i+1 == len(layout) {
+			b.WriteByte(layout[i])
+			continue
+		}
+		i++
+		switch layout[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%d", when.Year())
+		case 'y':
+			b.WriteString(when.Format("06"))
+		case 'm':
+			b.WriteString(when.Format("01"))
+		case 'd':
+			b.WriteString(when.Format("02"))
+		case 'e':
+			b.WriteString(when.Format("_2"))
+		case 'H':
+			b.WriteString(when.Format("15"))
+		case 'I':
+			b.WriteString(when.Format("03"))
+		case 'M':
+			b.WriteString(when.Format("04"))
+		case 'S':
+			b.WriteString(when.Format("05"))
+		case 'p':
+			b.WriteString(when.Format("PM"))
+		case 'a':
+			b.WriteString(when.Format("Mon"))
+		case 'A':
+			b.WriteString(when.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(when.Format("Jan"))
+		case 'B':
+			b.WriteString(when.Format("January"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", when.YearDay())
+		case 'z':
+			b.WriteString(when.Format("-0700"))
+		case 'Z':
+			b.WriteString(when.Format("MST"))
+		case 's':
+			fmt.Fprintf(&b, "%d", when.Unix())
+		case 'F':
+			b.WriteString(when.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(when.Format("15:04:05"))
+		case 'R':
+			b.WriteString(when.Format("15:04"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(layout[i])
+		}
+	}
+	return b.String()
+}
+
+// signatureMarkers start the signature appended to the message of a signed tag
+var signatureMarkers = []string{"-----BEGIN PGP SIGNATURE-----", "-----BEGIN PGP MESSAGE-----", "-----BEGIN SSH SIGNATURE-----", "-----BEGIN SIGNED MESSAGE-----"}
+
+// contents expands the message atoms of a commit or tag
+// The subject is the first paragraph on one line; the body is what follows, without the
+// signature of a signed tag.
+func contents(info *objectInfo, a atom) value {
+	var msg string
+	switch {
+	case info.commit != nil:
+		msg = string(info.commit.Data.Message)
+	case info.tag != nil:
+		msg = string(info.tag.Data.Message)
+	default:
+		return value{}
+	}
+	message, signature := msg, ""
+	for pos := 0; pos < len(msg); {
+		line := msg[pos:]
+		if hasMarker(line) {
+			message, signature = msg[:pos], msg[pos:]
+			break
+		}
+		next := strings.IndexByte(line, '\n')
+		if next == -1 {
+			break
+		}
+		pos += next + 1
+	}
+	trimmed := strings.TrimLeft(message, "\n")
+	subject, body, _ := strings.Cut(trimmed, "\n\n")
+	subject = strings.ReplaceAll(strings.TrimRight(subject, "\n"), "\r\n", "\n")
+	subject = strings.ReplaceAll(subject, "\n", " ")
+	body = strings.TrimLeft(body, "\n")
+
+	switch {
+	case a.name == "subject" && a.arg == "sanitize":
+		return text(sanitize(subject))
+	case a.name == "subject", a.arg == "subject":
+		return text(subject)
+	case a.name == "body", a.arg == "body":
+		return text(body)
+	case a.arg == "signature":
+		return text(signature)
+	case strings.HasPrefix(a.arg, "lines="):
+		n, _ := strconv.Atoi(strings.TrimPrefix(a.arg, "lines="))
+		lines := strings.Split(strings.TrimSuffix(trimmed, "\n"), "\n")
+		if n < len(lines) {
+			lines = lines[:n]
+		}
+		if trimmed == "" {
+			lines = nil
+		}
+		return text(strings.Join(lines, "\n    "))
+	}
+	return text(msg)
+}
+
+func hasMarker(line string) bool {
+	for _, m := range signatureMarkers {
+		if strings.HasPrefix(line, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize turns a subject into a file name: runs of characters other than letters, digits,
+// '.' and '_' become one '-', repeated dots one dot, and dots and dashes are trimmed from
+// the end
+func sanitize(subject string) string {
+	var b strings.Builder
+	space := 2
+	for i := 0; i < len(subject); i++ {
+		c := subject[i]
+		title := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_'
+		if !title {
+			space |= 1
+			continue
+		}
+		if space == 1 {
+			b.WriteByte('-')
+		}
+		space = 0
+		b.WriteByte(c)
+		for c == '.' && i+1 < len(subject) && subject[i+1] == '.' {
+			i++
+		}
+	}
+	return strings.TrimRight(b.String(), ".-")
+}
+
+// stripName applies a refname argument: short, lstrip=N, rstrip=N or strip=N
+// Negative counts keep that many components from the other end.
+func stripName(name, arg string) (string, error) {
+	if arg == "" {
+		return name, nil
+	}
+	if arg == "short" {
+		return refs.Shorten(name), nil
+	}
+	kind, count, ok := strings.Cut(arg, "=")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || kind != "lstrip" && kind != "rstrip" && kind != "strip" {
+		return "", fmt.Errorf("unrecognized argument: %s", arg)
+	}
+	parts := strings.Split(name, "/")
+	if n < 0 {
+		n += len(parts)
+		if n < 0 {
+			n = 0
+		}
+	}
+	if n > len(parts) {
+		n = len(parts)
+	}
+	if kind == "rstrip" {
+		return strings.Join(parts[:len(parts)-n], "/"), nil
+	}
+	return strings.Join(parts[n:], "/"), nil
+}
+
+// abbrevLength parses the "", "short" and "short=N" arguments of object id atoms
+func abbrevLength(arg string) (int, bool) {
+	switch arg {
+	case "":
+		return 0, true
+	case "short":
+		return 7, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "short="))
+	if !strings.HasPrefix(arg, "short=") || err != nil || n < 0 {
+		return 0, false
+	}
+	return max(n, 4), true
+}
+
+// abbrev shortens an object id as the argument of its atom asks
+func abbrev(sha, arg string) string {
+	n, _ := abbrevLength(arg)
+	if n == 0 || n >= len(sha) {
+		return sha
+	}
+	return sha[:n]
+}
+
+// upstream expands %(upstream) for a local branch
+func (item *Ref) upstream(arg string) (value, error) {
+	name, ok := strings.CutPrefix(item.Name, "refs/heads/")
+	if !ok {
+		return value{}, nil
+	}
+	up, err := branch.Upstream(item.r, name)
+	if err != nil {
+		return value{}, nil
+	}
+	section := fmt.Sprintf("branch \"%s\"", name)
+	track, short, bracket := "", false, true
+	s := up
+	for _, opt := range strings.Split(arg, ",") {
+		switch opt {
+		case "track", "trackshort":
+			track = opt
+		case "nobracket":
+			bracket = false
+		case "remotename":
+			remote, _ := repo.ConfigGet(item.r, section, "remote")
+			return text(remote), nil
+		case "remoteref":
+			merge, _ := repo.ConfigGet(item.r, section, "merge")
+			return text(merge), nil
+		case "short":
+			short = true
+		case "":
+		default:
+			s, _ = stripName(up, opt)
+		}
+	}
+	if track == "" {
+		if short {
+			s = refs.Shorten(up)
+		}
+		return text(s), nil
+	}
+	upSha, err := refs.ResolveRef(item.r, up)
+	if err != nil {
+		if track == "trackshort" {
+			return value{}, nil
+		}
+		return text(brackets("gone", bracket)), nil
+	}
+	ahead, behind, err := aheadBehind(item.r, item.Sha, upSha)
+	if err != nil {
+		return value{}, err
+	}
+	if track == "trackshort" {
+		switch {
+		case ahead > 0 && behind > 0:
+			return text("<>"), nil
+		case ahead > 0:
+			return text(">"), nil
+		case behind > 0:
+			return text("<"), nil
+		}
+		return text("="), nil
+	}
+	switch {
+	case ahead > 0 && behind > 0:
+		return text(brackets(fmt.Sprintf("ahead %d, behind %d", ahead, behind), bracket)), nil
+	case ahead > 0:
+		return text(brackets(fmt.Sprintf("ahead %d", ahead), bracket)), nil
+	case behind > 0:
+		return text(brackets(fmt.Sprintf("behind %d", behind), bracket)), nil
+	}
+	return value{}, nil
+}
+
+func brackets(s string, bracket bool) string {
+	if bracket {
+		return "[" + s + "]"
+	}
+	return s
+}
@@ -0,0 +1,270 @@
+package reffilter
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/tag"
+)
+
+// Options select the references List returns
+// Patterns match a reference by a leading run of its path components or as a glob where
+// "*" stays within one component. Commits are ids: a reference is kept when its commit
+// contains any of Contains and none of NoContains, is reachable from any of Merged and from
+// none of NoMerged, and points at one of PointsAt, directly or through a tag.
+type Options struct {
+	Patterns   []string
+	Contains   []string
+	NoContains []string
+	Merged     []string
+	NoMerged   []string
+	PointsAt   []string
+}
+
+// Ref is a reference being filtered, sorted and formatted
+// Objects and values are read as the format asks for them and kept for later atoms.
+type Ref struct {
+	refs.Ref
+	r      *repo.Gitrepo
+	head   string
+	values map[atom]value
+	// objects are read on first use
+	objects map[string]*objectInfo
+}
+
+// List returns the references under refs/ that pass the filters of opts, sorted by name
+func List(r *repo.Gitrepo, opts Options) ([]*Ref, error) {
+	all, err := refs.ListRefs(r, "refs/")
+	if err != nil {
+		return nil, err
+	}
+	head, _, _ := refs.Head(r)
+	merged, err := reachable(r, opts.Merged)
+	if err != nil {
+		return nil, err
+	}
+	notMerged, err := reachable(r, opts.NoMerged)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Ref
+	for _, ref := range all {
+		if !matches(ref.Name, opts.Patterns) {
+			continue
+		}
+		item := &Ref{Ref: ref, r: r, head: head, values: map[atom]value{}, objects: map[string]*objectInfo{}}
+		if len(opts.PointsAt) > 0 && !item.pointsAt(opts.PointsAt) {
+			continue
+		}
+		filtering := len(opts.Contains) > 0 || len(opts.NoContains) > 0 || len(opts.Merged) > 0 || len(opts.NoMerged) > 0
+		if !filtering {
+			list = append(list, item)
+			continue
+		}
+		// only references to commits, directly or through tags, take part in history filters
+		commit, err := object.Peel(r, ref.Sha, "commit")
+		if err != nil {
+			continue
+		}
+		if len(opts.Merged) > 0 && !merged[commit] || len(opts.NoMerged) > 0 && notMerged[commit] {
+			continue
+		}
+		if len(opts.Contains) > 0 {
+			ok, err := containsAny(r, commit, opts.Contains)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if len(opts.NoContains) > 0 {
+			ok, err := containsAny(r, commit, opts.NoContains)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// matches reports whether a reference name matches any of patterns, or there are none
+// A pattern without glob characters matches the names below it, like refs/heads does
+// refs/heads/main.
+func matches(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if name == p || strings.HasPrefix(name, p) && (strings.HasSuffix(p, "/") || name[len(p)] == '/') {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pointsAt reports whether the reference, or the object of the tag it points at, is one of shas
+func (item *Ref) pointsAt(shas []string) bool {
+	target := ""
+	if info, err := item.object(item.Sha); err == nil && info.tag != nil {
+		target = info.tag.Object()
+	}
+	for _, sha := range shas {
+		if sha == item.Sha || sha == target {
+			return true
+		}
+	}
+	return false
+}
+
+// reachable returns the commits reachable from any of heads
+func reachable(r *repo.Gitrepo, heads []string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	queue := append([]string(nil), heads...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents()...)
+	}
+	return seen, nil
+}
+
+// containsAny reports whether any of commits is an ancestor of sha, or sha itself
+func containsAny(r *repo.Gitrepo, sha string, commits []string) (bool, error) {
+	for _, c := range commits {
+		ok, err := object.IsAncestor(r, c, sha)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// aheadBehind counts the commits reachable from sha but not from upstream, and the reverse
+func aheadBehind(r *repo.Gitrepo, sha, upstream string) (int, int, error) {
+	ours, err := reachable(r, []string{sha})
+	if err != nil {
+		return 0, 0, err
+	}
+	theirs, err := reachable(r, []string{upstream})
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, behind := 0, 0
+	for c := range ours {
+		if !theirs[c] {
+			ahead++
+		}
+	}
+	for c := range theirs {
+		if !ours[c] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// SortKey is one --sort key: an atom, compared as a version or reversed as asked
+type SortKey struct {
+	atom    atom
+	version bool
+	reverse bool
+}
+
+// ParseSort parses --sort keys such as "-committerdate" or "version:refname"
+// The last key is the primary one, as with git.
+func ParseSort(keys []string) ([]SortKey, error) {
+	var parsed []SortKey
+	for i := len(keys) - 1; i >= 0; i-- {
+		k := SortKey{}
+		spec := keys[i]
+		if rest, ok := strings.CutPrefix(spec, "-"); ok {
+			k.reverse, spec = true, rest
+		}
+		for _, prefix := range []string{"version:", "v:"} {
+			if rest, ok := strings.CutPrefix(spec, prefix); ok {
+				k.version, spec = true, rest
+			}
+		}
+		a, err := parseAtom(spec)
+		if err != nil {
+			return nil, err
+		}
+		if a.block() {
+			return nil, unknownField(spec)
+		}
+		k.atom = a
+		parsed = append(parsed, k)
+	}
+	return parsed, nil
+}
+
+// Sort orders list by keys; references equal on every key stay ordered by name
+func Sort(list []*Ref, keys []SortKey) error {
+	var failed error
+	sort.SliceStable(list, func(i, j int) bool {
+		for _, k := range keys {
+			a, errA := list[i].value(k.atom)
+			b, errB := list[j].value(k.atom)
+			if errA != nil || errB != nil {
+				if failed == nil {
+					failed = errA
+					if failed == nil {
+						failed = errB
+					}
+				}
+				return false
+			}
+			c := compare(a, b, k)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return list[i].Name < list[j].Name
+	})
+	return failed
+}
+
+// compare orders two values of a sort key
+func compare(a, b value, k SortKey) int {
+	c := 0
+	switch {
+	case k.version:
+		if tag.VersionLess(a.s, b.s) {
+			c = -1
+		} else if tag.VersionLess(b.s, a.s) {
+			c = 1
+		}
+	case a.numeric:
+		if a.n < b.n {
+			c = -1
+		} else if a.n > b.n {
+			c = 1
+		}
+	default:
+		c = strings.Compare(a.s, b.s)
+	}
+	if k.reverse {
+		return -c
+	}
+	return c
+}
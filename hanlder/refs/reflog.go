@@ -0,0 +1,295 @@
+package refs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// ReflogEntry is one line of a reflog
+// Who is "Name <email>", When the time of the update
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Who     string
+	When    time.Time
+	Message string
+}
+
+// reflogPath returns the path of the reflog of name
+func reflogPath(r *repo.Gitrepo, name string) string {
+	return repo.RepoPath(r, "logs", filepath.FromSlash(name))
+}
+
+// shouldLog reports whether updates to name are recorded, following core.logAllRefUpdates
+func shouldLog(r *repo.Gitrepo, name string) bool {
+	if exists, _ := repo.PathExist(reflogPath(r, name)); exists {
+		return true
+	}
+	setting, ok := repo.ConfigGet(r, "core", "logallrefupdates")
+	if !ok {
+		// git enables it by default in repositories with a worktree
+		setting = strconv.FormatBool(!r.Bare)
+	}
+	switch strings.ToLower(setting) {
+	case "always":
+		return true
+	case "true", "yes", "on", "1":
+		return name == "HEAD" || name == "refs/stash" ||
+			strings.HasPrefix(name, "refs/heads/") ||
+			strings.HasPrefix(name, "refs/remotes/") ||
+			strings.HasPrefix(name, "refs/notes/")
+	}
+	return name == "refs/stash"
+}
+
+// reflogIdent returns the committer identity used in reflog lines
+func reflogIdent(r *repo.Gitrepo) string {
+	ident, err := repo.Ident(r, "committer")
+	if err != nil {
+		now := time.Now()
+		return fmt.Sprintf("unknown <unknown> %d %s", now.Unix(), now.Format("-0700"))
+	}
+	return ident
+}
+
+// AppendReflog records that name moved from oldSha to newSha
+// Empty ids are written as the zero id, as for created or deleted refs
+func AppendReflog(r *repo.Gitrepo, name, oldSha, newSha, msg string) error {
+	if !shouldLog(r, name) {
+		return nil
+	}
+	zero := repo.Format(r).ZeroID()
+	if oldSha == "" {
+		oldSha = zero
+	}
+	if newSha == "" {
+		newSha = zero
+	}
+	path := reflogPath(r, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	msg = strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", " ")
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", oldSha, newSha, reflogIdent(r), msg)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadReflog returns the reflog of name, oldest entry first
+func ReadReflog(r *repo.Gitrepo, name string) ([]ReflogEntry, error) {
+	f, err := os.Open(reflogPath(r, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		head, msg, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid reflog line in %s: %q", name, line)
+		}
+		who, when, err := repo.ParseIdent(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ReflogEntry{Old: fields[0], New: fields[1], Who: who, When: when, Message: msg})
+	}
+	return entries, scanner.Err()
+}
+
+// WriteReflog replaces the reflog of name with entries
+func WriteReflog(r *repo.Gitrepo, name string, entries []ReflogEntry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %s %s %d %s\t%s\n", e.Old, e.New, e.Who, e.When.Unix(), e.When.Format("-0700"), e.Message)
+	}
+	return writeFileLocked(reflogPath(r, name), []byte(b.String()))
+}
+
+// DeleteReflog removes the reflog of name
+func DeleteReflog(r *repo.Gitrepo, name string) error {
+	path := reflogPath(r, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	stop := repo.RepoPath(r, "logs")
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, stop+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ListReflogs returns the names of the refs that have a reflog
+func ListReflogs(r *repo.Gitrepo) ([]string, error) {
+	root := repo.RepoPath(r, "logs")
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// ReflogAt returns the id name pointed at n updates ago: name@{n}
+func ReflogAt(r *repo.Gitrepo, name string, n int) (string, error) {
+	entries, err := ReadReflog(r, name)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("invalid reflog index %d", n)
+	}
+	if n < len(entries) {
+		return entries[len(entries)-1-n].New, nil
+	}
+	if n == len(entries) && n > 0 && entries[0].Old != repo.Format(r).ZeroID() {
+		return entries[0].Old, nil
+	}
+	return "", fmt.Errorf("log for '%s' only has %d entries", name, len(entries))
+}
+
+// ReflogAtTime returns the id name pointed at, at the time when: name@{date}
+func ReflogAtTime(r *repo.Gitrepo, name string, when time.Time) (string, error) {
+	entries, err := ReadReflog(r, name)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("log for '%s' is empty", name)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].When.After(when) {
+			return entries[i].New, nil
+		}
+	}
+	// older than the whole log: the value before the first update
+	if old := entries[0].Old; old != repo.Format(r).ZeroID() {
+		return old, nil
+	}
+	return entries[0].New, nil
+}
+
+// approxUnits are the units accepted in dates like "2.weeks.ago"
+var approxUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// ParseApproxDate parses the dates git accepts in @{...} and --since:
+// "now", "yesterday", "<n> <unit>s ago" (with dots or spaces) and absolute dates
+func ParseApproxDate(s string, now time.Time) (time.Time, error) {
+	norm := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(s, ".", " ")))
+	switch norm {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.Add(-24 * time.Hour), nil
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	}
+	fields := strings.Fields(norm)
+	if len(fields) == 3 && fields[2] == "ago" || len(fields) == 2 {
+		n, err := strconv.Atoi(fields[0])
+		if err == nil {
+			unit := strings.TrimSuffix(fields[1], "s")
+			if d, ok := approxUnits[unit]; ok {
+				return now.Add(-time.Duration(n) * d), nil
+			}
+		}
+	}
+	return repo.ParseDate(s)
+}
+
+// ExpireReflog drops the entries of name older than before and returns how many went
+func ExpireReflog(r *repo.Gitrepo, name string, before time.Time, dryRun bool) (int, error) {
+	entries, err := ReadReflog(r, name)
+	if err != nil {
+		return 0, err
+	}
+	var kept []ReflogEntry
+	for _, e := range entries {
+		if e.When.Before(before) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(entries) - len(kept)
+	if dryRun || removed == 0 {
+		return removed, nil
+	}
+	return removed, WriteReflog(r, name, kept)
+}
+
+// DeleteReflogEntry removes name@{n} from the reflog
+// rewrite makes the next entry start where the removed one did,
+// updateRef moves the ref itself when its newest entry is removed
+func DeleteReflogEntry(r *repo.Gitrepo, name string, n int, rewrite, updateRef bool) error {
+	entries, err := ReadReflog(r, name)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(entries) {
+		return fmt.Errorf("reflog entry %s@{%d} does not exist", name, n)
+	}
+	i := len(entries) - 1 - n
+	removed := entries[i]
+	entries = append(entries[:i], entries[i+1:]...)
+	if rewrite && i < len(entries) {
+		entries[i].Old = removed.Old
+	}
+	if err := WriteReflog(r, name, entries); err != nil {
+		return err
+	}
+	if updateRef && i == len(entries) && len(entries) > 0 {
+		target, err := SymbolicTarget(r, name)
+		if err != nil {
+			return err
+		}
+		return writeFileLocked(repo.RepoPath(r, filepath.FromSlash(target)), []byte(entries[len(entries)-1].New+"\n"))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package refs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+var (
+	shaA = strings.Repeat("a", 40)
+	shaB = strings.Repeat("b", 40)
+	shaC = strings.Repeat("c", 40)
+)
+
+// writeReflog writes the reflog of name as lines, like git would
+func writeReflog(t *testing.T, r *repo.Gitrepo, name string, lines ...string) {
+	t.Helper()
+	path := reflogPath(r, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newRepo(t *testing.T) *repo.Gitrepo {
+	t.Helper()
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	r, err := repo.RepoCreate(t.TempDir(), false, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReadReflog(t *testing.T) {
+	r := newRepo(t)
+	zero := repo.Format(r).ZeroID()
+	lines := []string{
+		zero + " " + shaA + " C O Mitter <committer@example.com> 1700000000 +0000\tbranch: Created from HEAD",
+		shaA + " " + shaB + " C O Mitter <committer@example.com> 1700003600 +0530\tcommit: second\twith a tab",
+		shaB + " " + shaC + " Someone Else <else@example.com> 1700007200 -0800\treset: moving to HEAD~1",
+	}
+	writeReflog(t, r, "refs/heads/main", lines...)
+
+	entries, err := ReadReflog(r, "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("read %d entries, want 3", len(entries))
+	}
+	second := entries[1]
+	if second.Old != shaA || second.New != shaB || second.Who != "C O Mitter <committer@example.com>" ||
+		second.When.Unix() != 1700003600 || second.When.Format("-0700") != "+0530" || second.Message != "commit: second\twith a tab" {
+		t.Errorf("second entry is %+v", second)
+	}
+
+	// writing the entries back gives the same file
+	if err := WriteReflog(r, "refs/heads/main", entries); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(reflogPath(r, "refs/heads/main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(lines, "\n") + "\n"; string(data) != want {
+		t.Errorf("rewritten reflog is\n%s\nwant\n%s", data, want)
+	}
+
+	for n, want := range []string{shaC, shaB, shaA} {
+		if got, err := ReflogAt(r, "refs/heads/main", n); err != nil || got != want {
+			t.Errorf("main@{%d} is %s, %v; want %s", n, got, err, want)
+		}
+	}
+	if _, err := ReflogAt(r, "refs/heads/main", 3); err == nil {
+		t.Errorf("main@{3} resolved past the creation of the branch")
+	}
+
+	writeReflog(t, r, "refs/heads/bad", shaA+" "+shaB+"\tno identity")
+	if _, err := ReadReflog(r, "refs/heads/bad"); err == nil {
+		t.Errorf("a line without an identity was read")
+	}
+}
+
+func TestAppendReflog(t *testing.T) {
+	r := newRepo(t)
+	if err := AppendReflog(r, "refs/heads/main", "", shaA, "commit (initial): one\ntwo\n"); err != nil {
+		t.Fatal(err)
+	}
+	// tags are not logged unless core.logAllRefUpdates is always
+	if err := AppendReflog(r, "refs/tags/v1", "", shaA, "tag"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadReflog(r, "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Old != repo.Format(r).ZeroID() || entries[0].Message != "commit (initial): one two" {
+		t.Errorf("main's reflog is %+v", entries)
+	}
+	if names, err := ListReflogs(r); err != nil || strings.Join(names, " ") != "refs/heads/main" {
+		t.Errorf("reflogs are %q, %v", names, err)
+	}
+}
+
+func TestExpireReflog(t *testing.T) {
+	r := newRepo(t)
+	line := func(old, new string, unix int, msg string) string {
+		return fmt.Sprintf("%s %s C O Mitter <committer@example.com> %d +0000\t%s", old, new, unix, msg)
+	}
+	lines := []string{
+		line(shaA, shaB, 1000, "old"),
+		line(shaB, shaC, 2000, "middle"),
+		line(shaC, shaA, 3000, "new"),
+	}
+	writeReflog(t, r, "HEAD", lines...)
+
+	removed, err := ExpireReflog(r, "HEAD", time.Unix(2500, 0), true)
+	if err != nil || removed != 2 {
+		t.Errorf("a dry run would expire %d entries, %v; want 2", removed, err)
+	}
+	if entries, _ := ReadReflog(r, "HEAD"); len(entries) != 3 {
+		t.Errorf("a dry run left %d entries", len(entries))
+	}
+
+	// entries exactly at the cut-off are kept
+	removed, err = ExpireReflog(r, "HEAD", time.Unix(2000, 0), false)
+	if err != nil || removed != 1 {
+		t.Errorf("expired %d entries, %v; want 1", removed, err)
+	}
+	entries, err := ReadReflog(r, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Message != "middle" || entries[1].Message != "new" {
+		t.Errorf("entries left are %+v", entries)
+	}
+}
+
+func TestParseApproxDate(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"now":         now,
+		"yesterday":   now.Add(-24 * time.Hour),
+		"2.weeks.ago": now.Add(-14 * 24 * time.Hour),
+		"3 days ago":  now.Add(-3 * 24 * time.Hour),
+		"1.hour":      now.Add(-time.Hour),
+	} {
+		if got, err := ParseApproxDate(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("ParseApproxDate(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package refs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Ref is a reference name and the object id it points at
+// Peeled is the id of the object an annotated tag points at, if known
+type Ref struct {
+	Name   string
+	Sha    string
+	Peeled string
+}
+
+// ErrNotFound is returned when a reference does not exist
+var ErrNotFound = errors.New("reference not found")
+
+// maxSymrefDepth limits how many symbolic refs are followed
+const maxSymrefDepth = 5
+
+// ReadRef reads the reference name without following it
+// It returns the target and whether the reference is symbolic
+func ReadRef(r *repo.Gitrepo, name string) (string, bool, error) {
+	data, err := os.ReadFile(repo.RepoPath(r, filepath.FromSlash(name)))
+	if err == nil {
+		line := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(line, "ref:"); ok {
+			return strings.TrimSpace(target), true, nil
+		}
+		if !repo.Format(r).IsHexID(line) {
+			return "", false, fmt.Errorf("invalid reference %s: %q", name, line)
+		}
+		return line, false, nil
+	}
+	if !os.IsNotExist(err) && !isDirErr(r, name) {
+		return "", false, err
+	}
+	packed, err := readPackedRefs(r)
+	if err != nil {
+		return "", false, err
+	}
+	for _, ref := range packed {
+		if ref.Name == name {
+			return ref.Sha, false, nil
+		}
+	}
+	return "", false, ErrNotFound
+}
+
+// isDirErr reports whether the loose path of name is a directory, as for "refs/heads"
+func isDirErr(r *repo.Gitrepo, name string) bool {
+	_, isDir := repo.PathExist(repo.RepoPath(r, filepath.FromSlash(name)))
+	return isDir
+}
+
+// ResolveRef follows symbolic references and returns the object id name points at
+func ResolveRef(r *repo.Gitrepo, name string) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		target, symbolic, err := ReadRef(r, name)
+		if err != nil {
+			return "", err
+		}
+		if !symbolic {
+			return target, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs: %s", name)
+}
+
+// SymbolicTarget returns the final reference name a symbolic ref chain ends at
+// For a reference that is not symbolic it returns name itself
+func SymbolicTarget(r *repo.Gitrepo, name string) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		target, symbolic, err := ReadRef(r, name)
+		if err == ErrNotFound {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !symbolic {
+			return name, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs: %s", name)
+}
+
+// Head returns the branch HEAD points at and the commit it resolves to
+// The branch is empty when HEAD is detached, the commit is empty on an unborn branch
+func Head(r *repo.Gitrepo) (string, string, error) {
+	target, symbolic, err := ReadRef(r, "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if !symbolic {
+		return "", target, nil
+	}
+	sha, err := ResolveRef(r, target)
+	if err == ErrNotFound {
+		return target, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return target, sha, nil
+}
+
+// writeFileLocked writes data to path through a lock file, like git does
+func writeFileLocked(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("unable to lock %s: lock file exists", path)
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, path)
+}
+
+// UpdateRef points the reference name at sha, following HEAD if it is symbolic
+// The update is recorded in the reflog of the reference, and of HEAD when it points there
+func UpdateRef(r *repo.Gitrepo, name, sha, msg string) error {
+	if !repo.Format(r).IsHexID(sha) {
+		return fmt.Errorf("invalid object id: %s", sha)
+	}
+	target, err := SymbolicTarget(r, name)
+	if err != nil {
+		return err
+	}
+	old, _ := ResolveRef(r, target)
+	if err := writeFileLocked(repo.RepoPath(r, filepath.FromSlash(target)), []byte(sha+"\n")); err != nil {
+		return err
+	}
+	if err := AppendReflog(r, target, old, sha, msg); err != nil {
+		return err
+	}
+	if target != "HEAD" {
+		if head, err := SymbolicTarget(r, "HEAD"); err == nil && head == target {
+			return AppendReflog(r, "HEAD", old, sha, msg)
+		}
+	}
+	return nil
+}
+
+// WriteSymbolicRef makes name a symbolic reference to target
+func WriteSymbolicRef(r *repo.Gitrepo, name, target, msg string) error {
+	old, _ := ResolveRef(r, name)
+	if err := writeFileLocked(repo.RepoPath(r, filepath.FromSlash(name)), []byte("ref: "+target+"\n")); err != nil {
+		return err
+	}
+	if msg == "" {
+		return nil
+	}
+	sha, err := ResolveRef(r, target)
+	if err != nil {
+		// an unborn branch has nothing to log yet
+		return nil
+	}
+	return AppendReflog(r, name, old, sha, msg)
+}
+
+// DetachHead points HEAD directly at sha
+func DetachHead(r *repo.Gitrepo, sha, msg string) error {
+	if !repo.Format(r).IsHexID(sha) {
+		return fmt.Errorf("invalid object id: %s", sha)
+	}
+	old, _ := ResolveRef(r, "HEAD")
+	if err := writeFileLocked(repo.RepoPath(r, "HEAD"), []byte(sha+"\n")); err != nil {
+		return err
+	}
+	return AppendReflog(r, "HEAD", old, sha, msg)
+}
+
+// DeleteRef removes the loose and packed copies of the reference name
+func DeleteRef(r *repo.Gitrepo, name string) error {
+	found := false
+	path := repo.RepoPath(r, filepath.FromSlash(name))
+	if err := os.Remove(path); err == nil {
+		found = true
+		removeEmptyDirs(r, filepath.Dir(path))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	packed, err := readPackedRefs(r)
+	if err != nil {
+		return err
+	}
+	kept := packed[:0]
+	for _, ref := range packed {
+		if ref.Name == name {
+			found = true
+			continue
+		}
+		kept = append(kept, ref)
+	}
+	if len(kept) != len(packed) {
+		if err := writePackedRefs(r, kept); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return DeleteReflog(r, name)
+}
+
+// removeEmptyDirs removes empty directories below refs/ starting at dir
+func removeEmptyDirs(r *repo.Gitrepo, dir string) {
+	stop := repo.RepoPath(r, "refs")
+	for strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// RenameRef moves the reference oldName and its reflog to newName
+func RenameRef(r *repo.Gitrepo, oldName, newName, msg string) error {
+	sha, err := ResolveRef(r, oldName)
+	if err != nil {
+		return err
+	}
+	logs, err := ReadReflog(r, oldName)
+	if err != nil {
+		return err
+	}
+	if err := DeleteRef(r, oldName); err != nil {
+		return err
+	}
+	if len(logs) > 0 {
+		if err := WriteReflog(r, newName, logs); err != nil {
+			return err
+		}
+	}
+	if err := writeFileLocked(repo.RepoPath(r, filepath.FromSlash(newName)), []byte(sha+"\n")); err != nil {
+		return err
+	}
+	return AppendReflog(r, newName, sha, sha, msg)
+}
+
+// ListRefs returns the loose and packed references under prefix, sorted by name
+// Loose references take precedence over packed ones
+func ListRefs(r *repo.Gitrepo, prefix string) ([]Ref, error) {
+	all := map[string]Ref{}
+	packed, err := readPackedRefs(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packed {
+		if strings.HasPrefix(ref.Name, prefix) {
+			all[ref.Name] = ref
+		}
+	}
+	root := repo.RepoPath(r, "refs")
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		// names are relative to the directory holding refs/, which in a linked worktree
+		// is the common directory rather than the worktree's own
+		rel, err := filepath.Rel(filepath.Dir(root), path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		sha, err := ResolveRef(r, name)
+		if err != nil {
+			// dangling symbolic refs are skipped, like git does
+			return nil
+		}
+		all[name] = Ref{Name: name, Sha: sha}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Ref, 0, len(all))
+	for _, ref := range all {
+		list = append(list, ref)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// readPackedRefs parses the packed-refs file
+func readPackedRefs(r *repo.Gitrepo) ([]Ref, error) {
+	f, err := os.Open(repo.RepoPath(r, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var list []Ref
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if peeled, ok := strings.CutPrefix(line, "^"); ok {
+			if len(list) > 0 {
+				list[len(list)-1].Peeled = peeled
+			}
+			continue
+		}
+		sha, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid packed-refs line: %q", line)
+		}
+		list = append(list, Ref{Name: name, Sha: sha})
+	}
+	return list, scanner.Err()
+}
+
+// writePackedRefs rewrites the packed-refs file with list
+func writePackedRefs(r *repo.Gitrepo, list []Ref) error {
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	var b strings.Builder
+	b.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	for _, ref := range list {
+		fmt.Fprintf(&b, "%s %s\n", ref.Sha, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&b, "^%s\n", ref.Peeled)
+		}
+	}
+	return writeFileLocked(repo.RepoPath(r, "packed-refs"), []byte(b.String()))
+}
+
+// Exists reports whether the reference name exists
+func Exists(r *repo.Gitrepo, name string) bool {
+	_, _, err := ReadRef(r, name)
+	return err == nil
+}
+
+// dwimRules are the places a short reference name is looked up, in order
+var dwimRules = []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"}
+
+// Expand returns the full name of the reference a short name refers to
+func Expand(r *repo.Gitrepo, name string) (string, error) {
+	for _, rule := range dwimRules {
+		full := fmt.Sprintf(rule, name)
+		if full == name && !strings.HasPrefix(name, "refs/") && strings.ToUpper(name) != name {
+			// only all-caps names like HEAD or ORIG_HEAD live at the top level
+			continue
+		}
+		if Exists(r, full) {
+			return full, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Shorten returns the shortest unambiguous form of a full reference name
+func Shorten(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
+
+// CheckRefName validates name against git's reference naming rules
+func CheckRefName(name string) error {
+	bad := func(reason string) error {
+		return fmt.Errorf("'%s' is not a valid ref name: %s", name, reason)
+	}
+	if name == "" || name == "@" {
+		return bad("empty or '@'")
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return bad("bad slashes")
+	}
+	if strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return bad("contains '..', '@{' or ends with '.'")
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return bad(fmt.Sprintf("contains %q", c))
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return bad("component starts with '.' or ends with '.lock'")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// CloneOptions control Clone
+// Origin names the remote, origin by default; Branch is checked out instead of the
+// branch HEAD of the source points at. NoCheckout leaves the worktree empty. UploadPack
+// and Version are used to reach a file:// or ext:: source, as for a Remote.
+type CloneOptions struct {
+	Bare       bool
+	Origin     string
+	Branch     string
+	NoCheckout bool
+	UploadPack string
+	Version    int
+	Progress   Progress
+}
+
+// DefaultDir returns the directory a clone of url goes to when none is given
+func DefaultDir(url string, bare bool) string {
+	name := strings.TrimRight(url, "/")
+	name = strings.TrimSuffix(name, "/.git")
+	name = filepath.Base(name)
+	name = strings.TrimSuffix(name, ".git")
+	if bare {
+		name += ".git"
+	}
+	return name
+}
+
+// Clone copies the repository at url into a new one at dir
+// The branches of the source become remote-tracking branches of the origin remote, one of
+// which is checked out; a bare clone takes them as its own branches. Tags are copied as they
+// are. Nothing is left behind when it fails.
+func Clone(url, dir string, opts CloneOptions) (r *repo.Gitrepo, err error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("destination path '%s' already exists and is not an empty directory.", dir)
+	}
+	t, err := Open(nil, &Remote{URL: url, UploadPack: opts.UploadPack, Version: opts.Version}, UploadPack, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	existed, _ := repo.PathExist(dir)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if existed {
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				os.RemoveAll(filepath.Join(dir, e.Name()))
+			}
+		} else {
+			os.RemoveAll(dir)
+		}
+	}()
+	r, err = repo.RepoCreate(dir, opts.Bare, t.Format())
+	if err != nil {
+		return nil, err
+	}
+	return r, populate(r, t, absURL(url), opts)
+}
+
+// populate fills a new repository with the references and objects of the source
+func populate(r *repo.Gitrepo, t Transport, url string, opts CloneOptions) error {
+	origin := opts.Origin
+	if origin == "" {
+		origin = "origin"
+	}
+	fetch := DefaultFetch(origin)
+	if opts.Bare {
+		fetch = Refspec{Force: true, Src: "refs/heads/*", Dst: "refs/heads/*"}
+		repo.ConfigSet(r, section(origin), "url", url)
+		if err := repo.ConfigWrite(r); err != nil {
+			return err
+		}
+	} else if err := Add(r, origin, url, &fetch); err != nil {
+		return err
+	}
+	list, err := t.Refs()
+	if err != nil {
+		return err
+	}
+	tags := Refspec{Src: "refs/tags/*", Dst: "refs/tags/*"}
+	var wants []string
+	stored := map[string]string{}
+	var names []string
+	for _, ref := range list {
+		for _, rs := range []Refspec{fetch, tags} {
+			if dst, ok := rs.Map(ref.Name); ok {
+				wants = append(wants, ref.Sha)
+				stored[dst] = ref.Sha
+				names = append(names, dst)
+			}
+		}
+	}
+	if len(wants) > 0 {
+		if err := t.Fetch(r, wants, nil); err != nil {
+			return err
+		}
+	}
+	msg := "clone: from " + url
+	for _, name := range names {
+		if err := refs.UpdateRef(r, name, stored[name], msg); err != nil {
+			return err
+		}
+	}
+
+	head := t.Head()
+	if opts.Branch != "" {
+		head = "refs/heads/" + opts.Branch
+		if remoteSha(list, head) == "" {
+			return fmt.Errorf("Remote branch %s not found in upstream %s", opts.Branch, origin)
+		}
+	}
+	sha := remoteSha(list, head)
+	if head == "" {
+		// the source has a detached HEAD, or nothing at all
+		if len(list) > 0 && list[0].Name == "HEAD" {
+			sha = list[0].Sha
+			if err := refs.DetachHead(r, sha, msg); err != nil {
+				return err
+			}
+		}
+	} else if !opts.Bare {
+		if tracking, ok := fetch.Map(t.Head()); ok && sha != "" {
+			if err := refs.WriteSymbolicRef(r, "refs/remotes/"+origin+"/HEAD", tracking, msg); err != nil {
+				return err
+			}
+		}
+		if err := refs.WriteSymbolicRef(r, "HEAD", head, ""); err != nil {
+			return err
+		}
+		if sha != "" {
+			if err := refs.UpdateRef(r, head, sha, msg); err != nil {
+				return err
+			}
+		}
+		branch := fmt.Sprintf("branch \"%s\"", strings.TrimPrefix(head, "refs/heads/"))
+		repo.ConfigSet(r, branch, "remote", origin)
+		repo.ConfigSet(r, branch, "merge", head)
+		if err := repo.ConfigWrite(r); err != nil {
+			return err
+		}
+	} else if err := refs.WriteSymbolicRef(r, "HEAD", head, msg); err != nil {
+		return err
+	}
+	if opts.Bare || opts.NoCheckout || sha == "" {
+		return nil
+	}
+	commit, err := object.ReadCommit(r, sha)
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(r, "", commit.TreeSha(), true)
+}
@@ -0,0 +1,426 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// FetchOptions control Fetch
+// Force allows updates that are not fast-forwards, Tags fetches every tag of the remote,
+// and Message starts the reflog entries of the updated references.
+type FetchOptions struct {
+	Force    bool
+	Tags     bool
+	Message  string
+	Progress Progress
+}
+
+// Merge status of a fetched reference in FETCH_HEAD
+const (
+	forMerge = iota
+	notForMerge
+	ignored
+)
+
+// fetched is a remote reference being fetched, into Dst unless it is empty
+type fetched struct {
+	refs.Ref
+	dst   string
+	force bool
+	merge int
+}
+
+// FetchResult describes what Fetch did
+// Batches hold the updates of the branches and of the tags that followed them.
+type FetchResult struct {
+	URL     string
+	Batches [][]*Update
+}
+
+// localName returns the full name a short reference name is fetched into
+func localName(name string) string {
+	switch {
+	case strings.HasPrefix(name, "refs/"):
+		return name
+	case strings.HasPrefix(name, "heads/"), strings.HasPrefix(name, "tags/"), strings.HasPrefix(name, "remotes/"):
+		return "refs/" + name
+	}
+	return "refs/heads/" + name
+}
+
+// findRemote returns the remote reference a short name refers to, as refs.Expand does locally
+func findRemote(list []refs.Ref, name string) (refs.Ref, bool) {
+	for _, rule := range []string{"%s", "refs/%s", "refs/tags/%s", "refs/heads/%s", "refs/remotes/%s", "refs/remotes/%s/HEAD"} {
+		full := fmt.Sprintf(rule, name)
+		for _, ref := range list {
+			if ref.Name == full {
+				return ref, true
+			}
+		}
+	}
+	return refs.Ref{}, false
+}
+
+// mapRefs lists the remote references a refspec fetches
+func mapRefs(list []refs.Ref, rs Refspec, merge int) ([]*fetched, error) {
+	if !rs.Pattern() {
+		ref, ok := findRemote(list, rs.Src)
+		if !ok {
+			return nil, fmt.Errorf("couldn't find remote ref %s", rs.Src)
+		}
+		dst := ""
+		if rs.Dst != "" {
+			dst = localName(rs.Dst)
+			if err := refs.CheckRefName(dst); err != nil {
+				return nil, err
+			}
+		}
+		return []*fetched{{Ref: ref, dst: dst, force: rs.Force, merge: merge}}, nil
+	}
+	var found []*fetched
+	for _, ref := range list {
+		if dst, ok := rs.Map(ref.Name); ok && ref.Name != "HEAD" {
+			found = append(found, &fetched{Ref: ref, dst: dst, force: rs.Force, merge: merge})
+		}
+	}
+	return found, nil
+}
+
+// fetchMap lists what a fetch of specs from rem takes, in the order FETCH_HEAD lists it
+// Without specs the configured refspecs of the remote are used, and the upstream of the
+// current branch is the one to merge.
+func fetchMap(r *repo.Gitrepo, rem *Remote, list []refs.Ref, specs []Refspec, tags bool) ([]*fetched, bool, error) {
+	var entries []*fetched
+	follow := false
+	add := func(rs Refspec, merge int) error {
+		found, err := mapRefs(list, rs, merge)
+		entries = append(entries, found...)
+		follow = follow || rs.Dst != ""
+		return err
+	}
+	switch {
+	case len(specs) > 0:
+		for _, rs := range specs {
+			if err := add(rs, forMerge); err != nil {
+				return nil, false, err
+			}
+		}
+		// the remote-tracking references of what was named are updated too
+		for _, e := range slices.Clone(entries) {
+			for _, rs := range rem.Fetch {
+				if dst, ok := rs.Map(e.Name); ok && dst != "" {
+					entries = append(entries, &fetched{Ref: e.Ref, dst: dst, force: rs.Force, merge: ignored})
+					break
+				}
+			}
+		}
+	case len(rem.Fetch) > 0:
+		mergeRef := ""
+		if head, _, err := refs.Head(r); err == nil {
+			branch := fmt.Sprintf("branch \"%s\"", strings.TrimPrefix(head, "refs/heads/"))
+			if name, _ := repo.ConfigGet(r, branch, "remote"); name == rem.Name && rem.Name != "" {
+				mergeRef, _ = repo.ConfigGet(r, branch, "merge")
+			}
+		}
+		for i, rs := range rem.Fetch {
+			if err := add(rs, notForMerge); err != nil {
+				return nil, false, err
+			}
+			if i == 0 && mergeRef == "" && !rs.Pattern() && len(entries) > 0 {
+				entries[0].merge = forMerge
+			}
+		}
+		if mergeRef != "" {
+			merged := false
+			for _, e := range entries {
+				if e.Name == mergeRef {
+					e.merge, merged = forMerge, true
+				}
+			}
+			if !merged {
+				if err := add(Refspec{Src: mergeRef}, forMerge); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+	default:
+		if err := add(Refspec{Src: "HEAD"}, forMerge); err != nil {
+			return nil, false, err
+		}
+	}
+	if tags {
+		if err := add(Refspec{Src: "refs/tags/*", Dst: "refs/tags/*"}, notForMerge); err != nil {
+			return nil, false, err
+		}
+		follow = false
+	}
+	// a reference is only stored once, from the first refspec mapping to it
+	seen := map[string]bool{}
+	kept := entries[:0]
+	for _, e := range entries {
+		key := e.dst
+		if key == "" {
+			key = "\x00" + e.Name
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, e)
+	}
+	return kept, follow, nil
+}
+
+// followTags lists the tags of the remote not yet fetched that point at objects r has,
+// or at one of the tips being fetched
+func followTags(r *repo.Gitrepo, list []refs.Ref, entries []*fetched, tips map[string]bool) []*fetched {
+	taken := map[string]bool{}
+	for _, e := range entries {
+		taken[e.dst] = true
+	}
+	var found []*fetched
+	for _, ref := range list {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || taken[ref.Name] || refs.Exists(r, ref.Name) {
+			continue
+		}
+		target := ref.Sha
+		if ref.Peeled != "" {
+			target = ref.Peeled
+		}
+		if tips[target] || object.ObjectExists(r, target) {
+			found = append(found, &fetched{Ref: ref, dst: ref.Name, merge: notForMerge})
+		}
+	}
+	return found
+}
+
+// Fetch copies the references specs name from rem, and the objects they need, into r
+// It records them in FETCH_HEAD and updates the local references they map to.
+func Fetch(r *repo.Gitrepo, rem *Remote, specs []Refspec, opts FetchOptions) (*FetchResult, error) {
+	t, err := Open(r, rem, UploadPack, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	if t.Format().Name != repo.Format(r).Name {
+		return nil, fmt.Errorf("mismatched algorithms: client %s; server %s", repo.Format(r).Name, t.Format().Name)
+	}
+	list, err := t.Refs()
+	if err != nil {
+		return nil, err
+	}
+	entries, follow, err := fetchMap(r, rem, list, specs, opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if head, _, err := refs.Head(r); err == nil && !r.Bare {
+		for _, e := range entries {
+			if e.dst == head && refs.Exists(r, head) {
+				return nil, fmt.Errorf("refusing to fetch into branch '%s' checked out at '%s'", head, r.Worktree)
+			}
+		}
+	}
+	tips := map[string]bool{}
+	for _, e := range entries {
+		tips[e.Sha] = true
+	}
+	if follow {
+		entries = append(entries, followTags(r, list, entries, tips)...)
+	}
+	if err := fetchObjects(r, t, entries); err != nil {
+		return nil, err
+	}
+	batches := [][]*fetched{entries}
+	if follow {
+		// tags pointing into the history just fetched follow it
+		if more := followTags(r, list, entries, nil); len(more) > 0 {
+			if err := fetchObjects(r, t, more); err != nil {
+				return nil, err
+			}
+			batches = append(batches, more)
+		}
+	}
+
+	msg := opts.Message
+	if msg == "" {
+		msg = "fetch"
+	}
+	res := &FetchResult{URL: rem.URL}
+	var fetchHead strings.Builder
+	for _, batch := range batches {
+		var updates []*Update
+		for _, merge := range []int{forMerge, notForMerge, ignored} {
+			for _, e := range batch {
+				if e.merge != merge {
+					continue
+				}
+				if merge != ignored {
+					fetchHead.WriteString(fetchHeadLine(e, rem.URL))
+				}
+				u, err := storeFetched(r, e, opts.Force, msg)
+				if err != nil {
+					return nil, err
+				}
+				updates = append(updates, u)
+			}
+		}
+		res.Batches = append(res.Batches, updates)
+	}
+	if err := os.WriteFile(repo.RepoPath(r, "FETCH_HEAD"), []byte(fetchHead.String()), 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fetchObjects fetches what the entries point at and r does not have yet
+func fetchObjects(r *repo.Gitrepo, t Transport, entries []*fetched) error {
+	var wants []string
+	for _, e := range entries {
+		if !object.ObjectExists(r, e.Sha) {
+			wants = append(wants, e.Sha)
+		}
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+	var haves []string
+	if mine, err := refs.ListRefs(r, "refs/"); err == nil {
+		for _, ref := range mine {
+			haves = append(haves, ref.Sha)
+		}
+	}
+	if _, sha, err := refs.Head(r); err == nil && sha != "" {
+		haves = append(haves, sha)
+	}
+	return t.Fetch(r, wants, haves)
+}
+
+// storeFetched updates the local reference of a fetched one, if it has one
+func storeFetched(r *repo.Gitrepo, e *fetched, force bool, msg string) (*Update, error) {
+	u := &Update{Src: e.Name, Dst: e.dst, New: e.Sha, Force: e.force || force}
+	if e.dst == "" {
+		u.Status = Created
+		return u, nil
+	}
+	u.Old, _ = refs.ResolveRef(r, e.dst)
+	action := ""
+	switch {
+	case u.Old == u.New:
+		u.Status = UpToDate
+	case u.Old == "":
+		u.Status = Created
+		action = "storing ref"
+		if strings.HasPrefix(e.Name, "refs/tags/") {
+			action = "storing tag"
+		} else if strings.HasPrefix(e.Name, "refs/heads/") {
+			action = "storing head"
+		}
+	case strings.HasPrefix(e.dst, "refs/tags/") && u.Force:
+		u.Status, action = TagUpdate, "updating tag"
+	case strings.HasPrefix(e.dst, "refs/tags/"):
+		u.Status, u.Reason = Rejected, "would clobber existing tag"
+	case fastForward(r, u.Old, u.New):
+		u.Status, action = FastForward, "fast-forward"
+	case u.Force:
+		u.Status, u.Reason, action = Forced, "forced update", "forced-update"
+	default:
+		u.Status, u.Reason = Rejected, "non-fast-forward"
+	}
+	if action != "" {
+		if err := refs.UpdateRef(r, e.dst, e.Sha, msg+": "+action); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// describe returns the kind of a remote reference and the name FETCH_HEAD shows for it
+func describe(name string) (string, string) {
+	switch {
+	case name == "HEAD":
+		return "", ""
+	case strings.HasPrefix(name, "refs/heads/"):
+		return "branch", strings.TrimPrefix(name, "refs/heads/")
+	case strings.HasPrefix(name, "refs/tags/"):
+		return "tag", strings.TrimPrefix(name, "refs/tags/")
+	case strings.HasPrefix(name, "refs/remotes/"):
+		return "remote-tracking branch", strings.TrimPrefix(name, "refs/remotes/")
+	}
+	return "", name
+}
+
+// fetchHeadLine formats the FETCH_HEAD line of a fetched reference
+func fetchHeadLine(e *fetched, url string) string {
+	url = strings.TrimRight(url, "/")
+	url = strings.TrimSuffix(url, ".git")
+	marker := ""
+	if e.merge == notForMerge {
+		marker = "not-for-merge"
+	}
+	note := ""
+	switch kind, what := describe(e.Name); {
+	case what == "":
+	case kind == "":
+		note = "'" + what + "' of "
+	default:
+		note = kind + " '" + what + "' of "
+	}
+	return e.Sha + "\t" + marker + "\t" + note + url + "\n"
+}
+
+// Failed reports whether some reference could not be updated
+func (res *FetchResult) Failed() bool {
+	for _, batch := range res.Batches {
+		for _, u := range batch {
+			if u.Failed() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lines formats the fetch the way git reports it, leaving out references already up to date
+func (res *FetchResult) Lines() []string {
+	var out []string
+	for _, batch := range res.Batches {
+		width := 10
+		for _, u := range batch {
+			if u.Dst == "" || u.Src == "HEAD" || u.Status == UpToDate {
+				continue
+			}
+			from, to := prettify(u.Src), prettify(u.Dst)
+			if 21+len(from)+4+len(to) < 80 && len(from) > width {
+				width = len(from)
+			}
+		}
+		for _, u := range batch {
+			if u.Status == UpToDate {
+				continue
+			}
+			if len(out) == 0 {
+				out = append(out, "From "+res.URL)
+			}
+			if u.Dst == "" {
+				kind, what := describe(u.Src)
+				if kind == "" {
+					kind = "branch"
+				}
+				if what == "" {
+					what = "HEAD"
+				}
+				out = append(out, line('*', kind, what, "FETCH_HEAD", width, ""))
+				continue
+			}
+			flag, summary := u.flag()
+			out = append(out, line(flag, summary, prettify(u.Src), prettify(u.Dst), width, u.Reason))
+		}
+	}
+	return out
+}
@@ -0,0 +1,277 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// PushOptions control Push
+// Force allows updates that are not fast-forwards; SetUpstream makes each pushed branch
+// track the one it was pushed to.
+type PushOptions struct {
+	Force       bool
+	SetUpstream bool
+	Progress    Progress
+}
+
+// PushResult describes what Push did
+type PushResult struct {
+	URL      string
+	Updates  []*Update
+	Upstream []string
+}
+
+// DefaultPush returns what pushing to rem without refspecs sends: the refspecs configured
+// for the remote, or else the current branch to its upstream
+// A branch whose upstream is on another remote is pushed under its own name.
+func DefaultPush(r *repo.Gitrepo, rem *Remote) ([]Refspec, error) {
+	if len(rem.Push) > 0 {
+		return rem.Push, nil
+	}
+	head, _, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := strings.CutPrefix(head, "refs/heads/")
+	if !ok {
+		return nil, fmt.Errorf("You are not currently on a branch.")
+	}
+	branch := fmt.Sprintf("branch \"%s\"", name)
+	upstream, _ := repo.ConfigGet(r, branch, "remote")
+	if upstream != "" && upstream != rem.Name && upstream != rem.URL {
+		return []Refspec{{Src: head, Dst: head}}, nil
+	}
+	merge, ok := repo.ConfigGet(r, branch, "merge")
+	if !ok {
+		return nil, fmt.Errorf("The current branch %s has no upstream branch.", name)
+	}
+	return []Refspec{{Src: head, Dst: merge}}, nil
+}
+
+// remoteSha returns the id the remote reference name points at, or ""
+func remoteSha(list []refs.Ref, name string) string {
+	for _, ref := range list {
+		if ref.Name == name {
+			return ref.Sha
+		}
+	}
+	return ""
+}
+
+// pushMap lists the updates the refspecs ask of the remote
+func pushMap(r *repo.Gitrepo, list []refs.Ref, specs []Refspec, force bool) ([]*Update, error) {
+	var updates []*Update
+	for _, rs := range specs {
+		switch {
+		case rs.Src == "":
+			dst := rs.Dst
+			if ref, ok := findRemote(list, dst); ok && !strings.HasPrefix(dst, "refs/") {
+				dst = ref.Name
+			}
+			old := remoteSha(list, dst)
+			if old == "" {
+				return nil, fmt.Errorf("unable to delete '%s': remote ref does not exist", rs.Dst)
+			}
+			updates = append(updates, &Update{Dst: dst, Old: old, Force: true})
+		case rs.Pattern():
+			mine, err := refs.ListRefs(r, "refs/")
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range mine {
+				if dst, ok := rs.Map(ref.Name); ok {
+					updates = append(updates, &Update{Src: ref.Name, Dst: dst, New: ref.Sha, Force: rs.Force || force})
+				}
+			}
+		default:
+			u, err := pushOne(r, list, rs)
+			if err != nil {
+				return nil, err
+			}
+			u.Force = u.Force || force
+			updates = append(updates, u)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, u := range updates {
+		if seen[u.Dst] {
+			return nil, fmt.Errorf("dst ref %s receives from more than one src", u.Dst)
+		}
+		seen[u.Dst] = true
+		u.Old = remoteSha(list, u.Dst)
+	}
+	return updates, nil
+}
+
+// pushOne resolves a refspec naming a single local reference or object
+func pushOne(r *repo.Gitrepo, list []refs.Ref, rs Refspec) (*Update, error) {
+	name, err := refs.Expand(r, rs.Src)
+	var sha string
+	if err == nil {
+		sha, err = refs.ResolveRef(r, name)
+	} else if sha, err = object.ObjectFind(r, rs.Src, ""); err == nil {
+		name = rs.Src
+	}
+	if err != nil || sha == "" {
+		return nil, fmt.Errorf("src refspec %s does not match any", rs.Src)
+	}
+	// HEAD is shown as such but names the branch it points at
+	src := name
+	if name == "HEAD" {
+		src, _ = refs.SymbolicTarget(r, "HEAD")
+	}
+	dst := rs.Dst
+	switch {
+	case dst == "" && strings.HasPrefix(src, "refs/"):
+		dst = src
+	case strings.HasPrefix(dst, "refs/"):
+	case dst != "":
+		if ref, ok := findRemote(list, dst); ok {
+			dst = ref.Name
+		} else if strings.HasPrefix(src, "refs/heads/") {
+			dst = "refs/heads/" + dst
+		} else if strings.HasPrefix(src, "refs/tags/") {
+			dst = "refs/tags/" + dst
+		} else {
+			return nil, fmt.Errorf("The destination you provided is not a full refname (i.e., starting with \"refs/\").")
+		}
+	default:
+		return nil, fmt.Errorf("The destination you provided is not a full refname (i.e., starting with \"refs/\").")
+	}
+	if err := refs.CheckRefName(dst); err != nil {
+		return nil, err
+	}
+	return &Update{Src: name, Dst: dst, New: sha, Force: rs.Force}, nil
+}
+
+// check decides whether an update may be pushed before the remote sees it
+func check(r *repo.Gitrepo, u *Update) {
+	switch {
+	case u.New == "":
+		u.Status = Deleted
+	case u.Old == u.New:
+		u.Status = UpToDate
+	case u.Old == "":
+		u.Status = Created
+	case fastForward(r, u.Old, u.New) && !strings.HasPrefix(u.Dst, "refs/tags/"):
+		u.Status = FastForward
+	case u.Force:
+		u.Status, u.Reason = Forced, "forced update"
+	case strings.HasPrefix(u.Dst, "refs/tags/"):
+		u.Status, u.Reason = Rejected, "already exists"
+	case !object.ObjectExists(r, u.Old):
+		u.Status, u.Reason = Rejected, "fetch first"
+	default:
+		u.Status, u.Reason = Rejected, "non-fast-forward"
+		if _, err := object.Peel(r, u.Old, "commit"); err != nil {
+			u.Reason = "needs force"
+		}
+	}
+}
+
+// Push updates the references of rem the refspecs name, sending the objects they need
+// The remote-tracking references of the updated ones follow.
+func Push(r *repo.Gitrepo, rem *Remote, specs []Refspec, opts PushOptions) (*PushResult, error) {
+	t, err := Open(r, rem, ReceivePack, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	if t.Format().Name != repo.Format(r).Name {
+		return nil, fmt.Errorf("mismatched algorithms: client %s; server %s", repo.Format(r).Name, t.Format().Name)
+	}
+	list, err := t.Refs()
+	if err != nil {
+		return nil, err
+	}
+	updates, err := pushMap(r, list, specs, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+	var send []*Update
+	for _, u := range updates {
+		check(r, u)
+		if u.Status != UpToDate && u.Status != Rejected {
+			send = append(send, u)
+		}
+	}
+	if len(send) > 0 {
+		if err := t.Push(r, send); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &PushResult{URL: rem.URL, Updates: updates}
+	for _, u := range send {
+		if u.Failed() {
+			continue
+		}
+		if tracking, ok := rem.tracking(u.Dst); ok {
+			if u.New == "" {
+				refs.DeleteRef(r, tracking)
+			} else if err := refs.UpdateRef(r, tracking, u.New, "update by push"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.SetUpstream && rem.Name != "" {
+		for _, u := range updates {
+			name, ok := strings.CutPrefix(u.Src, "refs/heads/")
+			if !ok || u.Failed() || !strings.HasPrefix(u.Dst, "refs/heads/") {
+				continue
+			}
+			branch := fmt.Sprintf("branch \"%s\"", name)
+			repo.ConfigSet(r, branch, "remote", rem.Name)
+			repo.ConfigSet(r, branch, "merge", u.Dst)
+			res.Upstream = append(res.Upstream, fmt.Sprintf("branch '%s' set up to track '%s/%s'.", name, rem.Name, strings.TrimPrefix(u.Dst, "refs/heads/")))
+		}
+		if err := repo.ConfigWrite(r); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Failed reports whether some reference was not pushed
+func (res *PushResult) Failed() bool {
+	for _, u := range res.Updates {
+		if u.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Lines formats the push the way git reports it, leaving out references already up to date
+func (res *PushResult) Lines() []string {
+	var out []string
+	for _, u := range res.Updates {
+		if u.Status == UpToDate {
+			continue
+		}
+		if len(out) == 0 {
+			out = append(out, "To "+res.URL)
+		}
+		flag, summary := u.flag()
+		if u.Status == Created {
+			summary = newSummary(u.Dst)
+			if summary == "[new ref]" {
+				summary = "[new reference]"
+			}
+		}
+		s := fmt.Sprintf(" %c %-*s ", flag, summaryWidth, summary)
+		if u.Src != "" {
+			s += prettify(u.Src) + " -> "
+		}
+		s += prettify(u.Dst)
+		if u.Reason != "" {
+			s += " (" + u.Reason + ")"
+		}
+		out = append(out, s)
+	}
+	return out
+}
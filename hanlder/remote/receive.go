@@ -0,0 +1,180 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pack"
+	"github.com/Blue-Onion/pygo/hanlder/pktline"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// ServeReceivePack serves a push to r over in and out, speaking protocol version 0 or 1
+// Messages for the pusher go on a side-band when it takes one, and to errOut otherwise.
+func ServeReceivePack(r *repo.Gitrepo, in io.Reader, out, errOut io.Writer, version int) error {
+	format := repo.Format(r)
+	bin, bout := bufio.NewReader(in), bufio.NewWriter(out)
+	pr, w := pktline.NewReader(bin), pktline.NewWriter(bout)
+	list, err := refs.ListRefs(r, "refs/")
+	if err != nil {
+		return err
+	}
+	// pushing has no version 2 yet: the client gets version 0 instead
+	if version == 1 {
+		w.Printf("version 1\n")
+	}
+	advertise(w, format, list, []string{"report-status", "delete-refs", "side-band-64k", "quiet", "ofs-delta", "object-format=" + format.Name, "agent=" + agent})
+	if err := bout.Flush(); err != nil {
+		return err
+	}
+
+	updates, caps, err := readCommands(pr, format)
+	if err != nil || len(updates) == 0 {
+		return err
+	}
+	var mux *pktline.Mux
+	if caps["side-band-64k"] {
+		mux = pktline.NewMux(w, pktline.SideBand64kMax)
+	}
+	warn := func(format string, args ...any) {
+		msg := fmt.Sprintf("error: "+format+"\n", args...)
+		if mux != nil {
+			mux.Send(pktline.BandProgress, []byte(msg))
+		} else if errOut != nil {
+			io.WriteString(errOut, msg)
+		}
+	}
+
+	var unpackErr error
+	for _, u := range updates {
+		if u.New != "" {
+			// git sends thin packs, whose deltas may be against objects r has
+			_, unpackErr = pack.StoreThin(r, bin, func(sha string) (string, []byte, error) {
+				return object.ObjectReadRaw(r, sha)
+			})
+			break
+		}
+	}
+	if unpackErr != nil {
+		warn("%v", unpackErr)
+	}
+	var haves []string
+	for _, ref := range list {
+		haves = append(haves, ref.Sha)
+	}
+	current := ""
+	if !r.Bare {
+		current = (&local{r: r}).Head()
+	}
+	for _, u := range updates {
+		switch {
+		case unpackErr != nil:
+			u.Reason = "unpacker error"
+		case !strings.HasPrefix(u.Dst, "refs/") || refs.CheckRefName(u.Dst) != nil:
+			warn("refusing to create funny ref '%s' remotely", u.Dst)
+			u.Reason = "funny refname"
+		default:
+			u.Reason = refuse(r, u, current)
+		}
+		switch {
+		case u.Reason == "branch is currently checked out":
+			warn("refusing to update checked out branch: %s", u.Dst)
+		case u.Reason == "deletion of the current branch prohibited":
+			warn("refusing to delete the current branch: %s", u.Dst)
+		case u.Reason != "":
+		case u.New != "" && !connected(r, u.New, haves):
+			u.Reason = "missing necessary objects"
+		default:
+			if err := applyUpdate(r, u); err != nil {
+				warn("%v", err)
+				u.Reason = "failed to update ref"
+			}
+		}
+	}
+
+	if caps["report-status"] {
+		var report bytes.Buffer
+		rw := pktline.NewWriter(&report)
+		if unpackErr != nil {
+			rw.Printf("unpack %v\n", unpackErr)
+		} else {
+			rw.Printf("unpack ok\n")
+		}
+		for _, u := range updates {
+			if u.Reason != "" {
+				rw.Printf("ng %s %s\n", u.Dst, u.Reason)
+			} else {
+				rw.Printf("ok %s\n", u.Dst)
+			}
+		}
+		rw.Flush()
+		if mux != nil {
+			mux.Write(report.Bytes())
+		} else {
+			bout.Write(report.Bytes())
+		}
+	}
+	if mux != nil {
+		w.Flush()
+	}
+	return bout.Flush()
+}
+
+// readCommands reads the reference updates a pusher asks for, with the capabilities on
+// the first one
+func readCommands(pr *pktline.Reader, format *repo.ObjectFormat) ([]*Update, map[string]bool, error) {
+	var updates []*Update
+	caps := map[string]bool{}
+	zero := format.ZeroID()
+	for {
+		text, err := pr.ReadLine()
+		if err == io.EOF {
+			return updates, caps, nil
+		}
+		if err != nil {
+			if len(updates) == 0 {
+				// the pusher found nothing to do and hung up
+				return nil, caps, nil
+			}
+			return nil, nil, err
+		}
+		text, rest, hasCaps := strings.Cut(text, "\x00")
+		if hasCaps {
+			for _, c := range strings.Fields(rest) {
+				caps[c] = true
+			}
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 || !format.IsHexID(fields[0]) || !format.IsHexID(fields[1]) {
+			return nil, nil, fmt.Errorf("protocol error: expected old/new/ref, got '%s'", text)
+		}
+		u := &Update{Dst: fields[2], Old: fields[0], New: fields[1]}
+		if u.Old == zero {
+			u.Old = ""
+		}
+		if u.New == zero {
+			u.New = ""
+		}
+		updates = append(updates, u)
+	}
+}
+
+// connected reports whether everything reachable from sha is in r, given that what the
+// haves reach already is
+func connected(r *repo.Gitrepo, sha string, haves []string) bool {
+	shas, err := missing(r, []string{sha}, haves)
+	if err != nil {
+		return false
+	}
+	for _, s := range shas {
+		if !object.ObjectExists(r, s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec maps the references of one repository to those of another, such as
+// +refs/heads/*:refs/remotes/origin/*
+// Force allows updates that are not fast-forwards. Fetching with an empty Dst stores
+// nothing but FETCH_HEAD; pushing with an empty Src deletes Dst.
+type Refspec struct {
+	Force bool
+	Src   string
+	Dst   string
+}
+
+// ParseRefspec parses a refspec; both sides of a pattern hold exactly one "*"
+func ParseRefspec(s string) (Refspec, error) {
+	spec := s
+	rs := Refspec{}
+	if rest, ok := strings.CutPrefix(spec, "+"); ok {
+		rs.Force, spec = true, rest
+	}
+	if i := strings.LastIndexByte(spec, ':'); i >= 0 {
+		rs.Src, rs.Dst = spec[:i], spec[i+1:]
+	} else {
+		rs.Src = spec
+	}
+	srcStars, dstStars := strings.Count(rs.Src, "*"), strings.Count(rs.Dst, "*")
+	if srcStars > 1 || dstStars > 1 || rs.Dst != "" && srcStars != dstStars || rs.Src == "" && rs.Dst == "" && spec != ":" {
+		return Refspec{}, fmt.Errorf("invalid refspec '%s'", s)
+	}
+	return rs, nil
+}
+
+// Pattern reports whether the refspec maps many references with "*"
+func (rs Refspec) Pattern() bool {
+	return strings.Contains(rs.Src, "*")
+}
+
+// Map returns the name the reference name maps to, and whether the source side matches it
+func (rs Refspec) Map(name string) (string, bool) {
+	if !rs.Pattern() {
+		return rs.Dst, name == rs.Src
+	}
+	prefix, suffix, _ := strings.Cut(rs.Src, "*")
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	matched := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(rs.Dst, "*", matched, 1), true
+}
+
+// String formats the refspec the way it is written in the config
+func (rs Refspec) String() string {
+	s := rs.Src
+	if rs.Dst != "" {
+		s += ":" + rs.Dst
+	}
+	if rs.Force {
+		s = "+" + s
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Remote is a repository configured in a [remote "name"] section, or named by its URL
+// A remote named by URL has no Name and no refspecs. UploadPack and ReceivePack replace
+// the programs run for file:// URLs, and Version is the protocol version asked for.
+type Remote struct {
+	Name        string
+	URL         string
+	Fetch       []Refspec
+	Push        []Refspec
+	UploadPack  string
+	ReceivePack string
+	Version     int
+}
+
+// section returns the config section holding the settings of a remote
+func section(name string) string {
+	return fmt.Sprintf("remote \"%s\"", name)
+}
+
+// DefaultFetch returns the refspec a remote called name fetches its branches with
+func DefaultFetch(name string) Refspec {
+	return Refspec{Force: true, Src: "refs/heads/*", Dst: "refs/remotes/" + name + "/*"}
+}
+
+// Get returns the remote called name, or one for name taken as a path or URL
+func Get(r *repo.Gitrepo, name string) (*Remote, error) {
+	version := 2
+	if v, ok := repo.ConfigGet(r, "protocol", "version"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 2 {
+			return nil, fmt.Errorf("unknown value for config 'protocol.version': %s", v)
+		}
+		version = n
+	}
+	if url, ok := repo.ConfigGet(r, section(name), "url"); ok {
+		rem := &Remote{Name: name, URL: url, Version: version}
+		rem.UploadPack, _ = repo.ConfigGet(r, section(name), "uploadpack")
+		rem.ReceivePack, _ = repo.ConfigGet(r, section(name), "receivepack")
+		for key, list := range map[string]*[]Refspec{"fetch": &rem.Fetch, "push": &rem.Push} {
+			for _, v := range repo.ConfigGetAll(r, section(name), key) {
+				rs, err := ParseRefspec(v)
+				if err != nil {
+					return nil, err
+				}
+				*list = append(*list, rs)
+			}
+		}
+		return rem, nil
+	}
+	if _, err := os.Stat(name); err == nil || strings.ContainsAny(name, "/:") {
+		return &Remote{URL: name, Version: version}, nil
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a git repository", name)
+}
+
+// Add configures a remote called name at url, fetching with fetch unless it is nil
+func Add(r *repo.Gitrepo, name, url string, fetch *Refspec) error {
+	if _, ok := repo.ConfigGet(r, section(name), "url"); ok {
+		return fmt.Errorf("remote %s already exists.", name)
+	}
+	repo.ConfigSet(r, section(name), "url", url)
+	if fetch != nil {
+		repo.ConfigSet(r, section(name), "fetch", fetch.String())
+	}
+	return repo.ConfigWrite(r)
+}
+
+// DefaultName returns the remote of the current branch, or origin
+func DefaultName(r *repo.Gitrepo) string {
+	if head, _, err := refs.Head(r); err == nil {
+		branch := strings.TrimPrefix(head, "refs/heads/")
+		if name, ok := repo.ConfigGet(r, fmt.Sprintf("branch \"%s\"", branch), "remote"); ok && name != "." {
+			return name
+		}
+	}
+	return "origin"
+}
+
+// tracking returns the remote-tracking reference a remote reference is fetched into
+func (rem *Remote) tracking(name string) (string, bool) {
+	for _, rs := range rem.Fetch {
+		if dst, ok := rs.Map(name); ok && dst != "" {
+			return dst, true
+		}
+	}
+	return "", false
+}
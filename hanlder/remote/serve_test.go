@@ -0,0 +1,161 @@
+package remote
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// serveEnv makes the test binary run a server command instead of the tests, so that
+// git can be pointed at it with --upload-pack and --receive-pack
+const serveEnv = "PYGO_TEST_SERVE"
+
+func TestMain(m *testing.M) {
+	if service := os.Getenv(serveEnv); service != "" {
+		os.Exit(serve(service, os.Args[len(os.Args)-1]))
+	}
+	os.Exit(m.Run())
+}
+
+func serve(service, dir string) int {
+	r, err := repo.RepoOpen(dir)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 128
+	}
+	version := ProtocolVersion(os.Getenv("GIT_PROTOCOL"))
+	if service == "upload-pack" {
+		err = ServeUploadPack(r, os.Stdin, os.Stdout, version)
+	} else {
+		err = ServeReceivePack(r, os.Stdin, os.Stdout, os.Stderr, version)
+	}
+	if err != nil {
+		os.Stderr.WriteString("fatal: " + err.Error() + "\n")
+		return 128
+	}
+	return 0
+}
+
+// gitEnv sets up git for the tests, skipping them when it is not installed
+func gitEnv(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+}
+
+// git runs git in dir and returns its output
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// server returns the option telling git to run service through the test binary
+func server(t *testing.T, service string) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "--" + service + "=" + serveEnv + "=" + service + " '" + exe + "'"
+}
+
+// commit writes a file in the worktree dir and commits it
+func commit(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", name)
+	git(t, dir, "commit", "-q", "-m", "change "+name)
+}
+
+func TestReceivePackTwice(t *testing.T) {
+	gitEnv(t)
+	tmp := t.TempDir()
+	work, bare := filepath.Join(tmp, "work"), filepath.Join(tmp, "bare.git")
+	git(t, tmp, "init", "-q", "-b", "main", work)
+	git(t, tmp, "init", "-q", "--bare", bare)
+	content := strings.Repeat("a line that is long enough to be worth a delta\n", 50)
+	commit(t, work, "file", content)
+	commit(t, work, "other", "other\n")
+
+	push := server(t, "receive-pack")
+	git(t, work, "push", "-q", push, bare, "main")
+	// the second push is a thin pack, with deltas against what the first one sent
+	commit(t, work, "file", content+"one more line\n")
+	git(t, work, "push", "-q", push, bare, "main")
+
+	if got, want := git(t, bare, "rev-parse", "main"), git(t, work, "rev-parse", "main"); got != want {
+		t.Fatalf("main is %s after pushing %s", got, want)
+	}
+	git(t, bare, "fsck", "--strict")
+}
+
+func TestReceivePackRefusals(t *testing.T) {
+	gitEnv(t)
+	tmp := t.TempDir()
+	work, bare := filepath.Join(tmp, "work"), filepath.Join(tmp, "bare.git")
+	git(t, tmp, "init", "-q", "-b", "main", work)
+	git(t, tmp, "init", "-q", "--bare", bare)
+	commit(t, work, "file", "one\n")
+	commit(t, work, "file", "two\n")
+	push := server(t, "receive-pack")
+	git(t, work, "push", "-q", push, bare, "main", "main:side")
+	git(t, bare, "config", "receive.denyNonFastForwards", "true")
+	git(t, bare, "config", "receive.denyDeletes", "true")
+
+	cmd := exec.Command("git", "push", "--porcelain", push, bare, "+main~1:main", ":side")
+	cmd.Dir = work
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("push succeeded:\n%s", out)
+	}
+	for _, want := range []string{"!\tmain~1:refs/heads/main\t[remote rejected] (non-fast-forward)", "!\t:refs/heads/side\t[remote rejected] (deletion prohibited)"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("push output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestUploadPack(t *testing.T) {
+	gitEnv(t)
+	tmp := t.TempDir()
+	work := filepath.Join(tmp, "work")
+	git(t, tmp, "init", "-q", "-b", "main", work)
+	commit(t, work, "file", "one\n")
+	git(t, work, "tag", "-a", "v1", "-m", "v1")
+	fetch := server(t, "upload-pack")
+	for _, version := range []string{"0", "1", "2"} {
+		clone := filepath.Join(tmp, "clone"+version)
+		git(t, tmp, "-c", "protocol.version="+version, "clone", "-q", fetch, "file://"+work, clone)
+		if got := git(t, clone, "tag"); got != "v1" {
+			t.Errorf("version %s: tags are %q", version, got)
+		}
+	}
+	commit(t, work, "file", "two\n")
+	for _, version := range []string{"0", "1", "2"} {
+		clone := filepath.Join(tmp, "clone"+version)
+		git(t, clone, "-c", "protocol.version="+version, "fetch", "-q", fetch, "origin")
+		if got, want := git(t, clone, "rev-parse", "origin/main"), git(t, work, "rev-parse", "main"); got != want {
+			t.Errorf("version %s: origin/main is %s, want %s", version, got, want)
+		}
+		git(t, clone, "fsck", "--strict")
+	}
+}
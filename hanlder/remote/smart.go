@@ -0,0 +1,697 @@
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pack"
+	"github.com/Blue-Onion/pygo/hanlder/pktline"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/revision"
+)
+
+// agent is how this client introduces itself to servers that ask
+const agent = "pygo/1.0"
+
+// haveBatch is how many commits a negotiation round offers, and maxInVain how many
+// may go without finding one in common before the client gives up and asks for the pack
+const (
+	haveBatch = 32
+	maxInVain = 256
+)
+
+// smart speaks git's pack protocol, version 0, 1 or 2, with upload-pack or receive-pack
+// running as a process
+type smart struct {
+	service  string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	out      *bufio.Writer
+	in       *bufio.Reader
+	w        *pktline.Writer
+	r        *pktline.Reader
+	progress Progress
+	version  int
+	caps     map[string]string
+	refs     []refs.Ref
+	head     string
+	format   *repo.ObjectFormat
+	done     bool
+}
+
+// serviceCommand returns the process serving the repository of rem for service
+// A file:// URL runs the service, or the program configured for it, on the path through
+// the shell. An ext:: URL is a command line whose words are split on spaces, where
+// "% " is a space, "%%" a percent sign, "%S" the service and "%s" the service without
+// its "git-" prefix.
+func serviceCommand(rem *Remote, service string) (*exec.Cmd, error) {
+	if line, ok := strings.CutPrefix(rem.URL, "ext::"); ok {
+		var args []string
+		var word strings.Builder
+		inWord := false
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == ' ':
+				if inWord {
+					args = append(args, word.String())
+					word.Reset()
+					inWord = false
+				}
+				continue
+			case c == '%' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case ' ', '%':
+					word.WriteByte(line[i])
+				case 'S':
+					word.WriteString(service)
+				case 's':
+					word.WriteString(strings.TrimPrefix(service, "git-"))
+				default:
+					return nil, fmt.Errorf("Bad remote-ext placeholder '%%%c'.", line[i])
+				}
+			default:
+				word.WriteByte(c)
+			}
+			inWord = true
+		}
+		if inWord {
+			args = append(args, word.String())
+		}
+		if len(args) == 0 {
+			return nil, errors.New("Bad remote-ext command line")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), "GIT_EXT_SERVICE="+service, "GIT_EXT_SERVICE_NOPREFIX="+strings.TrimPrefix(service, "git-"))
+		return cmd, nil
+	}
+	path, err := url.PathUnescape(strings.TrimPrefix(rem.URL, "file://"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s'", rem.URL)
+	}
+	program := service
+	if service == UploadPack && rem.UploadPack != "" {
+		program = rem.UploadPack
+	} else if service == ReceivePack && rem.ReceivePack != "" {
+		program = rem.ReceivePack
+	}
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	cmd := exec.Command("sh", "-c", program+" "+quoted)
+	cmd.Env = os.Environ()
+	return cmd, nil
+}
+
+// openSmart starts the service and reads what it advertises
+func openSmart(rem *Remote, service string, progress Progress) (*smart, error) {
+	cmd, err := serviceCommand(rem, service)
+	if err != nil {
+		return nil, err
+	}
+	version := rem.Version
+	if service == ReceivePack && version == 2 {
+		// pushing has no version 2 yet
+		version = 0
+	}
+	if version > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_PROTOCOL=version=%d", version))
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s := &smart{service: service, cmd: cmd, stdin: stdin, out: bufio.NewWriter(stdin), in: bufio.NewReader(stdout), progress: progress, caps: map[string]string{}}
+	s.w = pktline.NewWriter(s.out)
+	s.r = pktline.NewReader(s.in)
+	if err := s.advertisement(); err != nil {
+		s.done = true
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// packetText returns the text of a data packet, failing on an error the remote sends
+func packetText(data []byte) (string, error) {
+	text := strings.TrimSuffix(string(data), "\n")
+	if msg, ok := strings.CutPrefix(text, "ERR "); ok {
+		return "", fmt.Errorf("remote error: %s", msg)
+	}
+	return text, nil
+}
+
+// advertisement reads the version, capabilities and, before version 2, the references
+// the service starts with
+func (s *smart) advertisement() error {
+	kind, data, err := s.r.Read()
+	if err != nil {
+		return errors.New("Could not read from remote repository.")
+	}
+	if kind == pktline.Flush {
+		s.format = repo.SHA1
+		return nil
+	}
+	first, err := packetText(data)
+	if err != nil {
+		return err
+	}
+	switch first {
+	case "version 2":
+		s.version = 2
+		for {
+			text, err := s.r.ReadLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			name, value, _ := strings.Cut(text, "=")
+			s.caps[name] = value
+		}
+	case "version 1":
+		s.version = 1
+		if first, err = s.r.ReadLine(); err == io.EOF {
+			s.format = repo.SHA1
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		if err := s.refLines(first); err != nil {
+			return err
+		}
+	}
+	if s.format, err = repo.FormatByName(s.caps["object-format"]); err != nil {
+		return err
+	}
+	if s.version == 2 {
+		if s.service != UploadPack {
+			return fmt.Errorf("protocol version 2 is not supported for %s", s.service)
+		}
+		return s.lsRefs()
+	}
+	return nil
+}
+
+// refLines reads a version 0 reference advertisement starting with first
+// The first line carries the capabilities; annotated tags are followed by their peeled
+// value as "<name>^{}".
+func (s *smart) refLines(first string) error {
+	text := first
+	for {
+		entry, caps, hasCaps := strings.Cut(text, "\x00")
+		if hasCaps {
+			for _, c := range strings.Fields(caps) {
+				name, value, _ := strings.Cut(c, "=")
+				if name == "symref" {
+					if from, to, ok := strings.Cut(value, ":"); ok && from == "HEAD" {
+						s.head = to
+					}
+					continue
+				}
+				s.caps[name] = value
+			}
+		}
+		sha, name, ok := strings.Cut(entry, " ")
+		if !ok {
+			return fmt.Errorf("protocol error: unexpected '%s'", entry)
+		}
+		if base, ok := strings.CutSuffix(name, "^{}"); ok {
+			if n := len(s.refs); n > 0 && s.refs[n-1].Name == base {
+				s.refs[n-1].Peeled = sha
+			}
+		} else if name != "capabilities^{}" {
+			s.refs = append(s.refs, refs.Ref{Name: name, Sha: sha})
+		}
+		var err error
+		if text, err = s.r.ReadLine(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if text, err = packetText([]byte(text)); err != nil {
+			return err
+		}
+	}
+}
+
+// command sends a version 2 command with its arguments
+func (s *smart) command(name string, args []string) error {
+	s.w.Printf("command=%s\n", name)
+	if _, ok := s.caps["agent"]; ok {
+		s.w.Printf("agent=%s\n", agent)
+	}
+	if format, ok := s.caps["object-format"]; ok {
+		s.w.Printf("object-format=%s\n", format)
+	}
+	s.w.Delim()
+	for _, arg := range args {
+		s.w.Printf("%s\n", arg)
+	}
+	s.w.Flush()
+	return s.out.Flush()
+}
+
+// lsRefs asks a version 2 server for its references
+func (s *smart) lsRefs() error {
+	args := []string{"peel", "symrefs"}
+	if strings.Contains(" "+s.caps["ls-refs"]+" ", " unborn ") {
+		args = append(args, "unborn")
+	}
+	if err := s.command("ls-refs", args); err != nil {
+		return err
+	}
+	for {
+		text, err := s.r.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if text, err = packetText([]byte(text)); err != nil {
+			return err
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return fmt.Errorf("protocol error: unexpected '%s'", text)
+		}
+		ref := refs.Ref{Sha: fields[0], Name: fields[1]}
+		for _, attr := range fields[2:] {
+			if target, ok := strings.CutPrefix(attr, "symref-target:"); ok && ref.Name == "HEAD" {
+				s.head = target
+			} else if peeled, ok := strings.CutPrefix(attr, "peeled:"); ok {
+				ref.Peeled = peeled
+			}
+		}
+		if ref.Sha != "unborn" {
+			s.refs = append(s.refs, ref)
+		}
+	}
+}
+
+func (s *smart) Refs() ([]refs.Ref, error) {
+	return s.refs, nil
+}
+
+func (s *smart) Head() string {
+	if strings.HasPrefix(s.head, "refs/heads/") {
+		return s.head
+	}
+	return ""
+}
+
+func (s *smart) Format() *repo.ObjectFormat {
+	return s.format
+}
+
+// has reports whether the server advertised a capability
+func (s *smart) has(name string) bool {
+	_, ok := s.caps[name]
+	return ok
+}
+
+// haveList lists the commits of r reachable from the tips, newest first, to offer
+func haveList(r *repo.Gitrepo, tips []string) []string {
+	w := revision.New(r)
+	for _, sha := range tips {
+		if object.ObjectExists(r, sha) {
+			w.Add(sha, false)
+		}
+	}
+	commits, err := w.Commits()
+	if err != nil {
+		return nil
+	}
+	return commits
+}
+
+// nextHaves takes the next batch of commits to offer, and reports whether they are the last
+func nextHaves(pending *[]string, inVain int) ([]string, bool) {
+	n := min(haveBatch, len(*pending))
+	batch := (*pending)[:n]
+	*pending = (*pending)[n:]
+	return batch, len(*pending) == 0 || inVain+n >= maxInVain
+}
+
+func (s *smart) Fetch(r *repo.Gitrepo, wants, haves []string) error {
+	if s.service != UploadPack {
+		return fmt.Errorf("cannot fetch through %s", s.service)
+	}
+	s.done = true
+	if s.version == 2 {
+		return s.fetchV2(r, wants, haveList(r, haves))
+	}
+	return s.fetchV0(r, wants, haveList(r, haves))
+}
+
+// fetchV2 negotiates with version 2 fetch commands, each sending the wants again with the
+// commits found in common so far and the next ones to try
+func (s *smart) fetchV2(r *repo.Gitrepo, wants, pending []string) error {
+	var common []string
+	inVain := 0
+	for {
+		batch, last := nextHaves(&pending, inVain)
+		args := []string{"ofs-delta"}
+		if s.progress.Quiet || s.progress.Out == nil {
+			args = append(args, "no-progress")
+		}
+		for _, sha := range wants {
+			args = append(args, "want "+sha)
+		}
+		for _, sha := range append(common, batch...) {
+			args = append(args, "have "+sha)
+		}
+		if last {
+			args = append(args, "done")
+		}
+		if err := s.command("fetch", args); err != nil {
+			return err
+		}
+		section, err := s.r.ReadLine()
+		if err != nil {
+			return err
+		}
+		if section == "acknowledgments" {
+			ready, acked, err := s.acknowledgments(&common)
+			if err != nil {
+				return err
+			}
+			if acked {
+				inVain = 0
+			} else {
+				inVain += len(batch)
+			}
+			if !ready {
+				continue
+			}
+			if section, err = s.r.ReadLine(); err != nil {
+				return err
+			}
+		}
+		// sections this client did not ask for are skipped
+		for section == "shallow-info" || section == "wanted-refs" || section == "packfile-uris" {
+			for {
+				kind, _, err := s.r.Read()
+				if err != nil {
+					return err
+				}
+				if kind == pktline.Delim {
+					break
+				}
+			}
+			if section, err = s.r.ReadLine(); err != nil {
+				return err
+			}
+		}
+		if section != "packfile" {
+			return fmt.Errorf("protocol error: expected packfile, got '%s'", section)
+		}
+		return s.receive(r, true)
+	}
+}
+
+// acknowledgments reads the acknowledgments section of a version 2 fetch response
+// It adds the commits acknowledged to common and reports whether the server is ready
+// to send the pack, which then follows after a delimiter.
+func (s *smart) acknowledgments(common *[]string) (bool, bool, error) {
+	ready, acked := false, false
+	for {
+		kind, data, err := s.r.Read()
+		if err != nil {
+			return false, false, err
+		}
+		switch kind {
+		case pktline.Flush:
+			return false, acked, nil
+		case pktline.Delim:
+			return ready, acked, nil
+		}
+		text, err := packetText(data)
+		if err != nil {
+			return false, false, err
+		}
+		switch {
+		case text == "NAK":
+		case text == "ready":
+			ready = true
+		case strings.HasPrefix(text, "ACK "):
+			*common = append(*common, strings.TrimPrefix(text, "ACK "))
+			acked = true
+		default:
+			return false, false, fmt.Errorf("protocol error: unexpected acknowledgment '%s'", text)
+		}
+	}
+}
+
+// fetchV0 negotiates over the single conversation of versions 0 and 1, offering commits
+// in batches until the server is ready or the client gives up
+func (s *smart) fetchV0(r *repo.Gitrepo, wants, pending []string) error {
+	var caps []string
+	for _, c := range []string{"multi_ack_detailed", "side-band-64k", "ofs-delta"} {
+		if s.has(c) {
+			caps = append(caps, c)
+		}
+	}
+	sideband := s.has("side-band-64k")
+	if !sideband && s.has("side-band") {
+		caps, sideband = append(caps, "side-band"), true
+	}
+	if (s.progress.Quiet || s.progress.Out == nil) && s.has("no-progress") {
+		caps = append(caps, "no-progress")
+	}
+	if s.has("agent") {
+		caps = append(caps, "agent="+agent)
+	}
+	if format, ok := s.caps["object-format"]; ok {
+		caps = append(caps, "object-format="+format)
+	}
+	for i, sha := range wants {
+		if i == 0 {
+			s.w.Printf("want %s %s\n", sha, strings.Join(caps, " "))
+		} else {
+			s.w.Printf("want %s\n", sha)
+		}
+	}
+	s.w.Flush()
+	if !s.has("multi_ack_detailed") {
+		// without detailed acknowledgments the whole history is asked for
+		pending = nil
+	}
+	inVain := 0
+	for len(pending) > 0 {
+		batch, last := nextHaves(&pending, inVain)
+		for _, sha := range batch {
+			s.w.Printf("have %s\n", sha)
+		}
+		s.w.Flush()
+		if err := s.out.Flush(); err != nil {
+			return err
+		}
+		ready, acked := false, false
+		for {
+			text, err := s.r.ReadLine()
+			if err == nil {
+				text, err = packetText([]byte(text))
+			}
+			if err != nil {
+				return err
+			}
+			if text == "NAK" {
+				break
+			}
+			fields := strings.Fields(text)
+			if len(fields) < 2 || fields[0] != "ACK" {
+				return fmt.Errorf("protocol error: expected ACK/NAK, got '%s'", text)
+			}
+			acked = true
+			ready = ready || len(fields) == 3 && fields[2] == "ready"
+		}
+		if acked {
+			inVain = 0
+		} else {
+			inVain += len(batch)
+		}
+		if ready || last {
+			break
+		}
+	}
+	s.w.Printf("done\n")
+	if err := s.out.Flush(); err != nil {
+		return err
+	}
+	// the last word on what is in common: an ACK or a NAK
+	text, err := s.r.ReadLine()
+	if err == nil {
+		_, err = packetText([]byte(text))
+	}
+	if err != nil {
+		return err
+	}
+	return s.receive(r, sideband)
+}
+
+// receive stores the pack the server sends, multiplexed on side-band channels or not
+func (s *smart) receive(r *repo.Gitrepo, sideband bool) error {
+	if !sideband {
+		_, err := pack.Store(r, s.in)
+		return err
+	}
+	d := pktline.NewDemux(s.r, s.progress.Out)
+	if _, err := pack.Store(r, d); err != nil {
+		return err
+	}
+	return d.Drain()
+}
+
+func (s *smart) Push(r *repo.Gitrepo, updates []*Update) error {
+	if s.service != ReceivePack {
+		return fmt.Errorf("cannot push through %s", s.service)
+	}
+	s.done = true
+	var caps []string
+	for _, c := range []string{"report-status", "side-band-64k"} {
+		if s.has(c) {
+			caps = append(caps, c)
+		}
+	}
+	if (s.progress.Quiet || s.progress.Out == nil) && s.has("quiet") {
+		caps = append(caps, "quiet")
+	}
+	if s.has("agent") {
+		caps = append(caps, "agent="+agent)
+	}
+	if format, ok := s.caps["object-format"]; ok {
+		caps = append(caps, "object-format="+format)
+	}
+	zero := s.format.ZeroID()
+	orZero := func(sha string) string {
+		if sha == "" {
+			return zero
+		}
+		return sha
+	}
+	var sent []*Update
+	var wants []string
+	for _, u := range updates {
+		if u.New == "" && !s.has("delete-refs") {
+			u.Status, u.Reason = RemoteRejected, "remote does not support deleting refs"
+			continue
+		}
+		cmd := fmt.Sprintf("%s %s %s", orZero(u.Old), orZero(u.New), u.Dst)
+		if len(sent) == 0 {
+			cmd += "\x00" + strings.Join(caps, " ")
+		}
+		s.w.Printf("%s\n", cmd)
+		sent = append(sent, u)
+		if u.New != "" {
+			wants = append(wants, u.New)
+		}
+	}
+	s.w.Flush()
+	if len(wants) > 0 {
+		var haves []string
+		for _, ref := range s.refs {
+			haves = append(haves, ref.Sha)
+		}
+		shas, err := missing(r, wants, haves)
+		if err != nil {
+			return err
+		}
+		if _, _, err := pack.Write(s.out, repo.Format(r), shas, func(sha string) (string, []byte, error) {
+			return object.ObjectReadRaw(r, sha)
+		}); err != nil {
+			return err
+		}
+	}
+	if err := s.out.Flush(); err != nil {
+		return err
+	}
+	s.stdin.Close()
+	if len(sent) == 0 || !s.has("report-status") {
+		return nil
+	}
+	return s.report(sent)
+}
+
+// report reads the report-status of a push and marks the updates the remote refused
+func (s *smart) report(sent []*Update) error {
+	rd := s.r
+	var d *pktline.Demux
+	if s.has("side-band-64k") {
+		d = pktline.NewDemux(s.r, s.progress.Out)
+		rd = pktline.NewReader(d)
+	}
+	text, err := rd.ReadLine()
+	if err != nil {
+		return err
+	}
+	unpack, ok := strings.CutPrefix(text, "unpack ")
+	if !ok {
+		return fmt.Errorf("protocol error: expected unpack status, got '%s'", text)
+	}
+	byName := map[string]*Update{}
+	for _, u := range sent {
+		byName[u.Dst] = u
+	}
+	for {
+		text, err := rd.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		status, rest, _ := strings.Cut(text, " ")
+		name, reason, _ := strings.Cut(rest, " ")
+		u := byName[name]
+		if u == nil {
+			continue
+		}
+		delete(byName, name)
+		if status == "ng" {
+			u.Status, u.Reason = RemoteRejected, reason
+		}
+	}
+	for _, u := range byName {
+		if unpack != "ok" {
+			u.Status, u.Reason = RemoteRejected, "unpacker error"
+		} else {
+			u.Status, u.Reason = RemoteRejected, "no report from remote"
+		}
+	}
+	if d != nil {
+		return d.Drain()
+	}
+	return nil
+}
+
+// Close ends the conversation and waits for the service to exit
+func (s *smart) Close() error {
+	if !s.done {
+		// nothing was asked for
+		s.w.Flush()
+		s.out.Flush()
+		s.done = true
+	}
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
@@ -0,0 +1,286 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pack"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/revision"
+)
+
+// Transport talks to the repository a remote points at
+type Transport interface {
+	// Refs returns the references of the remote, with HEAD first when it points at a
+	// commit; Peeled is set for annotated tags
+	Refs() ([]refs.Ref, error)
+	// Head returns the branch HEAD of the remote points at, if it is a branch
+	Head() string
+	// Format returns the object format of the remote
+	Format() *repo.ObjectFormat
+	// Fetch copies into r the objects reachable from wants and not from the haves
+	Fetch(r *repo.Gitrepo, wants, haves []string) error
+	// Push sends the objects the updates need and applies them, marking those the
+	// remote refuses as RemoteRejected
+	Push(r *repo.Gitrepo, updates []*Update) error
+	Close() error
+}
+
+// Services a remote runs to be fetched from and pushed to
+const (
+	UploadPack  = "git-upload-pack"
+	ReceivePack = "git-receive-pack"
+)
+
+// Progress says where the messages a remote sends go; Quiet asks it to leave out its
+// progress reports
+type Progress struct {
+	Out   io.Writer
+	Quiet bool
+}
+
+// Open connects to the repository of rem for service, from r, which is nil for a clone
+// A path on this filesystem is read and written directly; file:// and ext:: URLs run
+// the service as a process and talk git's pack protocol to it.
+func Open(r *repo.Gitrepo, rem *Remote, service string, progress Progress) (Transport, error) {
+	url := rem.URL
+	scheme, _, ok := strings.Cut(url, "://")
+	if !ok && strings.HasPrefix(url, "ext::") {
+		scheme, ok = "ext", true
+	}
+	if !ok {
+		r, err := repo.RepoOpen(url)
+		if err != nil {
+			return nil, fmt.Errorf("repository '%s' does not exist", url)
+		}
+		return &local{r: r}, nil
+	}
+	if scheme != "file" && scheme != "ext" {
+		return nil, fmt.Errorf("unable to find remote helper for '%s'", scheme)
+	}
+	if !protocolAllowed(r, scheme) {
+		return nil, fmt.Errorf("transport '%s' not allowed", scheme)
+	}
+	return openSmart(rem, service, progress)
+}
+
+// protocolAllowed applies git's transport policy: GIT_ALLOW_PROTOCOL lists the only
+// allowed transports when set; otherwise protocol.<name>.allow, then protocol.allow,
+// say "always", "never" or "user", and without either file:// is always allowed and
+// ext::, which runs any command, never is
+func protocolAllowed(r *repo.Gitrepo, scheme string) bool {
+	if allowed, ok := os.LookupEnv("GIT_ALLOW_PROTOCOL"); ok {
+		return slices.Contains(strings.Split(allowed, ":"), scheme)
+	}
+	policy, ok := repo.ConfigLookup(r, fmt.Sprintf("protocol \"%s\"", scheme), "allow")
+	if !ok {
+		policy, ok = repo.ConfigLookup(r, "protocol", "allow")
+	}
+	if !ok {
+		switch scheme {
+		case "file", "git", "http", "https", "ssh":
+			policy = "always"
+		case "ext":
+			policy = "never"
+		default:
+			policy = "user"
+		}
+	}
+	switch policy {
+	case "always":
+		return true
+	case "user":
+		// commands run on behalf of another program, like submodule updates, set this to 0
+		return os.Getenv("GIT_PROTOCOL_FROM_USER") != "0"
+	}
+	return false
+}
+
+// local is a repository on this filesystem, read and written directly
+type local struct {
+	r *repo.Gitrepo
+}
+
+func (l *local) Refs() ([]refs.Ref, error) {
+	list, err := refs.ListRefs(l.r, "refs/")
+	if err != nil {
+		return nil, err
+	}
+	for i, ref := range list {
+		if peeled, err := object.Peel(l.r, ref.Sha, ""); err == nil && peeled != ref.Sha {
+			list[i].Peeled = peeled
+		}
+	}
+	if _, sha, err := refs.Head(l.r); err == nil && sha != "" {
+		list = append([]refs.Ref{{Name: "HEAD", Sha: sha}}, list...)
+	}
+	return list, nil
+}
+
+func (l *local) Head() string {
+	head, _, _ := refs.Head(l.r)
+	if strings.HasPrefix(head, "refs/heads/") {
+		return head
+	}
+	return ""
+}
+
+func (l *local) Format() *repo.ObjectFormat {
+	return repo.Format(l.r)
+}
+
+func (l *local) Fetch(r *repo.Gitrepo, wants, haves []string) error {
+	return sendPack(l.r, r, wants, haves)
+}
+
+func (l *local) Push(r *repo.Gitrepo, updates []*Update) error {
+	current := ""
+	if !l.r.Bare {
+		current = l.Head()
+	}
+	var wants, haves []string
+	var accepted []*Update
+	for _, u := range updates {
+		if reason := refuse(l.r, u, current); reason != "" {
+			u.Status, u.Reason = RemoteRejected, reason
+			continue
+		}
+		if u.New != "" {
+			wants = append(wants, u.New)
+		}
+		accepted = append(accepted, u)
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	theirs, err := refs.ListRefs(l.r, "refs/")
+	if err != nil {
+		return err
+	}
+	for _, ref := range theirs {
+		haves = append(haves, ref.Sha)
+	}
+	if len(wants) > 0 {
+		if err := sendPack(r, l.r, wants, haves); err != nil {
+			return err
+		}
+	}
+	for _, u := range accepted {
+		if err := applyUpdate(l.r, u); err != nil {
+			u.Status, u.Reason = RemoteRejected, err.Error()
+		}
+	}
+	return nil
+}
+
+func (l *local) Close() error {
+	return nil
+}
+
+// refuse returns why the repository r does not take an update, or ""
+// A non-bare repository refuses to move its checked out branch unless
+// receive.denyCurrentBranch says otherwise; receive.denyDeletes and
+// receive.denyNonFastForwards refuse deleting branches and rewinding references.
+func refuse(r *repo.Gitrepo, u *Update, current string) string {
+	if old, _ := refs.ResolveRef(r, u.Dst); old != u.Old {
+		// the reference moved since the pusher looked at it
+		return "failed to update ref"
+	}
+	if u.New == "" && strings.HasPrefix(u.Dst, "refs/heads/") && repo.ConfigBool(r, "receive", "denydeletes") {
+		return "deletion prohibited"
+	}
+	if u.Old != "" && u.New != "" && repo.ConfigBool(r, "receive", "denynonfastforwards") && !fastForward(r, u.Old, u.New) {
+		return "non-fast-forward"
+	}
+	if u.Dst != current {
+		return ""
+	}
+	switch deny, _ := repo.ConfigGet(r, "receive", "denycurrentbranch"); strings.ToLower(deny) {
+	case "ignore", "warn", "false", "no", "off":
+		return ""
+	}
+	if u.New == "" {
+		return "deletion of the current branch prohibited"
+	}
+	return "branch is currently checked out"
+}
+
+// applyUpdate moves or deletes a reference of r once its objects are there
+func applyUpdate(r *repo.Gitrepo, u *Update) error {
+	if u.New == "" {
+		return refs.DeleteRef(r, u.Dst)
+	}
+	if !object.ObjectExists(r, u.New) {
+		return fmt.Errorf("missing necessary objects")
+	}
+	return refs.UpdateRef(r, u.Dst, u.New, "push")
+}
+
+// missing lists the objects of src reachable from wants and not from the haves it has:
+// commits, then tags, trees and blobs
+func missing(src *repo.Gitrepo, wants, haves []string) ([]string, error) {
+	w := revision.New(src)
+	for _, sha := range wants {
+		if err := w.Add(sha, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, sha := range haves {
+		// only what both sides have can be left out
+		if !object.ObjectExists(src, sha) {
+			continue
+		}
+		if err := w.Add(sha, true); err != nil {
+			return nil, err
+		}
+	}
+	commits, err := w.Commits()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := w.Objects(commits)
+	if err != nil {
+		return nil, err
+	}
+	list := commits
+	for _, o := range objects {
+		list = append(list, o.Sha)
+	}
+	return list, nil
+}
+
+// sendPack copies the objects dst is missing from src as a pack
+func sendPack(src, dst *repo.Gitrepo, wants, haves []string) error {
+	shas, err := missing(src, wants, haves)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, _, err := pack.Write(pw, repo.Format(src), shas, func(sha string) (string, []byte, error) {
+			return object.ObjectReadRaw(src, sha)
+		})
+		pw.CloseWithError(err)
+	}()
+	_, err = pack.Store(dst, pr)
+	// stop the writer if storing failed half way
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+// absURL returns the absolute form of a local path, as clone records it
+func absURL(url string) string {
+	if strings.Contains(url, "://") || strings.HasPrefix(url, "ext::") {
+		return url
+	}
+	if abs, err := filepath.Abs(url); err == nil {
+		return abs
+	}
+	return url
+}
@@ -0,0 +1,64 @@
+package remote
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+func TestProtocolAllowed(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	for _, env := range []string{"GIT_ALLOW_PROTOCOL", "GIT_PROTOCOL_FROM_USER"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	r, err := repo.RepoCreate(t.TempDir(), true, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(scheme string, want bool) {
+		t.Helper()
+		if got := protocolAllowed(r, scheme); got != want {
+			t.Errorf("protocolAllowed(%s) = %v, want %v", scheme, got, want)
+		}
+	}
+	check("file", true)
+	check("ext", false)
+	if got := protocolAllowed(nil, "ext"); got {
+		t.Errorf("ext is allowed without a repository")
+	}
+
+	repo.ConfigSet(r, `protocol "ext"`, "allow", "always")
+	check("ext", true)
+	repo.ConfigSet(r, "protocol", "allow", "never")
+	check("ext", true)
+	check("file", false)
+	repo.ConfigSet(r, `protocol "file"`, "allow", "user")
+	check("file", true)
+	t.Setenv("GIT_PROTOCOL_FROM_USER", "0")
+	check("file", false)
+
+	t.Setenv("GIT_ALLOW_PROTOCOL", "file")
+	check("file", true)
+	check("ext", false)
+}
+
+func TestCloneEscapedFileURL(t *testing.T) {
+	gitEnv(t)
+	tmp := t.TempDir()
+	work := filepath.Join(tmp, "a b%")
+	git(t, tmp, "init", "-q", "-b", "main", work)
+	commit(t, work, "file", "one\n")
+	url := "file://" + strings.ReplaceAll(strings.ReplaceAll(work, "%", "%25"), " ", "%20")
+	r, err := Clone(url, filepath.Join(tmp, "clone"), CloneOptions{Bare: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := git(t, r.Gitdir, "rev-parse", "main"), git(t, work, "rev-parse", "main"); got != want {
+		t.Errorf("main is %s in the clone, want %s", got, want)
+	}
+}
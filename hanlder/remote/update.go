@@ -0,0 +1,109 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Status says what became of a reference update
+type Status int
+
+const (
+	UpToDate Status = iota
+	FastForward
+	Forced
+	Created
+	Deleted
+	TagUpdate
+	Rejected
+	RemoteRejected
+)
+
+// Update moves the reference Dst from Old to New, either empty when it does not exist
+// Src names where New comes from, the remote reference when fetching and the local one
+// when pushing. Reason explains a rejection.
+type Update struct {
+	Src    string
+	Dst    string
+	Old    string
+	New    string
+	Force  bool
+	Status Status
+	Reason string
+}
+
+// summaryWidth is the width of the summary column of fetch and push output
+const summaryWidth = 17
+
+// Failed reports whether the update was refused
+func (u *Update) Failed() bool {
+	return u.Status == Rejected || u.Status == RemoteRejected
+}
+
+// fastForward reports whether the commit old is an ancestor of new
+func fastForward(r *repo.Gitrepo, old, new string) bool {
+	oldCommit, err := object.Peel(r, old, "commit")
+	if err != nil {
+		return false
+	}
+	newCommit, err := object.Peel(r, new, "commit")
+	if err != nil {
+		return false
+	}
+	ok, err := object.IsAncestor(r, oldCommit, newCommit)
+	return err == nil && ok
+}
+
+// prettify drops the prefix git leaves out when showing a reference
+func prettify(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, ok := strings.CutPrefix(name, prefix); ok {
+			return short
+		}
+	}
+	return name
+}
+
+// newSummary describes a reference that did not exist before, by the kind of name
+func newSummary(name string) string {
+	switch {
+	case strings.HasPrefix(name, "refs/tags/"):
+		return "[new tag]"
+	case strings.HasPrefix(name, "refs/heads/"):
+		return "[new branch]"
+	}
+	return "[new ref]"
+}
+
+// flag returns the status character and summary shown for an update
+func (u *Update) flag() (byte, string) {
+	switch u.Status {
+	case FastForward:
+		return ' ', object.Abbrev(u.Old) + ".." + object.Abbrev(u.New)
+	case Forced:
+		return '+', object.Abbrev(u.Old) + "..." + object.Abbrev(u.New)
+	case Created:
+		return '*', newSummary(u.Src)
+	case Deleted:
+		return '-', "[deleted]"
+	case TagUpdate:
+		return 't', "[tag update]"
+	case Rejected:
+		return '!', "[rejected]"
+	case RemoteRejected:
+		return '!', "[remote rejected]"
+	}
+	return '=', "[up to date]"
+}
+
+// line formats a status line with the source column padded to width
+func line(flag byte, summary, from, to string, width int, reason string) string {
+	s := fmt.Sprintf(" %c %-*s %-*s -> %s", flag, summaryWidth, summary, width, from, to)
+	if reason != "" {
+		s += "  (" + reason + ")"
+	}
+	return s
+}
@@ -0,0 +1,504 @@
+package remote
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pack"
+	"github.com/Blue-Onion/pygo/hanlder/pktline"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// ProtocolVersion returns the protocol version a client asks for in GIT_PROTOCOL, a list
+// of key=value parameters separated by colons; 0 when it asks for none this server knows
+func ProtocolVersion(params string) int {
+	version := 0
+	for _, p := range strings.Split(params, ":") {
+		if v, ok := strings.CutPrefix(p, "version="); ok {
+			// the highest version asked for wins, like git does
+			if n, err := strconv.Atoi(v); err == nil && n <= 2 && n > version {
+				version = n
+			}
+		}
+	}
+	return version
+}
+
+// advertise writes a version 0 reference advertisement, the capabilities riding on its
+// first line; a repository without references puts them on a placeholder
+// Annotated tags are followed by their peeled value as "<name>^{}".
+func advertise(w *pktline.Writer, format *repo.ObjectFormat, list []refs.Ref, caps []string) {
+	if len(list) == 0 {
+		w.Printf("%s capabilities^{}\x00%s\n", format.ZeroID(), strings.Join(caps, " "))
+	}
+	for i, ref := range list {
+		if i == 0 {
+			w.Printf("%s %s\x00%s\n", ref.Sha, ref.Name, strings.Join(caps, " "))
+		} else {
+			w.Printf("%s %s\n", ref.Sha, ref.Name)
+		}
+		if ref.Peeled != "" {
+			w.Printf("%s %s^{}\n", ref.Peeled, ref.Name)
+		}
+	}
+	w.Flush()
+}
+
+// uploader serves a fetch or clone of r: what git's upload-pack does
+type uploader struct {
+	r         *repo.Gitrepo
+	in        *pktline.Reader
+	out       *bufio.Writer
+	w         *pktline.Writer
+	list      []refs.Ref
+	tips      map[string]bool
+	wants     []string
+	common    map[string]bool
+	haves     []string
+	satisfied map[string]bool
+	checked   int
+}
+
+// ServeUploadPack serves a fetch or clone of r over in and out, speaking protocol version
+// 0, 1 or 2
+func ServeUploadPack(r *repo.Gitrepo, in io.Reader, out io.Writer, version int) error {
+	list, err := (&local{r: r}).Refs()
+	if err != nil {
+		return err
+	}
+	u := &uploader{r: r, in: pktline.NewReader(bufio.NewReader(in)), out: bufio.NewWriter(out), list: list, tips: map[string]bool{}}
+	u.w = pktline.NewWriter(u.out)
+	for _, ref := range list {
+		u.tips[ref.Sha] = true
+	}
+	if version == 2 {
+		return u.serveV2()
+	}
+	if version == 1 {
+		u.w.Printf("version 1\n")
+	}
+	caps := []string{"multi_ack_detailed", "side-band", "side-band-64k", "ofs-delta", "no-progress", "include-tag"}
+	if head, sha, err := refs.Head(r); err == nil && head != "" && sha != "" {
+		caps = append(caps, "symref=HEAD:"+head)
+	}
+	caps = append(caps, "object-format="+repo.Format(r).Name, "agent="+agent)
+	advertise(u.w, repo.Format(r), list, caps)
+	if err := u.out.Flush(); err != nil {
+		return err
+	}
+	return u.serveV0()
+}
+
+// fail tells the client why the request cannot be served and returns the reason
+func (u *uploader) fail(format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	u.w.Printf("ERR upload-pack: %s\n", err)
+	u.out.Flush()
+	return err
+}
+
+// want records a commit the client asks for, which must be one a reference points at
+func (u *uploader) want(sha string) error {
+	if !u.tips[sha] {
+		return u.fail("not our ref %s", sha)
+	}
+	for _, w := range u.wants {
+		if w == sha {
+			return nil
+		}
+	}
+	u.wants = append(u.wants, sha)
+	return nil
+}
+
+// reset forgets what a previous negotiation found
+func (u *uploader) reset() {
+	u.wants, u.haves, u.checked = nil, nil, 0
+	u.common, u.satisfied = map[string]bool{}, map[string]bool{}
+}
+
+// have records an object the client has, and reports whether this side has it too
+func (u *uploader) have(sha string) bool {
+	if !object.ObjectExists(u.r, sha) {
+		return false
+	}
+	if !u.common[sha] {
+		u.common[sha] = true
+		u.haves = append(u.haves, sha)
+	}
+	return true
+}
+
+// ready reports whether every commit wanted descends from one in common, so that the
+// pack can leave out what is below those and the negotiation can stop
+func (u *uploader) ready() bool {
+	for _, want := range u.wants {
+		if _, err := object.Peel(u.r, want, "commit"); err != nil {
+			// only commits have a history to negotiate
+			u.satisfied[want] = true
+		}
+	}
+	for _, have := range u.haves[u.checked:] {
+		for _, want := range u.wants {
+			if u.satisfied[want] {
+				continue
+			}
+			commit, _ := object.Peel(u.r, want, "commit")
+			if ok, err := object.IsAncestor(u.r, have, commit); err == nil && ok {
+				u.satisfied[want] = true
+			}
+		}
+	}
+	u.checked = len(u.haves)
+	return len(u.haves) > 0 && len(u.satisfied) == len(u.wants)
+}
+
+// serveV0 answers the single request of versions 0 and 1: the wants with the client's
+// capabilities, rounds of haves, and the pack
+func (u *uploader) serveV0() error {
+	u.reset()
+	caps := map[string]bool{}
+	for {
+		kind, data, err := u.in.Read()
+		if err == io.EOF && len(u.wants) == 0 {
+			// the client only wanted the references
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if kind == pktline.Flush {
+			break
+		}
+		text := strings.TrimSuffix(string(data), "\n")
+		rest, ok := strings.CutPrefix(text, "want ")
+		if !ok {
+			return u.fail("protocol error, expected to get object ID, not '%s'", text)
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return u.fail("protocol error, expected to get object ID, not '%s'", text)
+		}
+		for _, c := range fields[1:] {
+			caps[c] = true
+		}
+		if err := u.want(fields[0]); err != nil {
+			return err
+		}
+	}
+	if len(u.wants) == 0 {
+		return nil
+	}
+	if err := u.negotiate(caps["multi_ack_detailed"]); err != nil {
+		return err
+	}
+	size := 0
+	if caps["side-band-64k"] {
+		size = pktline.SideBand64kMax
+	} else if caps["side-band"] {
+		size = pktline.SideBandMax
+	}
+	return u.sendPack(size, !caps["no-progress"], caps["include-tag"])
+}
+
+// negotiate reads the rounds of haves up to the client's "done", acknowledging the
+// objects in common; with multiAck each of them is acknowledged, and the client told
+// when the server is ready to send the pack
+func (u *uploader) negotiate(multiAck bool) error {
+	gotCommon, gotOther := false, false
+	last := ""
+	for {
+		kind, data, err := u.in.Read()
+		if err != nil {
+			return err
+		}
+		if kind == pktline.Flush {
+			if multiAck && gotCommon && !gotOther && u.ready() {
+				u.w.Printf("ACK %s ready\n", last)
+			}
+			if len(u.haves) == 0 || multiAck {
+				u.w.Printf("NAK\n")
+			}
+			if err := u.out.Flush(); err != nil {
+				return err
+			}
+			gotCommon, gotOther = false, false
+			continue
+		}
+		text := strings.TrimSuffix(string(data), "\n")
+		if sha, ok := strings.CutPrefix(text, "have "); ok {
+			switch {
+			case !u.have(sha):
+				gotOther = true
+				if multiAck && u.ready() {
+					u.w.Printf("ACK %s ready\n", sha)
+				}
+			case multiAck:
+				gotCommon, last = true, sha
+				u.w.Printf("ACK %s common\n", sha)
+			default:
+				gotCommon, last = true, sha
+				if len(u.haves) == 1 {
+					u.w.Printf("ACK %s\n", sha)
+				}
+			}
+			continue
+		}
+		if text != "done" {
+			return u.fail("protocol error, expected to get 'have' or 'done', not '%s'", text)
+		}
+		if len(u.haves) == 0 {
+			u.w.Printf("NAK\n")
+		} else if multiAck {
+			u.w.Printf("ACK %s\n", last)
+		}
+		return nil
+	}
+}
+
+// serveV2 answers version 2 commands until the client hangs up
+func (u *uploader) serveV2() error {
+	format := repo.Format(u.r).Name
+	u.w.Printf("version 2\n")
+	u.w.Printf("agent=%s\n", agent)
+	u.w.Printf("ls-refs=unborn\n")
+	u.w.Printf("fetch\n")
+	u.w.Printf("object-format=%s\n", format)
+	u.w.Flush()
+	if err := u.out.Flush(); err != nil {
+		return err
+	}
+	for {
+		kind, data, err := u.in.Read()
+		if err == io.EOF || err == nil && kind == pktline.Flush {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if kind != pktline.Data {
+			return u.fail("protocol error: expected command")
+		}
+		name, ok := strings.CutPrefix(strings.TrimSuffix(string(data), "\n"), "command=")
+		if !ok {
+			return u.fail("protocol error: expected command, got '%s'", data)
+		}
+		args, err := u.commandArgs(format)
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "ls-refs":
+			err = u.lsRefs(args)
+		case "fetch":
+			err = u.fetch(args)
+		default:
+			err = u.fail("invalid command '%s'", name)
+		}
+		if err != nil {
+			return err
+		}
+		if err := u.out.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// commandArgs reads the capabilities and arguments of a version 2 command, returning
+// the arguments
+func (u *uploader) commandArgs(format string) ([]string, error) {
+	var args []string
+	inArgs := false
+	for {
+		kind, data, err := u.in.Read()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case pktline.Flush:
+			return args, nil
+		case pktline.Delim:
+			if inArgs {
+				return nil, u.fail("protocol error: unexpected delim packet")
+			}
+			inArgs = true
+			continue
+		case pktline.Data:
+		default:
+			return nil, u.fail("protocol error: unexpected special packet")
+		}
+		text := strings.TrimSuffix(string(data), "\n")
+		if inArgs {
+			args = append(args, text)
+		} else if value, ok := strings.CutPrefix(text, "object-format="); ok && value != format {
+			return nil, u.fail("mismatched object format: server %s; client %s", format, value)
+		}
+	}
+}
+
+// lsRefs answers the version 2 ls-refs command
+func (u *uploader) lsRefs(args []string) error {
+	peel, symrefs, unborn := false, false, false
+	var prefixes []string
+	for _, arg := range args {
+		switch {
+		case arg == "peel":
+			peel = true
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "unborn":
+			unborn = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		default:
+			return u.fail("unexpected line: '%s'", arg)
+		}
+	}
+	wanted := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+	if head, sha, err := refs.Head(u.r); err == nil && head != "" && sha == "" && unborn && wanted("HEAD") {
+		line := "unborn HEAD"
+		if symrefs {
+			line += " symref-target:" + head
+		}
+		u.w.Printf("%s\n", line)
+	}
+	for _, ref := range u.list {
+		if !wanted(ref.Name) {
+			continue
+		}
+		line := ref.Sha + " " + ref.Name
+		if symrefs {
+			if target, err := refs.SymbolicTarget(u.r, ref.Name); err == nil && target != ref.Name {
+				line += " symref-target:" + target
+			}
+		}
+		if peel && ref.Peeled != "" {
+			line += " peeled:" + ref.Peeled
+		}
+		u.w.Printf("%s\n", line)
+	}
+	return u.w.Flush()
+}
+
+// fetch answers the version 2 fetch command
+// Each one stands alone: the client sends again the commits found in common before.
+func (u *uploader) fetch(args []string) error {
+	u.reset()
+	done, progress, includeTag := false, true, false
+	var acked []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			if err := u.want(strings.TrimPrefix(arg, "want ")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "have "):
+			if sha := strings.TrimPrefix(arg, "have "); u.have(sha) {
+				acked = append(acked, sha)
+			}
+		case arg == "done":
+			done = true
+		case arg == "no-progress":
+			progress = false
+		case arg == "include-tag":
+			includeTag = true
+		case arg == "ofs-delta" || arg == "thin-pack":
+			// the pack holds whole objects, which every client can take
+		default:
+			return u.fail("unexpected line: '%s'", arg)
+		}
+	}
+	if len(u.wants) == 0 {
+		return u.fail("no wants given")
+	}
+	if !done {
+		u.w.Printf("acknowledgments\n")
+		if len(acked) == 0 {
+			u.w.Printf("NAK\n")
+		}
+		for _, sha := range acked {
+			u.w.Printf("ACK %s\n", sha)
+		}
+		if !u.ready() {
+			return u.w.Flush()
+		}
+		u.w.Printf("ready\n")
+		u.w.Delim()
+	}
+	u.w.Printf("packfile\n")
+	return u.sendPack(pktline.SideBand64kMax, progress, includeTag)
+}
+
+// withTags adds the annotated tags pointing at objects being sent
+func (u *uploader) withTags(shas []string) []string {
+	sending := map[string]bool{}
+	for _, sha := range shas {
+		sending[sha] = true
+	}
+	for _, ref := range u.list {
+		if strings.HasPrefix(ref.Name, "refs/tags/") && ref.Peeled != "" && sending[ref.Peeled] && !sending[ref.Sha] {
+			sending[ref.Sha] = true
+			shas = append(shas, ref.Sha)
+		}
+	}
+	return shas
+}
+
+// sendPack sends the objects reachable from the wants and not from the commits in
+// common, on side-band packets of at most size bytes, or bare when size is 0
+func (u *uploader) sendPack(size int, progress, includeTag bool) error {
+	var mux *pktline.Mux
+	var dst io.Writer = u.out
+	if size > 0 {
+		mux = pktline.NewMux(u.w, size)
+		dst = bufio.NewWriterSize(mux, size-1)
+	}
+	fail := func(err error) error {
+		if mux != nil {
+			mux.Send(pktline.BandError, []byte(err.Error()+"\n"))
+			u.out.Flush()
+		}
+		return err
+	}
+	shas, err := missing(u.r, u.wants, u.haves)
+	if err != nil {
+		return fail(err)
+	}
+	if includeTag {
+		shas = u.withTags(shas)
+	}
+	if progress && mux != nil {
+		mux.Send(pktline.BandProgress, fmt.Appendf(nil, "Enumerating objects: %d, done.\n", len(shas)))
+	}
+	r := u.r
+	if _, _, err := pack.Write(dst, repo.Format(r), shas, func(sha string) (string, []byte, error) {
+		return object.ObjectReadRaw(r, sha)
+	}); err != nil {
+		return fail(err)
+	}
+	if mux != nil {
+		if err := dst.(*bufio.Writer).Flush(); err != nil {
+			return err
+		}
+		if progress {
+			mux.Send(pktline.BandProgress, fmt.Appendf(nil, "Total %d (delta 0), reused 0 (delta 0), pack-reused 0\n", len(shas)))
+		}
+		u.w.Flush()
+	}
+	return u.out.Flush()
+}
@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"slices"
+	"strings"
+)
+
+// configLine tells what a line of a config file holds: the section a header starts, or
+// the key a variable sets, both normalized like parseConfig does; blank lines and
+// comments hold neither
+func configLine(line string) (section, key string) {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		return "", ""
+	case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+		return normalizeSection(line[1 : len(line)-1]), ""
+	}
+	key, _, _ = strings.Cut(line, "=")
+	return "", strings.ToLower(strings.TrimSpace(key))
+}
+
+// formatValue quotes a value that would not read back as it is
+func formatValue(value string) string {
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+// configVariable returns the line setting key to value
+func configVariable(key, value string) string {
+	return "\t" + key + " = " + formatValue(value)
+}
+
+// insertConfigLines inserts lines at i, or at the end of the file when i is negative
+func (repo *Gitrepo) insertConfigLines(i int, lines ...string) {
+	if i < 0 {
+		i = len(repo.configLines)
+		// keep the final newline last
+		if i > 0 && repo.configLines[i-1] == "" {
+			i--
+		}
+	}
+	repo.configLines = slices.Insert(repo.configLines, i, lines...)
+}
+
+// setConfigLine makes section.key have the single value value, rewriting the line that
+// sets it last, or adding one at the end of the section
+func (repo *Gitrepo) setConfigLine(section, key, value string) {
+	current, last, end := "", -1, -1
+	var extra []int
+	for i, line := range repo.configLines {
+		sec, k := configLine(line)
+		if sec != "" {
+			current = sec
+			if current == section {
+				end = i + 1
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			end = i + 1
+		}
+		if k == key {
+			if last >= 0 {
+				extra = append(extra, last)
+			}
+			last = i
+		}
+	}
+	switch {
+	case last >= 0:
+		repo.configLines[last] = configVariable(key, value)
+		for j := len(extra) - 1; j >= 0; j-- {
+			repo.configLines = slices.Delete(repo.configLines, extra[j], extra[j]+1)
+		}
+	case end >= 0:
+		repo.insertConfigLines(end, configVariable(key, value))
+	default:
+		repo.insertConfigLines(-1, "["+section+"]", configVariable(key, value))
+	}
+}
+
+// unsetConfigLines removes the lines setting section.key, and the header of a section
+// left with nothing in it
+func (repo *Gitrepo) unsetConfigLines(section, key string) {
+	current, header := "", -1
+	var kept []string
+	for _, line := range repo.configLines {
+		sec, k := configLine(line)
+		if sec != "" {
+			kept = repo.dropEmptyHeader(kept, header)
+			current, header = sec, -1
+			if sec == section {
+				header = len(kept)
+			}
+		} else if current == section && k == key {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	repo.configLines = repo.dropEmptyHeader(kept, header)
+}
+
+// dropEmptyHeader removes the header at index header of lines when nothing but blank
+// lines follows it
+func (repo *Gitrepo) dropEmptyHeader(lines []string, header int) []string {
+	if header < 0 {
+		return lines
+	}
+	for _, line := range lines[header+1:] {
+		if strings.TrimSpace(line) != "" {
+			return lines
+		}
+	}
+	return slices.Delete(lines, header, header+1)
+}
+
+// removeConfigSection removes the headers of section and every line below them
+func (repo *Gitrepo) removeConfigSection(section string) {
+	current := ""
+	var kept []string
+	for _, line := range repo.configLines {
+		if sec, _ := configLine(line); sec != "" {
+			current = sec
+		}
+		if current != section {
+			kept = append(kept, line)
+		}
+	}
+	repo.configLines = kept
+}
+
+// renameConfigSection rewrites the headers of section oldName to newName
+func (repo *Gitrepo) renameConfigSection(oldName, newName string) {
+	for i, line := range repo.configLines {
+		if sec, _ := configLine(line); sec == oldName {
+			repo.configLines[i] = "[" + newName + "]"
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ObjectFormat describes the hash algorithm used for object ids
+// Name is the value of extensions.objectformat
+// Size is the length of a raw id in bytes
+type ObjectFormat struct {
+	Name string
+	Size int
+	New  func() hash.Hash
+}
+
+var (
+	SHA1   = &ObjectFormat{Name: "sha1", Size: sha1.Size, New: sha1.New}
+	SHA256 = &ObjectFormat{Name: "sha256", Size: sha256.Size, New: sha256.New}
+)
+
+// knownExtensions are the repository extensions understood in format version 1
+// Nothing here prunes objects, so preciousObjects needs no care, and objects a partial
+// clone left out are reported missing like any other.
+var knownExtensions = map[string]bool{
+	"objectformat":    true,
+	"noop":            true,
+	"worktreeconfig":  true,
+	"partialclone":    true,
+	"preciousobjects": true,
+}
+
+// FormatByName returns the object format with the given name
+func FormatByName(name string) (*ObjectFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	}
+	return nil, fmt.Errorf("unknown object format: %s", name)
+}
+
+// HexSize returns the length of an id in hex
+func (f *ObjectFormat) HexSize() int {
+	return f.Size * 2
+}
+
+// Sum hashes data and returns the id in hex
+func (f *ObjectFormat) Sum(data []byte) string {
+	h := f.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ZeroID returns the all-zero id in hex, used for missing objects
+func (f *ObjectFormat) ZeroID() string {
+	return strings.Repeat("0", f.HexSize())
+}
+
+// IsHexID reports whether s is a full id in this format
+func (f *ObjectFormat) IsHexID(s string) bool {
+	if len(s) != f.HexSize() {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Format returns the object format of the repository
+func Format(repo *Gitrepo) *ObjectFormat {
+	if repo == nil || repo.Format == nil {
+		return SHA1
+	}
+	return repo.Format
+}
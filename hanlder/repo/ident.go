@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// globalConfig reads the user's global git configuration, if any
+func globalConfig() conf {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"), filepath.Join(home, ".gitconfig"))
+	}
+	c := conf{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		parsed, err := parseConfig(data)
+		if err != nil {
+			continue
+		}
+		for section, kv := range parsed {
+			for k, v := range kv {
+				addSection(c, section)
+				c[section][k] = append(c[section][k], v...)
+			}
+		}
+	}
+	return c
+}
+
+// ConfigLookup returns section.key from the repository config, falling back to the global config
+// With a nil repo only the global config is read.
+func ConfigLookup(repo *Gitrepo, section, key string) (string, bool) {
+	if repo != nil {
+		if v, ok := ConfigGet(repo, section, key); ok {
+			return v, true
+		}
+	}
+	v, ok := globalConfig().get(section, key)
+	return v, ok
+}
+
+// Ident returns the "Name <email> <seconds> <zone>" line for role, "author" or "committer"
+// GIT_<ROLE>_NAME, GIT_<ROLE>_EMAIL and GIT_<ROLE>_DATE override user.name and user.email
+func Ident(repo *Gitrepo, role string) (string, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+	name := os.Getenv(env + "NAME")
+	if name == "" {
+		name, _ = ConfigLookup(repo, "user", "name")
+	}
+	email := os.Getenv(env + "EMAIL")
+	if email == "" {
+		email, _ = ConfigLookup(repo, "user", "email")
+	}
+	if name == "" || email == "" {
+		return "", errors.New("please tell me who you are: set user.name and user.email")
+	}
+	when := time.Now()
+	if date := os.Getenv(env + "DATE"); date != "" {
+		parsed, err := ParseDate(date)
+		if err != nil {
+			return "", err
+		}
+		when = parsed
+	}
+	return fmt.Sprintf("%s <%s> %d %s", name, email, when.Unix(), when.Format("-0700")), nil
+}
+
+// ParseDate parses the date formats git accepts in GIT_*_DATE:
+// "<seconds> <zone>", "@<seconds> <zone>", RFC 2822 and ISO 8601
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if secs, zone, ok := strings.Cut(strings.TrimPrefix(s, "@"), " "); ok {
+		if n, err := strconv.ParseInt(secs, 10, 64); err == nil {
+			if tz, err := time.Parse("-0700", zone); err == nil {
+				return time.Unix(n, 0).In(tz.Location()), nil
+			}
+		}
+	}
+	if n, err := strconv.ParseInt(strings.TrimPrefix(s, "@"), 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC1123Z, "Mon, 2 Jan 2006 15:04:05 -0700", time.RFC3339, "2006-01-02 15:04:05 -0700", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %s", s)
+}
+
+// ParseIdent splits an identity line into the person and the time it records
+func ParseIdent(line string) (string, time.Time, error) {
+	end := strings.LastIndex(line, ">")
+	if end == -1 {
+		return "", time.Time{}, fmt.Errorf("invalid identity: %q", line)
+	}
+	person := line[:end+1]
+	when, err := ParseDate(strings.TrimSpace(line[end+1:]))
+	if err != nil {
+		return person, time.Time{}, err
+	}
+	return person, when, nil
+}
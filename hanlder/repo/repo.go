@@ -6,48 +6,93 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-type conf map[string]map[string]string
+// conf maps sections to their keys, each with every value it is given in order
+type conf map[string]map[string][]string
+
+// get returns the last value of section.key, which is the one that counts for keys
+// taking a single value
+func (c conf) get(section, key string) (string, bool) {
+	values := c[section][key]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// DefaultGitdirName is the name of the git directory created by RepoCreate
+const DefaultGitdirName = ".tit"
+
+// gitdirNames lists the git directory names looked for in a worktree, in order of preference
+var gitdirNames = []string{".git", DefaultGitdirName}
 
 // Gitrepo struct represents a git repository
-// Worktree is the path to the working directory
+// Worktree is the path to the working directory, empty for bare repositories
 // Gitdir is the path to the .git directory
 // Conf is the configuration of the repository
+// Bare reports whether the repository has no worktree
+// Commondir is the shared git directory of a linked worktree, empty otherwise
+// Format is the hash algorithm used for object ids
 type Gitrepo struct {
-	Worktree string
-	Gitdir   string
-	Conf     conf
+	Worktree  string
+	Gitdir    string
+	Conf      conf
+	Bare      bool
+	Commondir string
+	Format    *ObjectFormat
+	// configLines is the config file as read, which edits change in place
+	configLines []string
 }
 
+// commonPaths are the top-level entries a linked worktree shares with its main repository
+var commonPaths = map[string]bool{
+	"objects": true, "refs": true, "config": true, "packed-refs": true, "logs": true,
+	"hooks": true, "info": true, "remotes": true, "branches": true, "shallow": true,
+	"description": true,
+}
+
+// worktreePaths are the entries below common paths that stay private to a linked worktree
+var worktreePaths = []string{"logs/HEAD", "refs/bisect", "refs/worktree", "refs/rewritten"}
+
 // writeStringFile writes the content to the file at path
 func writeStringFile(path, content string) error {
 	return os.WriteFile(path, []byte(content), 0644)
 }
 
-// writeConfigFile writes the config to the file at path
-func writeConfigFile(path string, c conf) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+// formatConfig returns the text of a config file holding c
+func formatConfig(c conf) string {
+	var b strings.Builder
+	// core first, then the rest sorted, so the file is stable
+	sections := make([]string, 0, len(c))
+	for section := range c {
+		sections = append(sections, section)
 	}
-	defer f.Close()
-
-	for section, kv := range c {
-		if _, err := fmt.Fprintf(f, "[%s]\n", section); err != nil {
-			return err
+	sort.Slice(sections, func(i, j int) bool {
+		if (sections[i] == "core") != (sections[j] == "core") {
+			return sections[i] == "core"
 		}
-		for key, value := range kv {
-			if _, err := fmt.Fprintf(f, "\t%s = %s\n", key, value); err != nil {
-				return err
-			}
+		return sections[i] < sections[j]
+	})
+	for _, section := range sections {
+		kv := c[section]
+		fmt.Fprintf(&b, "[%s]\n", section)
+		keys := make([]string, 0, len(kv))
+		for key := range kv {
+			keys = append(keys, key)
 		}
-		if _, err := fmt.Fprintln(f); err != nil {
-			return err
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range kv[key] {
+				fmt.Fprintf(&b, "\t%s = %s\n", key, value)
+			}
 		}
+		b.WriteString("\n")
 	}
-	return nil
+	return b.String()
 }
 
 // isDirEmpty checks if the directory at path is empty
@@ -75,25 +120,34 @@ func isDirEmpty(path string) (bool, error) {
 }
 
 // parseConfig parses the config file data and returns a conf map
+// Section and key names are case-insensitive and stored lowercased,
+// subsection names keep their case: [remote "origin"] becomes remote "origin"
 func parseConfig(data []byte) (conf, error) {
 	c := conf{} // initialize your map
 	section := ""
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
+		line = strings.TrimSpace(line)
 		//[] #
 		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
 			continue
 		}
 		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			section = strings.TrimSpace(line[1 : len(line)-1])
-			c[section] = make(map[string]string)
+			section = normalizeSection(line[1 : len(line)-1])
+			addSection(c, section)
 			continue
 		}
+		if section == "" {
+			return nil, fmt.Errorf("config: key outside of a section: %q", line)
+		}
 		pair := strings.SplitN(line, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(pair[0]))
 		if len(pair) == 2 {
-			key := strings.TrimSpace(pair[0])
 			value := strings.TrimSpace(pair[1])
-			c[section][key] = value
+			c[section][key] = append(c[section][key], strings.Trim(value, "\""))
+		} else {
+			// a bare key is a boolean set to true
+			c[section][key] = append(c[section][key], "true")
 		}
 
 	}
@@ -101,15 +155,116 @@ func parseConfig(data []byte) (conf, error) {
 	return c, nil
 }
 
-// NewGitrepo creates a new Gitrepo struct
+// normalizeSection lowercases the section name and keeps the subsection as written
+func normalizeSection(s string) string {
+	s = strings.TrimSpace(s)
+	name, sub, ok := strings.Cut(s, " ")
+	if !ok {
+		// old-style [section.subsection]
+		if i := strings.Index(s, "."); i != -1 {
+			return strings.ToLower(s[:i]) + " \"" + s[i+1:] + "\""
+		}
+		return strings.ToLower(s)
+	}
+	return strings.ToLower(name) + " " + strings.TrimSpace(sub)
+}
+
+// isGitDir reports whether path looks like a git directory
+func isGitDir(path string) bool {
+	headExists, headIsDir := PathExist(filepath.Join(path, "HEAD"))
+	if common, err := readCommondir(path); err == nil && common != "" {
+		path = common
+	}
+	_, objects := PathExist(filepath.Join(path, "objects"))
+	_, refs := PathExist(filepath.Join(path, "refs"))
+	return headExists && !headIsDir && objects && refs
+}
+
+// readCommondir returns the directory named by the commondir file of a linked worktree
+func readCommondir(gitdir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitdir, "commondir"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitdir, dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// readGitfile resolves a "gitdir: <path>" file as used by worktrees and submodules
+func readGitfile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(data))
+	dir, ok := strings.CutPrefix(line, "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("invalid gitfile format: %s", path)
+	}
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	if !isGitDir(dir) {
+		return "", fmt.Errorf("not a git repository: %s", dir)
+	}
+	return dir, nil
+}
+
+// findGitdir returns the git directory of the worktree at path, or "" if there is none
+// .git is preferred over .tit, and a .git file is followed to the directory it names
+func findGitdir(path string) (string, error) {
+	for _, name := range gitdirNames {
+		p := filepath.Join(path, name)
+		exists, isDir := PathExist(p)
+		if !exists {
+			continue
+		}
+		if isDir {
+			if isGitDir(p) {
+				return p, nil
+			}
+			continue
+		}
+		return readGitfile(p)
+	}
+	return "", nil
+}
+
+// NewGitrepo creates a new Gitrepo struct for the worktree at path
+// The git directory is detected, falling back to DefaultGitdirName
 func NewGitrepo(path string, force bool) (*Gitrepo, error) {
+	gitdir, err := findGitdir(path)
+	if err != nil {
+		return nil, err
+	}
+	if gitdir == "" {
+		gitdir = filepath.Join(path, DefaultGitdirName)
+	}
+	return OpenGitrepo(path, gitdir, force)
+}
+
+// OpenGitrepo creates a Gitrepo struct from an explicit worktree and git directory
+// An empty worktree opens the repository as bare
+func OpenGitrepo(worktree, gitdir string, force bool) (*Gitrepo, error) {
 	repo := &Gitrepo{}
-	repo.Worktree = path
-	repo.Gitdir = filepath.Join(repo.Worktree, ".tit")
-	cf, err := RepoFile(repo, true, "config")
+	repo.Worktree = worktree
+	repo.Gitdir = gitdir
+	repo.Bare = worktree == ""
+	repo.Conf = conf{}
+	repo.Format = SHA1
+	common, err := readCommondir(gitdir)
 	if err != nil {
 		return nil, err
 	}
+	repo.Commondir = common
+	cf := RepoPath(repo, "config")
 	isPath, _ := PathExist(cf)
 	if isPath {
 		data, err := os.ReadFile(cf)
@@ -121,18 +276,179 @@ func NewGitrepo(path string, force bool) (*Gitrepo, error) {
 			return nil, err
 		}
 		repo.Conf = conf
+		repo.configLines = strings.Split(string(data), "\n")
 	} else if !force {
 		return nil, errors.New("No config file in this repo")
 	}
 	if !force {
-		ver, exist := repo.Conf["core"]["repoformatversion"]
-		if !exist || ver != "0" {
-			return nil, errors.New("Unsupported version")
+		if err := checkFormatVersion(repo); err != nil {
+			return nil, err
 		}
+		if err := readWorktreeConfig(repo); err != nil {
+			return nil, err
+		}
+		applyCoreConfig(repo)
 	}
 	return repo, nil
 }
 
+// readWorktreeConfig adds the settings of config.worktree, which override the shared
+// ones, when extensions.worktreeConfig is set
+func readWorktreeConfig(repo *Gitrepo) error {
+	if !ConfigBool(repo, "extensions", "worktreeconfig") {
+		return nil
+	}
+	data, err := os.ReadFile(RepoPath(repo, "config.worktree"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	wc, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	for section, kv := range wc {
+		for k, v := range kv {
+			addSection(repo.Conf, section)
+			repo.Conf[section][k] = append(repo.Conf[section][k], v...)
+		}
+	}
+	return nil
+}
+
+// checkFormatVersion checks that the repository format is one we understand
+// and sets the object format from extensions.objectformat
+func checkFormatVersion(repo *Gitrepo) error {
+	ver, exist := repo.Conf.get("core", "repositoryformatversion")
+	if !exist {
+		// repositories created by older versions of this tool
+		ver, exist = repo.Conf.get("core", "repoformatversion")
+	}
+	if !exist {
+		return errors.New("Unsupported version")
+	}
+	switch ver {
+	case "0":
+		return nil
+	case "1":
+		for ext := range repo.Conf["extensions"] {
+			if !knownExtensions[ext] {
+				return fmt.Errorf("unknown repository extension: %s", ext)
+			}
+		}
+		name, _ := repo.Conf.get("extensions", "objectformat")
+		format, err := FormatByName(name)
+		if err != nil {
+			return err
+		}
+		repo.Format = format
+		return nil
+	}
+	return errors.New("Unsupported version")
+}
+
+// applyCoreConfig honors core.bare and core.worktree
+func applyCoreConfig(repo *Gitrepo) {
+	if ConfigBool(repo, "core", "bare") {
+		repo.Worktree = ""
+		repo.Bare = true
+		return
+	}
+	if wt, ok := repo.Conf.get("core", "worktree"); ok && os.Getenv("GIT_WORK_TREE") == "" {
+		if !filepath.IsAbs(wt) {
+			wt = filepath.Join(repo.Gitdir, wt)
+		}
+		repo.Worktree = filepath.Clean(wt)
+		repo.Bare = false
+	}
+}
+
+// ConfigBool reports whether section.key is set to a true value
+func ConfigBool(repo *Gitrepo, section, key string) bool {
+	v, _ := repo.Conf.get(section, key)
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// ConfigGet returns the value of section.key and whether it is set
+// A key set more than once has its last value.
+func ConfigGet(repo *Gitrepo, section, key string) (string, bool) {
+	return repo.Conf.get(section, key)
+}
+
+// ConfigGetAll returns every value of section.key, in the order they are set
+func ConfigGetAll(repo *Gitrepo, section, key string) []string {
+	return repo.Conf[section][key]
+}
+
+// ConfigHasSection reports whether the config has section
+func ConfigHasSection(repo *Gitrepo, section string) bool {
+	_, ok := repo.Conf[section]
+	return ok
+}
+
+// ConfigSet sets section.key in memory, replacing all its values; ConfigWrite saves it
+func ConfigSet(repo *Gitrepo, section, key, value string) {
+	if repo.Conf == nil {
+		repo.Conf = conf{}
+	}
+	setSection(repo.Conf, section, key, value)
+	repo.setConfigLine(section, key, value)
+}
+
+// ConfigUnset removes section.key, and the section once it is empty
+func ConfigUnset(repo *Gitrepo, section, key string) {
+	delete(repo.Conf[section], key)
+	if len(repo.Conf[section]) == 0 {
+		delete(repo.Conf, section)
+	}
+	repo.unsetConfigLines(section, key)
+}
+
+// ConfigRemoveSection removes a whole section
+func ConfigRemoveSection(repo *Gitrepo, section string) {
+	delete(repo.Conf, section)
+	repo.removeConfigSection(section)
+}
+
+// ConfigRenameSection moves the keys of section oldName to newName
+func ConfigRenameSection(repo *Gitrepo, oldName, newName string) {
+	kv, ok := repo.Conf[oldName]
+	if !ok {
+		return
+	}
+	delete(repo.Conf, oldName)
+	repo.Conf[newName] = kv
+	repo.renameConfigSection(oldName, newName)
+}
+
+// ConfigWrite saves the configuration of the repository
+// Only the lines the edits touched change: comments, layout and the keys this tool does
+// not know are kept.
+func ConfigWrite(repo *Gitrepo) error {
+	text := strings.Join(repo.configLines, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	path := RepoPath(repo, "config")
+	if err := os.WriteFile(path+".lock", []byte(text), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".lock", path)
+}
+
+// RequireWorktree returns an error if the repository is bare
+func RequireWorktree(repo *Gitrepo) error {
+	if repo.Bare || repo.Worktree == "" {
+		return errors.New("this operation must be run in a work tree")
+	}
+	return nil
+}
+
 // PathExist checks if the path exists and if it is a directory
 func PathExist(path string) (exists bool, isDir bool) {
 	info, err := os.Stat(path)
@@ -146,11 +462,34 @@ func PathExist(path string) (exists bool, isDir bool) {
 }
 
 // RepoPath returns the path to the file in the .git directory calls recursive
+// In a linked worktree shared files resolve to the common directory
 func RepoPath(repo *Gitrepo, paths ...string) string {
-	all := append([]string{repo.Gitdir}, paths...)
+	base := repo.Gitdir
+	if repo.Commondir != "" && isCommonPath(paths) {
+		base = repo.Commondir
+	}
+	all := append([]string{base}, paths...)
 	return filepath.Join(all...)
 }
 
+// isCommonPath reports whether the git directory path is shared between worktrees
+func isCommonPath(paths []string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	rel := filepath.ToSlash(filepath.Join(paths...))
+	first, _, _ := strings.Cut(rel, "/")
+	if !commonPaths[first] {
+		return false
+	}
+	for _, p := range worktreePaths {
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return false
+		}
+	}
+	return true
+}
+
 // RepoDir returns the path to the directory in the .git directory
 func RepoDir(repo *Gitrepo, mkdir bool, paths ...string) (string, error) {
 	path := RepoPath(repo, paths...)
@@ -190,12 +529,30 @@ func RepoFile(repo *Gitrepo, mkdir bool, paths ...string) (string, error) {
 }
 
 // RepoCreate creates a new git repository
-func RepoCreate(path string) (*Gitrepo, error) {
-	repo, err := NewGitrepo(path, true)
+// A bare repository uses path itself as the git directory
+// A nil format creates a sha1 repository
+func RepoCreate(path string, bare bool, format *ObjectFormat) (*Gitrepo, error) {
+	var repo *Gitrepo
+	var err error
+	switch gitdir := os.Getenv("GIT_DIR"); {
+	case gitdir != "":
+		worktree := path
+		if wt := os.Getenv("GIT_WORK_TREE"); wt != "" {
+			worktree = wt
+		}
+		if bare {
+			worktree = ""
+		}
+		repo, err = OpenGitrepo(worktree, gitdir, true)
+	case bare:
+		repo, err = OpenGitrepo("", path, true)
+	default:
+		repo, err = NewGitrepo(path, true)
+	}
 	if err != nil {
 		return nil, err
 	}
-	isWorkTree, isWorkDir := PathExist(repo.Worktree)
+	isWorkTree, isWorkDir := PathExist(path)
 
 	if isWorkTree {
 		if !isWorkDir {
@@ -209,7 +566,7 @@ func RepoCreate(path string) (*Gitrepo, error) {
 
 		}
 	} else {
-		err = os.Mkdir(path, 0755)
+		err = os.MkdirAll(path, 0755)
 		if err != nil {
 			return nil, err
 		}
@@ -238,7 +595,13 @@ func RepoCreate(path string) (*Gitrepo, error) {
 	}
 
 	// config
-	if err := writeConfigFile(RepoPath(repo, "config"), getDefaultConfig()); err != nil {
+	if format == nil {
+		format = SHA1
+	}
+	repo.Format = format
+	repo.Conf = getDefaultConfig(bare, format)
+	repo.configLines = strings.Split(formatConfig(repo.Conf), "\n")
+	if err := ConfigWrite(repo); err != nil {
 		return nil, err
 	}
 
@@ -246,29 +609,38 @@ func RepoCreate(path string) (*Gitrepo, error) {
 }
 
 // getDefaultConfig returns the default configuration for a new repository
-func getDefaultConfig() conf {
+func getDefaultConfig(bare bool, format *ObjectFormat) conf {
 	Conf := conf{}
 	addSection(Conf, "core")
-	setSection(Conf, "core", "repoformatversion", "0")
-	setSection(Conf, "core", "bare", "false")
+	setSection(Conf, "core", "repositoryformatversion", "0")
+	setSection(Conf, "core", "bare", strconv.FormatBool(bare))
+	if format != SHA1 {
+		// extensions are only honored in format version 1
+		setSection(Conf, "core", "repositoryformatversion", "1")
+		setSection(Conf, "extensions", "objectformat", format.Name)
+	}
 	return Conf
 }
 
 // addSection adds a new section to the config map
 func addSection(c conf, section string) {
 	if _, ok := c[section]; !ok {
-		c[section] = make(map[string]string)
+		c[section] = make(map[string][]string)
 	}
 }
 
 // setSection sets the value for a key in a section
 func setSection(c conf, section, key, value string) {
 	addSection(c, section)
-	c[section][key] = value
+	c[section][key] = []string{value}
 }
 
 // RepoFind finds the root of the git repository
+// GIT_DIR and GIT_WORK_TREE take precedence over searching from path
 func RepoFind(path string, req bool) (*Gitrepo, error) {
+	if gitdir := os.Getenv("GIT_DIR"); gitdir != "" {
+		return repoFromEnv(gitdir, path)
+	}
 	path, err := filepath.Abs(path)
 
 
@@ -277,10 +649,16 @@ func RepoFind(path string, req bool) (*Gitrepo, error) {
 	}
 
 
-	_, isDir := PathExist(filepath.Join(path, ".tit"))
-
-	if isDir {
-		return NewGitrepo(path, false)
+	gitdir, err := findGitdir(path)
+	if err != nil {
+		return nil, err
+	}
+	if gitdir != "" {
+		return OpenGitrepo(path, gitdir, false)
+	}
+	// bare repository, or the inside of a git directory
+	if isGitDir(path) {
+		return OpenGitrepo("", path, false)
 	}
 	parentPath := filepath.Dir(path)
 	if parentPath == path {
@@ -294,3 +672,50 @@ func RepoFind(path string, req bool) (*Gitrepo, error) {
 
 	return RepoFind(parentPath, req)
 }
+
+// RepoOpen opens the repository whose worktree or git directory is path
+// Unlike RepoFind it neither looks at GIT_DIR nor searches the parent directories
+func RepoOpen(path string) (*Gitrepo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	gitdir, err := findGitdir(path)
+	if err != nil {
+		return nil, err
+	}
+	if gitdir != "" {
+		return OpenGitrepo(path, gitdir, false)
+	}
+	if isGitDir(path) {
+		return OpenGitrepo("", path, false)
+	}
+	return nil, fmt.Errorf("'%s' does not appear to be a git repository", path)
+}
+
+// repoFromEnv opens the repository named by GIT_DIR
+// Without GIT_WORK_TREE the worktree is path, like git does
+func repoFromEnv(gitdir, path string) (*Gitrepo, error) {
+	gitdir, err := filepath.Abs(gitdir)
+	if err != nil {
+		return nil, err
+	}
+	if exists, isDir := PathExist(gitdir); exists && !isDir {
+		gitdir, err = readGitfile(gitdir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !isGitDir(gitdir) {
+		return nil, fmt.Errorf("not a git repository: %s", gitdir)
+	}
+	worktree := os.Getenv("GIT_WORK_TREE")
+	if worktree == "" {
+		worktree = path
+	}
+	worktree, err = filepath.Abs(worktree)
+	if err != nil {
+		return nil, err
+	}
+	return OpenGitrepo(worktree, gitdir, false)
+}
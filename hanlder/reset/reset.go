@@ -0,0 +1,163 @@
+package reset
+
+import (
+	"errors"
+
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Mode selects what reset updates besides the branch ref
+type Mode int
+
+const (
+	// Soft only moves the branch
+	Soft Mode = iota
+	// Mixed also resets the index
+	Mixed
+	// Hard also resets the index and the worktree
+	Hard
+	// Keep resets the index and the files that differ between HEAD and the target,
+	// refusing to touch files with local changes
+	Keep
+)
+
+// ParseMode returns the mode named by a --soft, --mixed, --hard or --keep option
+func ParseMode(opt string) (Mode, bool) {
+	switch opt {
+	case "--soft":
+		return Soft, true
+	case "--mixed":
+		return Mixed, true
+	case "--hard":
+		return Hard, true
+	case "--keep":
+		return Keep, true
+	}
+	return 0, false
+}
+
+// Reset moves the current branch, or the detached HEAD, to the commit rev
+// The old value of HEAD is saved in ORIG_HEAD
+func Reset(r *repo.Gitrepo, rev string, mode Mode) (string, error) {
+	target, err := object.ObjectFind(r, rev, "commit")
+	if err != nil {
+		return "", err
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return "", err
+	}
+	if mode != Soft {
+		if err := repo.RequireWorktree(r); err != nil {
+			return "", err
+		}
+	} else {
+		idx, err := index.Read(r)
+		if err != nil {
+			return "", err
+		}
+		if conflicts := idx.Conflicts(); len(conflicts) > 0 {
+			return "", errors.New("cannot do a soft reset in the middle of a merge")
+		}
+	}
+
+	newTree, err := object.ObjectFind(r, target, "tree")
+	if err != nil {
+		return "", err
+	}
+	oldTree := ""
+	if head != "" {
+		oldTree, err = object.ObjectFind(r, head, "tree")
+		if err != nil {
+			return "", err
+		}
+	}
+	switch mode {
+	case Mixed, Keep:
+		if mode == Keep {
+			// carry local changes the way switching branches does, but unstage everything
+			if err := worktree.Checkout(r, oldTree, newTree, false); err != nil {
+				var overwrite *worktree.OverwriteError
+				if errors.As(err, &overwrite) {
+					overwrite.Op = "reset"
+				}
+				return "", err
+			}
+		}
+		old, err := index.Read(r)
+		if err != nil {
+			return "", err
+		}
+		idx, err := worktree.IndexFromTree(r, newTree, old)
+		if err != nil {
+			return "", err
+		}
+		if err := index.Write(r, idx); err != nil {
+			return "", err
+		}
+	case Hard:
+		if err := worktree.Checkout(r, oldTree, newTree, true); err != nil {
+			return "", err
+		}
+	}
+
+	if head != "" {
+		if err := refs.UpdateRef(r, "ORIG_HEAD", head, ""); err != nil {
+			return "", err
+		}
+	}
+	if err := refs.UpdateRef(r, "HEAD", target, "reset: moving to "+rev); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// ResetPaths sets the index entries matching ps to their state in the commit rev,
+// removing those the commit does not have; HEAD does not move
+func ResetPaths(r *repo.Gitrepo, rev string, ps *pathspec.Pathspec) ([]string, error) {
+	files := map[string]object.TreeFile{}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	if rev != "HEAD" || head != "" {
+		tree, err := object.ObjectFind(r, rev, "tree")
+		if err != nil {
+			return nil, err
+		}
+		files, err = object.TreeFiles(r, tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for _, e := range append([]*index.Entry(nil), idx.Entries...) {
+		if !ps.Match(e.Name) {
+			continue
+		}
+		if _, ok := files[e.Name]; !ok {
+			idx.Remove(e.Name)
+			changed = append(changed, e.Name)
+		}
+	}
+	for name, f := range files {
+		if !ps.Match(name) {
+			continue
+		}
+		if e := idx.Find(name, 0); e != nil && e.Sha == f.Sha && e.Mode == f.Mode {
+			continue
+		}
+		idx.Add(&index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name})
+		changed = append(changed, name)
+	}
+	return changed, index.Write(r, idx)
+}
@@ -0,0 +1,150 @@
+package reset
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// history is a repository whose branch has two commits, checked out at the second
+type history struct {
+	r             *repo.Gitrepo
+	first, second string
+}
+
+func newHistory(t *testing.T) *history {
+	t.Helper()
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	r, err := repo.RepoCreate(t.TempDir(), false, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &history{r: r}
+	blob := func(content string) string {
+		sha, err := object.HashObject(r, "blob", []byte(content), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	tree := func(files map[string]string) string {
+		entries := map[string]object.TreeFile{}
+		for name, sha := range files {
+			entries[name] = object.TreeFile{Mode: 0100644, Sha: sha}
+		}
+		sha, err := object.WriteTree(r, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	commit := func(tree string, parents ...string) string {
+		ident := "A U Thor <author@example.com> 1700000000 +0000"
+		sha, err := object.ObjectWrite(r, object.NewCommit(tree, parents, ident, ident, []byte("commit\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	same := blob("same\n")
+	tree1 := tree(map[string]string{"a": blob("one\n"), "same": same})
+	tree2 := tree(map[string]string{"a": blob("two\n"), "b": blob("new\n"), "same": same})
+	h.first = commit(tree1)
+	h.second = commit(tree2, h.first)
+	if err := refs.UpdateRef(r, "HEAD", h.second, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := worktree.Checkout(r, "", tree2, false); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func (h *history) check(t *testing.T, head, indexA, worktreeA string) {
+	t.Helper()
+	if sha, err := refs.ResolveRef(h.r, "HEAD"); err != nil || sha != head {
+		t.Errorf("HEAD is %s, %v; want %s", sha, err, head)
+	}
+	if orig, _ := refs.ResolveRef(h.r, "ORIG_HEAD"); orig != h.second {
+		t.Errorf("ORIG_HEAD is %s, want %s", orig, h.second)
+	}
+	idx, err := index.Read(h.r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := idx.Find("a", 0); e == nil || e.Sha != object.HashString(h.r, "blob", []byte(indexA)) {
+		t.Errorf("the index entry of a is %+v, want the blob of %q", e, indexA)
+	}
+	data, err := os.ReadFile(worktree.FullPath(h.r, "a"))
+	if err != nil || string(data) != worktreeA {
+		t.Errorf("a is %q, %v in the worktree, want %q", data, err, worktreeA)
+	}
+}
+
+func TestResetModes(t *testing.T) {
+	t.Run("soft", func(t *testing.T) {
+		h := newHistory(t)
+		if _, err := Reset(h.r, h.first, Soft); err != nil {
+			t.Fatal(err)
+		}
+		h.check(t, h.first, "two\n", "two\n")
+	})
+	t.Run("mixed", func(t *testing.T) {
+		h := newHistory(t)
+		if _, err := Reset(h.r, h.first, Mixed); err != nil {
+			t.Fatal(err)
+		}
+		h.check(t, h.first, "one\n", "two\n")
+		if _, err := os.Lstat(worktree.FullPath(h.r, "b")); err != nil {
+			t.Errorf("a mixed reset touched the worktree: %v", err)
+		}
+	})
+	t.Run("hard", func(t *testing.T) {
+		h := newHistory(t)
+		os.WriteFile(worktree.FullPath(h.r, "same"), []byte("local\n"), 0644)
+		if _, err := Reset(h.r, h.first, Hard); err != nil {
+			t.Fatal(err)
+		}
+		h.check(t, h.first, "one\n", "one\n")
+		if _, err := os.Lstat(worktree.FullPath(h.r, "b")); !os.IsNotExist(err) {
+			t.Errorf("b survived a hard reset: %v", err)
+		}
+		if data, _ := os.ReadFile(worktree.FullPath(h.r, "same")); string(data) != "same\n" {
+			t.Errorf("a hard reset kept the local change %q", data)
+		}
+	})
+	t.Run("keep", func(t *testing.T) {
+		h := newHistory(t)
+		// a change to a file both commits have the same is carried over
+		os.WriteFile(worktree.FullPath(h.r, "same"), []byte("local\n"), 0644)
+		if _, err := Reset(h.r, h.first, Keep); err != nil {
+			t.Fatal(err)
+		}
+		h.check(t, h.first, "one\n", "one\n")
+		if data, _ := os.ReadFile(worktree.FullPath(h.r, "same")); string(data) != "local\n" {
+			t.Errorf("a keep reset lost the local change, same is %q", data)
+		}
+	})
+	t.Run("keep refused", func(t *testing.T) {
+		h := newHistory(t)
+		os.WriteFile(worktree.FullPath(h.r, "a"), []byte("local\n"), 0644)
+		_, err := Reset(h.r, h.first, Keep)
+		var overwrite *worktree.OverwriteError
+		if !errors.As(err, &overwrite) || overwrite.Op != "reset" || len(overwrite.Modified) != 1 {
+			t.Fatalf("a keep reset over a local change returned %v", err)
+		}
+		if sha, _ := refs.ResolveRef(h.r, "HEAD"); sha != h.second {
+			t.Errorf("a refused reset moved HEAD to %s", sha)
+		}
+		if data, _ := os.ReadFile(worktree.FullPath(h.r, "a")); string(data) != "local\n" {
+			t.Errorf("a refused reset changed a to %q", data)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// ErrNoMatch is returned when the pathspec selects no known file
+var ErrNoMatch = errors.New("pathspec did not match any file(s) known to git")
+
+// Options select what restore writes and where the content comes from
+// Without Staged or Worktree only the worktree is restored.
+// Source is a tree-ish; when empty the worktree is restored from the index
+// and the index from HEAD. Stage restores unmerged paths from stage 2 (ours) or 3 (theirs).
+type Options struct {
+	Staged   bool
+	Worktree bool
+	Source   string
+	Stage    int
+}
+
+// Restore brings the paths selected by ps back to their content in the source
+// It returns the restored paths
+func Restore(r *repo.Gitrepo, ps *pathspec.Pathspec, opts Options) ([]string, error) {
+	if !opts.Staged && !opts.Worktree {
+		opts.Worktree = true
+	}
+	if opts.Worktree {
+		if err := repo.RequireWorktree(r); err != nil {
+			return nil, err
+		}
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fromIndex := opts.Source == "" && !opts.Staged
+	var source map[string]object.TreeFile
+	if fromIndex {
+		source, err = indexFiles(idx, ps, opts.Stage)
+	} else {
+		source, err = treeFiles(r, opts.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// every path selected in the source or the index
+	selected := map[string]bool{}
+	for name := range source {
+		if ps.Match(name) {
+			selected[name] = true
+		}
+	}
+	for _, e := range idx.Entries {
+		if ps.Match(e.Name) {
+			selected[e.Name] = true
+		}
+	}
+	if len(selected) == 0 {
+		return nil, ErrNoMatch
+	}
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := filter.New(r)
+	for _, name := range names {
+		f, inSource := source[name]
+		if opts.Staged {
+			if inSource {
+				if e := idx.Find(name, 0); e == nil || e.Sha != f.Sha || e.Mode != f.Mode {
+					idx.Add(&index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name})
+				}
+			} else {
+				idx.Remove(name)
+			}
+		}
+		if !opts.Worktree {
+			continue
+		}
+		if !inSource {
+			if fromIndex {
+				continue
+			}
+			if err := worktree.RemoveFile(r, name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e, err := worktree.WriteFile(r, filters, name, f.Mode, f.Sha)
+		if err != nil {
+			return nil, err
+		}
+		// refresh the stat data of an index entry that matches what was written
+		if cur := idx.Find(name, 0); cur != nil && cur.Sha == e.Sha && cur.Mode == e.Mode {
+			idx.Add(e)
+		}
+	}
+	return names, index.Write(r, idx)
+}
+
+// indexFiles returns the entries of the index selected by ps, at stage 0 or the given stage
+func indexFiles(idx *index.Index, ps *pathspec.Pathspec, stage int) (map[string]object.TreeFile, error) {
+	files := map[string]object.TreeFile{}
+	unmerged := map[string]bool{}
+	for _, e := range idx.Entries {
+		if !ps.Match(e.Name) {
+			continue
+		}
+		switch {
+		case e.Stage == 0:
+			files[e.Name] = object.TreeFile{Mode: e.Mode, Sha: e.Sha}
+		case e.Stage == stage:
+			files[e.Name] = object.TreeFile{Mode: e.Mode, Sha: e.Sha}
+			delete(unmerged, e.Name)
+		default:
+			if _, ok := files[e.Name]; !ok {
+				unmerged[e.Name] = true
+			}
+		}
+	}
+	for name := range unmerged {
+		if _, ok := files[name]; !ok {
+			return nil, fmt.Errorf("path '%s' is unmerged", name)
+		}
+	}
+	return files, nil
+}
+
+// treeFiles returns the files of a tree-ish, HEAD when rev is empty
+// An unborn HEAD has no files
+func treeFiles(r *repo.Gitrepo, rev string) (map[string]object.TreeFile, error) {
+	if rev == "" {
+		if _, head, err := refs.Head(r); err != nil || head == "" {
+			return map[string]object.TreeFile{}, err
+		}
+		rev = "HEAD"
+	}
+	tree, err := object.ObjectFind(r, rev, "tree")
+	if err != nil {
+		return nil, err
+	}
+	return object.TreeFiles(r, tree)
+}
@@ -0,0 +1,1039 @@
+package revision
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/pathspec"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// Order is the order Commits returns a walk in
+type Order int
+
+const (
+	// WalkOrder returns commits as the walk reaches them, newest first
+	WalkOrder Order = iota
+	// DateOrder shows no parent before its children, otherwise newest first
+	DateOrder
+	// TopoOrder shows no parent before its children and keeps lines of history together
+	TopoOrder
+)
+
+// Walk is a set of revisions and the options that select commits from their history
+// Commits reachable from an included commit and from no excluded one are walked. With
+// Paths, history is simplified: a commit that changes nothing in them is hidden, and a
+// merge that takes them from one parent is walked through that parent only.
+type Walk struct {
+	Order        Order
+	FirstParent  bool
+	AncestryPath bool
+	Reverse      bool
+	// FullHistory keeps walking every parent of a merge when Paths are given
+	FullHistory bool
+	// MaxCount is the most commits to show, or -1 for all; Skip is how many to leave out first
+	MaxCount int
+	Skip     int
+	// Since hides commits older than it and stops the walk there; Until hides newer ones
+	Since time.Time
+	Until time.Time
+	// a commit must match one of the patterns of each kind given
+	Authors    []*regexp.Regexp
+	Committers []*regexp.Regexp
+	Greps      []*regexp.Regexp
+	Paths      *pathspec.Pathspec
+
+	r       *repo.Gitrepo
+	include []string
+	exclude []string
+	// pending are the tags, trees and blobs named directly, listed by Objects
+	pending []pending
+	// refsGiven is set by options naming references, even when none matched
+	refsGiven bool
+
+	commits  map[string]*commitInfo
+	parents  map[string][]string
+	treesame map[string]bool
+	hidden   map[string]bool
+	bottoms  map[string]bool
+	walked   []string
+}
+
+type commitInfo struct {
+	commit *object.Commit
+	date   int64
+}
+
+type pending struct {
+	Object
+	typ     string
+	exclude bool
+}
+
+// Object is a tag, tree or blob listed by Objects, with the name it was reached by
+type Object struct {
+	Sha  string
+	Name string
+}
+
+// New returns an empty walk of the history of r
+func New(r *repo.Gitrepo) *Walk {
+	return &Walk{MaxCount: -1, r: r, commits: map[string]*commitInfo{}, parents: map[string][]string{}, treesame: map[string]bool{}}
+}
+
+// Include adds the commit sha to the commits whose history is walked
+func (w *Walk) Include(sha string) {
+	w.include = append(w.include, sha)
+}
+
+// Exclude leaves the history of the commit sha out of the walk
+func (w *Walk) Exclude(sha string) {
+	w.exclude = append(w.exclude, sha)
+}
+
+// Empty reports whether no revision was given to walk from
+func (w *Walk) Empty() bool {
+	return len(w.include) == 0 && len(w.exclude) == 0 && len(w.pending) == 0 && !w.refsGiven
+}
+
+// ambiguous is the error for an argument that is neither a revision nor a path
+func ambiguous(arg string) error {
+	return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree.\n"+
+		"Use '--' to separate paths from revisions, like this:\n"+
+		"'git <command> [<revision>...] -- [<file>...]'", arg)
+}
+
+// Parse builds a walk from command line arguments given relative to cwd
+// It takes revisions (A, ^A, A..B, A...B, A^@, A^!), --not, --all, --branches, --tags,
+// --remotes and --glob, the ordering, limiting and filtering options, and paths after
+// "--" or once an argument names an existing file. The options it does not know are
+// returned in order for the caller.
+func Parse(r *repo.Gitrepo, cwd string, args []string) (*Walk, []string, error) {
+	w := New(r)
+	var rest, paths, authors, committers, greps []string
+	not, ignoreCase := false, false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, val, hasVal := strings.Cut(arg, "=")
+		// value returns the value of an option given as --opt=value or --opt value
+		value := func() (string, error) {
+			if hasVal {
+				return val, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option '%s' requires a value", strings.TrimLeft(name, "-"))
+			}
+			i++
+			return args[i], nil
+		}
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case len(paths) > 0 && !strings.HasPrefix(arg, "-"):
+			if _, err := os.Lstat(filepath.Join(cwd, arg)); err != nil {
+				return nil, nil, ambiguous(arg)
+			}
+			paths = append(paths, arg)
+		case arg == "--not":
+			not = !not
+		case arg == "--all":
+			if err := w.addRefs("", "", not); err != nil {
+				return nil, nil, err
+			}
+		case name == "--branches" || name == "--tags" || name == "--remotes" || name == "--glob":
+			prefix := map[string]string{"--branches": "refs/heads/", "--tags": "refs/tags/", "--remotes": "refs/remotes/", "--glob": "refs/"}[name]
+			if name == "--glob" && !hasVal {
+				return nil, nil, fmt.Errorf("option 'glob' requires a value")
+			}
+			if err := w.addRefs(prefix, val, not); err != nil {
+				return nil, nil, err
+			}
+		case arg == "--topo-order":
+			w.Order = TopoOrder
+		case arg == "--date-order":
+			w.Order = DateOrder
+		case arg == "--first-parent":
+			w.FirstParent = true
+		case arg == "--ancestry-path":
+			// the path needs every parent, so history is not simplified
+			w.AncestryPath, w.FullHistory = true, true
+		case arg == "--full-history":
+			w.FullHistory = true
+		case arg == "--reverse":
+			w.Reverse = true
+		case arg == "-i" || arg == "--regexp-ignore-case":
+			ignoreCase = true
+		case name == "--since" || name == "--after" || name == "--until" || name == "--before":
+			v, err := value()
+			if err != nil {
+				return nil, nil, err
+			}
+			when, err := refs.ParseApproxDate(v, time.Now())
+			if err != nil {
+				return nil, nil, err
+			}
+			if name == "--since" || name == "--after" {
+				w.Since = when
+			} else {
+				w.Until = when
+			}
+		case name == "--max-age" || name == "--min-age":
+			v, err := value()
+			if err != nil {
+				return nil, nil, err
+			}
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("'%s': not an integer", v)
+			}
+			if name == "--max-age" {
+				w.Since = time.Unix(secs, 0)
+			} else {
+				w.Until = time.Unix(secs, 0)
+			}
+		case name == "--author" || name == "--committer" || name == "--grep":
+			v, err := value()
+			if err != nil {
+				return nil, nil, err
+			}
+			switch name {
+			case "--author":
+				authors = append(authors, v)
+			case "--committer":
+				committers = append(committers, v)
+			default:
+				greps = append(greps, v)
+			}
+		case name == "-n" || name == "--max-count" || name == "--skip" || strings.HasPrefix(arg, "-n") && len(arg) > 2:
+			v := strings.TrimPrefix(arg, "-n")
+			if name == "-n" || strings.HasPrefix(arg, "--") {
+				var err error
+				if v, err = value(); err != nil {
+					return nil, nil, err
+				}
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("'%s': not an integer", v)
+			}
+			if name == "--skip" {
+				w.Skip = n
+			} else {
+				w.MaxCount = n
+			}
+		case len(arg) > 1 && arg[0] == '-' && isDigits(arg[1:]):
+			w.MaxCount, _ = strconv.Atoi(arg[1:])
+		case strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		default:
+			err := w.addRevision(arg, not)
+			if err == nil {
+				continue
+			}
+			if strings.HasPrefix(arg, "^") || slices.Contains(args[i:], "--") {
+				return nil, nil, fmt.Errorf("bad revision '%s'", arg)
+			}
+			if _, statErr := os.Lstat(filepath.Join(cwd, arg)); statErr != nil {
+				return nil, nil, ambiguous(arg)
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) > 0 {
+		ps, err := pathspec.Parse(r.Worktree, cwd, paths)
+		if err != nil {
+			return nil, nil, err
+		}
+		w.Paths = ps
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var list []*regexp.Regexp
+		for _, p := range patterns {
+			if ignoreCase {
+				p = "(?i)" + p
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex: %s", err)
+			}
+			list = append(list, re)
+		}
+		return list, nil
+	}
+	var err error
+	if w.Authors, err = compile(authors); err != nil {
+		return nil, nil, err
+	}
+	if w.Committers, err = compile(committers); err != nil {
+		return nil, nil, err
+	}
+	if w.Greps, err = compile(greps); err != nil {
+		return nil, nil, err
+	}
+	return w, rest, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// addRevision adds one revision argument, excluded when not is set
+func (w *Walk) addRevision(arg string, not bool) error {
+	if from, to, ok := strings.Cut(arg, "..."); ok {
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+		a, err := object.ObjectFind(w.r, from, "commit")
+		if err != nil {
+			return err
+		}
+		b, err := object.ObjectFind(w.r, to, "commit")
+		if err != nil {
+			return err
+		}
+		bases, err := MergeBases(w.r, a, b)
+		if err != nil {
+			return err
+		}
+		w.add(a, not)
+		w.add(b, not)
+		for _, base := range bases {
+			w.add(base, !not)
+		}
+		return nil
+	}
+	if from, to, ok := strings.Cut(arg, ".."); ok {
+		if from == "" {
+			from = "HEAD"
+		}
+		if to == "" {
+			to = "HEAD"
+		}
+		a, err := object.ObjectFind(w.r, from, "commit")
+		if err != nil {
+			return err
+		}
+		b, err := object.ObjectFind(w.r, to, "commit")
+		if err != nil {
+			return err
+		}
+		w.add(a, !not)
+		w.add(b, not)
+		return nil
+	}
+	if rev, ok := strings.CutSuffix(arg, "^@"); ok {
+		parents, err := w.revParents(rev)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			w.add(p, not)
+		}
+		return nil
+	}
+	if rev, ok := strings.CutSuffix(arg, "^!"); ok {
+		parents, err := w.revParents(rev)
+		if err != nil {
+			return err
+		}
+		sha, err := object.ObjectFind(w.r, rev, "commit")
+		if err != nil {
+			return err
+		}
+		w.add(sha, not)
+		for _, p := range parents {
+			w.add(p, !not)
+		}
+		return nil
+	}
+	if rev, ok := strings.CutPrefix(arg, "^"); ok {
+		arg, not = rev, !not
+	}
+	sha, err := object.ObjectFind(w.r, arg, "")
+	if err != nil {
+		return err
+	}
+	return w.Add(sha, not)
+}
+
+// revParents returns the parents of the commit rev names
+func (w *Walk) revParents(rev string) ([]string, error) {
+	sha, err := object.ObjectFind(w.r, rev, "commit")
+	if err != nil {
+		return nil, err
+	}
+	c, err := w.commit(sha)
+	if err != nil {
+		return nil, err
+	}
+	return c.commit.Parents(), nil
+}
+
+// add includes or excludes a commit
+func (w *Walk) add(sha string, exclude bool) {
+	if exclude {
+		w.Exclude(sha)
+	} else {
+		w.Include(sha)
+	}
+}
+
+// Add includes or excludes an object of any type: tags are listed by Objects and peeled,
+// commits walked, and trees and blobs listed by Objects
+func (w *Walk) Add(sha string, exclude bool) error {
+	for {
+		typ, data, err := object.ObjectReadRaw(w.r, sha)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "commit":
+			w.add(sha, exclude)
+			return nil
+		case "tag":
+			t := &object.Tag{}
+			if err := t.Deserialize(data); err != nil {
+				return err
+			}
+			w.pending = append(w.pending, pending{Object{sha, t.Name()}, typ, exclude})
+			sha = t.Object()
+		default:
+			w.pending = append(w.pending, pending{Object{Sha: sha}, typ, exclude})
+			return nil
+		}
+	}
+}
+
+// addRefs adds the references under prefix that match glob, or all references and HEAD
+// when prefix is empty. A glob without wildcards matches the references below it.
+func (w *Walk) addRefs(prefix, glob string, exclude bool) error {
+	w.refsGiven = true
+	var match *pathspec.Pathspec
+	if glob != "" {
+		if !strings.HasPrefix(glob, prefix) {
+			glob = prefix + glob
+		}
+		if !strings.ContainsAny(glob, "*?[") {
+			glob = strings.TrimSuffix(glob, "/") + "/*"
+		}
+		var err error
+		if match, err = pathspec.Parse("", "", []string{glob}); err != nil {
+			return err
+		}
+	}
+	if prefix == "" {
+		if _, sha, err := refs.Head(w.r); err == nil && sha != "" {
+			if err := w.Add(sha, exclude); err != nil {
+				return err
+			}
+		}
+		prefix = "refs/"
+	}
+	list, err := refs.ListRefs(w.r, prefix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range list {
+		if !match.Match(ref.Name) {
+			continue
+		}
+		if err := w.Add(ref.Sha, exclude); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit reads a commit once
+func (w *Walk) commit(sha string) (*commitInfo, error) {
+	if c, ok := w.commits[sha]; ok {
+		return c, nil
+	}
+	c, err := object.ReadCommit(w.r, sha)
+	if err != nil {
+		return nil, err
+	}
+	info := &commitInfo{commit: c}
+	if v := c.Data.Header["committer"]; len(v) > 0 {
+		if _, when, err := repo.ParseIdent(v[0]); err == nil {
+			info.date = when.Unix()
+		}
+	}
+	w.commits[sha] = info
+	return info, nil
+}
+
+// ancestors returns heads and every commit reachable from them
+func (w *Walk) ancestors(heads []string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	queue := append([]string(nil), heads...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		c, err := w.commit(sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.commit.Parents()...)
+	}
+	return seen, nil
+}
+
+// MergeBases returns the best common ancestors of the commits a and b, sorted
+func MergeBases(r *repo.Gitrepo, a, b string) ([]string, error) {
+	w := New(r)
+	fromA, err := w.ancestors([]string{a})
+	if err != nil {
+		return nil, err
+	}
+	fromB, err := w.ancestors([]string{b})
+	if err != nil {
+		return nil, err
+	}
+	var common, parents []string
+	for sha := range fromA {
+		if fromB[sha] {
+			common = append(common, sha)
+			parents = append(parents, w.commits[sha].commit.Parents()...)
+		}
+	}
+	// a common ancestor of another common ancestor is not a best one
+	below, err := w.ancestors(parents)
+	if err != nil {
+		return nil, err
+	}
+	var bases []string
+	for _, sha := range common {
+		if !below[sha] {
+			bases = append(bases, sha)
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
+}
+
+// Commits walks the history and returns the selected commits in the walk's order
+func (w *Walk) Commits() ([]string, error) {
+	hidden, err := w.ancestors(w.exclude)
+	if err != nil {
+		return nil, err
+	}
+	w.hidden, w.bottoms = hidden, map[string]bool{}
+	for _, sha := range w.exclude {
+		w.bottoms[sha] = true
+	}
+	walked, err := w.walk()
+	if err != nil {
+		return nil, err
+	}
+	if w.AncestryPath && len(w.exclude) > 0 {
+		walked = w.ancestryPath(walked)
+	}
+	w.walked = walked
+	if w.Order != WalkOrder {
+		walked = w.sort(walked)
+	}
+	var list []string
+	skip := w.Skip
+	for _, sha := range walked {
+		if w.MaxCount >= 0 && len(list) >= w.MaxCount {
+			break
+		}
+		if !w.shown(sha) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		list = append(list, sha)
+	}
+	if w.Reverse {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	return list, nil
+}
+
+// walk returns the commits reachable from the included ones and not hidden, newest first
+func (w *Walk) walk() ([]string, error) {
+	queue := &dateQueue{}
+	seen := map[string]bool{}
+	for _, sha := range w.include {
+		if w.hidden[sha] || seen[sha] {
+			continue
+		}
+		c, err := w.commit(sha)
+		if err != nil {
+			return nil, err
+		}
+		seen[sha] = true
+		queue.put(sha, c.date)
+	}
+	var walked []string
+	for queue.Len() > 0 {
+		sha := queue.get()
+		walked = append(walked, sha)
+		if !w.Since.IsZero() && w.commits[sha].date < w.Since.Unix() {
+			continue
+		}
+		parents, err := w.walkParents(sha)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if w.hidden[p] || seen[p] {
+				continue
+			}
+			c, err := w.commit(p)
+			if err != nil {
+				return nil, err
+			}
+			seen[p] = true
+			queue.put(p, c.date)
+		}
+	}
+	return walked, nil
+}
+
+// walkParents returns the parents the walk goes on to from sha, simplified by Paths
+func (w *Walk) walkParents(sha string) ([]string, error) {
+	if parents, ok := w.parents[sha]; ok {
+		return parents, nil
+	}
+	c, err := w.commit(sha)
+	if err != nil {
+		return nil, err
+	}
+	parents := c.commit.Parents()
+	if w.FirstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+	if !w.Paths.Empty() {
+		if parents, err = w.simplify(sha, parents); err != nil {
+			return nil, err
+		}
+	}
+	w.parents[sha] = parents
+	return parents, nil
+}
+
+// simplify records whether sha changes anything in Paths and returns the parents to walk:
+// the first walked or excluded parent it is the same as, or all of them
+func (w *Walk) simplify(sha string, parents []string) ([]string, error) {
+	tree := w.commits[sha].commit.TreeSha()
+	if len(parents) == 0 {
+		changed, err := w.treeChanged("", tree, "")
+		w.treesame[sha] = !changed
+		return parents, err
+	}
+	// parents the walk does not reach, other than the excluded commits themselves, cannot
+	// make a merge differ when there are walked ones
+	relevant, relevantChange, otherChange := false, false, false
+	for _, p := range parents {
+		c, err := w.commit(p)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := w.treeChanged(c.commit.TreeSha(), tree, "")
+		if err != nil {
+			return nil, err
+		}
+		if w.hidden[p] && !w.bottoms[p] {
+			otherChange = otherChange || changed
+			continue
+		}
+		if !changed && !w.FullHistory {
+			w.treesame[sha] = true
+			return []string{p}, nil
+		}
+		relevant, relevantChange = true, relevantChange || changed
+	}
+	if relevant {
+		w.treesame[sha] = !relevantChange
+	} else {
+		w.treesame[sha] = !otherChange
+	}
+	return parents, nil
+}
+
+// treeChanged reports whether the trees a and b differ in a path selected by Paths
+// dir is the path of the trees; either may be empty for a missing tree.
+func (w *Walk) treeChanged(a, b, dir string) (bool, error) {
+	if a == b {
+		return false, nil
+	}
+	ea, err := w.treeEntries(a)
+	if err != nil {
+		return false, err
+	}
+	eb, err := w.treeEntries(b)
+	if err != nil {
+		return false, err
+	}
+	names := map[string]bool{}
+	for name := range ea {
+		names[name] = true
+	}
+	for name := range eb {
+		names[name] = true
+	}
+	for name := range names {
+		x, y := ea[name], eb[name]
+		if x == y {
+			continue
+		}
+		full := name
+		if dir != "" {
+			full = dir + "/" + name
+		}
+		xTree, yTree := x.Sha != "" && object.IsTreeMode(x.Mode), y.Sha != "" && object.IsTreeMode(y.Mode)
+		if !xTree && !yTree {
+			if w.Paths.Match(full) {
+				return true, nil
+			}
+			continue
+		}
+		// a file replaced by a directory changes the file's path; the directory is compared entry by entry
+		if (!xTree && x.Sha != "" || !yTree && y.Sha != "") && w.Paths.Match(full) {
+			return true, nil
+		}
+		if !w.Paths.MatchDir(full) {
+			continue
+		}
+		sa, sb := "", ""
+		if xTree {
+			sa = x.Sha
+		}
+		if yTree {
+			sb = y.Sha
+		}
+		changed, err := w.treeChanged(sa, sb, full)
+		if err != nil || changed {
+			return changed, err
+		}
+	}
+	return false, nil
+}
+
+// treeEntries reads the entries of a tree by name; a missing tree has none
+func (w *Walk) treeEntries(sha string) (map[string]object.TreeFile, error) {
+	entries := map[string]object.TreeFile{}
+	if sha == "" {
+		return entries, nil
+	}
+	t, err := object.ReadTree(w.r, sha)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range t.Data {
+		mode, err := object.ParseMode(e.Mode)
+		if err != nil {
+			return nil, err
+		}
+		entries[string(e.Name)] = object.TreeFile{Mode: mode, Sha: fmt.Sprintf("%x", e.Sha)}
+	}
+	return entries, nil
+}
+
+// ancestryPath keeps the walked commits that descend from an excluded commit
+func (w *Walk) ancestryPath(walked []string) []string {
+	onPath := map[string]bool{}
+	for _, sha := range w.exclude {
+		onPath[sha] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(walked) - 1; i >= 0; i-- {
+			sha := walked[i]
+			if onPath[sha] {
+				continue
+			}
+			for _, p := range w.parents[sha] {
+				if onPath[p] {
+					onPath[sha], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	var kept []string
+	for _, sha := range walked {
+		if onPath[sha] {
+			kept = append(kept, sha)
+		}
+	}
+	return kept
+}
+
+// sort orders the walked commits so that no parent comes before its children
+// TopoOrder follows one line of history as far as it goes; DateOrder takes the newest
+// commit whose children are all shown.
+func (w *Walk) sort(list []string) []string {
+	indegree := map[string]int{}
+	for _, sha := range list {
+		indegree[sha] = 1
+	}
+	for _, sha := range list {
+		for _, p := range w.parents[sha] {
+			if indegree[p] > 0 {
+				indegree[p]++
+			}
+		}
+	}
+	queue := &dateQueue{}
+	var stack []string
+	put := func(sha string) {
+		if w.Order == TopoOrder {
+			stack = append(stack, sha)
+		} else {
+			queue.put(sha, w.commits[sha].date)
+		}
+	}
+	// the tips keep the order of the walk
+	for i := range list {
+		sha := list[i]
+		if w.Order == TopoOrder {
+			sha = list[len(list)-1-i]
+		}
+		if indegree[sha] == 1 {
+			put(sha)
+		}
+	}
+	var sorted []string
+	for len(stack) > 0 || queue.Len() > 0 {
+		var sha string
+		if w.Order == TopoOrder {
+			sha, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		} else {
+			sha = queue.get()
+		}
+		for _, p := range w.parents[sha] {
+			if indegree[p] == 0 {
+				continue
+			}
+			if indegree[p]--; indegree[p] == 1 {
+				put(p)
+			}
+		}
+		indegree[sha] = 0
+		sorted = append(sorted, sha)
+	}
+	return sorted
+}
+
+// shown reports whether a walked commit passes the filters
+func (w *Walk) shown(sha string) bool {
+	c := w.commits[sha]
+	if !w.Since.IsZero() && c.date < w.Since.Unix() || !w.Until.IsZero() && c.date > w.Until.Unix() {
+		return false
+	}
+	if !w.Paths.Empty() && w.treesame[sha] {
+		return false
+	}
+	header := c.commit.Data.Header
+	ident := func(role string) string {
+		if v := header[role]; len(v) > 0 {
+			// the date is not matched
+			if i := strings.LastIndexByte(v[0], '>'); i >= 0 {
+				return v[0][:i+1]
+			}
+			return v[0]
+		}
+		return ""
+	}
+	return matchAny(w.Authors, ident("author")) && matchAny(w.Committers, ident("committer")) &&
+		matchAny(w.Greps, string(c.commit.Data.Message))
+}
+
+// matchAny reports whether any of patterns matches s, or there are none
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return len(patterns) == 0
+}
+
+// Objects lists the tags, trees and blobs of the commits Commits returned, and those named
+// directly, leaving out what the excluded commits bordering the walk already have
+// Each object is listed once, trees before their entries; Paths limits the entries.
+func (w *Walk) Objects(commits []string) ([]Object, error) {
+	seen := map[string]bool{}
+	for _, sha := range w.walked {
+		for _, p := range w.commits[sha].commit.Parents() {
+			if !w.hidden[p] || seen[p] {
+				continue
+			}
+			seen[p] = true
+			c, err := w.commit(p)
+			if err != nil {
+				return nil, err
+			}
+			if err := w.markTree(c.commit.TreeSha(), seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range w.pending {
+		if !p.exclude {
+			continue
+		}
+		seen[p.Sha] = true
+		if p.typ == "tree" {
+			if err := w.markTree(p.Sha, seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var list []Object
+	for _, p := range w.pending {
+		if p.exclude || seen[p.Sha] {
+			continue
+		}
+		if p.typ == "tree" {
+			if err := w.listTree(p.Sha, "", seen, &list); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seen[p.Sha] = true
+		list = append(list, p.Object)
+	}
+	for _, sha := range commits {
+		if err := w.listTree(w.commits[sha].commit.TreeSha(), "", seen, &list); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// markTree marks a tree and everything below it as seen
+func (w *Walk) markTree(sha string, seen map[string]bool) error {
+	if seen[sha] {
+		return nil
+	}
+	seen[sha] = true
+	entries, err := object.ReadTree(w.r, sha)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries.Data {
+		mode, err := object.ParseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%x", e.Sha)
+		switch {
+		case mode == 0160000:
+		case object.IsTreeMode(mode):
+			if err := w.markTree(id, seen); err != nil {
+				return err
+			}
+		default:
+			seen[id] = true
+		}
+	}
+	return nil
+}
+
+// listTree appends a tree not seen yet and its entries selected by Paths
+func (w *Walk) listTree(sha, name string, seen map[string]bool, list *[]Object) error {
+	if seen[sha] {
+		return nil
+	}
+	seen[sha] = true
+	*list = append(*list, Object{sha, name})
+	t, err := object.ReadTree(w.r, sha)
+	if err != nil {
+		return err
+	}
+	for _, e := range t.Data {
+		mode, err := object.ParseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("%x", e.Sha)
+		full := string(e.Name)
+		if name != "" {
+			full = name + "/" + full
+		}
+		switch {
+		case mode == 0160000:
+			// submodule commits live in another repository
+		case object.IsTreeMode(mode):
+			if !w.Paths.MatchDir(full) {
+				continue
+			}
+			if err := w.listTree(id, full, seen, list); err != nil {
+				return err
+			}
+		default:
+			if seen[id] || !w.Paths.Match(full) {
+				continue
+			}
+			seen[id] = true
+			*list = append(*list, Object{id, full})
+		}
+	}
+	return nil
+}
+
+// dateQueue hands out commits newest first, and in the order they came when dated alike
+type dateQueue struct {
+	items []queued
+	n     int
+}
+
+type queued struct {
+	sha  string
+	date int64
+	n    int
+}
+
+func (q *dateQueue) Len() int { return len(q.items) }
+func (q *dateQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	return a.date > b.date || a.date == b.date && a.n < b.n
+}
+func (q *dateQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *dateQueue) Push(x any)    { q.items = append(q.items, x.(queued)) }
+func (q *dateQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+func (q *dateQueue) put(sha string, date int64) {
+	q.n++
+	heap.Push(q, queued{sha, date, q.n})
+}
+
+func (q *dateQueue) get() string {
+	return heap.Pop(q).(queued).sha
+}
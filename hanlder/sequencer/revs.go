@@ -0,0 +1,108 @@
+package sequencer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// ListCommits returns the commits selected by revs
+// Without ranges or ^exclusions the commits are taken as given; otherwise the
+// history is walked and the commits come out parents first when oldestFirst,
+// children first otherwise.
+func ListCommits(r *repo.Gitrepo, revs []string, oldestFirst bool) ([]string, error) {
+	var include, exclude []string
+	for _, rev := range revs {
+		switch {
+		case strings.Contains(rev, "..."):
+			return nil, errors.New("symmetric difference ranges are not supported: " + rev)
+		case strings.Contains(rev, ".."):
+			from, to, _ := strings.Cut(rev, "..")
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			exclude = append(exclude, from)
+			include = append(include, to)
+		case strings.HasPrefix(rev, "^"):
+			exclude = append(exclude, rev[1:])
+		default:
+			include = append(include, rev)
+		}
+	}
+	resolve := func(names []string) ([]string, error) {
+		var shas []string
+		for _, name := range names {
+			sha, err := object.ObjectFind(r, name, "commit")
+			if err != nil {
+				return nil, err
+			}
+			shas = append(shas, sha)
+		}
+		return shas, nil
+	}
+	heads, err := resolve(include)
+	if err != nil {
+		return nil, err
+	}
+	if len(exclude) == 0 {
+		return heads, nil
+	}
+	bottoms, err := resolve(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := map[string]bool{}
+	queue := bottoms
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if hidden[sha] {
+			continue
+		}
+		hidden[sha] = true
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents()...)
+	}
+
+	// a commit is listed after all of its parents
+	var list []string
+	seen := map[string]bool{}
+	var visit func(sha string) error
+	visit = func(sha string) error {
+		if seen[sha] || hidden[sha] {
+			return nil
+		}
+		seen[sha] = true
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return err
+		}
+		for _, p := range c.Parents() {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		list = append(list, sha)
+		return nil
+	}
+	for _, sha := range heads {
+		if err := visit(sha); err != nil {
+			return nil, err
+		}
+	}
+	if !oldestFirst {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,558 @@
+package sequencer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/merge"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/reset"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Actions a step can take
+const (
+	Pick   = "pick"
+	Revert = "revert"
+)
+
+// ErrNoOperation is returned by Continue, Skip and Abort when nothing is in progress
+var ErrNoOperation = errors.New("no cherry-pick or revert in progress")
+
+// ErrInProgress is returned by Start when an earlier operation has not finished
+var ErrInProgress = errors.New("a cherry-pick or revert is already in progress: try --continue, --skip or --abort")
+
+// ErrEmpty is returned when a step would make a commit that changes nothing
+var ErrEmpty = errors.New("the previous cherry-pick is now empty, possibly due to conflict resolution: use --skip to drop it")
+
+// Options change how the commits are applied
+// Mainline picks the parent of merge commits the change is taken against, starting at 1;
+// RecordOrigin appends a "(cherry picked from commit ...)" line to picked messages
+type Options struct {
+	Mainline     int
+	RecordOrigin bool
+}
+
+// Step is one line of the todo list
+type Step struct {
+	Action  string
+	Sha     string
+	Subject string
+}
+
+// Picked is a commit made while applying a step
+type Picked struct {
+	Sha      string
+	Subject  string
+	Messages []string
+}
+
+// StopError is returned when a step stops on conflicts for the user to resolve
+type StopError struct {
+	Step      Step
+	Messages  []string
+	Conflicts []string
+}
+
+func (e *StopError) Error() string {
+	verb := "apply"
+	if e.Step.Action == Revert {
+		verb = "revert"
+	}
+	return fmt.Sprintf("could not %s %s... %s", verb, object.Abbrev(e.Step.Sha), e.Step.Subject)
+}
+
+// state is what the sequencer directory records between commands
+// head is HEAD before the operation, safety is HEAD after the last commit it made
+type state struct {
+	head   string
+	safety string
+	todo   []Step
+	opts   Options
+}
+
+// pickHead is the file naming the commit a stopped step was applying
+func pickHead(action string) string {
+	if action == Revert {
+		return "REVERT_HEAD"
+	}
+	return "CHERRY_PICK_HEAD"
+}
+
+// InProgress reports whether a cherry-pick or revert has stopped and waits to be continued
+func InProgress(r *repo.Gitrepo) bool {
+	exists, _ := repo.PathExist(repo.RepoPath(r, "sequencer"))
+	return exists
+}
+
+// load reads the sequencer directory
+func load(r *repo.Gitrepo) (*state, error) {
+	if !InProgress(r) {
+		return nil, ErrNoOperation
+	}
+	s := &state{}
+	head, err := os.ReadFile(repo.RepoPath(r, "sequencer", "head"))
+	if err != nil {
+		return nil, err
+	}
+	s.head = strings.TrimSpace(string(head))
+	s.safety = s.head
+	if safety, err := os.ReadFile(repo.RepoPath(r, "sequencer", "abort-safety")); err == nil {
+		s.safety = strings.TrimSpace(string(safety))
+	}
+	if data, err := os.ReadFile(repo.RepoPath(r, "sequencer", "opts")); err == nil {
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if !ok {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "mainline":
+				s.opts.Mainline, _ = strconv.Atoi(strings.TrimSpace(value))
+			case "record-origin":
+				s.opts.RecordOrigin = strings.TrimSpace(value) == "true"
+			}
+		}
+	}
+	todo, err := os.ReadFile(repo.RepoPath(r, "sequencer", "todo"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(todo), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, rest, _ := strings.Cut(line, " ")
+		sha, subject, _ := strings.Cut(rest, " ")
+		switch action {
+		case "p":
+			action = Pick
+		case "r":
+			action = Revert
+		}
+		if action != Pick && action != Revert {
+			return nil, fmt.Errorf("invalid line in sequencer todo: %s", line)
+		}
+		s.todo = append(s.todo, Step{Action: action, Sha: sha, Subject: subject})
+	}
+	return s, nil
+}
+
+// save writes the sequencer directory
+func (s *state) save(r *repo.Gitrepo) error {
+	if _, err := repo.RepoDir(r, true, "sequencer"); err != nil {
+		return err
+	}
+	var todo strings.Builder
+	for _, step := range s.todo {
+		fmt.Fprintf(&todo, "%s %s %s\n", step.Action, step.Sha, step.Subject)
+	}
+	opts := "[options]\n"
+	if s.opts.Mainline > 0 {
+		opts += fmt.Sprintf("\tmainline = %d\n", s.opts.Mainline)
+	}
+	if s.opts.RecordOrigin {
+		opts += "\trecord-origin = true\n"
+	}
+	files := map[string]string{
+		"head":         s.head + "\n",
+		"abort-safety": s.safety + "\n",
+		"todo":         todo.String(),
+		"opts":         opts,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(repo.RepoPath(r, "sequencer", name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearPickHead removes what a stopped step leaves in the repository
+func clearPickHead(r *repo.Gitrepo) {
+	for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+		os.Remove(repo.RepoPath(r, name))
+	}
+}
+
+// Start applies or reverts the commits selected by revs on top of HEAD
+// A single revision selects one commit; ranges such as A..B select the commits in B
+// but not in A, picked oldest first and reverted newest first.
+// It returns the commits it made; on conflicts it stops with a *StopError
+// and the operation is finished with Continue, Skip or Abort.
+func Start(r *repo.Gitrepo, action string, revs []string, opts Options) ([]Picked, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	if InProgress(r) {
+		return nil, ErrInProgress
+	}
+	commits, err := ListCommits(r, revs, action == Pick)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, errors.New("empty commit set passed")
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &state{head: head, safety: head, opts: opts}
+	for _, sha := range commits {
+		c, err := object.ReadCommit(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		s.todo = append(s.todo, Step{Action: action, Sha: sha, Subject: c.Subject()})
+	}
+	if err := s.save(r); err != nil {
+		return nil, err
+	}
+	done, err := s.run(r)
+	var stop *StopError
+	if err != nil && len(done) == 0 && !errors.As(err, &stop) && !errors.Is(err, ErrEmpty) {
+		// nothing happened, so there is nothing to continue
+		os.RemoveAll(repo.RepoPath(r, "sequencer"))
+	}
+	return done, err
+}
+
+// Continue commits the resolved conflicts of the stopped step and applies the remaining ones
+func Continue(r *repo.Gitrepo) ([]Picked, error) {
+	s, err := load(r)
+	if err != nil {
+		return nil, err
+	}
+	var done []Picked
+	if len(s.todo) > 0 {
+		idx, err := index.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(idx.Conflicts()) > 0 {
+			return nil, errors.New("committing is not possible because you have unmerged files")
+		}
+		_, head, err := refs.Head(r)
+		if err != nil {
+			return nil, err
+		}
+		step := s.todo[0]
+		if exists, _ := repo.PathExist(repo.RepoPath(r, pickHead(step.Action))); exists {
+			picked, err := s.commitIndex(r, step, idx, head)
+			if err != nil {
+				return nil, err
+			}
+			done = append(done, *picked)
+			s.todo = s.todo[1:]
+			if err := s.save(r); err != nil {
+				return done, err
+			}
+		} else if head != s.safety {
+			// the step was committed by hand
+			s.todo = s.todo[1:]
+			s.safety = head
+			if err := s.save(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	more, err := s.run(r)
+	return append(done, more...), err
+}
+
+// Skip drops the stopped step, resetting the index and worktree to HEAD, and applies the remaining ones
+func Skip(r *repo.Gitrepo) ([]Picked, error) {
+	s, err := load(r)
+	if err != nil {
+		return nil, err
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := object.ReadCommitTree(r, head)
+	if err != nil {
+		return nil, err
+	}
+	if err := worktree.Checkout(r, tree, tree, true); err != nil {
+		return nil, err
+	}
+	clearPickHead(r)
+	if len(s.todo) > 0 {
+		s.todo = s.todo[1:]
+	}
+	s.safety = head
+	if err := s.save(r); err != nil {
+		return nil, err
+	}
+	return s.run(r)
+}
+
+// Abort gives up the operation and resets HEAD, the index and the worktree to where it started
+// HEAD is left alone if it was moved by something else in the meantime
+func Abort(r *repo.Gitrepo) error {
+	s, err := load(r)
+	if err != nil {
+		return err
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return err
+	}
+	clearPickHead(r)
+	defer os.RemoveAll(repo.RepoPath(r, "sequencer"))
+	if head != s.safety {
+		return errors.New("you seem to have moved HEAD: not rewinding, check your HEAD")
+	}
+	if s.head == "" {
+		return nil
+	}
+	_, err = reset.Reset(r, s.head, reset.Hard)
+	return err
+}
+
+// run applies the steps left in the todo list, saving the state after each
+func (s *state) run(r *repo.Gitrepo) ([]Picked, error) {
+	var done []Picked
+	for len(s.todo) > 0 {
+		picked, err := s.apply(r, s.todo[0])
+		if err != nil {
+			return done, err
+		}
+		done = append(done, *picked)
+		s.todo = s.todo[1:]
+		s.safety = picked.Sha
+		if err := s.save(r); err != nil {
+			return done, err
+		}
+	}
+	return done, os.RemoveAll(repo.RepoPath(r, "sequencer"))
+}
+
+// Replay merges the change made by the commit sha into HEAD, updating the index and worktree
+// With Revert the change is undone; mainline picks the parent of a merge commit, 0 for other commits.
+// It returns the merge result and the tree of HEAD before the merge.
+func Replay(r *repo.Gitrepo, action, sha string, mainline int) (*merge.Result, string, error) {
+	c, err := object.ReadCommit(r, sha)
+	if err != nil {
+		return nil, "", err
+	}
+	parent, err := mainlineParent(c, sha, mainline)
+	if err != nil {
+		return nil, "", err
+	}
+	parentTree, err := object.ReadCommitTree(r, parent)
+	if err != nil {
+		return nil, "", err
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, "", err
+	}
+	headTree, err := object.ReadCommitTree(r, head)
+	if err != nil {
+		return nil, "", err
+	}
+
+	label := object.Abbrev(sha) + " (" + c.Subject() + ")"
+	opts := merge.Options{Ours: "HEAD", Base: "parent of " + label, Theirs: label}
+	base, theirs := parentTree, c.TreeSha()
+	if action == Revert {
+		base, theirs = theirs, base
+		opts.Base, opts.Theirs = opts.Theirs, opts.Base
+	}
+	res, err := merge.Trees(r, base, headTree, theirs, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := merge.Apply(r, headTree, res); err != nil {
+		return nil, "", err
+	}
+	return res, headTree, nil
+}
+
+// mainlineParent returns the parent of c a change is taken against, "" for a root commit
+func mainlineParent(c *object.Commit, sha string, mainline int) (string, error) {
+	parents := c.Parents()
+	switch {
+	case len(parents) > 1:
+		if mainline == 0 {
+			return "", fmt.Errorf("commit %s is a merge but no -m option was given", sha)
+		}
+		if mainline > len(parents) {
+			return "", fmt.Errorf("commit %s does not have parent %d", sha, mainline)
+		}
+		return parents[mainline-1], nil
+	case mainline > 0:
+		return "", fmt.Errorf("mainline was specified but commit %s is not a merge", sha)
+	case len(parents) == 1:
+		return parents[0], nil
+	}
+	return "", nil
+}
+
+// apply merges the change of one step into HEAD and commits it
+func (s *state) apply(r *repo.Gitrepo, step Step) (*Picked, error) {
+	c, err := object.ReadCommit(r, step.Sha)
+	if err != nil {
+		return nil, err
+	}
+	res, headTree, err := Replay(r, step.Action, step.Sha, s.opts.Mainline)
+	if err != nil {
+		return nil, err
+	}
+	parent, _ := mainlineParent(c, step.Sha, s.opts.Mainline)
+
+	message := s.message(step, c, parent)
+	if !res.Clean() {
+		conflicts := res.ConflictNames()
+		message += "\n# Conflicts:\n"
+		for _, name := range conflicts {
+			message += "#\t" + name + "\n"
+		}
+		if err := s.stop(r, step, message); err != nil {
+			return nil, err
+		}
+		return nil, &StopError{Step: step, Messages: res.Messages, Conflicts: conflicts}
+	}
+	tree, err := object.WriteTree(r, res.Files)
+	if err != nil {
+		return nil, err
+	}
+	if tree == headTree {
+		if err := s.stop(r, step, message); err != nil {
+			return nil, err
+		}
+		return nil, ErrEmpty
+	}
+	picked, err := commit(r, step, c, tree, message)
+	if err != nil {
+		return nil, err
+	}
+	picked.Messages = res.Messages
+	return picked, nil
+}
+
+// stop records the step being applied and its message for Continue
+func (s *state) stop(r *repo.Gitrepo, step Step, message string) error {
+	if err := os.WriteFile(repo.RepoPath(r, pickHead(step.Action)), []byte(step.Sha+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(repo.RepoPath(r, "MERGE_MSG"), []byte(message), 0644)
+}
+
+// message returns the commit message for a step
+func (s *state) message(step Step, c *object.Commit, parent string) string {
+	if step.Action == Revert {
+		msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", step.Subject, step.Sha)
+		if s.opts.Mainline > 0 {
+			msg += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		return msg + ".\n"
+	}
+	msg := string(c.Data.Message)
+	if s.opts.RecordOrigin {
+		msg = strings.TrimRight(msg, "\n") + fmt.Sprintf("\n\n(cherry picked from commit %s)\n", step.Sha)
+	}
+	return msg
+}
+
+// commitIndex commits the index of a stopped step with the message left in MERGE_MSG
+func (s *state) commitIndex(r *repo.Gitrepo, step Step, idx *index.Index, head string) (*Picked, error) {
+	tree, err := object.WriteTree(r, idx.Files())
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := object.ReadCommitTree(r, head)
+	if err != nil {
+		return nil, err
+	}
+	if tree == headTree {
+		return nil, ErrEmpty
+	}
+	c, err := object.ReadCommit(r, step.Sha)
+	if err != nil {
+		return nil, err
+	}
+	message, err := os.ReadFile(repo.RepoPath(r, "MERGE_MSG"))
+	if err != nil {
+		return nil, err
+	}
+	picked, err := commit(r, step, c, tree, CleanupMessage(string(message)))
+	if err != nil {
+		return nil, err
+	}
+	clearPickHead(r)
+	return picked, nil
+}
+
+// CleanupMessage drops comment lines and surrounding blank lines from a message
+func CleanupMessage(message string) string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimRight(line, " \t"))
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+// commit makes a commit of tree on top of HEAD for a step
+// Picked commits keep their author; reverts are authored by the current user
+func commit(r *repo.Gitrepo, step Step, orig *object.Commit, tree, message string) (*Picked, error) {
+	author := ""
+	if authors := orig.Data.Header["author"]; step.Action == Pick && len(authors) > 0 {
+		author = authors[0]
+	}
+	verb := "cherry-pick"
+	if step.Action == Revert {
+		verb = "revert"
+	}
+	_, head, err := refs.Head(r)
+	if err != nil {
+		return nil, err
+	}
+	var parents []string
+	if head != "" {
+		parents = []string{head}
+	}
+	subject := object.Subject([]byte(message))
+	sha, err := Commit(r, tree, parents, author, message, verb+": "+subject)
+	if err != nil {
+		return nil, err
+	}
+	return &Picked{Sha: sha, Subject: subject}, nil
+}
+
+// Commit writes a commit of tree with the given parents and moves HEAD to it, logging reflog
+// An empty author means the current user
+func Commit(r *repo.Gitrepo, tree string, parents []string, author, message, reflog string) (string, error) {
+	if author == "" {
+		var err error
+		if author, err = repo.Ident(r, "author"); err != nil {
+			return "", err
+		}
+	}
+	committer, err := repo.Ident(r, "committer")
+	if err != nil {
+		return "", err
+	}
+	sha, err := object.ObjectWrite(r, object.NewCommit(tree, parents, author, committer, []byte(message)))
+	if err != nil {
+		return "", err
+	}
+	if err := refs.UpdateRef(r, "HEAD", sha, reflog); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
@@ -0,0 +1,379 @@
+package stash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/ignore"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/merge"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+	"github.com/Blue-Onion/pygo/hanlder/worktree"
+)
+
+// Ref is the reference holding the newest stash; older ones live in its reflog
+const Ref = "refs/stash"
+
+var (
+	ErrNoChanges     = errors.New("No local changes to save")
+	ErrNoStash       = errors.New("No stash entries found.")
+	ErrInitialCommit = errors.New("You do not have the initial commit yet")
+)
+
+// Entry is one stash of the stash list
+type Entry struct {
+	Index   int
+	Sha     string
+	Message string
+}
+
+// Name returns the name of the entry as shown by list
+func (e Entry) Name() string {
+	return fmt.Sprintf("stash@{%d}", e.Index)
+}
+
+// PushOptions control what Push saves
+// KeepIndex leaves the staged changes in place; IncludeUntracked also saves
+// and removes the files that are neither tracked nor ignored
+type PushOptions struct {
+	Message          string
+	KeepIndex        bool
+	IncludeUntracked bool
+}
+
+// ApplyOptions control how Apply restores a stash
+// Index restores the staged changes into the index too
+type ApplyOptions struct {
+	Index bool
+}
+
+// List returns the stashes, newest first
+func List(r *repo.Gitrepo) ([]Entry, error) {
+	entries, err := refs.ReadReflog(r, Ref)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Entry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		list = append(list, Entry{Index: len(list), Sha: entries[i].New, Message: entries[i].Message})
+	}
+	return list, nil
+}
+
+// Lookup finds the stash named by name: stash@{n}, refs/stash@{n} or just n
+// An empty name is the newest stash
+func Lookup(r *repo.Gitrepo, name string) (Entry, error) {
+	list, err := List(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(list) == 0 {
+		return Entry{}, ErrNoStash
+	}
+	if name == "" {
+		return list[0], nil
+	}
+	spec := strings.TrimPrefix(name, "refs/")
+	if strings.HasPrefix(spec, "stash@{") && strings.HasSuffix(spec, "}") {
+		spec = spec[len("stash@{") : len(spec)-1]
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		return Entry{}, fmt.Errorf("%s is not a stash reference", name)
+	}
+	if n >= len(list) {
+		return Entry{}, fmt.Errorf("%s is not a valid reference", name)
+	}
+	return list[n], nil
+}
+
+// Push saves the local changes as a stash and resets the worktree and index to HEAD
+// The stash is a commit whose tree is the worktree, with HEAD, a commit of the index
+// and, with untracked files, a commit of those as parents, like git's.
+func Push(r *repo.Gitrepo, opts PushOptions) (Entry, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return Entry{}, err
+	}
+	branch, head, err := refs.Head(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	if head == "" {
+		return Entry{}, ErrInitialCommit
+	}
+	headCommit, err := object.ReadCommit(r, head)
+	if err != nil {
+		return Entry{}, err
+	}
+	headTree := headCommit.TreeSha()
+	idx, err := index.Read(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	if conflicts := idx.Conflicts(); len(conflicts) > 0 {
+		return Entry{}, fmt.Errorf("%s: needs merge", conflicts[0])
+	}
+
+	indexTree, err := object.WriteTree(r, idx.Files())
+	if err != nil {
+		return Entry{}, err
+	}
+	filters := filter.New(r)
+	files := idx.Files()
+	changes, err := worktree.Unstaged(r, filters, idx)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, c := range changes {
+		if c.Status == 'D' {
+			delete(files, c.Name)
+			continue
+		}
+		f, err := worktree.StoreFile(r, filters, c.Name)
+		if err != nil {
+			return Entry{}, err
+		}
+		files[c.Name] = f
+	}
+	worktreeTree, err := object.WriteTree(r, files)
+	if err != nil {
+		return Entry{}, err
+	}
+	var untracked []string
+	if opts.IncludeUntracked {
+		if untracked, err = worktree.Untracked(r, idx, ignore.New(r)); err != nil {
+			return Entry{}, err
+		}
+	}
+	if indexTree == headTree && worktreeTree == headTree && len(untracked) == 0 {
+		return Entry{}, ErrNoChanges
+	}
+
+	branchName := "(no branch)"
+	if branch != "" {
+		branchName = refs.Shorten(branch)
+	}
+	about := fmt.Sprintf("%s: %s %s", branchName, object.Abbrev(head), headCommit.Subject())
+	ident, err := repo.Ident(r, "committer")
+	if err != nil {
+		return Entry{}, err
+	}
+	commit := func(tree string, parents []string, message string) (string, error) {
+		return object.ObjectWrite(r, object.NewCommit(tree, parents, ident, ident, []byte(message+"\n")))
+	}
+	indexCommit, err := commit(indexTree, []string{head}, "index on "+about)
+	if err != nil {
+		return Entry{}, err
+	}
+	parents := []string{head, indexCommit}
+	if len(untracked) > 0 {
+		saved := map[string]object.TreeFile{}
+		for _, name := range untracked {
+			if saved[name], err = worktree.StoreFile(r, filters, name); err != nil {
+				return Entry{}, err
+			}
+		}
+		untrackedTree, err := object.WriteTree(r, saved)
+		if err != nil {
+			return Entry{}, err
+		}
+		untrackedCommit, err := commit(untrackedTree, nil, "untracked files on "+about)
+		if err != nil {
+			return Entry{}, err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+	message := "WIP on " + about
+	if opts.Message != "" {
+		message = fmt.Sprintf("On %s: %s", branchName, opts.Message)
+	}
+	sha, err := commit(worktreeTree, parents, message)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := refs.UpdateRef(r, Ref, sha, message); err != nil {
+		return Entry{}, err
+	}
+
+	if err := worktree.Checkout(r, headTree, headTree, true); err != nil {
+		return Entry{}, err
+	}
+	if opts.KeepIndex && indexTree != headTree {
+		if err := worktree.Checkout(r, headTree, indexTree, false); err != nil {
+			return Entry{}, err
+		}
+	}
+	for _, name := range untracked {
+		if err := worktree.RemoveFile(r, name); err != nil {
+			return Entry{}, err
+		}
+	}
+	return Entry{Index: 0, Sha: sha, Message: message}, nil
+}
+
+// parts returns the commits a stash is made of: its base, its index and its untracked files,
+// the last empty when the stash has none
+func parts(r *repo.Gitrepo, sha string) (stash *object.Commit, base, idx, untracked string, err error) {
+	stash, err = object.ReadCommit(r, sha)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	parents := stash.Parents()
+	if len(parents) < 2 || len(parents) > 3 {
+		return nil, "", "", "", fmt.Errorf("%s is not a stash-like commit", sha)
+	}
+	if len(parents) == 3 {
+		untracked = parents[2]
+	}
+	return stash, parents[0], parents[1], untracked, nil
+}
+
+// Apply merges the changes of the stash commit sha into the worktree
+// The stash is merged three ways onto the current index, with its base as the
+// merge base. Staged changes come back as unstaged ones, except for new files,
+// unless opts.Index is set. Conflicts are left in the index and reported in the result.
+func Apply(r *repo.Gitrepo, sha string, opts ApplyOptions) (*merge.Result, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	stash, base, indexCommit, untrackedCommit, err := parts(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	baseTree, err := object.ReadCommitTree(r, base)
+	if err != nil {
+		return nil, err
+	}
+	indexTree, err := object.ReadCommitTree(r, indexCommit)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.Conflicts()) > 0 {
+		return nil, errors.New("Cannot apply a stash in the middle of a merge")
+	}
+	// the current index is our side, so staged changes survive the merge
+	oursFiles := idx.Files()
+	oursTree, err := object.WriteTree(r, oursFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var staged map[string]object.TreeFile
+	if opts.Index && indexTree != baseTree {
+		res, err := merge.Trees(r, baseTree, oursTree, indexTree, merge.Options{})
+		if err != nil {
+			return nil, err
+		}
+		if !res.Clean() {
+			return nil, errors.New("Conflicts in index. Try without --index.")
+		}
+		staged = res.Files
+	}
+
+	var untracked map[string]object.TreeFile
+	if untrackedCommit != "" {
+		untrackedTree, err := object.ReadCommitTree(r, untrackedCommit)
+		if err != nil {
+			return nil, err
+		}
+		if untracked, err = object.TreeFiles(r, untrackedTree); err != nil {
+			return nil, err
+		}
+		for name := range untracked {
+			if _, err := os.Lstat(worktree.FullPath(r, name)); err == nil {
+				return nil, fmt.Errorf("%s already exists, no checkout\ncould not restore untracked files from stash", name)
+			}
+		}
+	}
+
+	res, err := merge.Trees(r, baseTree, oursTree, stash.TreeSha(), merge.Options{
+		Ours: "Updated upstream", Base: "Stash base", Theirs: "Stashed changes",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := merge.Apply(r, oursTree, res); err != nil {
+		return nil, err
+	}
+
+	// the merge staged everything it changed; put the index back where it belongs
+	want := staged
+	if want == nil {
+		want = map[string]object.TreeFile{}
+		for name, f := range oursFiles {
+			want[name] = f
+		}
+		// new files stay added, or they would be lost to a later clean
+		for name, f := range res.Files {
+			if _, ok := oursFiles[name]; !ok {
+				want[name] = f
+			}
+		}
+	}
+	if idx, err = index.Read(r); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, files := range []map[string]object.TreeFile{oursFiles, res.Files, want} {
+		for name := range files {
+			names[name] = true
+		}
+	}
+	for name := range names {
+		if _, conflicted := res.Conflicts[name]; conflicted {
+			continue
+		}
+		f, ok := want[name]
+		e := idx.Find(name, 0)
+		switch {
+		case !ok:
+			idx.Remove(name)
+		case e == nil || e.Sha != f.Sha || e.Mode != f.Mode:
+			idx.Add(&index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name})
+		}
+	}
+	if err := index.Write(r, idx); err != nil {
+		return nil, err
+	}
+
+	filters := filter.New(r)
+	for name, f := range untracked {
+		if _, err := worktree.WriteFile(r, filters, name, f.Mode, f.Sha); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Drop removes a stash from the list; dropping the last one deletes refs/stash
+func Drop(r *repo.Gitrepo, e Entry) error {
+	if err := refs.DeleteReflogEntry(r, Ref, e.Index, true, true); err != nil {
+		return err
+	}
+	entries, err := refs.ReadReflog(r, Ref)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return refs.DeleteRef(r, Ref)
+	}
+	return nil
+}
+
+// Clear removes every stash
+func Clear(r *repo.Gitrepo) error {
+	if err := refs.DeleteRef(r, Ref); err != nil && !errors.Is(err, refs.ErrNotFound) {
+		return err
+	}
+	return refs.DeleteReflog(r, Ref)
+}
@@ -0,0 +1,242 @@
+package tag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/refs"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+const tagsPrefix = "refs/tags/"
+
+// Info describes a tag for listing
+// Sha is what the ref points at: the tag object for annotated tags
+type Info struct {
+	Name      string
+	Sha       string
+	Annotated bool
+	Subject   string
+}
+
+// List returns the tags matching any of patterns, sorted by sortKey
+// sortKey is refname or version:refname (v:refname), optionally prefixed with "-" to reverse
+func List(r *repo.Gitrepo, patterns []string, sortKey string) ([]Info, error) {
+	all, err := refs.ListRefs(r, tagsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var list []Info
+	for _, ref := range all {
+		name := strings.TrimPrefix(ref.Name, tagsPrefix)
+		if !matches(name, patterns) {
+			continue
+		}
+		info := Info{Name: name, Sha: ref.Sha}
+		if obj, err := object.ObjectRead(r, ref.Sha); err == nil {
+			switch o := obj.(type) {
+			case *object.Tag:
+				info.Annotated = true
+				info.Subject = o.Subject()
+			case *object.Commit:
+				info.Subject = o.Subject()
+			}
+		}
+		list = append(list, info)
+	}
+	reverse := strings.HasPrefix(sortKey, "-")
+	sortKey = strings.TrimPrefix(sortKey, "-")
+	var less func(a, b string) bool
+	switch sortKey {
+	case "", "refname":
+		less = func(a, b string) bool { return a < b }
+	case "version:refname", "v:refname":
+		less = VersionLess
+	default:
+		return nil, fmt.Errorf("unsupported sort key: %s", sortKey)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if reverse {
+			return less(list[j].Name, list[i].Name)
+		}
+		return less(list[i].Name, list[j].Name)
+	})
+	return list, nil
+}
+
+func matches(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionLess compares tag names treating runs of digits as numbers, so v1.10 sorts after v1.9
+func VersionLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da > 0 && db > 0 {
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[da:], b[db:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// checkNew validates a tag name and refuses to replace an existing tag without force
+func checkNew(r *repo.Gitrepo, name string, force bool) error {
+	if err := refs.CheckRefName(tagsPrefix + name); err != nil {
+		return err
+	}
+	if refs.Exists(r, tagsPrefix+name) && !force {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+	return nil
+}
+
+// CreateLightweight points refs/tags/<name> straight at the object target
+func CreateLightweight(r *repo.Gitrepo, name, target string, force bool) (string, error) {
+	if err := checkNew(r, name, force); err != nil {
+		return "", err
+	}
+	sha, err := object.ObjectFind(r, target, "")
+	if err != nil {
+		return "", err
+	}
+	return sha, refs.UpdateRef(r, tagsPrefix+name, sha, "tag: tagging "+sha)
+}
+
+// CreateAnnotated writes a tag object for target with message and points refs/tags/<name> at it
+func CreateAnnotated(r *repo.Gitrepo, name, target, message string, force bool) (string, error) {
+	if err := checkNew(r, name, force); err != nil {
+		return "", err
+	}
+	sha, err := object.ObjectFind(r, target, "")
+	if err != nil {
+		return "", err
+	}
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return "", err
+	}
+	tagger, err := repo.Ident(r, "committer")
+	if err != nil {
+		return "", err
+	}
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	t := &object.Tag{}
+	t.Data.Header = map[string][]string{
+		"object": {sha},
+		"type":   {obj.Type()},
+		"tag":    {name},
+		"tagger": {tagger},
+	}
+	t.Data.Message = []byte(message)
+	tagSha, err := object.ObjectWrite(r, t)
+	if err != nil {
+		return "", err
+	}
+	return tagSha, refs.UpdateRef(r, tagsPrefix+name, tagSha, "tag: tagging "+sha)
+}
+
+// Delete removes refs/tags/<name> and returns what it pointed at
+func Delete(r *repo.Gitrepo, name string) (string, error) {
+	sha, err := refs.ResolveRef(r, tagsPrefix+name)
+	if err != nil {
+		return "", fmt.Errorf("tag '%s' not found", name)
+	}
+	return sha, refs.DeleteRef(r, tagsPrefix+name)
+}
+
+// signatureMarker starts the detached signature at the end of a signed tag message
+const signatureMarker = "-----BEGIN PGP SIGNATURE-----"
+
+// Verify checks that the tag object is well formed, points at an existing object
+// of the recorded type, and that its signature verifies with gpg
+func Verify(r *repo.Gitrepo, name string) (*object.Tag, error) {
+	sha, err := refs.ResolveRef(r, tagsPrefix+name)
+	if err != nil {
+		return nil, fmt.Errorf("tag '%s' not found", name)
+	}
+	obj, err := object.ObjectRead(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := obj.(*object.Tag)
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot verify a non-tag object of type %s", name, obj.Type())
+	}
+	if t.Object() == "" || t.ObjectType() == "" || t.Name() == "" {
+		return nil, fmt.Errorf("%s: malformed tag object", name)
+	}
+	target, err := object.ObjectRead(r, t.Object())
+	if err != nil {
+		return nil, fmt.Errorf("%s: tagged object %s is missing", name, t.Object())
+	}
+	if target.Type() != t.ObjectType() {
+		return nil, fmt.Errorf("%s: tagged object is a %s, tag says %s", name, target.Type(), t.ObjectType())
+	}
+	raw, err := t.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(raw, []byte(signatureMarker))
+	if i == -1 {
+		return nil, errors.New("no signature found")
+	}
+	return t, gpgVerify(raw[:i], raw[i:])
+}
+
+// gpgVerify checks a detached signature over payload with gpg
+func gpgVerify(payload, signature []byte) error {
+	f, err := os.CreateTemp("", "tag-signature-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(signature); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	cmd := exec.Command("gpg", "--verify", f.Name(), "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	return nil
+}
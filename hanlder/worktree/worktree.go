@@ -0,0 +1,590 @@
+package worktree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Blue-Onion/pygo/hanlder/filter"
+	"github.com/Blue-Onion/pygo/hanlder/ignore"
+	"github.com/Blue-Onion/pygo/hanlder/index"
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// OverwriteError lists the files a checkout refused to touch
+// Op names the operation in the message, checkout when empty
+type OverwriteError struct {
+	Op        string
+	Modified  []string
+	Untracked []string
+}
+
+func (e *OverwriteError) Error() string {
+	op := e.Op
+	if op == "" {
+		op = "checkout"
+	}
+	var b strings.Builder
+	if len(e.Modified) > 0 {
+		fmt.Fprintf(&b, "Your local changes to the following files would be overwritten by %s:\n", op)
+		for _, name := range e.Modified {
+			fmt.Fprintf(&b, "\t%s\n", name)
+		}
+	}
+	if len(e.Untracked) > 0 {
+		fmt.Fprintf(&b, "The following untracked working tree files would be overwritten by %s:\n", op)
+		for _, name := range e.Untracked {
+			fmt.Fprintf(&b, "\t%s\n", name)
+		}
+	}
+	if op == "checkout" {
+		b.WriteString("Please commit your changes or stash them before you switch branches.")
+	} else {
+		fmt.Fprintf(&b, "Please commit your changes or stash them before you %s.", op)
+	}
+	return b.String()
+}
+
+// FullPath returns the filesystem path of a slash-separated worktree path
+func FullPath(r *repo.Gitrepo, name string) string {
+	return filepath.Join(r.Worktree, filepath.FromSlash(name))
+}
+
+// HashFile returns the blob id of the worktree file name, without writing it
+// The content goes through the clean side of f first
+func HashFile(r *repo.Gitrepo, f *filter.Filter, name string) (string, os.FileInfo, error) {
+	data, info, err := ReadFile(r, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Mode().IsRegular() {
+		data, err = f.ToGit(name, data)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return object.HashString(r, "blob", data), info, nil
+}
+
+// ReadFile returns the blob content of the worktree file name
+// The content of a symlink is its target
+func ReadFile(r *repo.Gitrepo, name string) ([]byte, os.FileInfo, error) {
+	path := FullPath(r, name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []byte(filepath.ToSlash(target)), info, nil
+	}
+	if info.IsDir() {
+		return nil, info, fmt.Errorf("%s is a directory", name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// IsModified reports whether the worktree file differs from its index entry
+func IsModified(r *repo.Gitrepo, f *filter.Filter, e *index.Entry) (bool, error) {
+	info, err := os.Lstat(FullPath(r, e.Name))
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	if e.Mode == 0160000 {
+		// submodules are compared by their checked out commit elsewhere
+		return false, nil
+	}
+	if index.ModeFromFileInfo(info) != e.Mode {
+		return true, nil
+	}
+	if e.StatMatches(info) {
+		return false, nil
+	}
+	sha, _, err := HashFile(r, f, e.Name)
+	if err != nil {
+		return false, err
+	}
+	return sha != e.Sha, nil
+}
+
+// WriteFile writes the blob sha to the worktree file name with the given mode
+// and returns an index entry describing it; regular files go through the smudge side of f
+func WriteFile(r *repo.Gitrepo, f *filter.Filter, name string, mode uint32, sha string) (*index.Entry, error) {
+	var data []byte
+	if mode != 0160000 {
+		obj, err := object.ObjectRead(r, sha)
+		if err != nil {
+			return nil, err
+		}
+		blob, ok := obj.(*object.Blob)
+		if !ok {
+			return nil, fmt.Errorf("%s is a %s, not a blob", sha, obj.Type())
+		}
+		data = blob.Data
+	}
+	info, err := WriteContent(r, f, name, mode, data)
+	if err != nil {
+		return nil, err
+	}
+	e := &index.Entry{Mode: mode, Sha: sha, Name: name}
+	e.SetStat(info)
+	return e, nil
+}
+
+// WriteContent writes data, as it is stored in a blob, to the worktree file name with the given mode
+// A submodule becomes an empty directory
+func WriteContent(r *repo.Gitrepo, f *filter.Filter, name string, mode uint32, data []byte) (os.FileInfo, error) {
+	path := FullPath(r, name)
+	if err := makeParentDirs(r, name); err != nil {
+		return nil, err
+	}
+	// whatever was there before goes, file or empty directory
+	if info, err := os.Lstat(path); err == nil {
+		if info.IsDir() && mode != 0160000 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		} else if !info.IsDir() {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch mode {
+	case 0160000:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+	case 0120000:
+		if err := os.Symlink(filepath.FromSlash(string(data)), path); err != nil {
+			return nil, err
+		}
+	default:
+		perm := os.FileMode(0644)
+		if mode == 0100755 {
+			perm = 0755
+		}
+		data, err := f.ToWorktree(name, data)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, perm); err != nil {
+			return nil, err
+		}
+		// WriteFile keeps the permissions of an existing file
+		if err := os.Chmod(path, perm); err != nil {
+			return nil, err
+		}
+	}
+	return os.Lstat(path)
+}
+
+// makeParentDirs creates the directories above name
+// A file in the way is an error: callers remove the tracked ones first, and InTheWay
+// finds the untracked ones before anything is written.
+func makeParentDirs(r *repo.Gitrepo, name string) error {
+	dir := r.Worktree
+	parts := strings.Split(name, "/")
+	for i, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err == nil && !info.IsDir() {
+			return fmt.Errorf("cannot create directory for '%s': '%s' is in the way", name, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// InTheWay returns the files that writing name with mode would destroy: a file where
+// one of its parent directories goes, or the files inside a directory where name goes
+// Paths for which removed is true are deleted before the write and are left out.
+func InTheWay(r *repo.Gitrepo, name string, mode uint32, removed func(string) bool) ([]string, error) {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		info, err := os.Lstat(FullPath(r, dir))
+		if err != nil {
+			return nil, nil
+		}
+		if info.IsDir() {
+			continue
+		}
+		if removed(dir) {
+			return nil, nil
+		}
+		return []string{dir}, nil
+	}
+	info, err := os.Lstat(FullPath(r, name))
+	if err != nil || !info.IsDir() || mode == 0160000 {
+		return nil, nil
+	}
+	var found []string
+	err = filepath.WalkDir(FullPath(r, name), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Worktree, path)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); !removed(rel) {
+			found = append(found, rel)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// clearWay deletes whatever is in the way of writing name with mode, for a forced checkout
+func clearWay(r *repo.Gitrepo, name string, mode uint32) error {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := FullPath(r, strings.Join(parts[:i], "/"))
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			return os.Remove(dir)
+		}
+	}
+	if info, err := os.Lstat(FullPath(r, name)); err == nil && info.IsDir() && mode != 0160000 {
+		return os.RemoveAll(FullPath(r, name))
+	}
+	return nil
+}
+
+// RemoveFile deletes the worktree file name and any directories it leaves empty
+func RemoveFile(r *repo.Gitrepo, name string) error {
+	path := FullPath(r, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != r.Worktree && strings.HasPrefix(dir, r.Worktree); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// fileState is what a path looks like in the index and two trees
+type fileState struct {
+	index    *index.Entry
+	old, new *object.TreeFile
+}
+
+func sameFile(e *index.Entry, f *object.TreeFile) bool {
+	if e == nil || f == nil {
+		return e == nil && f == nil
+	}
+	return e.Sha == f.Sha && e.Mode == f.Mode
+}
+
+func sameTreeFile(a, b *object.TreeFile) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Checkout moves the index and worktree from the tree oldTree to newTree
+// Local changes to files that are the same in both trees are carried over;
+// changes that would be overwritten make it fail before anything is touched.
+// With force the index and worktree are reset to newTree.
+func Checkout(r *repo.Gitrepo, oldTree, newTree string, force bool) error {
+	if err := repo.RequireWorktree(r); err != nil {
+		return err
+	}
+	oldFiles, err := object.TreeFiles(r, oldTree)
+	if err != nil {
+		return err
+	}
+	newFiles, err := object.TreeFiles(r, newTree)
+	if err != nil {
+		return err
+	}
+	idx, err := index.Read(r)
+	if err != nil {
+		return err
+	}
+	filters := filter.New(r)
+	conflicts := idx.Conflicts()
+	if len(conflicts) > 0 {
+		if !force {
+			return fmt.Errorf("you need to resolve your current index first: %s", strings.Join(conflicts, ", "))
+		}
+		for _, name := range conflicts {
+			idx.Remove(name)
+		}
+	}
+
+	states := map[string]*fileState{}
+	state := func(name string) *fileState {
+		if states[name] == nil {
+			states[name] = &fileState{}
+		}
+		return states[name]
+	}
+	for _, e := range idx.Entries {
+		state(e.Name).index = e
+	}
+	// unmerged paths were tracked, so they go unless newTree has them
+	for _, name := range conflicts {
+		state(name).index = &index.Entry{Name: name}
+	}
+	for name, f := range oldFiles {
+		f := f
+		state(name).old = &f
+	}
+	for name, f := range newFiles {
+		f := f
+		state(name).new = &f
+	}
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// decide first, so a refused checkout leaves everything as it was
+	var write, remove []string
+	conflict := &OverwriteError{}
+	for _, name := range names {
+		s := states[name]
+		if force {
+			if s.new != nil {
+				if sameFile(s.index, s.new) {
+					modified, err := IsModified(r, filters, s.index)
+					if err != nil {
+						return err
+					}
+					if !modified {
+						continue
+					}
+				}
+				write = append(write, name)
+			} else if s.index != nil || s.old != nil {
+				remove = append(remove, name)
+			}
+			continue
+		}
+		switch {
+		case sameTreeFile(s.old, s.new), sameFile(s.index, s.new):
+			// keep the index and the worktree as they are
+		case s.index == nil && s.old == nil:
+			// new file: do not clobber an untracked file with other content
+			if sha, _, err := HashFile(r, filters, name); err == nil && sha != s.new.Sha {
+				conflict.Untracked = append(conflict.Untracked, name)
+				continue
+			}
+			write = append(write, name)
+		case sameFile(s.index, s.old):
+			modified, err := IsModified(r, filters, s.index)
+			if err != nil {
+				return err
+			}
+			if modified {
+				conflict.Modified = append(conflict.Modified, name)
+				continue
+			}
+			if s.new == nil {
+				remove = append(remove, name)
+			} else {
+				write = append(write, name)
+			}
+		default:
+			conflict.Modified = append(conflict.Modified, name)
+		}
+	}
+	// untracked files may also be in the way of the directories of what is written, or
+	// sit in a directory where a file goes
+	removing, reported := map[string]bool{}, map[string]bool{}
+	for _, name := range remove {
+		removing[name] = true
+	}
+	for _, name := range write {
+		if force {
+			continue
+		}
+		blockers, err := InTheWay(r, name, states[name].new.Mode, func(p string) bool { return removing[p] })
+		if err != nil {
+			return err
+		}
+		for _, p := range blockers {
+			if reported[p] {
+				continue
+			}
+			reported[p] = true
+			if states[p] != nil && states[p].index != nil {
+				conflict.Modified = append(conflict.Modified, p)
+			} else {
+				conflict.Untracked = append(conflict.Untracked, p)
+			}
+		}
+	}
+	if len(conflict.Modified) > 0 || len(conflict.Untracked) > 0 {
+		return conflict
+	}
+
+	for _, name := range remove {
+		if err := RemoveFile(r, name); err != nil {
+			return err
+		}
+		idx.Remove(name)
+	}
+	for _, name := range write {
+		f := states[name].new
+		if force {
+			if err := clearWay(r, name, f.Mode); err != nil {
+				return err
+			}
+		}
+		e, err := WriteFile(r, filters, name, f.Mode, f.Sha)
+		if err != nil {
+			return err
+		}
+		idx.Add(e)
+	}
+	return index.Write(r, idx)
+}
+
+// IndexFromTree returns an index holding the files of the tree sha
+// Entries unchanged from old keep their stat information, so unmodified
+// worktree files are not reported as changed
+func IndexFromTree(r *repo.Gitrepo, sha string, old *index.Index) (*index.Index, error) {
+	files, err := object.TreeFiles(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	idx := index.New()
+	if old != nil {
+		idx.Version = old.Version
+	}
+	for name, f := range files {
+		if old != nil {
+			if e := old.Find(name, 0); e != nil && e.Sha == f.Sha && e.Mode == f.Mode {
+				idx.Entries = append(idx.Entries, e)
+				continue
+			}
+		}
+		idx.Entries = append(idx.Entries, &index.Entry{Mode: f.Mode, Sha: f.Sha, Name: name})
+	}
+	idx.Sort()
+	return idx, nil
+}
+
+// Change is a path that differs between two sides, with git's status letter
+type Change struct {
+	Status byte
+	Name   string
+}
+
+// Unstaged lists the index entries whose worktree file is modified or deleted
+func Unstaged(r *repo.Gitrepo, f *filter.Filter, idx *index.Index) ([]Change, error) {
+	var changes []Change
+	for _, e := range idx.Entries {
+		if e.Stage != 0 {
+			if len(changes) == 0 || changes[len(changes)-1].Name != e.Name {
+				changes = append(changes, Change{Status: 'U', Name: e.Name})
+			}
+			continue
+		}
+		if _, err := os.Lstat(FullPath(r, e.Name)); os.IsNotExist(err) {
+			changes = append(changes, Change{Status: 'D', Name: e.Name})
+			continue
+		}
+		modified, err := IsModified(r, f, e)
+		if err != nil {
+			return nil, err
+		}
+		if modified {
+			changes = append(changes, Change{Status: 'M', Name: e.Name})
+		}
+	}
+	return changes, nil
+}
+
+// StoreFile writes the worktree file name as a blob and returns it as a tree entry
+func StoreFile(r *repo.Gitrepo, f *filter.Filter, name string) (object.TreeFile, error) {
+	data, info, err := ReadFile(r, name)
+	if err != nil {
+		return object.TreeFile{}, err
+	}
+	if info.Mode().IsRegular() {
+		data, err = f.ToGit(name, data)
+		if err != nil {
+			return object.TreeFile{}, err
+		}
+	}
+	sha, err := object.ObjectWrite(r, &object.Blob{Data: data})
+	if err != nil {
+		return object.TreeFile{}, err
+	}
+	return object.TreeFile{Mode: index.ModeFromFileInfo(info), Sha: sha}, nil
+}
+
+// Untracked lists the worktree files that are neither in the index nor ignored by m
+func Untracked(r *repo.Gitrepo, idx *index.Index, m *ignore.Matcher) ([]string, error) {
+	if err := repo.RequireWorktree(r); err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+		// directories holding tracked files are walked even when ignored
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			tracked[dir+"/"] = true
+		}
+	}
+	var names []string
+	err := filepath.WalkDir(r.Worktree, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == r.Worktree {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Worktree, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == repo.DefaultGitdirName {
+				return filepath.SkipDir
+			}
+			if !tracked[name+"/"] && m.Ignored(name, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !tracked[name] && !m.Ignored(name, false) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,150 @@
+package worktree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Blue-Onion/pygo/hanlder/object"
+	"github.com/Blue-Onion/pygo/hanlder/repo"
+)
+
+// newRepo creates an empty repository with a worktree in a temporary directory
+func newRepo(t *testing.T) *repo.Gitrepo {
+	t.Helper()
+	r, err := repo.RepoCreate(t.TempDir(), false, repo.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// writeTree stores files, path to content, as blobs and trees and returns the top tree
+func writeTree(t *testing.T, r *repo.Gitrepo, files map[string]string) string {
+	t.Helper()
+	entries := map[string]object.TreeFile{}
+	for name, content := range files {
+		sha, err := object.HashObject(r, "blob", []byte(content), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[name] = object.TreeFile{Mode: 0100644, Sha: sha}
+	}
+	sha, err := object.WriteTree(r, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func writeFile(t *testing.T, r *repo.Gitrepo, name, content string) {
+	t.Helper()
+	path := FullPath(r, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, r *repo.Gitrepo, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(FullPath(r, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// checkRefused checks that err refuses to overwrite exactly the untracked files want
+func checkRefused(t *testing.T, err error, want ...string) {
+	t.Helper()
+	var overwrite *OverwriteError
+	if !errors.As(err, &overwrite) {
+		t.Fatalf("checkout returned %v, want an OverwriteError", err)
+	}
+	if !slices.Equal(overwrite.Untracked, want) {
+		t.Errorf("untracked files in the way are %q, want %q", overwrite.Untracked, want)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	r := newRepo(t)
+	one := writeTree(t, r, map[string]string{"a": "one\n", "sub/x": "x\n"})
+	two := writeTree(t, r, map[string]string{"a": "two\n", "b": "b\n"})
+	if err := Checkout(r, "", one, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Checkout(r, one, two, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, r, "a"); got != "two\n" {
+		t.Errorf("a is %q after checkout", got)
+	}
+	if _, err := os.Lstat(FullPath(r, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub is still there after checkout: %v", err)
+	}
+
+	// local changes to files that differ between the trees are kept
+	writeFile(t, r, "a", "local\n")
+	var overwrite *OverwriteError
+	if err := Checkout(r, two, one, false); !errors.As(err, &overwrite) || !slices.Equal(overwrite.Modified, []string{"a"}) {
+		t.Fatalf("checkout over a modified file returned %v", err)
+	}
+	if got := readFile(t, r, "a"); got != "local\n" {
+		t.Errorf("a is %q after a refused checkout", got)
+	}
+	if err := Checkout(r, two, one, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, r, "a"); got != "one\n" {
+		t.Errorf("a is %q after a forced checkout", got)
+	}
+}
+
+func TestCheckoutUntrackedInTheWay(t *testing.T) {
+	r := newRepo(t)
+	base := writeTree(t, r, map[string]string{"a": "a\n"})
+	withDir := writeTree(t, r, map[string]string{"a": "a\n", "sub/x": "x\n"})
+	withFile := writeTree(t, r, map[string]string{"a": "a\n", "sub": "file\n", "new": "new\n"})
+	if err := Checkout(r, "", base, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// an untracked file where a directory goes
+	writeFile(t, r, "sub", "precious\n")
+	checkRefused(t, Checkout(r, base, withDir, false), "sub")
+	if got := readFile(t, r, "sub"); got != "precious\n" {
+		t.Errorf("sub is %q after a refused checkout", got)
+	}
+	os.Remove(FullPath(r, "sub"))
+
+	// an untracked directory where a file goes, refused before anything is written
+	writeFile(t, r, "sub/u", "precious\n")
+	checkRefused(t, Checkout(r, base, withFile, false), "sub/u")
+	if _, err := os.Lstat(FullPath(r, "new")); !os.IsNotExist(err) {
+		t.Errorf("a refused checkout wrote new: %v", err)
+	}
+
+	// a tracked directory still holding an untracked file
+	os.RemoveAll(FullPath(r, "sub"))
+	if err := Checkout(r, base, withDir, false); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, r, "sub/u", "precious\n")
+	checkRefused(t, Checkout(r, withDir, withFile, false), "sub/u")
+	if got := readFile(t, r, "sub/x"); got != "x\n" {
+		t.Errorf("sub/x is %q after a refused checkout", got)
+	}
+
+	// forcing clears the way
+	if err := Checkout(r, withDir, withFile, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, r, "sub"); got != "file\n" {
+		t.Errorf("sub is %q after a forced checkout", got)
+	}
+}